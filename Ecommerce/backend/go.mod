@@ -16,6 +16,7 @@ require (
 	github.com/resendlabs/resend-go v1.7.0
 	github.com/stripe/stripe-go/v74 v74.30.0
 	golang.org/x/crypto v0.20.0
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -64,9 +65,9 @@ require (
 	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
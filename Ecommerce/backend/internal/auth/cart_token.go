@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CartTokenHeader es la cabecera con la que el cliente envía y recibe el token del carrito de invitado
+const CartTokenHeader = "X-Cart-Token"
+
+// NewGuestCartID genera el identificador aleatorio de un carrito de invitado
+func NewGuestCartID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// GenerateCartToken firma el identificador de un carrito de invitado. No lleva vencimiento: el
+// carrito vence en la base de datos tras un tiempo sin actividad.
+func GenerateCartToken(guestID string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"cart": guestID,
+		"type": "guest_cart",
+	})
+	return token.SignedString(getJWTSecret())
+}
+
+// ParseCartToken valida la firma del token y devuelve el identificador del carrito de invitado
+func ParseCartToken(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("método de firma inesperado: %v", token.Header["alg"])
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("token de carrito inválido")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["type"] != "guest_cart" {
+		return "", fmt.Errorf("token de carrito inválido")
+	}
+	guestID, ok := claims["cart"].(string)
+	if !ok || guestID == "" {
+		return "", fmt.Errorf("token de carrito inválido")
+	}
+	return guestID, nil
+}
+
+// GenerateCartRestoreToken firma el enlace de un recordatorio de carrito abandonado
+func GenerateCartRestoreToken(recoveryEmailID, userID int, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"recovery": recoveryEmailID,
+		"user_id":  userID,
+		"type":     "cart_restore",
+		"exp":      time.Now().Add(ttl).Unix(),
+	})
+	return token.SignedString(getJWTSecret())
+}
+
+// ParseCartRestoreToken valida el enlace del recordatorio y devuelve el recordatorio y su usuario
+func ParseCartRestoreToken(tokenString string) (recoveryEmailID, userID int, err error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("método de firma inesperado: %v", token.Header["alg"])
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, 0, fmt.Errorf("enlace de carrito inválido o expirado")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["type"] != "cart_restore" {
+		return 0, 0, fmt.Errorf("enlace de carrito inválido o expirado")
+	}
+	recovery, ok1 := claims["recovery"].(float64)
+	user, ok2 := claims["user_id"].(float64)
+	if !ok1 || !ok2 || recovery <= 0 || user <= 0 {
+		return 0, 0, fmt.Errorf("enlace de carrito inválido o expirado")
+	}
+	return int(recovery), int(user), nil
+}
@@ -30,7 +30,7 @@ type AuthHandler struct {
 }
 
 func NewAuthHandler(db *pgxpool.Pool) (*AuthHandler, error) {
-	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService)
+	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService, GuestOrderLink)
 	return &AuthHandler{
 		db:              db,
 		notificationSvc: notificationSvc,
@@ -399,10 +399,21 @@ func (h *AuthHandler) FinishRegistration(c *gin.Context) {
 	}
 
 	delete(sessionStore, user.Email)
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"message": "Registro completado exitosamente",
-	})
+	}
+	if merge := h.mergeGuestCart(c, user.ID); merge != nil {
+		response["cart_merge"] = merge
+	}
+	// El email ya se verificó con el código de registro, así que los pedidos que hizo como
+	// invitado pasan a su cuenta
+	claimed, err := db.ClaimGuestOrders(h.db, user.ID, user.Email)
+	if err != nil {
+		log.Printf("Error reclamando pedidos de invitado del usuario %d: %v", user.ID, err)
+	}
+	response["claimed_orders"] = claimed
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
@@ -600,12 +611,35 @@ func (h *AuthHandler) FinishLogin(c *gin.Context) {
 		"is_verified": user.IsVerified,
 		"is_active":   user.IsActive,
 	}
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success":       true,
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
 		"user":          userResponse,
-	})
+	}
+	if merge := h.mergeGuestCart(c, user.ID); merge != nil {
+		response["cart_merge"] = merge
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// mergeGuestCart pasa al carrito del usuario el carrito de invitado indicado en X-Cart-Token.
+// Un error no impide el inicio de sesión: el invitado conserva su carrito hasta que venza.
+func (h *AuthHandler) mergeGuestCart(c *gin.Context, userID int) *db.CartMergeResult {
+	token := c.GetHeader(CartTokenHeader)
+	if token == "" {
+		return nil
+	}
+	guestID, err := ParseCartToken(token)
+	if err != nil {
+		return nil
+	}
+	result, err := db.MergeGuestCart(h.db, guestID, userID)
+	if err != nil {
+		log.Printf("Error fusionando carrito de invitado con el del usuario %d: %v", userID, err)
+		return nil
+	}
+	return result
 }
 
 func CheckPasswordHash(password, hash string) bool {
@@ -91,6 +91,19 @@ func JWTMiddleware() gin.HandlerFunc {
 	}
 }
 
+// OptionalJWTMiddleware identifica al usuario si envía un token, pero deja pasar a los invitados.
+// Un token presente pero inválido se rechaza para que el cliente lo renueve.
+func OptionalJWTMiddleware() gin.HandlerFunc {
+	required := JWTMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		required(c)
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
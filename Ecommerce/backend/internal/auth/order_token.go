@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// OrderTokenHeader es la cabecera con la que el invitado envía el token de acceso a su pedido
+const OrderTokenHeader = "X-Order-Token"
+
+// GuestOrderLink arma el enlace mágico de un pedido de invitado para los avisos por email
+// (email.NewNotificationService)
+func GuestOrderLink(order *models.Order) (string, error) {
+	token, err := GenerateOrderAccessToken(order.ID, order.GuestEmail, OrderLinkTTLFromEnv())
+	if err != nil {
+		return "", err
+	}
+	return GuestOrderURL(order.ID, token), nil
+}
+
+// OrderLinkTTLFromEnv lee ORDER_LINK_TTL (30 días por defecto)
+func OrderLinkTTLFromEnv() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("ORDER_LINK_TTL"))); err == nil && d > 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// GuestOrderURL arma el enlace del frontend para consultar y pagar un pedido con su token
+func GuestOrderURL(orderID int, token string) string {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "https://axiora.pro"
+	}
+	return fmt.Sprintf("%s/orders/guest?order=%d&token=%s", frontendURL, orderID, url.QueryEscape(token))
+}
+
+// GenerateOrderAccessToken firma el acceso de un invitado a un pedido. Es el token del enlace
+// mágico que se envía por email.
+func GenerateOrderAccessToken(orderID int, email string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"order": orderID,
+		"email": strings.ToLower(strings.TrimSpace(email)),
+		"type":  "order_access",
+		"exp":   time.Now().Add(ttl).Unix(),
+		"iat":   time.Now().Unix(),
+	})
+	return token.SignedString(getJWTSecret())
+}
+
+// ParseOrderAccessToken valida el token y devuelve el pedido al que da acceso
+func ParseOrderAccessToken(tokenString string) (int, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("método de firma inesperado: %v", token.Header["alg"])
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, fmt.Errorf("token de pedido inválido o expirado")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["type"] != "order_access" {
+		return 0, fmt.Errorf("token de pedido inválido o expirado")
+	}
+	orderID, ok := claims["order"].(float64)
+	if !ok || orderID <= 0 {
+		return 0, fmt.Errorf("token de pedido inválido o expirado")
+	}
+	return int(orderID), nil
+}
@@ -128,6 +128,9 @@ var (
 
 	// Rate limiter para registro (3 intentos por 30 minutos)
 	RegistrationLimiter = NewRateLimiter(30*time.Minute, 3)
+
+	// Rate limiter para reenviar el enlace de un pedido de invitado (5 intentos por 15 minutos)
+	OrderLookupLimiter = NewRateLimiter(15*time.Minute, 5)
 )
 
 // Inicializar cleanup para todos los rate limiters
@@ -135,4 +138,5 @@ func init() {
 	VerificationCodeLimiter.Cleanup()
 	LoginLimiter.Cleanup()
 	RegistrationLimiter.Cleanup()
+	OrderLookupLimiter.Cleanup()
 }
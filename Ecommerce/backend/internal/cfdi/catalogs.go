@@ -0,0 +1,145 @@
+package cfdi
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Claves del SAT usadas al armar el comprobante
+const (
+	ImpuestoISR  = "001"
+	ImpuestoIVA  = "002"
+	ImpuestoIEPS = "003"
+
+	RFCPublicoGeneral   = "XAXX010101000"
+	RFCExtranjero       = "XEXX010101000"
+	ClaveUnidadPieza    = "H87"
+	ClaveUnidadServicio = "E48"
+	ClaveProdServEnvio  = "78102203" // Servicios de envío, recogida o entrega de correo
+	ClaveProdServOtros  = "01010101" // No existe en el catálogo
+)
+
+// Motivos de cancelación (c_MotivoCancelacion)
+const (
+	MotivoErroresConRelacion = "01" // Requiere el UUID que lo sustituye
+	MotivoErroresSinRelacion = "02"
+	MotivoNoSeLlevoACabo     = "03"
+	MotivoOperacionGlobal    = "04"
+)
+
+// RegimenesFiscales es el catálogo c_RegimenFiscal; el valor indica si aplica a personas físicas (F), morales (M) o ambas
+var RegimenesFiscales = map[string]string{
+	"601": "M",  // General de Ley Personas Morales
+	"603": "M",  // Personas Morales con Fines no Lucrativos
+	"605": "F",  // Sueldos y Salarios e Ingresos Asimilados a Salarios
+	"606": "F",  // Arrendamiento
+	"607": "F",  // Régimen de Enajenación o Adquisición de Bienes
+	"608": "F",  // Demás ingresos
+	"610": "FM", // Residentes en el Extranjero sin Establecimiento Permanente en México
+	"611": "F",  // Ingresos por Dividendos (socios y accionistas)
+	"612": "F",  // Personas Físicas con Actividades Empresariales y Profesionales
+	"614": "F",  // Ingresos por intereses
+	"615": "F",  // Régimen de los ingresos por obtención de premios
+	"616": "F",  // Sin obligaciones fiscales
+	"620": "M",  // Sociedades Cooperativas de Producción que optan por diferir sus ingresos
+	"621": "F",  // Incorporación Fiscal
+	"622": "FM", // Actividades Agrícolas, Ganaderas, Silvícolas y Pesqueras
+	"623": "M",  // Opcional para Grupos de Sociedades
+	"624": "M",  // Coordinados
+	"625": "F",  // Actividades Empresariales con ingresos a través de Plataformas Tecnológicas
+	"626": "FM", // Régimen Simplificado de Confianza
+}
+
+// UsosCFDI es el catálogo c_UsoCFDI con el tipo de persona que puede usarlo
+var UsosCFDI = map[string]string{
+	"G01":  "FM", // Adquisición de mercancías
+	"G02":  "FM", // Devoluciones, descuentos o bonificaciones
+	"G03":  "FM", // Gastos en general
+	"I01":  "FM", // Construcciones
+	"I02":  "FM", // Mobiliario y equipo de oficina por inversiones
+	"I03":  "FM", // Equipo de transporte
+	"I04":  "FM", // Equipo de computo y accesorios
+	"I05":  "FM", // Dados, troqueles, moldes, matrices y herramental
+	"I06":  "FM", // Comunicaciones telefónicas
+	"I07":  "FM", // Comunicaciones satelitales
+	"I08":  "FM", // Otra maquinaria y equipo
+	"D01":  "F",  // Honorarios médicos, dentales y gastos hospitalarios
+	"D02":  "F",  // Gastos médicos por incapacidad o discapacidad
+	"D03":  "F",  // Gastos funerales
+	"D04":  "F",  // Donativos
+	"D05":  "F",  // Intereses reales por créditos hipotecarios
+	"D06":  "F",  // Aportaciones voluntarias al SAR
+	"D07":  "F",  // Primas por seguros de gastos médicos
+	"D08":  "F",  // Gastos de transportación escolar obligatoria
+	"D09":  "F",  // Depósitos en cuentas para el ahorro
+	"D10":  "F",  // Pagos por servicios educativos (colegiaturas)
+	"S01":  "FM", // Sin efectos fiscales
+	"CP01": "FM", // Pagos
+	"CN01": "F",  // Nómina
+}
+
+var (
+	rfcPattern = regexp.MustCompile(`^([A-ZÑ&]{3,4})(\d{6})([A-Z0-9]{3})$`)
+	cpPattern  = regexp.MustCompile(`^\d{5}$`)
+)
+
+// NormalizeRFC quita espacios y guiones y pasa el RFC a mayúsculas
+func NormalizeRFC(rfc string) string {
+	rfc = strings.ToUpper(strings.TrimSpace(rfc))
+	rfc = strings.ReplaceAll(rfc, "-", "")
+	return strings.ReplaceAll(rfc, " ", "")
+}
+
+// ValidRFC valida el formato del RFC (12 caracteres personas morales, 13 físicas)
+func ValidRFC(rfc string) bool {
+	return rfcPattern.MatchString(rfc)
+}
+
+// PersonType devuelve F para personas físicas (RFC de 13) y M para morales (RFC de 12)
+func PersonType(rfc string) string {
+	if len([]rune(rfc)) == 13 {
+		return "F"
+	}
+	return "M"
+}
+
+// ValidPostalCode valida el código postal del domicilio fiscal
+func ValidPostalCode(cp string) bool {
+	return cpPattern.MatchString(cp)
+}
+
+// ValidRegimen indica si el régimen existe y aplica al tipo de persona del RFC
+func ValidRegimen(regimen, rfc string) bool {
+	kinds, ok := RegimenesFiscales[regimen]
+	return ok && strings.Contains(kinds, PersonType(rfc))
+}
+
+// ValidUsoCFDI indica si el uso existe y aplica al tipo de persona del RFC
+func ValidUsoCFDI(uso, rfc string) bool {
+	kinds, ok := UsosCFDI[uso]
+	return ok && strings.Contains(kinds, PersonType(rfc))
+}
+
+// ValidCancelReason indica si el motivo de cancelación existe
+func ValidCancelReason(motivo string) bool {
+	switch motivo {
+	case MotivoErroresConRelacion, MotivoErroresSinRelacion, MotivoNoSeLlevoACabo, MotivoOperacionGlobal:
+		return true
+	}
+	return false
+}
+
+// NormalizeRazonSocial deja la razón social como la pide CFDI 4.0: en mayúsculas, sin el régimen
+// societario (S.A. de C.V., etc.) y con un solo espacio entre palabras
+func NormalizeRazonSocial(name string) string {
+	name = strings.Join(strings.Fields(strings.ToUpper(name)), " ")
+	for _, suffix := range []string{
+		" S.A.P.I. DE C.V.", " SAPI DE CV", " S.A.B. DE C.V.", " SAB DE CV", " S.A. DE C.V.", " SA DE CV",
+		" S. DE R.L. DE C.V.", " S DE RL DE CV", " S.C.", " A.C.", " S.A.",
+	} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
@@ -0,0 +1,252 @@
+package cfdi
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+func testIssuer() *Issuer {
+	return &Issuer{
+		RFC:             "EKU9003173C9",
+		Nombre:          "ESCUELA KEMPER URGATE",
+		RegimenFiscal:   "601",
+		LugarExpedicion: "42501",
+		Serie:           "A",
+		FormaPago:       "04",
+		ClaveProdServ:   ClaveProdServOtros,
+		Location:        time.UTC,
+	}
+}
+
+func testProfile() *models.BillingProfile {
+	return &models.BillingProfile{
+		RFC:           "URE180429TM6",
+		RazonSocial:   "UNIVERSIDAD ROBOTICA ESPAÑOLA",
+		RegimenFiscal: "601",
+		UsoCFDI:       "G03",
+		CodigoPostal:  "86991",
+	}
+}
+
+func iva(taxable, amount float64, inclusive bool) []models.TaxComponent {
+	return []models.TaxComponent{{Name: "IVA", Rate: 0.16, Inclusive: inclusive, Taxable: taxable, Amount: amount}}
+}
+
+// testLines tiene una línea con IVA aparte, una con descuento y una con el IVA incluido en el precio
+func testLines() []Line {
+	return []Line{
+		{Item: models.OrderItem{ProductID: 1, Quantity: 2, Price: 100, Subtotal: 200, TaxBreakdown: iva(200, 32, false)}, Description: "Taza", SKU: "TAZA-01"},
+		{Item: models.OrderItem{ProductID: 2, Quantity: 1, Price: 100, Subtotal: 100, Discount: 10, TaxBreakdown: iva(90, 14.4, false)}, Description: "Playera"},
+		{Item: models.OrderItem{ProductID: 3, Quantity: 1, Price: 116, Subtotal: 116, TaxBreakdown: iva(100, 16, true)}, Description: "Gorra"},
+	}
+}
+
+// wellFormed comprueba que el documento sea XML válido
+func wellFormed(t *testing.T, data []byte) {
+	t.Helper()
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err == io.EOF {
+				return
+			}
+			t.Fatalf("XML mal formado: %v", err)
+		}
+	}
+}
+
+func TestBuildComprobante(t *testing.T) {
+	order := &models.Order{ID: 7, Currency: "mxn", Shipping: 50}
+	now := time.Date(2026, 3, 1, 18, 30, 0, 0, time.UTC)
+
+	c, err := Build(testIssuer(), order, testLines(), testProfile(), 15, now)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	checks := []struct{ name, got, want string }{
+		{"Moneda", c.Moneda, "MXN"},
+		{"Folio", c.Folio, "15"},
+		{"Fecha", c.Fecha, "2026-03-01T18:30:00"},
+		{"SubTotal", c.SubTotal, "450.00"},
+		{"Descuento", c.Descuento, "10.00"},
+		{"Total", c.Total, "502.40"},
+		{"TotalImpuestosTrasladados", c.Impuestos.TotalImpuestosTrasladados, "62.40"},
+		{"Receptor", c.Receptor.Rfc, "URE180429TM6"},
+	}
+	for _, ch := range checks {
+		if ch.got != ch.want {
+			t.Errorf("%s = %q, se esperaba %q", ch.name, ch.got, ch.want)
+		}
+	}
+
+	if len(c.Conceptos) != 4 {
+		t.Fatalf("se esperaban 4 conceptos (3 productos y el envío), hay %d", len(c.Conceptos))
+	}
+	concepts := []struct{ importe, descuento, objetoImp string }{
+		{"200.00", "", "02"},
+		{"100.00", "10.00", "02"},
+		{"100.00", "", "02"}, // El IVA incluido se separa del importe
+		{"50.00", "", "01"},  // El envío no lleva impuestos
+	}
+	for i, want := range concepts {
+		got := c.Conceptos[i]
+		if got.Importe != want.importe || got.Descuento != want.descuento || got.ObjetoImp != want.objetoImp {
+			t.Errorf("concepto %d: importe %s, descuento %q, objeto %s; se esperaba %s, %q, %s",
+				i, got.Importe, got.Descuento, got.ObjetoImp, want.importe, want.descuento, want.objetoImp)
+		}
+	}
+	if c.Conceptos[3].ClaveProdServ != ClaveProdServEnvio {
+		t.Errorf("el envío tiene la clave %s", c.Conceptos[3].ClaveProdServ)
+	}
+
+	if len(c.Impuestos.Traslados) != 1 {
+		t.Fatalf("se esperaba un traslado agrupado, hay %d", len(c.Impuestos.Traslados))
+	}
+	if tr := c.Impuestos.Traslados[0]; tr.Impuesto != ImpuestoIVA || tr.Base != "390.00" || tr.TasaOCuota != "0.160000" {
+		t.Errorf("traslado %+v", tr)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	lines := testLines()
+	if _, err := Build(testIssuer(), &models.Order{Currency: "USD"}, lines, testProfile(), 1, time.Now()); err == nil {
+		t.Error("se facturó en USD sin tipo de cambio")
+	}
+	if _, err := Build(testIssuer(), &models.Order{Currency: "MXN"}, nil, testProfile(), 1, time.Now()); err == nil {
+		t.Error("se facturó un pedido sin conceptos")
+	}
+	unknown := []Line{{Item: models.OrderItem{Quantity: 1, Subtotal: 10, TaxBreakdown: []models.TaxComponent{{Name: "Sales tax", Rate: 0.08, Taxable: 10, Amount: 0.8}}}}}
+	if _, err := Build(testIssuer(), &models.Order{Currency: "MXN"}, unknown, testProfile(), 1, time.Now()); err == nil {
+		t.Error("se aceptó un impuesto sin clave del SAT")
+	}
+}
+
+func TestMarshalComprobante(t *testing.T) {
+	c, err := Build(testIssuer(), &models.Order{Currency: "MXN", Shipping: 50}, testLines(), testProfile(), 15, time.Now())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	data, err := Marshal(c)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	wellFormed(t, data)
+
+	out := string(data)
+	for _, want := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4"`,
+		`Version="4.0"`,
+		`<cfdi:Emisor Rfc="EKU9003173C9"`,
+		`UsoCFDI="G03"`,
+		`<cfdi:Traslado Base="390.00" Impuesto="002" TipoFactor="Tasa" TasaOCuota="0.160000" Importe="62.40">`,
+		`Nombre="UNIVERSIDAD ROBOTICA ESPAÑOLA"`,
+		`Descripcion="Taza"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("el XML no contiene %s", want)
+		}
+	}
+	if strings.Contains(out, "Sello=") {
+		t.Error("el XML sin timbrar no debe llevar sello")
+	}
+}
+
+func TestFakeStamperStamp(t *testing.T) {
+	c, err := Build(testIssuer(), &models.Order{Currency: "MXN"}, testLines(), testProfile(), 15, time.Now())
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	data, err := Marshal(c)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	stamp, err := NewFakeStamper().Stamp(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Stamp: %v", err)
+	}
+	if !uuidPattern.MatchString(stamp.UUID) {
+		t.Errorf("UUID con formato inválido: %s", stamp.UUID)
+	}
+	if stamp.SelloCFD == "" || stamp.SelloSAT == "" || stamp.FechaTimbrado.IsZero() {
+		t.Errorf("timbre incompleto: %+v", stamp)
+	}
+
+	wellFormed(t, stamp.XML)
+	out := string(stamp.XML)
+	tfd := strings.Index(out, `<tfd:TimbreFiscalDigital`)
+	if tfd < 0 || !strings.Contains(out, `UUID="`+stamp.UUID+`"`) {
+		t.Fatal("el XML timbrado no tiene el TimbreFiscalDigital con el UUID")
+	}
+	if tfd > strings.LastIndex(out, "</cfdi:Comprobante>") {
+		t.Error("el complemento quedó fuera del comprobante")
+	}
+	if !strings.HasPrefix(out, string(data[:bytes.LastIndex(data, []byte("</cfdi:Comprobante>"))])) {
+		t.Error("el timbrado modificó el comprobante original")
+	}
+
+	// Cada timbre tiene su propio UUID
+	again, err := NewFakeStamper().Stamp(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Stamp: %v", err)
+	}
+	if again.UUID == stamp.UUID {
+		t.Error("dos timbres con el mismo UUID")
+	}
+
+	if _, err := NewFakeStamper().Stamp(context.Background(), []byte("<otro/>")); err == nil {
+		t.Error("se timbró un XML que no es un comprobante")
+	}
+}
+
+func TestFakeStamperCancel(t *testing.T) {
+	stamper := NewFakeStamper()
+	req := CancelRequest{UUID: "6F2B4E8A-1C3D-4E5F-8A9B-0C1D2E3F4A5B", RfcEmisor: "EKU9003173C9", Motivo: MotivoErroresConRelacion}
+	if _, err := stamper.Cancel(context.Background(), req); err == nil {
+		t.Error("se canceló con motivo 01 sin folio de sustitución")
+	}
+
+	req.Motivo = MotivoErroresSinRelacion
+	result, err := stamper.Cancel(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if result.Status != CancelStatusCancelled || !bytes.Contains(result.Acuse, []byte(req.UUID)) {
+		t.Errorf("cancelación %s con acuse %s", result.Status, result.Acuse)
+	}
+	wellFormed(t, result.Acuse)
+}
+
+func TestNewStamperFailsClosed(t *testing.T) {
+	for _, name := range []string{"", "  ", "finkok"} {
+		if stamper, err := NewStamper(name); err == nil || stamper != nil {
+			t.Errorf("CFDI_PAC=%q devolvió %v sin error", name, stamper)
+		}
+	}
+	for _, name := range []string{"fake", "FAKE"} {
+		stamper, err := NewStamper(name)
+		if err != nil || stamper.Name() != "fake" {
+			t.Errorf("CFDI_PAC=%q: %v", name, err)
+		}
+	}
+}
+
+func TestServiceWithoutStamper(t *testing.T) {
+	s := &Service{Issuer: testIssuer()}
+	order := &models.Order{ID: 1, PaymentStatus: "paid", Currency: "MXN"}
+	if doc, err := s.Issue(context.Background(), order, testProfile()); err == nil || doc != nil {
+		t.Errorf("se emitió un CFDI sin PAC: %v", err)
+	}
+	if _, err := s.Cancel(context.Background(), &models.CFDIDocument{Status: "stamped"}, MotivoErroresSinRelacion, ""); err == nil {
+		t.Error("se canceló un CFDI sin PAC")
+	}
+}
@@ -0,0 +1,209 @@
+package cfdi
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/tuusuario/ecommerce-backend/internal/pdf"
+)
+
+const (
+	marginLeft  = 40.0
+	marginRight = pdf.PageWidth - 40
+	bottomLimit = 60.0
+)
+
+// Render genera la representación impresa del CFDI timbrado. En lugar del código QR se imprime la
+// URL de verificación del SAT con los mismos parámetros.
+func Render(c *Comprobante, stamp *Stamp) []byte {
+	doc := pdf.New()
+	y := header(doc, c)
+
+	// Receptor
+	doc.Text(marginLeft, y, 10, true, "Receptor")
+	y -= 13
+	for _, line := range []string{
+		c.Receptor.Nombre,
+		"RFC: " + c.Receptor.Rfc,
+		"Domicilio fiscal (CP): " + c.Receptor.DomicilioFiscalReceptor,
+		"Régimen fiscal: " + c.Receptor.RegimenFiscalReceptor + "   Uso CFDI: " + c.Receptor.UsoCFDI,
+	} {
+		doc.Text(marginLeft, y, 8, false, line)
+		y -= 11
+	}
+	y -= 10
+
+	// Conceptos
+	y = conceptsHeader(doc, y)
+	for _, concept := range c.Conceptos {
+		lines := wrap(concept.Descripcion, 230, 8)
+		if y-float64(len(lines)-1)*10 < bottomLimit {
+			doc.AddPage()
+			y = conceptsHeader(doc, header(doc, c))
+		}
+		doc.Text(marginLeft, y, 8, false, concept.ClaveProdServ)
+		doc.TextRight(145, y, 8, false, concept.Cantidad)
+		doc.Text(155, y, 8, false, concept.ClaveUnidad)
+		for i, line := range lines {
+			doc.Text(190, y-float64(i)*10, 8, false, line)
+		}
+		doc.TextRight(490, y, 8, false, concept.ValorUnitario)
+		doc.TextRight(marginRight, y, 8, false, concept.Importe)
+		y -= float64(len(lines))*10 + 4
+	}
+
+	// Totales
+	rows := [][2]string{{"Subtotal", c.SubTotal}}
+	if c.Descuento != "" {
+		rows = append(rows, [2]string{"Descuento", "-" + c.Descuento})
+	}
+	if c.Impuestos != nil {
+		for _, t := range c.Impuestos.Traslados {
+			rows = append(rows, [2]string{fmt.Sprintf("%s %s", taxName(t.Impuesto), t.TasaOCuota), t.Importe})
+		}
+	}
+	rows = append(rows, [2]string{"Total", c.Total})
+	if y < bottomLimit+float64(len(rows)+14)*11 {
+		doc.AddPage()
+		y = header(doc, c)
+	}
+	doc.Line(marginLeft, y+6, marginRight, y+6, 0.5)
+	y -= 8
+	for i, row := range rows {
+		bold := i == len(rows)-1
+		doc.Text(400, y, 9, bold, row[0])
+		doc.TextRight(marginRight, y, 9, bold, row[1])
+		y -= 12
+	}
+	payment := fmt.Sprintf("Moneda: %s   Forma de pago: %s   Método de pago: %s", c.Moneda, c.FormaPago, c.MetodoPago)
+	if c.TipoCambio != "" {
+		payment += "   Tipo de cambio: " + c.TipoCambio
+	}
+	doc.Text(marginLeft, y, 8, false, payment)
+	y -= 20
+
+	// Timbre fiscal
+	doc.Text(marginLeft, y, 9, true, "Timbre fiscal digital")
+	y -= 12
+	doc.Text(marginLeft, y, 8, false, "Folio fiscal (UUID): "+stamp.UUID)
+	y -= 10
+	doc.Text(marginLeft, y, 8, false, fmt.Sprintf("Fecha de certificación: %s   No. certificado SAT: %s   RFC PAC: %s",
+		stamp.FechaTimbrado.Format("2006-01-02T15:04:05"), stamp.NoCertificadoSAT, stamp.RfcProvCertif))
+	y -= 14
+
+	chain := fmt.Sprintf("||1.1|%s|%s|%s|%s|%s||", stamp.UUID, stamp.FechaTimbrado.Format("2006-01-02T15:04:05"),
+		stamp.RfcProvCertif, stamp.SelloCFD, stamp.NoCertificadoSAT)
+	for _, block := range [][2]string{
+		{"Sello digital del CFDI", stamp.SelloCFD},
+		{"Sello del SAT", stamp.SelloSAT},
+		{"Cadena original del complemento de certificación", chain},
+		{"Verificación", VerificationURL(c, stamp)},
+	} {
+		lines := wrap(block[1], marginRight-marginLeft, 7)
+		if y-float64(len(lines)+1)*9 < bottomLimit {
+			doc.AddPage()
+			y = header(doc, c)
+		}
+		doc.Text(marginLeft, y, 8, true, block[0])
+		y -= 10
+		for _, line := range lines {
+			doc.Text(marginLeft, y, 7, false, line)
+			y -= 9
+		}
+		y -= 4
+	}
+
+	doc.Text(marginLeft, 40, 7, false, "Este documento es una representación impresa de un CFDI versión 4.0")
+	return doc.Bytes()
+}
+
+// VerificationURL arma la URL del servicio de verificación de CFDI del SAT
+func VerificationURL(c *Comprobante, stamp *Stamp) string {
+	fe := stamp.SelloCFD
+	if len(fe) > 8 {
+		fe = fe[len(fe)-8:]
+	}
+	q := url.Values{}
+	q.Set("id", stamp.UUID)
+	q.Set("re", c.Emisor.Rfc)
+	q.Set("rr", c.Receptor.Rfc)
+	q.Set("tt", c.Total)
+	q.Set("fe", fe)
+	return "https://verificacfdi.facturaelectronica.sat.gob.mx/default.aspx?" + q.Encode()
+}
+
+func header(doc *pdf.Document, c *Comprobante) float64 {
+	y := pdf.PageHeight - 50
+	doc.Text(marginLeft, y, 14, true, c.Emisor.Nombre)
+	doc.TextRight(marginRight, y, 12, true, "FACTURA CFDI "+c.Version)
+	y -= 14
+	left := []string{"RFC: " + c.Emisor.Rfc, "Régimen fiscal: " + c.Emisor.RegimenFiscal}
+	right := []string{
+		fmt.Sprintf("Serie y folio: %s-%s", c.Serie, c.Folio),
+		"Fecha de emisión: " + c.Fecha,
+		"Lugar de expedición: " + c.LugarExpedicion,
+	}
+	for i := 0; i < len(left) || i < len(right); i++ {
+		if i < len(left) {
+			doc.Text(marginLeft, y, 8, false, left[i])
+		}
+		if i < len(right) {
+			doc.TextRight(marginRight, y, 8, false, right[i])
+		}
+		y -= 11
+	}
+	y -= 4
+	doc.Line(marginLeft, y, marginRight, y, 1)
+	return y - 18
+}
+
+func conceptsHeader(doc *pdf.Document, y float64) float64 {
+	doc.Rect(marginLeft, y-4, marginRight-marginLeft, 15, 0.9)
+	doc.Text(marginLeft+2, y, 8, true, "Clave")
+	doc.TextRight(145, y, 8, true, "Cant.")
+	doc.Text(155, y, 8, true, "Unidad")
+	doc.Text(190, y, 8, true, "Descripción")
+	doc.TextRight(490, y, 8, true, "Valor unitario")
+	doc.TextRight(marginRight, y, 8, true, "Importe")
+	return y - 18
+}
+
+func taxName(code string) string {
+	switch code {
+	case ImpuestoIVA:
+		return "IVA"
+	case ImpuestoIEPS:
+		return "IEPS"
+	case ImpuestoISR:
+		return "ISR"
+	}
+	return code
+}
+
+// wrap parte el texto en líneas que quepan en el ancho. Corta en espacios cuando puede; los
+// sellos no tienen espacios y se cortan por caracteres.
+func wrap(text string, width, size float64) []string {
+	var lines []string
+	runes := []rune(text)
+	for len(runes) > 0 {
+		n := len(runes)
+		for n > 1 && pdf.TextWidth(string(runes[:n]), size) > width {
+			n--
+		}
+		next := n
+		if n < len(runes) {
+			for i := n; i > 0; i-- {
+				if runes[i] == ' ' {
+					n, next = i, i+1
+					break
+				}
+			}
+		}
+		lines = append(lines, string(runes[:n]))
+		runes = runes[next:]
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	return lines
+}
@@ -0,0 +1,234 @@
+package cfdi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/lib"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Service emite, guarda y cancela los CFDI de los pedidos
+type Service struct {
+	DB      *pgxpool.Pool
+	Stamper Stamper
+	Issuer  *Issuer
+	Upload  func(data []byte, key, contentType string) (string, error) // Capa de almacenamiento (S3)
+}
+
+// NewService crea el servicio con el emisor y el PAC configurados en el entorno
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		DB:      db,
+		Stamper: StamperFromEnv(),
+		Issuer:  IssuerFromEnv(),
+		Upload:  lib.UploadBytesToS3,
+	}
+}
+
+// IssuerFromEnv lee los datos del emisor: CFDI_EMISOR_RFC, CFDI_EMISOR_NOMBRE, CFDI_EMISOR_REGIMEN,
+// CFDI_LUGAR_EXPEDICION, CFDI_SERIE, CFDI_FORMA_PAGO, CFDI_CLAVE_PROD_SERV y CFDI_TIPO_CAMBIO
+func IssuerFromEnv() *Issuer {
+	location, err := time.LoadLocation("America/Mexico_City")
+	if err != nil {
+		location = time.FixedZone("CST", -6*60*60)
+	}
+	rate, _ := strconv.ParseFloat(strings.TrimSpace(os.Getenv("CFDI_TIPO_CAMBIO")), 64)
+	return &Issuer{
+		RFC:             NormalizeRFC(os.Getenv("CFDI_EMISOR_RFC")),
+		Nombre:          NormalizeRazonSocial(os.Getenv("CFDI_EMISOR_NOMBRE")),
+		RegimenFiscal:   envOr("CFDI_EMISOR_REGIMEN", "601"),
+		LugarExpedicion: strings.TrimSpace(os.Getenv("CFDI_LUGAR_EXPEDICION")),
+		Serie:           strings.ToUpper(envOr("CFDI_SERIE", "A")),
+		FormaPago:       envOr("CFDI_FORMA_PAGO", "04"),
+		ClaveProdServ:   envOr("CFDI_CLAVE_PROD_SERV", ClaveProdServOtros),
+		ExchangeRate:    rate,
+		Location:        location,
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ValidateProfile revisa los datos fiscales del receptor contra los catálogos del SAT
+func ValidateProfile(p *models.BillingProfile) error {
+	if !ValidRFC(p.RFC) {
+		return fmt.Errorf("RFC inválido")
+	}
+	if p.RFC == RFCPublicoGeneral || p.RFC == RFCExtranjero {
+		return fmt.Errorf("el RFC genérico no se puede usar en un perfil fiscal")
+	}
+	if p.RazonSocial == "" || len(p.RazonSocial) > 300 {
+		return fmt.Errorf("razón social inválida")
+	}
+	if !ValidRegimen(p.RegimenFiscal, p.RFC) {
+		return fmt.Errorf("el régimen fiscal no existe o no corresponde al tipo de persona del RFC")
+	}
+	if !ValidUsoCFDI(p.UsoCFDI, p.RFC) {
+		return fmt.Errorf("el uso de CFDI no existe o no corresponde al tipo de persona del RFC")
+	}
+	if !ValidPostalCode(p.CodigoPostal) {
+		return fmt.Errorf("el código postal fiscal debe tener 5 dígitos")
+	}
+	return nil
+}
+
+// Issue emite el CFDI de un pedido pagado con el perfil fiscal indicado. Los errores de validación
+// devuelven un documento nil; si falla el timbrado se devuelve el documento con estado error.
+func (s *Service) Issue(ctx context.Context, order *models.Order, profile *models.BillingProfile) (*models.CFDIDocument, error) {
+	if s.Stamper == nil || s.Issuer.RFC == "" || s.Issuer.Nombre == "" || s.Issuer.LugarExpedicion == "" {
+		return nil, fmt.Errorf("la facturación electrónica no está configurada")
+	}
+	if order.PaymentStatus != "paid" && order.PaymentStatus != "partially_refunded" {
+		return nil, fmt.Errorf("solo se pueden facturar pedidos pagados")
+	}
+	if err := ValidateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	items, err := db.GetOrderItems(s.DB, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]Line, 0, len(items))
+	for _, item := range items {
+		line := Line{Item: item, Description: fmt.Sprintf("Producto #%d", item.ProductID)}
+		if product, err := db.GetProductByID(s.DB, item.ProductID); err == nil {
+			line.Description = product.Name
+			if product.SKU != nil {
+				line.SKU = *product.SKU
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	comprobante, err := Build(s.Issuer, order, lines, profile, 0, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	total, _ := strconv.ParseFloat(comprobante.Total, 64)
+
+	doc, err := db.ReserveCFDI(s.DB, &models.CFDIDocument{
+		OrderID:          order.ID,
+		BillingProfileID: profile.ID,
+		Series:           s.Issuer.Serie,
+		ReceptorRFC:      profile.RFC,
+		Total:            total,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if doc.Status == "stamped" {
+		return doc, nil
+	}
+	comprobante.Serie = doc.Series
+	comprobante.Folio = strconv.Itoa(doc.Folio)
+
+	data, err := Marshal(comprobante)
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := s.Stamper.Stamp(ctx, data)
+	if err != nil {
+		log.Printf("Error timbrando CFDI %d del pedido %d: %v", doc.ID, order.ID, err)
+		if markErr := db.MarkCFDIError(s.DB, doc.ID, err.Error()); markErr != nil {
+			log.Printf("Error guardando estado del CFDI %d: %v", doc.ID, markErr)
+		}
+		doc.Status = "error"
+		doc.ErrorMessage = err.Error()
+		return doc, fmt.Errorf("error timbrando CFDI: %w", err)
+	}
+
+	// El CFDI ya es válido ante el SAT: un fallo del almacenamiento no debe perder el timbre,
+	// por eso el XML también queda en la base de datos
+	prefix := fmt.Sprintf("cfdi/%s/%s-%d-%s", s.Issuer.RFC, doc.Series, doc.Folio, stamp.UUID)
+	xmlURL, err := s.Upload(stamp.XML, prefix+".xml", "application/xml")
+	if err != nil {
+		log.Printf("Error guardando XML del CFDI %s: %v", stamp.UUID, err)
+	}
+	pdfURL, err := s.Upload(Render(comprobante, stamp), prefix+".pdf", "application/pdf")
+	if err != nil {
+		log.Printf("Error guardando PDF del CFDI %s: %v", stamp.UUID, err)
+	}
+
+	if err := db.MarkCFDIStamped(s.DB, doc.ID, stamp.UUID, stamp.XML, xmlURL, pdfURL, stamp.FechaTimbrado); err != nil {
+		return nil, err
+	}
+	return db.GetCFDIByID(s.DB, doc.ID)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9A-F]{8}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{12}$`)
+
+// Cancel solicita al PAC la cancelación de un CFDI timbrado. Con motivo 01 se indica el UUID del
+// CFDI que lo sustituye. Si el receptor debe aceptarla, el documento queda en cancel_pending y
+// se puede volver a llamar para consultar el resultado.
+func (s *Service) Cancel(ctx context.Context, doc *models.CFDIDocument, motivo, replacementUUID string) (*models.CFDIDocument, error) {
+	if s.Stamper == nil {
+		return nil, fmt.Errorf("la facturación electrónica no está configurada")
+	}
+	if doc.Status != "stamped" && doc.Status != "cancel_pending" {
+		return nil, fmt.Errorf("solo se pueden cancelar CFDI timbrados")
+	}
+	if !ValidCancelReason(motivo) {
+		return nil, fmt.Errorf("motivo de cancelación inválido")
+	}
+	replacementUUID = strings.ToUpper(strings.TrimSpace(replacementUUID))
+	if motivo == MotivoErroresConRelacion {
+		if !uuidPattern.MatchString(replacementUUID) || replacementUUID == doc.UUID {
+			return nil, fmt.Errorf("el motivo 01 requiere el UUID del CFDI que sustituye al cancelado")
+		}
+	} else {
+		replacementUUID = ""
+	}
+
+	result, err := s.Stamper.Cancel(ctx, CancelRequest{
+		UUID:             doc.UUID,
+		RfcEmisor:        s.Issuer.RFC,
+		RfcReceptor:      doc.ReceptorRFC,
+		Total:            money(doc.Total),
+		Motivo:           motivo,
+		FolioSustitucion: replacementUUID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error cancelando CFDI: %w", err)
+	}
+
+	status := "cancel_pending"
+	switch result.Status {
+	case CancelStatusCancelled:
+		status = "cancelled"
+	case CancelStatusRejected:
+		status = "stamped"
+	}
+	if err := db.UpdateCFDICancellation(s.DB, doc.ID, status, motivo, replacementUUID); err != nil {
+		return nil, err
+	}
+
+	if len(result.Acuse) > 0 {
+		key := fmt.Sprintf("cfdi/%s/%s-%d-%s-acuse.xml", s.Issuer.RFC, doc.Series, doc.Folio, doc.UUID)
+		if _, err := s.Upload(result.Acuse, key, "application/xml"); err != nil {
+			log.Printf("Error guardando acuse de cancelación del CFDI %s: %v", doc.UUID, err)
+		}
+	}
+
+	updated, err := db.GetCFDIByID(s.DB, doc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result.Status == CancelStatusRejected {
+		return updated, fmt.Errorf("el receptor rechazó la cancelación")
+	}
+	return updated, nil
+}
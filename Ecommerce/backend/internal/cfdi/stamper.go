@@ -0,0 +1,162 @@
+package cfdi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stamp es el timbre fiscal digital devuelto por el PAC
+type Stamp struct {
+	UUID             string
+	FechaTimbrado    time.Time
+	RfcProvCertif    string
+	SelloCFD         string
+	NoCertificadoSAT string
+	SelloSAT         string
+	XML              []byte // Comprobante sellado con el complemento TimbreFiscalDigital
+}
+
+// CancelRequest contiene los datos que pide el SAT para cancelar un CFDI
+type CancelRequest struct {
+	UUID             string
+	RfcEmisor        string
+	RfcReceptor      string
+	Total            string
+	Motivo           string // c_MotivoCancelacion
+	FolioSustitucion string // UUID que sustituye al cancelado (motivo 01)
+}
+
+// Estados de una solicitud de cancelación
+const (
+	CancelStatusCancelled = "cancelled"   // Cancelado sin aceptación del receptor
+	CancelStatusPending   = "in_progress" // El receptor debe aceptar la cancelación
+	CancelStatusRejected  = "rejected"
+)
+
+// CancelResult es la respuesta del PAC a una solicitud de cancelación
+type CancelResult struct {
+	Status string
+	Acuse  []byte // Acuse de cancelación del SAT
+}
+
+// Stamper abstrae al proveedor autorizado de certificación (PAC)
+type Stamper interface {
+	// Name identifica al PAC
+	Name() string
+	// Stamp sella y timbra el XML del comprobante
+	Stamp(ctx context.Context, xml []byte) (*Stamp, error)
+	// Cancel solicita la cancelación de un CFDI timbrado
+	Cancel(ctx context.Context, req CancelRequest) (*CancelResult, error)
+}
+
+// NewStamper devuelve el PAC configurado por nombre. El timbrado local de prueba solo se usa si se
+// pide explícitamente con "fake"; sin nombre no hay PAC.
+func NewStamper(name string) (Stamper, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return nil, fmt.Errorf("CFDI_PAC no configurado")
+	case "fake":
+		return NewFakeStamper(), nil
+	default:
+		return nil, fmt.Errorf("PAC no soportado: %s", name)
+	}
+}
+
+var (
+	defaultStamper     Stamper
+	defaultStamperOnce sync.Once
+)
+
+// StamperFromEnv devuelve el PAC configurado en CFDI_PAC, o nil si no hay uno válido: sin PAC la
+// emisión y cancelación de CFDI quedan deshabilitadas en lugar de timbrar sin validez fiscal
+func StamperFromEnv() Stamper {
+	defaultStamperOnce.Do(func() {
+		stamper, err := NewStamper(os.Getenv("CFDI_PAC"))
+		if err != nil {
+			log.Printf("⚠️ %v, la facturación electrónica queda deshabilitada", err)
+			return
+		}
+		if stamper.Name() == "fake" {
+			log.Printf("⚠️ CFDI_PAC=fake: los CFDI se timbran localmente y no tienen validez fiscal")
+		}
+		defaultStamper = stamper
+	})
+	return defaultStamper
+}
+
+// FakeStamper timbra localmente sin validez fiscal. Genera un UUID y sellos deterministas a partir
+// del XML para desarrollo y pruebas; nunca debe usarse en producción.
+type FakeStamper struct{}
+
+// NewFakeStamper crea el timbrador de prueba
+func NewFakeStamper() *FakeStamper {
+	return &FakeStamper{}
+}
+
+func (f *FakeStamper) Name() string {
+	return "fake"
+}
+
+func (f *FakeStamper) Stamp(ctx context.Context, data []byte) (*Stamp, error) {
+	end := []byte("</cfdi:Comprobante>")
+	idx := bytes.LastIndex(data, end)
+	if idx < 0 {
+		return nil, fmt.Errorf("XML de comprobante inválido")
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	stamp := &Stamp{
+		UUID:             uuid,
+		FechaTimbrado:    time.Now().UTC(),
+		RfcProvCertif:    "SPR190613I52",
+		SelloCFD:         base64.StdEncoding.EncodeToString(sum[:]),
+		NoCertificadoSAT: "00001000000000000000",
+	}
+	sat := sha256.Sum256(append(sum[:], uuid...))
+	stamp.SelloSAT = base64.StdEncoding.EncodeToString(sat[:])
+
+	complement := fmt.Sprintf(`  <cfdi:Complemento>
+    <tfd:TimbreFiscalDigital xmlns:tfd="http://www.sat.gob.mx/TimbreFiscalDigital" xsi:schemaLocation="http://www.sat.gob.mx/TimbreFiscalDigital http://www.sat.gob.mx/sitio_internet/cfd/TimbreFiscalDigital/TimbreFiscalDigitalv11.xsd" Version="1.1" UUID="%s" FechaTimbrado="%s" RfcProvCertif="%s" SelloCFD="%s" NoCertificadoSAT="%s" SelloSAT="%s"/>
+  </cfdi:Complemento>
+`, stamp.UUID, stamp.FechaTimbrado.Format("2006-01-02T15:04:05"), stamp.RfcProvCertif, stamp.SelloCFD, stamp.NoCertificadoSAT, stamp.SelloSAT)
+
+	var out bytes.Buffer
+	out.Write(data[:idx])
+	out.WriteString(complement)
+	out.Write(data[idx:])
+	stamp.XML = out.Bytes()
+	return stamp, nil
+}
+
+func (f *FakeStamper) Cancel(ctx context.Context, req CancelRequest) (*CancelResult, error) {
+	if req.Motivo == MotivoErroresConRelacion && req.FolioSustitucion == "" {
+		return nil, fmt.Errorf("el motivo 01 requiere el folio que sustituye al CFDI")
+	}
+	acuse := fmt.Sprintf(`<Acuse Fecha="%s" RfcEmisor="%s"><Folios><UUID>%s</UUID><EstatusUUID>201</EstatusUUID></Folios></Acuse>`,
+		time.Now().UTC().Format("2006-01-02T15:04:05"), req.RfcEmisor, req.UUID)
+	return &CancelResult{Status: CancelStatusCancelled, Acuse: []byte(acuse)}, nil
+}
+
+// newUUID genera un UUID v4 en mayúsculas, como los asigna el SAT
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generando UUID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])), nil
+}
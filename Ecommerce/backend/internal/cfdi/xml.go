@@ -0,0 +1,320 @@
+package cfdi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Comprobante es el nodo raíz de un CFDI 4.0. Los nombres llevan el prefijo cfdi: porque los PAC
+// esperan el documento con prefijos y encoding/xml no los genera a partir de un namespace.
+// Sello, NoCertificado y Certificado los completa el PAC al sellar con el CSD del emisor.
+type Comprobante struct {
+	XMLName           xml.Name   `xml:"cfdi:Comprobante"`
+	XmlnsCfdi         string     `xml:"xmlns:cfdi,attr"`
+	XmlnsXsi          string     `xml:"xmlns:xsi,attr"`
+	SchemaLocation    string     `xml:"xsi:schemaLocation,attr"`
+	Version           string     `xml:"Version,attr"`
+	Serie             string     `xml:"Serie,attr,omitempty"`
+	Folio             string     `xml:"Folio,attr,omitempty"`
+	Fecha             string     `xml:"Fecha,attr"`
+	Sello             string     `xml:"Sello,attr,omitempty"`
+	FormaPago         string     `xml:"FormaPago,attr"`
+	NoCertificado     string     `xml:"NoCertificado,attr,omitempty"`
+	Certificado       string     `xml:"Certificado,attr,omitempty"`
+	SubTotal          string     `xml:"SubTotal,attr"`
+	Descuento         string     `xml:"Descuento,attr,omitempty"`
+	Moneda            string     `xml:"Moneda,attr"`
+	TipoCambio        string     `xml:"TipoCambio,attr,omitempty"`
+	Total             string     `xml:"Total,attr"`
+	TipoDeComprobante string     `xml:"TipoDeComprobante,attr"`
+	Exportacion       string     `xml:"Exportacion,attr"`
+	MetodoPago        string     `xml:"MetodoPago,attr"`
+	LugarExpedicion   string     `xml:"LugarExpedicion,attr"`
+	Emisor            Emisor     `xml:"cfdi:Emisor"`
+	Receptor          Receptor   `xml:"cfdi:Receptor"`
+	Conceptos         []Concepto `xml:"cfdi:Conceptos>cfdi:Concepto"`
+	Impuestos         *Impuestos `xml:"cfdi:Impuestos,omitempty"`
+}
+
+// Emisor son los datos fiscales de la tienda
+type Emisor struct {
+	Rfc           string `xml:"Rfc,attr"`
+	Nombre        string `xml:"Nombre,attr"`
+	RegimenFiscal string `xml:"RegimenFiscal,attr"`
+}
+
+// Receptor son los datos fiscales del cliente tomados de su perfil
+type Receptor struct {
+	Rfc                     string `xml:"Rfc,attr"`
+	Nombre                  string `xml:"Nombre,attr"`
+	DomicilioFiscalReceptor string `xml:"DomicilioFiscalReceptor,attr"`
+	RegimenFiscalReceptor   string `xml:"RegimenFiscalReceptor,attr"`
+	UsoCFDI                 string `xml:"UsoCFDI,attr"`
+}
+
+// Concepto es una línea del comprobante
+type Concepto struct {
+	ClaveProdServ    string             `xml:"ClaveProdServ,attr"`
+	NoIdentificacion string             `xml:"NoIdentificacion,attr,omitempty"`
+	Cantidad         string             `xml:"Cantidad,attr"`
+	ClaveUnidad      string             `xml:"ClaveUnidad,attr"`
+	Descripcion      string             `xml:"Descripcion,attr"`
+	ValorUnitario    string             `xml:"ValorUnitario,attr"`
+	Importe          string             `xml:"Importe,attr"`
+	Descuento        string             `xml:"Descuento,attr,omitempty"`
+	ObjetoImp        string             `xml:"ObjetoImp,attr"` // 01 no objeto de impuesto, 02 sí objeto
+	Impuestos        *ConceptoImpuestos `xml:"cfdi:Impuestos,omitempty"`
+}
+
+// ConceptoImpuestos son los traslados de un concepto
+type ConceptoImpuestos struct {
+	Traslados []Traslado `xml:"cfdi:Traslados>cfdi:Traslado"`
+}
+
+// Traslado es un impuesto trasladado (IVA, IEPS)
+type Traslado struct {
+	Base       string `xml:"Base,attr"`
+	Impuesto   string `xml:"Impuesto,attr"`
+	TipoFactor string `xml:"TipoFactor,attr"`
+	TasaOCuota string `xml:"TasaOCuota,attr,omitempty"`
+	Importe    string `xml:"Importe,attr,omitempty"`
+}
+
+// Impuestos es el resumen de traslados del comprobante
+type Impuestos struct {
+	TotalImpuestosTrasladados string     `xml:"TotalImpuestosTrasladados,attr"`
+	Traslados                 []Traslado `xml:"cfdi:Traslados>cfdi:Traslado"`
+}
+
+// Issuer son los datos del emisor y los valores por omisión del comprobante
+type Issuer struct {
+	RFC             string
+	Nombre          string
+	RegimenFiscal   string
+	LugarExpedicion string  // Código postal del lugar de expedición
+	Serie           string  // Serie de los folios CFDI
+	FormaPago       string  // c_FormaPago, 04 tarjeta de crédito por defecto
+	ClaveProdServ   string  // Clave para los productos sin una propia
+	ExchangeRate    float64 // Tipo de cambio a MXN cuando el pedido está en otra moneda
+	Location        *time.Location
+}
+
+// Line es un producto del pedido con la descripción que se imprime en el comprobante
+type Line struct {
+	Item        models.OrderItem
+	Description string
+	SKU         string
+}
+
+// Build arma el comprobante de ingreso (PUE) de un pedido pagado. El importe de cada concepto no lleva
+// los impuestos incluidos en el precio, que se separan igual que en el cálculo del pedido, y el
+// descuento de cupones se declara aparte para que la base gravable sea el importe menos el descuento.
+func Build(issuer *Issuer, order *models.Order, lines []Line, profile *models.BillingProfile, folio int, now time.Time) (*Comprobante, error) {
+	currency := strings.ToUpper(order.Currency)
+	if currency == "" {
+		currency = "MXN"
+	}
+	c := &Comprobante{
+		XmlnsCfdi:         "http://www.sat.gob.mx/cfd/4",
+		XmlnsXsi:          "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation:    "http://www.sat.gob.mx/cfd/4 http://www.sat.gob.mx/sitio_internet/cfd/4/cfdv40.xsd",
+		Version:           "4.0",
+		Serie:             issuer.Serie,
+		Folio:             fmt.Sprintf("%d", folio),
+		Fecha:             now.In(issuer.Location).Format("2006-01-02T15:04:05"),
+		FormaPago:         issuer.FormaPago,
+		Moneda:            currency,
+		TipoDeComprobante: "I",
+		Exportacion:       "01",
+		MetodoPago:        "PUE",
+		LugarExpedicion:   issuer.LugarExpedicion,
+		Emisor: Emisor{
+			Rfc:           issuer.RFC,
+			Nombre:        issuer.Nombre,
+			RegimenFiscal: issuer.RegimenFiscal,
+		},
+		Receptor: Receptor{
+			Rfc:                     profile.RFC,
+			Nombre:                  profile.RazonSocial,
+			DomicilioFiscalReceptor: profile.CodigoPostal,
+			RegimenFiscalReceptor:   profile.RegimenFiscal,
+			UsoCFDI:                 profile.UsoCFDI,
+		},
+	}
+	if currency != "MXN" {
+		if issuer.ExchangeRate <= 0 {
+			return nil, fmt.Errorf("falta el tipo de cambio para facturar en %s", currency)
+		}
+		c.TipoCambio = fmt.Sprintf("%.6f", issuer.ExchangeRate)
+	}
+
+	type taxKey struct{ impuesto, tasa string }
+	totals := map[taxKey]*[2]float64{} // base e importe por impuesto y tasa
+	var subtotal, discount, transferred float64
+
+	for _, line := range lines {
+		item := line.Item
+		if item.Quantity <= 0 {
+			continue
+		}
+		// Base gravable de la línea: lo cobrado sin los impuestos incluidos en el precio
+		base := round2(item.Subtotal - item.Discount - inclusiveTax(item))
+		amount := base
+		var lineDiscount float64
+		if item.Discount > 0 {
+			amount = round2(item.Subtotal / (1 + inclusiveRate(item)))
+			lineDiscount = round2(amount - base)
+		}
+		concept := Concepto{
+			ClaveProdServ:    issuer.ClaveProdServ,
+			NoIdentificacion: line.SKU,
+			Cantidad:         fmt.Sprintf("%d", item.Quantity),
+			ClaveUnidad:      ClaveUnidadPieza,
+			Descripcion:      line.Description,
+			ValorUnitario:    fmt.Sprintf("%.6f", amount/float64(item.Quantity)),
+			Importe:          money(amount),
+			ObjetoImp:        "01",
+		}
+		if lineDiscount > 0 {
+			concept.Descuento = money(lineDiscount)
+		}
+
+		var traslados []Traslado
+		for _, t := range item.TaxBreakdown {
+			if t.Amount <= 0 {
+				continue
+			}
+			impuesto := taxCode(t.Name)
+			if impuesto == "" {
+				return nil, fmt.Errorf("el impuesto %q no tiene clave del SAT", t.Name)
+			}
+			tasa := fmt.Sprintf("%.6f", t.Rate)
+			traslados = append(traslados, Traslado{
+				Base:       money(t.Taxable),
+				Impuesto:   impuesto,
+				TipoFactor: "Tasa",
+				TasaOCuota: tasa,
+				Importe:    money(t.Amount),
+			})
+			key := taxKey{impuesto, tasa}
+			if totals[key] == nil {
+				totals[key] = &[2]float64{}
+			}
+			totals[key][0] += t.Taxable
+			totals[key][1] += t.Amount
+			transferred += t.Amount
+		}
+		if len(traslados) > 0 {
+			concept.ObjetoImp = "02"
+			concept.Impuestos = &ConceptoImpuestos{Traslados: traslados}
+		}
+		c.Conceptos = append(c.Conceptos, concept)
+		subtotal += amount
+		discount += lineDiscount
+	}
+
+	// El cálculo del pedido no grava el envío, así que se declara tal como se cobró
+	if order.Shipping > 0 {
+		c.Conceptos = append(c.Conceptos, Concepto{
+			ClaveProdServ: ClaveProdServEnvio,
+			Cantidad:      "1",
+			ClaveUnidad:   ClaveUnidadServicio,
+			Descripcion:   "Envío",
+			ValorUnitario: fmt.Sprintf("%.6f", order.Shipping),
+			Importe:       money(order.Shipping),
+			ObjetoImp:     "01",
+		})
+		subtotal += order.Shipping
+	}
+	if len(c.Conceptos) == 0 {
+		return nil, fmt.Errorf("el pedido no tiene conceptos para facturar")
+	}
+
+	if len(totals) > 0 {
+		keys := make([]taxKey, 0, len(totals))
+		for k := range totals {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].impuesto != keys[j].impuesto {
+				return keys[i].impuesto < keys[j].impuesto
+			}
+			return keys[i].tasa < keys[j].tasa
+		})
+		imp := &Impuestos{TotalImpuestosTrasladados: money(transferred)}
+		for _, k := range keys {
+			imp.Traslados = append(imp.Traslados, Traslado{
+				Base:       money(totals[k][0]),
+				Impuesto:   k.impuesto,
+				TipoFactor: "Tasa",
+				TasaOCuota: k.tasa,
+				Importe:    money(totals[k][1]),
+			})
+		}
+		c.Impuestos = imp
+	}
+
+	c.SubTotal = money(subtotal)
+	if discount > 0 {
+		c.Descuento = money(discount)
+	}
+	c.Total = money(subtotal - discount + transferred)
+	return c, nil
+}
+
+// Marshal serializa el comprobante con la declaración XML
+func Marshal(c *Comprobante) ([]byte, error) {
+	out, err := xml.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error generando XML del CFDI: %w", err)
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// inclusiveTax suma los impuestos incluidos en el precio de la línea
+func inclusiveTax(item models.OrderItem) float64 {
+	var total float64
+	for _, t := range item.TaxBreakdown {
+		if t.Inclusive {
+			total += t.Amount
+		}
+	}
+	return total
+}
+
+// inclusiveRate suma las tasas de los impuestos incluidos en el precio de la línea
+func inclusiveRate(item models.OrderItem) float64 {
+	var rate float64
+	for _, t := range item.TaxBreakdown {
+		if t.Inclusive {
+			rate += t.Rate
+		}
+	}
+	return rate
+}
+
+// taxCode traduce el nombre de la tasa configurada a la clave de impuesto del SAT
+func taxCode(name string) string {
+	upper := strings.ToUpper(name)
+	switch {
+	case strings.Contains(upper, "IEPS"):
+		return ImpuestoIEPS
+	case strings.Contains(upper, "IVA"), strings.Contains(upper, "VAT"):
+		return ImpuestoIVA
+	}
+	return ""
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", round2(v))
+}
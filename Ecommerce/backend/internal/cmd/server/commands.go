@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/jobs"
+)
+
+// runCommand ejecuta un subcomando de línea de comandos. Devuelve false si no hay subcomando.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "reconcile":
+		os.Exit(reconcileCommand(args[1:]))
+	case "search-bench":
+		os.Exit(searchBenchCommand(args[1:]))
+	default:
+		return false
+	}
+	return true
+}
+
+// reconcileCommand ejecuta una conciliación de pagos e imprime el reporte en JSON
+func reconcileCommand(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	reconciler := jobs.NewReconciler(db.Pool)
+	fs.DurationVar(&reconciler.Lookback, "lookback", reconciler.Lookback, "ventana de tiempo a conciliar (ej. 72h)")
+	fs.Parse(args)
+
+	run, err := reconciler.Run(context.Background(), "cli")
+	if run != nil {
+		out, _ := json.MarshalIndent(run, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error en conciliación: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// searchBenchCommand compara la búsqueda con ILIKE y la de texto completo sobre productos de prueba
+// que se insertan en una transacción y se descartan al terminar (ej. `server search-bench -products 100000`)
+func searchBenchCommand(args []string) int {
+	fs := flag.NewFlagSet("search-bench", flag.ExitOnError)
+	products := fs.Int("products", 100000, "productos de prueba a insertar")
+	runs := fs.Int("runs", 5, "repeticiones de cada consulta")
+	terms := fs.String("terms", "lampara,mesa de roble,marmol,lampra,BENCH-4242,chair", "términos a buscar, separados por comas")
+	fs.Parse(args)
+
+	if *products <= 0 || *runs <= 0 {
+		fmt.Fprintln(os.Stderr, "products y runs deben ser mayores que 0")
+		return 1
+	}
+	results, err := db.BenchmarkProductSearch(db.Pool, *products, *runs, strings.Split(*terms, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error en benchmark de búsqueda: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%-20s %12s %6s %12s %6s\n", "término", "ILIKE", "filas", "texto", "filas")
+	for _, r := range results {
+		fmt.Printf("%-20s %12s %6d %12s %6d\n", r.Term, r.ILikeTime, r.ILikeRows, r.FullText, r.FullTextRows)
+	}
+	return 0
+}
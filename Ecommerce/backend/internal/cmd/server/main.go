@@ -13,6 +13,10 @@ import (
 	"github.com/tuusuario/ecommerce-backend/internal/db"
 	"github.com/tuusuario/ecommerce-backend/internal/email"
 	"github.com/tuusuario/ecommerce-backend/internal/handlers"
+	"github.com/tuusuario/ecommerce-backend/internal/jobs"
+	"github.com/tuusuario/ecommerce-backend/internal/middleware"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
 )
 
 func main() {
@@ -30,6 +34,35 @@ func main() {
 	// Inicializar servicio de Email
 	email.InitEmailService()
 
+	// Configurar Stripe
+	payments.ConfigureStripe()
+
+	// Subcomandos de línea de comandos (ej. `server reconcile -lookback 24h`)
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	// Conciliación periódica de pagos
+	jobs.NewReconciler(db.Pool).Start(context.Background(), jobs.DurationFromEnv("RECONCILIATION_INTERVAL", time.Hour))
+
+	// Cancelación automática de pedidos sin pagar
+	jobs.NewOrderExpirer(db.Pool).Start(context.Background(), jobs.DurationFromEnv("ORDER_EXPIRATION_INTERVAL", 10*time.Minute))
+
+	// Sincronización del rastreo de envíos con la paquetería
+	jobs.NewTrackingSyncer(db.Pool, shipping.CarrierFromEnv()).Start(context.Background(), jobs.DurationFromEnv("TRACKING_SYNC_INTERVAL", 15*time.Minute))
+
+	// Limpieza de claves de idempotencia vencidas
+	jobs.NewIdempotencyCleaner(db.Pool).Start(context.Background(), time.Hour)
+
+	// Limpieza de carritos de invitado abandonados
+	jobs.NewGuestCartCleaner(db.Pool).Start(context.Background(), time.Hour)
+
+	// Recordatorios de carritos abandonados
+	jobs.NewCartRecovery(db.Pool).Start(context.Background(), jobs.DurationFromEnv("CART_RECOVERY_INTERVAL", 15*time.Minute))
+
+	// Alertas de vuelta de stock y bajada de precio
+	jobs.NewProductAlerts(db.Pool).Start(context.Background(), jobs.DurationFromEnv("PRODUCT_ALERTS_INTERVAL", 5*time.Minute))
+
 	// Inicializar Auth Handler (contiene WebAuthn)
 	authHandler, err := auth.NewAuthHandler(db.Pool)
 	if err != nil {
@@ -50,7 +83,8 @@ func main() {
 		"http://localhost:3000",                 // desarrollo local
 	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", auth.CartTokenHeader, auth.OrderTokenHeader}
+	config.ExposeHeaders = []string{auth.CartTokenHeader}
 	config.AllowCredentials = true // Permitir cookies
 	router.Use(cors.New(config))
 
@@ -83,36 +117,46 @@ func main() {
 	router.GET("/products/:id", h.GetProduct)
 	router.GET("/categories", h.GetCategories)
 	router.GET("/categories-with-count", h.GetCategoriesWithProductCount)
+	router.GET("/categories/tree", h.GetCategoryTree)
 
 	// --- Rutas de Autenticación ---
 	authRoutes := router.Group("/auth")
 	auth.AddAuthRoutes(authRoutes, authHandler)
 
+	// --- Carrito ---
+	// Funciona con usuario autenticado o como invitado con el token de X-Cart-Token
+	cart := router.Group("/api/cart")
+	cart.Use(auth.OptionalJWTMiddleware())
+	{
+		cart.GET("", h.GetCart)
+		cart.POST("/items", h.AddToCart)
+		cart.PUT("/items/:itemID", h.UpdateCartItem)
+		cart.DELETE("/items/:itemID", h.RemoveCartItem)
+		cart.POST("/items/:itemID/save-for-later", h.SaveCartItemForLater)
+		cart.POST("/clear", h.ClearCartHandler)
+		cart.POST("/coupon", h.ApplyCartCoupon)
+		cart.DELETE("/coupon", h.RemoveCartCoupon)
+		cart.POST("/restore", h.RestoreCart)
+	}
+
 	// --- Rutas Protegidas ---
 	// Requieren un JWT válido
 	api := router.Group("/api")
 	api.Use(auth.JWTMiddleware())
 	{
+		// Idempotency-Key para las rutas de checkout y pago que crean recursos
+		idempotent := middleware.NewIdempotency(db.Pool).Handler()
+
 		// Perfil de usuario
 		api.GET("/profile", h.GetUserProfile)
 		api.PUT("/profile", h.UpdateUserProfile)
 
-		// Carrito de compras
-		cart := api.Group("/cart")
-		{
-			cart.GET("", h.GetCart)
-			cart.POST("/items", h.AddToCart)
-			cart.PUT("/items/:itemID", h.UpdateCartItem)
-			cart.DELETE("/items/:itemID", h.RemoveCartItem)
-			cart.POST("/clear", h.ClearCartHandler)
-		}
-
 		// Pagos con Stripe
 		paymentHandler := handlers.NewPaymentHandler(db.Pool)
 		payments := api.Group("/payments")
 		{
-			payments.POST("/create-intent", paymentHandler.CreatePaymentIntent)
-			payments.POST("/confirm", paymentHandler.ConfirmPayment)
+			payments.POST("/create-intent", idempotent, paymentHandler.CreatePaymentIntent)
+			payments.POST("/confirm", idempotent, paymentHandler.ConfirmPayment)
 			payments.GET("/status/:paymentIntentId", paymentHandler.GetPaymentStatus)
 			payments.POST("/test-email", paymentHandler.TestEmailEndpoint)
 		}
@@ -121,15 +165,41 @@ func main() {
 		orderHandler := handlers.NewOrderHandler(db.Pool)
 		orders := api.Group("/orders")
 		{
-			orders.POST("/create", orderHandler.CreateOrderFromCart)
+			orders.POST("/create", idempotent, orderHandler.CreateOrderFromCart)
 			orders.GET("", orderHandler.GetUserOrders)
 			orders.GET("/:orderID", orderHandler.GetOrderDetails)
-			orders.POST("/:orderID/cancel", orderHandler.CancelOrder)
+			orders.POST("/:orderID/cancel", idempotent, orderHandler.CancelOrder)
+			orders.POST("/:orderID/returns", idempotent, orderHandler.CreateReturn)
+			orders.GET("/:orderID/returns", orderHandler.GetOrderReturns)
+			orders.GET("/:orderID/invoice.pdf", orderHandler.GetOrderInvoice)
+			orders.GET("/:orderID/receipt.pdf", orderHandler.GetOrderReceipt)
+			orders.POST("/:orderID/cfdi", idempotent, orderHandler.RequestOrderCFDI)
+			orders.GET("/:orderID/cfdi", orderHandler.GetOrderCFDIs)
+			orders.GET("/:orderID/cfdi/:cfdiID/xml", orderHandler.GetOrderCFDIXML)
+		}
+
+		// Checkout de invitado: el pedido queda ligado a un email y se consulta y paga con el token
+		// del enlace que se le envía (X-Order-Token o ?token=)
+		guest := router.Group("/api/guest")
+		{
+			guest.POST("/orders", idempotent, orderHandler.CreateGuestOrder)
+			guest.POST("/orders/lookup", orderHandler.LookupGuestOrder)
+			guest.GET("/orders/:orderID", orderHandler.GetGuestOrder)
+			guest.POST("/payments/create-intent", idempotent, paymentHandler.CreatePaymentIntent)
+			guest.POST("/payments/confirm", idempotent, paymentHandler.ConfirmPayment)
+			guest.GET("/payments/status/:paymentIntentId", paymentHandler.GetPaymentStatus)
 		}
 
+		// Envíos
+		shippingHandler := handlers.NewShippingHandler(db.Pool)
+		api.POST("/shipping/quote", shippingHandler.QuoteShipping)
+
 		// Webhook de Stripe (no requiere autenticación)
 		router.POST("/webhooks/stripe", paymentHandler.StripeWebhook)
 
+		// Webhook de rastreo de paquetería (firmado con CARRIER_WEBHOOK_SECRET)
+		router.POST("/webhooks/carrier/:carrier", shippingHandler.CarrierWebhook)
+
 		// Logout (esencialmente invalida el token en el lado del cliente)
 		api.POST("/logout", h.Logout)
 
@@ -142,6 +212,30 @@ func main() {
 			favorites.DELETE(":product_id", favoritesHandler.RemoveFavorite)
 		}
 
+		// Listas de deseos; :id acepta también "favorites" y "saved" (guardados para después)
+		wishlists := api.Group("/wishlists")
+		{
+			wishlists.GET("", favoritesHandler.GetWishlists)
+			wishlists.POST("", favoritesHandler.CreateWishlist)
+			wishlists.GET("/:id", favoritesHandler.GetWishlist)
+			wishlists.PUT("/:id", favoritesHandler.UpdateWishlist)
+			wishlists.DELETE("/:id", favoritesHandler.DeleteWishlist)
+			wishlists.POST("/:id/share", favoritesHandler.RegenerateWishlistShareLink)
+			wishlists.POST("/:id/items", favoritesHandler.AddWishlistItem)
+			wishlists.DELETE("/:id/items/:productID", favoritesHandler.RemoveWishlistItem)
+			wishlists.POST("/:id/items/:productID/move-to-cart", favoritesHandler.MoveWishlistItemToCart)
+		}
+
+		// Alertas de vuelta de stock y bajada de precio
+		productAlertHandler := handlers.NewProductAlertHandler(db.Pool)
+		api.GET("/product-alerts", productAlertHandler.GetProductAlerts)
+		api.POST("/product-alerts", productAlertHandler.CreateProductAlert)
+		api.DELETE("/product-alerts/:id", productAlertHandler.DeleteProductAlert)
+
+		// Listas compartidas, de solo lectura y sin autenticación
+		router.GET("/wishlists/shared/:token", favoritesHandler.GetSharedWishlist)
+		router.GET("/wishlists/public/:id", favoritesHandler.GetPublicWishlist)
+
 		// Direcciones
 		addressHandler := handlers.NewAddressHandler(db.Pool)
 		addresses := api.Group("/addresses")
@@ -154,6 +248,16 @@ func main() {
 			addresses.PUT("/:id/set-default", addressHandler.SetDefaultAddress)
 		}
 
+		// Perfiles fiscales para facturación electrónica
+		billingProfileHandler := handlers.NewBillingProfileHandler(db.Pool)
+		billingProfiles := api.Group("/billing-profiles")
+		{
+			billingProfiles.GET("", billingProfileHandler.GetBillingProfiles)
+			billingProfiles.POST("", billingProfileHandler.CreateBillingProfile)
+			billingProfiles.PUT("/:id", billingProfileHandler.UpdateBillingProfile)
+			billingProfiles.DELETE("/:id", billingProfileHandler.DeleteBillingProfile)
+		}
+
 		// Notificaciones
 		notificationHandler := handlers.NewNotificationHandlers(db.Pool)
 		notifications := api.Group("/notifications")
@@ -185,6 +289,16 @@ func main() {
 			products.POST("", adminHandler.CreateProduct)
 			products.PUT("/:id", adminHandler.UpdateProduct)
 			products.DELETE("/:id", adminHandler.DeleteProduct)
+			products.GET("/:id/attributes", adminHandler.GetProductAttributes)
+			products.PUT("/:id/attributes", adminHandler.SetProductAttributes)
+			products.GET("/:id/variants", adminHandler.GetProductVariants)
+			products.POST("/:id/variants/generate", adminHandler.GenerateProductVariants)
+			products.PUT("/:id/variants/:variantID", adminHandler.UpdateProductVariant)
+			products.GET("/:id/media", adminHandler.GetProductMedia)
+			products.POST("/:id/media", adminHandler.UploadProductMedia)
+			products.PUT("/:id/media/order", adminHandler.ReorderProductMedia)
+			products.PUT("/:id/media/:mediaID", adminHandler.UpdateProductMedia)
+			products.DELETE("/:id/media/:mediaID", adminHandler.DeleteProductMedia)
 		}
 
 		// Gestión de categorías
@@ -193,6 +307,7 @@ func main() {
 			categories.GET("", adminHandler.GetAllCategories)
 			categories.POST("", adminHandler.CreateCategory)
 			categories.PUT("/:id", adminHandler.UpdateCategory)
+			categories.PUT("/:id/move", adminHandler.MoveCategory)
 			categories.DELETE("/:id", adminHandler.DeleteCategory)
 		}
 
@@ -202,6 +317,89 @@ func main() {
 			orders.GET("", adminHandler.GetAllOrders)
 			orders.GET(":id", adminHandler.GetOrderByID)
 			orders.PUT(":id", adminHandler.UpdateOrder)
+			orders.GET(":id/shipments", adminHandler.GetOrderShipments)
+			orders.POST(":id/shipments", adminHandler.CreateOrderShipment)
+			orders.GET(":id/invoice.pdf", adminHandler.GetOrderInvoice)
+			orders.GET(":id/receipt.pdf", adminHandler.GetOrderReceipt)
+			orders.POST(":id/cfdi", adminHandler.IssueOrderCFDI)
+		}
+
+		// Guías y rastreo
+		admin.PUT("/shipments/:id", adminHandler.UpdateShipment)
+		admin.POST("/shipments/:id/cancel", adminHandler.CancelShipment)
+		admin.POST("/shipments/:id/refresh", adminHandler.RefreshShipment)
+
+		// Devoluciones (RMA)
+		returnsAdmin := admin.Group("/returns")
+		{
+			returnsAdmin.GET("", adminHandler.GetReturnRequests)
+			returnsAdmin.GET("/:id", adminHandler.GetReturnRequest)
+			returnsAdmin.POST("/:id/approve", adminHandler.ApproveReturn)
+			returnsAdmin.POST("/:id/reject", adminHandler.RejectReturn)
+			returnsAdmin.POST("/:id/receive", adminHandler.ReceiveReturn)
+			returnsAdmin.POST("/:id/restock", adminHandler.RestockReturn)
+			returnsAdmin.POST("/:id/refund", adminHandler.RetryReturnRefund)
+		}
+
+		// Facturación electrónica (CFDI)
+		cfdiAdmin := admin.Group("/cfdi")
+		{
+			cfdiAdmin.GET("", adminHandler.GetCFDIDocuments)
+			cfdiAdmin.GET("/:id", adminHandler.GetCFDIDocument)
+			cfdiAdmin.GET("/:id/xml", adminHandler.GetCFDIXML)
+			cfdiAdmin.POST("/:id/cancel", adminHandler.CancelCFDI)
+		}
+
+		// Conciliación de pagos
+		admin.GET("/reconciliation", adminHandler.GetReconciliationReport)
+
+		// Impuestos
+		taxAdmin := admin.Group("/tax")
+		{
+			taxAdmin.GET("/rates", adminHandler.GetTaxRates)
+			taxAdmin.POST("/rates", adminHandler.CreateTaxRate)
+			taxAdmin.PUT("/rates/:id", adminHandler.UpdateTaxRate)
+			taxAdmin.DELETE("/rates/:id", adminHandler.DeleteTaxRate)
+			taxAdmin.GET("/classes", adminHandler.GetTaxClasses)
+			taxAdmin.PUT("/categories/:id", adminHandler.UpdateCategoryTaxClass)
+			taxAdmin.PUT("/exemptions/:id", adminHandler.SetUserTaxExempt)
+		}
+
+		// Cupones
+		couponsAdmin := admin.Group("/coupons")
+		{
+			couponsAdmin.GET("", adminHandler.GetCoupons)
+			couponsAdmin.POST("", adminHandler.CreateCoupon)
+			couponsAdmin.GET("/report", adminHandler.GetCouponReport)
+			couponsAdmin.GET("/:id", adminHandler.GetCoupon)
+			couponsAdmin.PUT("/:id", adminHandler.UpdateCoupon)
+			couponsAdmin.DELETE("/:id", adminHandler.DeleteCoupon)
+			couponsAdmin.GET("/:id/redemptions", adminHandler.GetCouponRedemptions)
+		}
+
+		// Recuperación de carritos abandonados
+		admin.GET("/cart-recovery/report", adminHandler.GetCartRecoveryReport)
+
+		// Promociones automáticas
+		promotionsAdmin := admin.Group("/promotions")
+		{
+			promotionsAdmin.GET("", adminHandler.GetPromotions)
+			promotionsAdmin.POST("", adminHandler.CreatePromotion)
+			promotionsAdmin.GET("/:id", adminHandler.GetPromotion)
+			promotionsAdmin.PUT("/:id", adminHandler.UpdatePromotion)
+			promotionsAdmin.DELETE("/:id", adminHandler.DeletePromotion)
+		}
+
+		// Envíos
+		shippingAdmin := admin.Group("/shipping")
+		{
+			shippingAdmin.GET("/zones", adminHandler.GetShippingZones)
+			shippingAdmin.POST("/zones", adminHandler.CreateShippingZone)
+			shippingAdmin.PUT("/zones/:id", adminHandler.UpdateShippingZone)
+			shippingAdmin.DELETE("/zones/:id", adminHandler.DeleteShippingZone)
+			shippingAdmin.POST("/methods", adminHandler.CreateShippingMethod)
+			shippingAdmin.PUT("/methods/:id", adminHandler.UpdateShippingMethod)
+			shippingAdmin.DELETE("/methods/:id", adminHandler.DeleteShippingMethod)
 		}
 
 		// Rutas para gestión de usuarios
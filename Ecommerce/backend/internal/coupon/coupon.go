@@ -0,0 +1,203 @@
+package coupon
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/discount"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Tipos de cupón
+const (
+	TypePercent      = "percent"
+	TypeFixed        = "fixed"
+	TypeFreeShipping = "free_shipping"
+)
+
+// ValidType indica si el tipo de cupón existe
+func ValidType(t string) bool {
+	return t == TypePercent || t == TypeFixed || t == TypeFreeShipping
+}
+
+// Line es una línea del carrito a la que se le puede aplicar un descuento
+type Line struct {
+	ProductID  int
+	CategoryID int
+	Amount     float64 // Importe de la línea después de las promociones automáticas
+}
+
+// Usage son los usos registrados de un cupón para revisar sus límites
+type Usage struct {
+	Total    int // Usos de todos los clientes
+	Customer int // Usos del cliente actual
+}
+
+// Applied es el descuento que aportó un cupón
+type Applied struct {
+	CouponID     int     `json:"coupon_id"`
+	Code         string  `json:"code"`
+	Type         string  `json:"type"`
+	Description  string  `json:"description,omitempty"`
+	Discount     float64 `json:"discount"`
+	FreeShipping bool    `json:"free_shipping,omitempty"`
+}
+
+// Result es el descuento de un conjunto de cupones sobre un carrito
+type Result struct {
+	Lines        []float64 // Descuento de cada línea, en el mismo orden que las recibidas
+	Discount     float64   // Suma de los descuentos de las líneas
+	FreeShipping bool
+	Applied      []Applied
+}
+
+// Eligible indica si una línea entra en las restricciones de producto y categoría del cupón.
+// Sin restricciones aplica a todo; con ambas basta con cumplir una.
+func Eligible(c *models.Coupon, line Line) bool {
+	if len(c.ProductIDs) == 0 && len(c.CategoryIDs) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, id := range c.CategoryIDs {
+		if line.CategoryID != 0 && id == line.CategoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate revisa que el cupón se pueda usar ahora en el carrito recibido
+func Validate(c *models.Coupon, lines []Line, usage Usage, now time.Time) error {
+	if !c.IsActive {
+		return fmt.Errorf("el cupón %s no está activo", c.Code)
+	}
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return fmt.Errorf("el cupón %s todavía no está vigente", c.Code)
+	}
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return fmt.Errorf("el cupón %s ya expiró", c.Code)
+	}
+	if c.UsageLimit != nil && usage.Total >= *c.UsageLimit {
+		return fmt.Errorf("el cupón %s ya alcanzó su límite de usos", c.Code)
+	}
+	if c.PerCustomerLimit != nil && usage.Customer >= *c.PerCustomerLimit {
+		return fmt.Errorf("ya usaste el cupón %s el máximo de veces permitido", c.Code)
+	}
+
+	var subtotal float64
+	eligible := false
+	for _, line := range lines {
+		subtotal += line.Amount
+		if Eligible(c, line) && line.Amount > 0 {
+			eligible = true
+		}
+	}
+	if c.MinSubtotal > 0 && subtotal < c.MinSubtotal {
+		return fmt.Errorf("el cupón %s requiere un subtotal mínimo de %.2f", c.Code, c.MinSubtotal)
+	}
+	if !eligible {
+		return fmt.Errorf("el cupón %s no aplica a los productos del carrito", c.Code)
+	}
+	return nil
+}
+
+// CanStack revisa las reglas de combinación: un cupón no acumulable debe ir solo
+func CanStack(applied []models.Coupon, c *models.Coupon) error {
+	for _, other := range applied {
+		if other.ID == c.ID {
+			return fmt.Errorf("el cupón %s ya está aplicado", c.Code)
+		}
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	if !c.Stackable {
+		return fmt.Errorf("el cupón %s no se puede combinar con otros cupones", c.Code)
+	}
+	for _, other := range applied {
+		if !other.Stackable {
+			return fmt.Errorf("el cupón %s no se puede combinar con otros cupones", other.Code)
+		}
+	}
+	return nil
+}
+
+// Apply calcula el descuento de los cupones, que ya deben estar validados. Primero se aplican los
+// porcentuales y después los de importe fijo, cada uno sobre lo que queda de sus líneas elegibles;
+// el descuento se reparte entre las líneas en proporción a lo que les queda y nunca las deja en negativo.
+func Apply(coupons []models.Coupon, lines []Line) *Result {
+	result := &Result{Lines: make([]float64, len(lines))}
+	remaining := make([]int64, len(lines)) // Centavos que quedan por descontar en cada línea
+	for i, line := range lines {
+		remaining[i] = discount.Cents(line.Amount)
+	}
+
+	ordered := make([]models.Coupon, len(coupons))
+	copy(ordered, coupons)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return typeOrder(ordered[i].Type) < typeOrder(ordered[j].Type)
+	})
+
+	for i := range ordered {
+		c := &ordered[i]
+		applied := Applied{CouponID: c.ID, Code: c.Code, Type: c.Type, Description: c.Description}
+
+		weights := make([]int64, len(lines))
+		var base int64
+		for j, line := range lines {
+			if Eligible(c, line) {
+				weights[j] = remaining[j]
+				base += remaining[j]
+			}
+		}
+
+		var amount int64
+		switch c.Type {
+		case TypePercent:
+			amount = int64(math.Round(float64(base) * c.Value / 100))
+			if c.MaxDiscount != nil && *c.MaxDiscount > 0 && amount > discount.Cents(*c.MaxDiscount) {
+				amount = discount.Cents(*c.MaxDiscount)
+			}
+		case TypeFixed:
+			amount = discount.Cents(c.Value)
+		case TypeFreeShipping:
+			applied.FreeShipping = true
+			result.FreeShipping = true
+		}
+		if amount > base {
+			amount = base
+		}
+
+		if amount > 0 {
+			for j, share := range discount.Allocate(amount, weights, weights) {
+				remaining[j] -= share
+				result.Lines[j] += discount.Amount(share)
+			}
+			applied.Discount = discount.Amount(amount)
+		}
+		result.Applied = append(result.Applied, applied)
+	}
+
+	for i := range result.Lines {
+		result.Lines[i] = math.Round(result.Lines[i]*100) / 100
+		result.Discount += result.Lines[i]
+	}
+	result.Discount = math.Round(result.Discount*100) / 100
+	return result
+}
+
+func typeOrder(t string) int {
+	switch t {
+	case TypePercent:
+		return 0
+	case TypeFixed:
+		return 1
+	}
+	return 2
+}
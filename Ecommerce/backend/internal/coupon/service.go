@@ -0,0 +1,64 @@
+package coupon
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Service valida los cupones contra los usos registrados en la base de datos
+type Service struct {
+	DB *pgxpool.Pool
+}
+
+// NewService crea el servicio de cupones
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{DB: db}
+}
+
+// Rejected es un cupón del carrito que dejó de ser válido
+type Rejected struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// usage obtiene los usos del cupón; los del cliente solo se cuentan si el cupón tiene límite por
+// cliente. Del invitado aún no se conoce el email: su límite se comprueba al canjear el cupón en el
+// checkout (db.RedeemCoupons).
+func (s *Service) usage(c *models.Coupon, userID int) (Usage, error) {
+	usage := Usage{Total: c.TimesUsed}
+	if c.PerCustomerLimit != nil && userID != 0 {
+		count, err := db.CountCustomerCouponUses(s.DB, c.ID, userID)
+		if err != nil {
+			return usage, err
+		}
+		usage.Customer = count
+	}
+	return usage, nil
+}
+
+// Evaluate vuelve a validar los cupones aplicados (en el orden en que se agregaron) y calcula su
+// descuento. Los que ya no son válidos o no se pueden combinar con los anteriores se devuelven aparte.
+func (s *Service) Evaluate(coupons []models.Coupon, userID int, lines []Line) (*Result, []Rejected, error) {
+	var valid []models.Coupon
+	var rejected []Rejected
+	for i := range coupons {
+		c := &coupons[i]
+		usage, err := s.usage(c, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = Validate(c, lines, usage, time.Now())
+		if err == nil {
+			err = CanStack(valid, c)
+		}
+		if err != nil {
+			rejected = append(rejected, Rejected{Code: c.Code, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, *c)
+	}
+	return Apply(valid, lines), rejected, nil
+}
@@ -5,8 +5,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
 )
@@ -151,47 +153,30 @@ func GetAllProductsAdmin(db *pgxpool.Pool, page, limit int, search string, categ
 	return products, total, nil
 }
 
-// GetPublicProducts obtiene productos para la vista pública (activos) con filtros y paginación
-func GetPublicProducts(db *pgxpool.Pool, page, limit int, categoryID int, sortBy, order, search string, featuredOnly bool) ([]models.Product, int, error) {
+// GetPublicProducts obtiene productos para la vista pública (activos) con filtros y paginación. La
+// búsqueda usa texto completo con tolerancia a errores de escritura; con búsqueda el orden por
+// defecto es "relevance" y cada producto incluye el nombre y un fragmento resaltados.
+func GetPublicProducts(db *pgxpool.Pool, page, limit int, filter ProductFilter, sortBy, order string) ([]models.Product, int, error) {
 	offset := (page - 1) * limit
+	filter.Search = strings.TrimSpace(filter.Search)
+	search := filter.Search
 
-	baseQuery := `
-		SELECT p.id, p.name, p.description, p.price, p.image_url, p.category_id, 
+	columns := `p.id, p.name, p.description, p.price, p.image_url, p.category_id,
 			   p.stock, p.sku, p.weight, p.dimensions, p.model_url, p.is_active, p.created_at, p.updated_at,
-			   COALESCE(c.name, 'Sin categoría') as category_name
-		FROM products p
-		LEFT JOIN categories c ON p.category_id = c.id
-		WHERE p.is_active = true
-	`
-	countQuery := `SELECT COUNT(*) FROM products p WHERE p.is_active = true`
+			   p.image_variants`
 
 	var args []interface{}
-	argCount := 1
-
-	if search != "" {
-		baseQuery += fmt.Sprintf(" AND (p.name ILIKE $%d OR p.description ILIKE $%d)", argCount, argCount)
-		countQuery += fmt.Sprintf(" AND (p.name ILIKE $%d OR p.description ILIKE $%d)", argCount, argCount)
-		args = append(args, "%"+search+"%")
-		argCount++
-	}
-
-	if categoryID > 0 {
-		baseQuery += fmt.Sprintf(" AND p.category_id = $%d", argCount)
-		countQuery += fmt.Sprintf(" AND p.category_id = $%d", argCount)
-		args = append(args, categoryID)
-		argCount++
-	}
-
-	if featuredOnly {
-		baseQuery += " AND p.featured = true"
-		countQuery += " AND p.featured = true"
-	}
+	whereClause, searchArg := filter.where(&args, facetNone)
+	argCount := len(args) + 1
 
 	validSorts := map[string]string{
 		"created_at": "p.created_at",
 		"price":      "p.price",
 		"name":       "p.name",
 	}
+	if sortBy == "" && search != "" {
+		sortBy = "relevance"
+	}
 	sortColumn, ok := validSorts[sortBy]
 	if !ok {
 		sortColumn = "p.created_at"
@@ -199,8 +184,27 @@ func GetPublicProducts(db *pgxpool.Pool, page, limit int, categoryID int, sortBy
 	if order != "asc" && order != "desc" {
 		order = "desc"
 	}
+	orderBy := fmt.Sprintf("%s %s, p.id", sortColumn, order)
+	relevance := ""
+	if search != "" && sortBy == "relevance" {
+		relevance = ", " + productSearchRank(searchArg) + " AS relevance"
+		orderBy = "relevance DESC, p.id"
+	}
 
-	baseQuery += fmt.Sprintf(" ORDER BY %s %s LIMIT $%d OFFSET $%d", sortColumn, order, argCount, argCount+1)
+	baseQuery := `
+		SELECT ` + columns + `,
+			   COALESCE(c.name, 'Sin categoría') as category_name` + relevance + `
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.id` + whereClause +
+		fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", orderBy, argCount, argCount+1)
+	if search != "" {
+		// El resaltado se calcula solo para la página pedida
+		baseQuery = `
+			SELECT ` + columns + `, p.category_name, ` + productSearchHeadline(searchArg) + `
+			FROM (` + baseQuery + `) p
+			ORDER BY ` + orderBy
+	}
+	countQuery := `SELECT COUNT(*) FROM products p` + whereClause
 	argsWithPagination := append(args, limit, offset)
 
 	var total int
@@ -218,30 +222,41 @@ func GetPublicProducts(db *pgxpool.Pool, page, limit int, categoryID int, sortBy
 	var products []models.Product
 	for rows.Next() {
 		var product models.Product
-		err := rows.Scan(
+		var imageVariants []models.ImageVariant
+		dest := []interface{}{
 			&product.ID, &product.Name, &product.Description, &product.Price,
 			&product.ImageURL, &product.CategoryID, &product.Stock, &product.SKU,
 			&product.Weight, &product.Dimensions, &product.ModelURL, &product.IsActive,
-			&product.CreatedAt, &product.UpdatedAt, &product.CategoryName,
-		)
-		if err != nil {
+			&product.CreatedAt, &product.UpdatedAt, &imageVariants, &product.CategoryName,
+		}
+		if search != "" {
+			dest = append(dest, &product.HighlightedName, &product.Snippet)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, 0, fmt.Errorf("error escaneando producto: %v", err)
 		}
+		product.ImageSet = models.NewImageSet(imageVariants)
 		products = append(products, product)
 	}
 
 	return products, total, nil
 }
 
-// GetProductSuggestions busca nombres de productos para autocompletar
+// GetProductSuggestions busca nombres de productos para autocompletar. Compara sin acentos ni
+// mayúsculas y por trigramas, así que tolera errores de escritura ("lampra" sugiere "Lámpara").
 func GetProductSuggestions(db *pgxpool.Pool, query string) ([]string, error) {
 	sqlQuery := `
-		SELECT name 
-		FROM products 
-		WHERE name ILIKE $1 AND is_active = true
+		SELECT name
+		FROM products
+		WHERE is_active = true
+			AND (immutable_unaccent(lower(name)) LIKE '%' || immutable_unaccent(lower($1)) || '%'
+				OR immutable_unaccent(lower($1)) <% immutable_unaccent(lower(name)))
+		ORDER BY immutable_unaccent(lower(name)) LIKE immutable_unaccent(lower($1)) || '%' DESC,
+			word_similarity(immutable_unaccent(lower($1)), immutable_unaccent(lower(name))) DESC,
+			name
 		LIMIT 5
 	`
-	rows, err := db.Query(context.Background(), sqlQuery, "%"+query+"%")
+	rows, err := db.Query(context.Background(), sqlQuery, strings.TrimSpace(query))
 	if err != nil {
 		return nil, fmt.Errorf("error obteniendo sugerencias de productos: %v", err)
 	}
@@ -284,8 +299,20 @@ func CreateCategory(db *pgxpool.Pool, category *models.Category) (*models.Catego
 	return category, nil
 }
 
-// UpdateCategory actualiza una categoría existente
+// UpdateCategory actualiza una categoría existente. La categoría padre no puede ser la propia
+// categoría ni una de sus subcategorías.
 func UpdateCategory(db *pgxpool.Pool, category *models.Category) (*models.Category, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCategoryParent(ctx, tx, category.ID, category.ParentID); err != nil {
+		return nil, err
+	}
+
 	query := `
 		UPDATE categories 
 		SET name = $1, description = $2, image_url = $3, parent_id = $4, 
@@ -294,7 +321,7 @@ func UpdateCategory(db *pgxpool.Pool, category *models.Category) (*models.Catego
 		RETURNING id, name, description, image_url, parent_id, is_active, created_at, updated_at
 	`
 
-	err := db.QueryRow(context.Background(), query,
+	err = tx.QueryRow(ctx, query,
 		category.Name, category.Description, category.ImageURL,
 		category.ParentID, category.IsActive, category.UpdatedAt, category.ID,
 	).Scan(
@@ -303,9 +330,15 @@ func UpdateCategory(db *pgxpool.Pool, category *models.Category) (*models.Catego
 	)
 
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("categoría no encontrada")
+		}
 		return nil, fmt.Errorf("error actualizando categoría: %v", err)
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error actualizando categoría: %v", err)
+	}
 	return category, nil
 }
 
@@ -515,10 +548,10 @@ func DeleteUser(db *pgxpool.Pool, userID int) error {
 		return fmt.Errorf("error eliminando sesiones: %w", err)
 	}
 
-	// 4. Eliminar favoritos
-	_, err = tx.Exec(context.Background(), "DELETE FROM favorites WHERE user_id = $1", userID)
+	// 4. Eliminar listas de deseos (favoritos, guardados y listas con nombre)
+	_, err = tx.Exec(context.Background(), "DELETE FROM wishlists WHERE user_id = $1", userID)
 	if err != nil {
-		return fmt.Errorf("error eliminando favoritos: %w", err)
+		return fmt.Errorf("error eliminando listas de deseos: %w", err)
 	}
 
 	// 5. Eliminar direcciones
@@ -593,7 +626,7 @@ func DeleteUser(db *pgxpool.Pool, userID int) error {
 // Listar todos los pedidos con paginación
 func GetAllOrdersAdmin(db *pgxpool.Pool, page, limit int) ([]models.Order, int, error) {
 	offset := (page - 1) * limit
-	query := `SELECT id, user_id, order_number, status, subtotal, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, tracking, created_at, updated_at FROM orders ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	query := `SELECT id, COALESCE(user_id, 0), COALESCE(guest_email, ''), order_number, status, subtotal, discount, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, tracking, created_at, updated_at FROM orders ORDER BY created_at DESC LIMIT $1 OFFSET $2`
 	rows, err := db.Query(context.Background(), query, limit, offset)
 	if err != nil {
 		return nil, 0, err
@@ -606,7 +639,7 @@ func GetAllOrdersAdmin(db *pgxpool.Pool, page, limit int) ([]models.Order, int,
 		var shippingAddrJSON, billingAddrJSON []byte
 		var tracking sql.NullString
 		err := rows.Scan(
-			&order.ID, &order.UserID, &order.OrderNumber, &order.Status, &order.Subtotal, &order.Tax, &order.Shipping, &order.Total, &order.Currency, &order.PaymentStatus,
+			&order.ID, &order.UserID, &order.GuestEmail, &order.OrderNumber, &order.Status, &order.Subtotal, &order.Discount, &order.Tax, &order.Shipping, &order.Total, &order.Currency, &order.PaymentStatus,
 			&shippingAddrJSON, &billingAddrJSON, &order.Notes, &tracking, &order.CreatedAt, &order.UpdatedAt,
 		)
 		if err != nil {
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// createCartRecoveryTables crea el registro de los recordatorios de carrito abandonado. Cada
+// abandono se identifica por el carrito y la última actividad de sus items (abandoned_at): si el
+// cliente vuelve a tocar el carrito empieza una secuencia nueva.
+func createCartRecoveryTables() error {
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS cart_recovery_emails (
+			id SERIAL PRIMARY KEY,
+			cart_id INTEGER NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			step INTEGER NOT NULL,
+			abandoned_at TIMESTAMPTZ NOT NULL,
+			cart_value DECIMAL(10, 2) NOT NULL DEFAULT 0,
+			coupon_id INTEGER REFERENCES coupons(id) ON DELETE SET NULL,
+			coupon_code VARCHAR(50),
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			clicked_at TIMESTAMPTZ,
+			order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+			recovered_at TIMESTAMPTZ,
+			UNIQUE (cart_id, abandoned_at, step)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_cart_recovery_emails_sent_at ON cart_recovery_emails(sent_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_cart_recovery_emails_order_id ON cart_recovery_emails(order_id)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating cart recovery: %w", err)
+		}
+	}
+	return nil
+}
+
+// AbandonedCart es un carrito sin actividad al que le toca un recordatorio
+type AbandonedCart struct {
+	CartID      int
+	UserID      int
+	Email       string
+	Nombre      *string
+	AbandonedAt time.Time
+	ItemCount   int
+	Value       float64
+	CouponID    *int   // Cupón generado en un recordatorio anterior del mismo abandono
+	CouponCode  string // Código de ese cupón
+}
+
+// GetAbandonedCarts obtiene los carritos de usuarios que aceptan emails de marketing y que llevan
+// sin actividad desde antes de idleBefore, a los que les toca el recordatorio step (empezando en
+// 0). Desde el segundo recordatorio se exige que el anterior se haya enviado antes de
+// prevSentBefore; el primero solo se envía si el abandono es posterior a notBefore.
+func GetAbandonedCarts(db *pgxpool.Pool, step int, idleBefore, prevSentBefore, notBefore time.Time, limit int) ([]AbandonedCart, error) {
+	rows, err := db.Query(context.Background(), `
+		WITH activity AS (
+			SELECT ci.cart_id, MAX(ci.updated_at) AS abandoned_at, SUM(ci.quantity) AS item_count,
+				SUM(ci.quantity * COALESCE(v.price, p.price)) AS value
+			FROM cart_items ci
+			JOIN products p ON p.id = ci.product_id
+			LEFT JOIN product_variants v ON v.id = ci.variant_id
+			GROUP BY ci.cart_id
+		)
+		SELECT c.id, c.user_id, u.email, u.nombre, a.abandoned_at, a.item_count, a.value,
+			prev.coupon_id, COALESCE(prev.coupon_code, '')
+		FROM activity a
+		JOIN carts c ON c.id = a.cart_id AND c.user_id IS NOT NULL
+		JOIN users u ON u.id = c.user_id
+		JOIN notification_preferences np ON np.user_id = c.user_id AND np.type = 'marketing' AND np.email_enabled
+		LEFT JOIN cart_recovery_emails prev
+			ON prev.cart_id = c.id AND prev.abandoned_at = a.abandoned_at AND prev.step = $1 - 1
+		WHERE a.abandoned_at <= $2
+		  AND ($1 = 0 AND a.abandoned_at > $4 OR prev.sent_at <= $3)
+		  AND NOT EXISTS (
+			SELECT 1 FROM cart_recovery_emails e
+			WHERE e.cart_id = c.id AND e.abandoned_at = a.abandoned_at AND e.step = $1
+		  )
+		ORDER BY a.abandoned_at
+		LIMIT $5
+	`, step, idleBefore, prevSentBefore, notBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo carritos abandonados: %w", err)
+	}
+	defer rows.Close()
+
+	var carts []AbandonedCart
+	for rows.Next() {
+		var a AbandonedCart
+		if err := rows.Scan(&a.CartID, &a.UserID, &a.Email, &a.Nombre, &a.AbandonedAt, &a.ItemCount, &a.Value,
+			&a.CouponID, &a.CouponCode); err != nil {
+			return nil, fmt.Errorf("error escaneando carrito abandonado: %w", err)
+		}
+		carts = append(carts, a)
+	}
+	return carts, nil
+}
+
+// RecordCartRecoveryEmail reserva el recordatorio antes de enviarlo para no mandarlo dos veces.
+// Devuelve 0 si ya estaba registrado.
+func RecordCartRecoveryEmail(db *pgxpool.Pool, cart *AbandonedCart, step int) (int, error) {
+	var id int
+	var couponCode *string
+	if cart.CouponCode != "" {
+		couponCode = &cart.CouponCode
+	}
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO cart_recovery_emails (cart_id, user_id, step, abandoned_at, cart_value, coupon_id, coupon_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (cart_id, abandoned_at, step) DO NOTHING
+		RETURNING id
+	`, cart.CartID, cart.UserID, step, cart.AbandonedAt, cart.Value, cart.CouponID, couponCode).Scan(&id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error registrando recordatorio de carrito: %w", err)
+	}
+	return id, nil
+}
+
+// DeleteCartRecoveryEmail libera un recordatorio que no se pudo enviar para reintentarlo
+func DeleteCartRecoveryEmail(db *pgxpool.Pool, id int) error {
+	_, err := db.Exec(context.Background(), `DELETE FROM cart_recovery_emails WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error eliminando recordatorio de carrito: %w", err)
+	}
+	return nil
+}
+
+// CartRecoveryClick es el recordatorio desde el que el cliente volvió a su carrito
+type CartRecoveryClick struct {
+	CartID   int
+	UserID   int
+	CouponID *int
+}
+
+// MarkCartRecoveryClicked registra la primera vez que se abre el enlace del recordatorio
+func MarkCartRecoveryClicked(db *pgxpool.Pool, id int) (*CartRecoveryClick, error) {
+	var click CartRecoveryClick
+	err := db.QueryRow(context.Background(), `
+		UPDATE cart_recovery_emails SET clicked_at = COALESCE(clicked_at, NOW())
+		WHERE id = $1
+		RETURNING cart_id, user_id, coupon_id
+	`, id).Scan(&click.CartID, &click.UserID, &click.CouponID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("recordatorio no encontrado")
+		}
+		return nil, fmt.Errorf("error registrando apertura del recordatorio: %w", err)
+	}
+	return &click, nil
+}
+
+// AttributeCartRecovery atribuye el pedido a los recordatorios del carrito enviados dentro de la
+// ventana de atribución. Devuelve cuántos recordatorios se atribuyeron.
+func AttributeCartRecovery(db *pgxpool.Pool, cartID, orderID int, window time.Duration) (int64, error) {
+	result, err := db.Exec(context.Background(), `
+		UPDATE cart_recovery_emails SET order_id = $2, recovered_at = NOW()
+		WHERE cart_id = $1 AND order_id IS NULL AND sent_at > $3
+	`, cartID, orderID, time.Now().Add(-window))
+	if err != nil {
+		return 0, fmt.Errorf("error atribuyendo pedido a recordatorios: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// CartRecoveryStepRow resume un paso de la secuencia de recordatorios
+type CartRecoveryStepRow struct {
+	Step      int     `json:"step"` // Empieza en 1
+	Sent      int     `json:"sent"`
+	Clicked   int     `json:"clicked"`
+	Recovered int     `json:"recovered"` // Recordatorios seguidos de un pedido no cancelado
+	Revenue   float64 `json:"revenue"`   // Total de esos pedidos; un pedido cuenta en cada paso que lo precedió
+}
+
+// CartRecoveryReport resume la recuperación de carritos en un periodo
+type CartRecoveryReport struct {
+	AbandonedCarts   int                   `json:"abandoned_carts"` // Abandonos que recibieron al menos un recordatorio
+	RecoveredCarts   int                   `json:"recovered_carts"`
+	ConversionRate   float64               `json:"conversion_rate"` // Porcentaje de abandonos recuperados
+	AbandonedValue   float64               `json:"abandoned_value"`
+	RecoveredRevenue float64               `json:"recovered_revenue"`
+	CouponsIssued    int                   `json:"coupons_issued"`
+	CouponsRedeemed  int                   `json:"coupons_redeemed"`
+	Steps            []CartRecoveryStepRow `json:"steps"`
+}
+
+// GetCartRecoveryReport resume los recordatorios enviados entre dos fechas y los pedidos que
+// generaron, sin pedidos cancelados
+func GetCartRecoveryReport(db *pgxpool.Pool, from, to time.Time) (*CartRecoveryReport, error) {
+	ctx := context.Background()
+	report := &CartRecoveryReport{Steps: []CartRecoveryStepRow{}}
+
+	err := db.QueryRow(ctx, `
+		WITH episodes AS (
+			SELECT cart_id, abandoned_at, MAX(cart_value) AS value, MAX(order_id) AS order_id
+			FROM cart_recovery_emails
+			WHERE sent_at >= $1 AND sent_at < $2
+			GROUP BY cart_id, abandoned_at
+		),
+		recovered AS (
+			SELECT DISTINCT o.id, o.total
+			FROM episodes e
+			JOIN orders o ON o.id = e.order_id AND o.status <> 'cancelled'
+		)
+		SELECT
+			(SELECT COUNT(*) FROM episodes),
+			(SELECT COUNT(*) FROM episodes e JOIN orders o ON o.id = e.order_id AND o.status <> 'cancelled'),
+			(SELECT COALESCE(SUM(value), 0) FROM episodes),
+			(SELECT COALESCE(SUM(total), 0) FROM recovered)
+	`, from, to).Scan(&report.AbandonedCarts, &report.RecoveredCarts, &report.AbandonedValue, &report.RecoveredRevenue)
+	if err != nil {
+		return nil, fmt.Errorf("error generando reporte de carritos recuperados: %w", err)
+	}
+	if report.AbandonedCarts > 0 {
+		report.ConversionRate = float64(report.RecoveredCarts) * 100 / float64(report.AbandonedCarts)
+	}
+
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT e.coupon_id),
+			(SELECT COUNT(*) FROM coupon_redemptions cr
+			 JOIN orders o ON o.id = cr.order_id AND o.status <> 'cancelled'
+			 WHERE cr.coupon_id IN (
+				SELECT coupon_id FROM cart_recovery_emails WHERE sent_at >= $1 AND sent_at < $2 AND coupon_id IS NOT NULL
+			 ))
+		FROM cart_recovery_emails e
+		WHERE e.sent_at >= $1 AND e.sent_at < $2 AND e.coupon_id IS NOT NULL
+	`, from, to).Scan(&report.CouponsIssued, &report.CouponsRedeemed)
+	if err != nil {
+		return nil, fmt.Errorf("error generando reporte de cupones de recuperación: %w", err)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT e.step + 1, COUNT(*), COUNT(e.clicked_at), COUNT(o.id), COALESCE(SUM(o.total), 0)
+		FROM cart_recovery_emails e
+		LEFT JOIN orders o ON o.id = e.order_id AND o.status <> 'cancelled'
+		WHERE e.sent_at >= $1 AND e.sent_at < $2
+		GROUP BY e.step
+		ORDER BY e.step
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error generando reporte de recordatorios: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r CartRecoveryStepRow
+		if err := rows.Scan(&r.Step, &r.Sent, &r.Clicked, &r.Recovered, &r.Revenue); err != nil {
+			return nil, fmt.Errorf("error escaneando reporte de recordatorios: %w", err)
+		}
+		report.Steps = append(report.Steps, r)
+	}
+	return report, nil
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createCatalogFilterTables crea lo que necesitan los filtros del catálogo: los atributos de
+// producto, las reseñas (para filtrar por calificación) y la categoría padre, para que filtrar por
+// una categoría incluya sus subcategorías
+func createCatalogFilterTables() error {
+	migrations := []string{
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES categories(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories(parent_id)`,
+		`CREATE TABLE IF NOT EXISTS product_attributes (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			value VARCHAR(100) NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			UNIQUE (product_id, name, value)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_attributes_name_value ON product_attributes(name, value)`,
+		`CREATE TABLE IF NOT EXISTS reviews (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+			rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+			title VARCHAR(200) NOT NULL DEFAULT '',
+			comment TEXT NOT NULL DEFAULT '',
+			is_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, product_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_reviews_product_active ON reviews(product_id) WHERE is_active`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating catalog filters: %w", err)
+		}
+	}
+	return nil
+}
+
+// PriceBucketBounds son los límites de los rangos de precio de los filtros
+var PriceBucketBounds = []float64{25, 50, 100, 250, 500}
+
+// ProductFilter son los filtros del catálogo público
+type ProductFilter struct {
+	Search      string
+	CategoryIDs []int // Incluye las subcategorías
+	MinPrice    *float64
+	MaxPrice    *float64
+	InStock     bool
+	MinRating   float64
+	Attributes  map[string][]string // Nombre -> valores aceptados (cualquiera de ellos)
+	Featured    bool
+}
+
+// Dimensiones de filtro que se excluyen al contar cada grupo de facetas
+const (
+	facetNone       = ""
+	facetCategories = "categories"
+	facetPrice      = "price"
+	facetInStock    = "in_stock"
+	facetRating     = "rating"
+	facetAttribute  = "attribute:"
+)
+
+// productRatingExpr es la calificación media de las reseñas activas del producto
+const productRatingExpr = `(SELECT AVG(r.rating) FROM reviews r WHERE r.product_id = p.id AND r.is_active)`
+
+// where arma las condiciones del filtro sobre el alias p, agregando sus parámetros a args. exclude
+// omite una dimensión (ver facet*). Devuelve además el número del parámetro de la búsqueda (0 si no
+// hay búsqueda).
+func (f *ProductFilter) where(args *[]interface{}, exclude string) (string, int) {
+	conditions := []string{"p.is_active = true"}
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	searchArg := 0
+	if f.Search != "" {
+		param(f.Search)
+		searchArg = len(*args)
+		conditions = append(conditions, productSearchCondition(searchArg))
+	}
+	if len(f.CategoryIDs) > 0 && exclude != facetCategories {
+		conditions = append(conditions, fmt.Sprintf(`p.category_id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = ANY(%s)
+				UNION
+				SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+			)
+			SELECT id FROM tree)`, param(f.CategoryIDs)))
+	}
+	if exclude != facetPrice {
+		if f.MinPrice != nil {
+			conditions = append(conditions, "p.price >= "+param(*f.MinPrice))
+		}
+		if f.MaxPrice != nil {
+			conditions = append(conditions, "p.price <= "+param(*f.MaxPrice))
+		}
+	}
+	if f.InStock && exclude != facetInStock {
+		conditions = append(conditions, "p.stock > 0")
+	}
+	if f.MinRating > 0 && exclude != facetRating {
+		conditions = append(conditions, productRatingExpr+" >= "+param(f.MinRating))
+	}
+	for _, name := range f.attributeNames() {
+		if exclude == facetAttribute+name {
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM product_attributes pa WHERE pa.product_id = p.id AND pa.name = %s AND pa.value = ANY(%s))",
+			param(name), param(f.Attributes[name])))
+	}
+	if f.Featured {
+		conditions = append(conditions, "p.featured = true")
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), searchArg
+}
+
+// attributeNames devuelve los atributos filtrados en orden estable
+func (f *ProductFilter) attributeNames() []string {
+	names := make([]string, 0, len(f.Attributes))
+	for name, values := range f.Attributes {
+		if len(values) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetCatalogFacets cuenta los productos de cada opción de filtro para el filtro actual
+func GetCatalogFacets(db *pgxpool.Pool, f ProductFilter) (*models.CatalogFacets, error) {
+	ctx := context.Background()
+	facets := &models.CatalogFacets{
+		Categories:   []models.CategoryFacet{},
+		PriceBuckets: []models.PriceBucket{},
+		Attributes:   []models.AttributeFacet{},
+		Ratings:      []models.RatingFacet{},
+	}
+
+	// Categorías
+	var args []interface{}
+	where, _ := f.where(&args, facetCategories)
+	rows, err := db.Query(ctx, `
+		SELECT c.id, c.name, COUNT(*)
+		FROM products p JOIN categories c ON c.id = p.category_id`+where+`
+		GROUP BY c.id, c.name
+		ORDER BY c.name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error contando categorías: %w", err)
+	}
+	for rows.Next() {
+		var cf models.CategoryFacet
+		if err := rows.Scan(&cf.ID, &cf.Name, &cf.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		facets.Categories = append(facets.Categories, cf)
+	}
+	rows.Close()
+
+	// Rangos de precio
+	args = nil
+	where, _ = f.where(&args, facetPrice)
+	args = append(args, PriceBucketBounds)
+	rows, err = db.Query(ctx, fmt.Sprintf(`
+		SELECT width_bucket(p.price, $%d::numeric[]) AS bucket, COUNT(*), MIN(p.price), MAX(p.price)
+		FROM products p`, len(args))+where+`
+		GROUP BY bucket
+		ORDER BY bucket`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error contando rangos de precio: %w", err)
+	}
+	first := true
+	for rows.Next() {
+		var bucket, count int
+		var minPrice, maxPrice float64
+		if err := rows.Scan(&bucket, &count, &minPrice, &maxPrice); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pb := models.PriceBucket{Count: count}
+		if bucket > 0 {
+			pb.Min = PriceBucketBounds[bucket-1]
+		}
+		if bucket < len(PriceBucketBounds) {
+			max := PriceBucketBounds[bucket]
+			pb.Max = &max
+		}
+		facets.PriceBuckets = append(facets.PriceBuckets, pb)
+		if first || minPrice < facets.MinPrice {
+			facets.MinPrice = minPrice
+		}
+		if maxPrice > facets.MaxPrice {
+			facets.MaxPrice = maxPrice
+		}
+		first = false
+	}
+	rows.Close()
+
+	// Atributos: los no filtrados se cuentan juntos; cada filtrado, sin su propio filtro
+	filtered := f.attributeNames()
+	args = nil
+	where, _ = f.where(&args, facetNone)
+	args = append(args, filtered)
+	values, err := attributeFacetValues(db, where+fmt.Sprintf(" AND pa.name <> ALL($%d)", len(args)), args)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range filtered {
+		args = nil
+		where, _ = f.where(&args, facetAttribute+name)
+		args = append(args, name)
+		nameValues, err := attributeFacetValues(db, where+fmt.Sprintf(" AND pa.name = $%d", len(args)), args)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, nameValues...)
+	}
+	for _, v := range values {
+		n := len(facets.Attributes)
+		if n == 0 || facets.Attributes[n-1].Name != v.name {
+			facets.Attributes = append(facets.Attributes, models.AttributeFacet{Name: v.name})
+			n++
+		}
+		facets.Attributes[n-1].Values = append(facets.Attributes[n-1].Values, v.FacetValue)
+	}
+	sort.SliceStable(facets.Attributes, func(i, j int) bool { return facets.Attributes[i].Name < facets.Attributes[j].Name })
+
+	// Calificación
+	args = nil
+	where, _ = f.where(&args, facetRating)
+	var ratings [4]int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE rating >= 4), COUNT(*) FILTER (WHERE rating >= 3),
+			COUNT(*) FILTER (WHERE rating >= 2), COUNT(*) FILTER (WHERE rating >= 1)
+		FROM (SELECT `+productRatingExpr+` AS rating FROM products p`+where+`) rated`, args...).
+		Scan(&ratings[0], &ratings[1], &ratings[2], &ratings[3])
+	if err != nil {
+		return nil, fmt.Errorf("error contando calificaciones: %w", err)
+	}
+	for i, count := range ratings {
+		facets.Ratings = append(facets.Ratings, models.RatingFacet{MinRating: 4 - i, Count: count})
+	}
+
+	// Disponibles
+	args = nil
+	where, _ = f.where(&args, facetInStock)
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM products p`+where+` AND p.stock > 0`, args...).
+		Scan(&facets.InStock); err != nil {
+		return nil, fmt.Errorf("error contando productos disponibles: %w", err)
+	}
+
+	return facets, nil
+}
+
+// attributeFacetValue es un valor de atributo contado, con el nombre al que pertenece
+type attributeFacetValue struct {
+	name string
+	models.FacetValue
+}
+
+// attributeFacetValues cuenta los productos por nombre y valor de atributo bajo la condición dada
+func attributeFacetValues(db *pgxpool.Pool, where string, args []interface{}) ([]attributeFacetValue, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT pa.name, pa.value, COUNT(DISTINCT p.id)
+		FROM products p JOIN product_attributes pa ON pa.product_id = p.id`+where+`
+		GROUP BY pa.name, pa.value
+		ORDER BY pa.name, pa.value`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error contando atributos: %w", err)
+	}
+	defer rows.Close()
+
+	var values []attributeFacetValue
+	for rows.Next() {
+		var v attributeFacetValue
+		if err := rows.Scan(&v.name, &v.Value, &v.Count); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// GetProductAttributes obtiene los atributos de un producto en su orden
+func GetProductAttributes(db *pgxpool.Pool, productID int) ([]models.ProductAttribute, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT name, value FROM product_attributes WHERE product_id = $1 ORDER BY position, id
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := []models.ProductAttribute{}
+	for rows.Next() {
+		var a models.ProductAttribute
+		if err := rows.Scan(&a.Name, &a.Value); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, a)
+	}
+	return attributes, rows.Err()
+}
+
+// SetProductAttributes reemplaza los atributos de un producto
+func SetProductAttributes(db *pgxpool.Pool, productID int, attributes []models.ProductAttribute) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM product_attributes WHERE product_id = $1`, productID); err != nil {
+		return fmt.Errorf("error borrando atributos: %w", err)
+	}
+	for i, a := range attributes {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO product_attributes (product_id, name, value, position)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (product_id, name, value) DO NOTHING
+		`, productID, a.Name, a.Value, i); err != nil {
+			return fmt.Errorf("error guardando atributo: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
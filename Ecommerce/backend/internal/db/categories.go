@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createCategoryTreeTables agrega a categories las columnas que ya usa la administración de
+// categorías y un trigger que impide ciclos en parent_id aunque se escriba fuera de MoveCategory
+func createCategoryTreeTables() error {
+	migrations := []string{
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS description TEXT`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS image_url TEXT`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`CREATE OR REPLACE FUNCTION categories_prevent_cycle() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			IF NEW.parent_id IS NOT NULL AND EXISTS (
+				WITH RECURSIVE ancestors AS (
+					SELECT id, parent_id FROM categories WHERE id = NEW.parent_id
+					UNION
+					SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+				)
+				SELECT 1 FROM ancestors WHERE id = NEW.id
+			) THEN
+				RAISE EXCEPTION 'la categoría % no puede quedar dentro de sí misma', NEW.id
+					USING ERRCODE = 'check_violation';
+			END IF;
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS categories_prevent_cycle ON categories`,
+		`CREATE TRIGGER categories_prevent_cycle
+			BEFORE UPDATE OF parent_id ON categories
+			FOR EACH ROW EXECUTE FUNCTION categories_prevent_cycle()`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating category tree: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetCategoryTree obtiene las categorías activas como árbol, ordenadas por nombre, con el conteo
+// de productos activos de cada una y de sus subcategorías. Las subcategorías de una categoría
+// desactivada quedan ocultas con ella.
+func GetCategoryTree(db *pgxpool.Pool) ([]*models.CategoryNode, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT c.id, c.name, c.description, c.image_url, c.parent_id, c.is_active, c.created_at, c.updated_at,
+			COUNT(p.id)
+		FROM categories c
+		LEFT JOIN products p ON p.category_id = c.id AND p.is_active = true
+		WHERE c.is_active
+		GROUP BY c.id
+		ORDER BY c.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo categorías: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*models.CategoryNode
+	byID := map[int]*models.CategoryNode{}
+	for rows.Next() {
+		n := &models.CategoryNode{Children: []*models.CategoryNode{}}
+		err := rows.Scan(&n.ID, &n.Name, &n.Description, &n.ImageURL, &n.ParentID, &n.IsActive,
+			&n.CreatedAt, &n.UpdatedAt, &n.ProductCount)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando categoría: %w", err)
+		}
+		nodes = append(nodes, n)
+		byID[n.ID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*models.CategoryNode{}
+	for _, n := range nodes {
+		if n.ParentID == nil {
+			roots = append(roots, n)
+		} else if parent, ok := byID[*n.ParentID]; ok {
+			parent.Children = append(parent.Children, n)
+		}
+	}
+	for _, root := range roots {
+		sumCategoryCounts(root)
+	}
+	return roots, nil
+}
+
+// sumCategoryCounts suma al conteo de cada categoría el de sus subcategorías
+func sumCategoryCounts(n *models.CategoryNode) int {
+	for _, child := range n.Children {
+		n.ProductCount += sumCategoryCounts(child)
+	}
+	return n.ProductCount
+}
+
+// CategoryCount es una categoría con sus productos activos, incluidos los de sus subcategorías
+type CategoryCount struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id,omitempty"`
+	Count    int    `json:"count"`
+}
+
+// GetCategoriesWithProductCount obtiene las categorías activas en lista plana con el conteo
+// recursivo de productos
+func GetCategoriesWithProductCount(db *pgxpool.Pool) ([]CategoryCount, error) {
+	tree, err := GetCategoryTree(db)
+	if err != nil {
+		return nil, err
+	}
+	counts := []CategoryCount{}
+	var walk func(nodes []*models.CategoryNode)
+	walk = func(nodes []*models.CategoryNode) {
+		for _, n := range nodes {
+			counts = append(counts, CategoryCount{ID: n.ID, Name: n.Name, ParentID: n.ParentID, Count: n.ProductCount})
+			walk(n.Children)
+		}
+	}
+	walk(tree)
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Name < counts[j].Name })
+	return counts, nil
+}
+
+// GetCategoryBreadcrumbs obtiene la ruta de una categoría desde la raíz hasta ella
+func GetCategoryBreadcrumbs(db *pgxpool.Pool, categoryID int) ([]models.CategoryBreadcrumb, error) {
+	rows, err := db.Query(context.Background(), `
+		WITH RECURSIVE path AS (
+			SELECT id, name, parent_id, 0 AS depth FROM categories WHERE id = $1
+			UNION ALL
+			SELECT c.id, c.name, c.parent_id, path.depth + 1
+			FROM categories c JOIN path ON c.id = path.parent_id
+			WHERE path.depth < 20
+		)
+		SELECT id, name FROM path ORDER BY depth DESC
+	`, categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo ruta de la categoría: %w", err)
+	}
+	defer rows.Close()
+
+	breadcrumbs := []models.CategoryBreadcrumb{}
+	for rows.Next() {
+		var b models.CategoryBreadcrumb
+		if err := rows.Scan(&b.ID, &b.Name); err != nil {
+			return nil, fmt.Errorf("error escaneando ruta de la categoría: %w", err)
+		}
+		breadcrumbs = append(breadcrumbs, b)
+	}
+	return breadcrumbs, rows.Err()
+}
+
+// MoveCategory cambia la categoría padre (nil la deja en la raíz); sus subcategorías se mueven con
+// ella. No se permite moverla dentro de sí misma ni de una de sus subcategorías.
+func MoveCategory(db *pgxpool.Pool, categoryID int, parentID *int) (*models.Category, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCategoryParent(ctx, tx, categoryID, parentID); err != nil {
+		return nil, err
+	}
+
+	var category models.Category
+	err = tx.QueryRow(ctx, `
+		UPDATE categories SET parent_id = $1, updated_at = NOW()
+		WHERE id = $2
+		RETURNING id, name, description, image_url, parent_id, is_active, created_at, updated_at
+	`, parentID, categoryID).Scan(
+		&category.ID, &category.Name, &category.Description, &category.ImageURL,
+		&category.ParentID, &category.IsActive, &category.CreatedAt, &category.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("categoría no encontrada")
+		}
+		return nil, fmt.Errorf("error moviendo categoría: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando movimiento de categoría: %w", err)
+	}
+	return &category, nil
+}
+
+// checkCategoryParent bloquea el árbol de categorías hasta el final de la transacción (para que dos
+// movimientos a la vez no formen un ciclo) y comprueba que parentID exista y no sea la propia
+// categoría ni una de sus subcategorías
+func checkCategoryParent(ctx context.Context, tx pgx.Tx, categoryID int, parentID *int) error {
+	if _, err := tx.Exec(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("error bloqueando categorías: %w", err)
+	}
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == categoryID {
+		return fmt.Errorf("categoría padre inválida")
+	}
+
+	var exists, inSubtree bool
+	err := tx.QueryRow(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM categories WHERE id = $1
+			UNION
+			SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+		)
+		SELECT EXISTS (SELECT 1 FROM categories WHERE id = $2),
+			EXISTS (SELECT 1 FROM subtree WHERE id = $2)
+	`, categoryID, *parentID).Scan(&exists, &inSubtree)
+	if err != nil {
+		return fmt.Errorf("error comprobando categoría padre: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("categoría padre no encontrada")
+	}
+	if inSubtree {
+		return fmt.Errorf("categoría padre inválida")
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createCFDITables crea las tablas de perfiles fiscales y de CFDI emitidos
+func createCFDITables() error {
+	profilesTable := `
+	CREATE TABLE IF NOT EXISTS billing_profiles (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		rfc VARCHAR(13) NOT NULL,
+		razon_social VARCHAR(300) NOT NULL,
+		regimen_fiscal VARCHAR(3) NOT NULL,
+		uso_cfdi VARCHAR(4) NOT NULL,
+		codigo_postal VARCHAR(5) NOT NULL,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		is_default BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	`
+	_, err := Pool.Exec(context.Background(), profilesTable)
+	if err != nil {
+		return fmt.Errorf("error creating billing_profiles table: %w", err)
+	}
+
+	documentsTable := `
+	CREATE TABLE IF NOT EXISTS cfdi_documents (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL,
+		billing_profile_id INTEGER NOT NULL,
+		series VARCHAR(10) NOT NULL,
+		folio INTEGER NOT NULL,
+		uuid VARCHAR(36) NOT NULL DEFAULT '',
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		receptor_rfc VARCHAR(13) NOT NULL,
+		total DECIMAL(10,2) NOT NULL DEFAULT 0,
+		xml_url TEXT NOT NULL DEFAULT '',
+		pdf_url TEXT NOT NULL DEFAULT '',
+		stamped_xml TEXT NOT NULL DEFAULT '',
+		error_message TEXT NOT NULL DEFAULT '',
+		cancel_reason VARCHAR(2) NOT NULL DEFAULT '',
+		replacement_uuid VARCHAR(36) NOT NULL DEFAULT '',
+		stamped_at TIMESTAMPTZ,
+		cancelled_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (series, folio),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE RESTRICT,
+		FOREIGN KEY(billing_profile_id) REFERENCES billing_profiles(id) ON DELETE RESTRICT
+	);
+	`
+	_, err = Pool.Exec(context.Background(), documentsTable)
+	if err != nil {
+		return fmt.Errorf("error creating cfdi_documents table: %w", err)
+	}
+
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_billing_profiles_user_id ON billing_profiles(user_id)`,
+		// Un pedido solo puede tener un CFDI vigente; tras cancelarlo se puede emitir otro
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_cfdi_documents_active_order ON cfdi_documents(order_id) WHERE status <> 'cancelled'`,
+		`CREATE INDEX IF NOT EXISTS idx_cfdi_documents_uuid ON cfdi_documents(uuid)`,
+	}
+	for _, idx := range indexes {
+		if _, err := Pool.Exec(context.Background(), idx); err != nil {
+			return fmt.Errorf("error creating cfdi indexes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ===== PERFILES FISCALES =====
+
+const billingProfileColumns = `id, user_id, rfc, razon_social, regimen_fiscal, uso_cfdi, codigo_postal, email, is_default, created_at, updated_at`
+
+func scanBillingProfile(row pgx.Row) (*models.BillingProfile, error) {
+	var p models.BillingProfile
+	err := row.Scan(&p.ID, &p.UserID, &p.RFC, &p.RazonSocial, &p.RegimenFiscal, &p.UsoCFDI, &p.CodigoPostal, &p.Email,
+		&p.IsDefault, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetUserBillingProfiles obtiene los perfiles fiscales de un usuario
+func GetUserBillingProfiles(db *pgxpool.Pool, userID int) ([]models.BillingProfile, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+billingProfileColumns+` FROM billing_profiles
+		WHERE user_id = $1
+		ORDER BY is_default DESC, created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo perfiles fiscales: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := []models.BillingProfile{}
+	for rows.Next() {
+		p, err := scanBillingProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando perfil fiscal: %w", err)
+		}
+		profiles = append(profiles, *p)
+	}
+	return profiles, nil
+}
+
+// GetBillingProfileByID obtiene un perfil fiscal por su ID
+func GetBillingProfileByID(db *pgxpool.Pool, profileID int) (*models.BillingProfile, error) {
+	p, err := scanBillingProfile(db.QueryRow(context.Background(),
+		`SELECT `+billingProfileColumns+` FROM billing_profiles WHERE id = $1`, profileID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("perfil fiscal no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo perfil fiscal: %w", err)
+	}
+	return p, nil
+}
+
+// GetDefaultBillingProfile obtiene el perfil fiscal predeterminado del usuario
+func GetDefaultBillingProfile(db *pgxpool.Pool, userID int) (*models.BillingProfile, error) {
+	p, err := scanBillingProfile(db.QueryRow(context.Background(), `
+		SELECT `+billingProfileColumns+` FROM billing_profiles
+		WHERE user_id = $1
+		ORDER BY is_default DESC, created_at DESC
+		LIMIT 1
+	`, userID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("perfil fiscal no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo perfil fiscal: %w", err)
+	}
+	return p, nil
+}
+
+// CreateBillingProfile crea un perfil fiscal; si es el predeterminado desmarca los demás
+func CreateBillingProfile(db *pgxpool.Pool, p *models.BillingProfile) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// El primer perfil del usuario siempre es el predeterminado
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM billing_profiles WHERE user_id = $1`, p.UserID).Scan(&count); err != nil {
+		return fmt.Errorf("error contando perfiles fiscales: %w", err)
+	}
+	if count == 0 {
+		p.IsDefault = true
+	}
+	if p.IsDefault {
+		if _, err := tx.Exec(ctx, `UPDATE billing_profiles SET is_default = FALSE WHERE user_id = $1`, p.UserID); err != nil {
+			return fmt.Errorf("error actualizando perfiles fiscales: %w", err)
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO billing_profiles (user_id, rfc, razon_social, regimen_fiscal, uso_cfdi, codigo_postal, email, is_default)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+	`, p.UserID, p.RFC, p.RazonSocial, p.RegimenFiscal, p.UsoCFDI, p.CodigoPostal, p.Email, p.IsDefault,
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando perfil fiscal: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateBillingProfile actualiza los datos de un perfil fiscal
+func UpdateBillingProfile(db *pgxpool.Pool, p *models.BillingProfile) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if p.IsDefault {
+		if _, err := tx.Exec(ctx, `UPDATE billing_profiles SET is_default = FALSE WHERE user_id = $1 AND id <> $2`, p.UserID, p.ID); err != nil {
+			return fmt.Errorf("error actualizando perfiles fiscales: %w", err)
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE billing_profiles
+		SET rfc = $1, razon_social = $2, regimen_fiscal = $3, uso_cfdi = $4, codigo_postal = $5, email = $6,
+			is_default = $7, updated_at = NOW()
+		WHERE id = $8 AND user_id = $9
+		RETURNING updated_at
+	`, p.RFC, p.RazonSocial, p.RegimenFiscal, p.UsoCFDI, p.CodigoPostal, p.Email, p.IsDefault, p.ID, p.UserID,
+	).Scan(&p.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("perfil fiscal no encontrado")
+		}
+		return fmt.Errorf("error actualizando perfil fiscal: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteBillingProfile elimina un perfil fiscal que no se haya usado en ningún CFDI
+func DeleteBillingProfile(db *pgxpool.Pool, userID, profileID int) error {
+	var used bool
+	err := db.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM cfdi_documents WHERE billing_profile_id = $1)`, profileID).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("error verificando perfil fiscal: %w", err)
+	}
+	if used {
+		return fmt.Errorf("el perfil fiscal ya se usó en un CFDI y no se puede eliminar")
+	}
+
+	result, err := db.Exec(context.Background(),
+		`DELETE FROM billing_profiles WHERE id = $1 AND user_id = $2`, profileID, userID)
+	if err != nil {
+		return fmt.Errorf("error eliminando perfil fiscal: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("perfil fiscal no encontrado")
+	}
+	return nil
+}
+
+// ===== CFDI =====
+
+const cfdiColumns = `id, order_id, billing_profile_id, series, folio, uuid, status, receptor_rfc, total, xml_url, pdf_url,
+	error_message, cancel_reason, replacement_uuid, stamped_at, cancelled_at, created_at, updated_at`
+
+func scanCFDI(row pgx.Row) (*models.CFDIDocument, error) {
+	var d models.CFDIDocument
+	err := row.Scan(&d.ID, &d.OrderID, &d.BillingProfileID, &d.Series, &d.Folio, &d.UUID, &d.Status, &d.ReceptorRFC,
+		&d.Total, &d.XMLURL, &d.PDFURL, &d.ErrorMessage, &d.CancelReason, &d.ReplacementUUID, &d.StampedAt,
+		&d.CancelledAt, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ReserveCFDI registra el CFDI de un pedido con el siguiente folio de la serie. Si el pedido ya tiene
+// uno vigente lo devuelve; uno con error o pendiente se reutiliza (con el perfil indicado) para reintentar el timbrado.
+func ReserveCFDI(db *pgxpool.Pool, doc *models.CFDIDocument) (*models.CFDIDocument, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, doc.OrderID); err != nil {
+		return nil, fmt.Errorf("error bloqueando pedido: %w", err)
+	}
+	existing, err := scanCFDI(tx.QueryRow(ctx,
+		`SELECT `+cfdiColumns+` FROM cfdi_documents WHERE order_id = $1 AND status <> 'cancelled'`, doc.OrderID))
+	if err == nil {
+		if existing.Status == "stamped" {
+			return existing, nil
+		}
+		existing, err = scanCFDI(tx.QueryRow(ctx, `
+			UPDATE cfdi_documents
+			SET billing_profile_id = $1, receptor_rfc = $2, total = $3, status = 'pending', error_message = '', updated_at = NOW()
+			WHERE id = $4
+			RETURNING `+cfdiColumns,
+			doc.BillingProfileID, doc.ReceptorRFC, doc.Total, existing.ID))
+		if err != nil {
+			return nil, fmt.Errorf("error actualizando CFDI: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("error confirmando CFDI: %w", err)
+		}
+		return existing, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo CFDI: %w", err)
+	}
+
+	doc.Folio, err = nextFolio(ctx, tx, doc.Series)
+	if err != nil {
+		return nil, err
+	}
+	created, err := scanCFDI(tx.QueryRow(ctx, `
+		INSERT INTO cfdi_documents (order_id, billing_profile_id, series, folio, receptor_rfc, total)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+cfdiColumns,
+		doc.OrderID, doc.BillingProfileID, doc.Series, doc.Folio, doc.ReceptorRFC, doc.Total))
+	if err != nil {
+		return nil, fmt.Errorf("error registrando CFDI: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando CFDI: %w", err)
+	}
+	return created, nil
+}
+
+// GetCFDIByID obtiene un CFDI por su ID
+func GetCFDIByID(db *pgxpool.Pool, id int) (*models.CFDIDocument, error) {
+	d, err := scanCFDI(db.QueryRow(context.Background(), `SELECT `+cfdiColumns+` FROM cfdi_documents WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CFDI no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo CFDI: %w", err)
+	}
+	return d, nil
+}
+
+// GetOrderCFDIs obtiene los CFDI de un pedido, incluidos los cancelados
+func GetOrderCFDIs(db *pgxpool.Pool, orderID int) ([]models.CFDIDocument, error) {
+	rows, err := db.Query(context.Background(),
+		`SELECT `+cfdiColumns+` FROM cfdi_documents WHERE order_id = $1 ORDER BY created_at DESC`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo CFDI del pedido: %w", err)
+	}
+	defer rows.Close()
+
+	docs := []models.CFDIDocument{}
+	for rows.Next() {
+		d, err := scanCFDI(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando CFDI: %w", err)
+		}
+		docs = append(docs, *d)
+	}
+	return docs, nil
+}
+
+// GetCFDIDocuments lista los CFDI más recientes para el panel de administración, filtrando por estado
+func GetCFDIDocuments(db *pgxpool.Pool, status string, limit int) ([]models.CFDIDocument, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+cfdiColumns+` FROM cfdi_documents
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo CFDI: %w", err)
+	}
+	defer rows.Close()
+
+	docs := []models.CFDIDocument{}
+	for rows.Next() {
+		d, err := scanCFDI(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando CFDI: %w", err)
+		}
+		docs = append(docs, *d)
+	}
+	return docs, nil
+}
+
+// MarkCFDIStamped guarda el XML timbrado y las URLs de los archivos en el almacenamiento
+func MarkCFDIStamped(db *pgxpool.Pool, id int, uuid string, stampedXML []byte, xmlURL, pdfURL string, stampedAt time.Time) error {
+	_, err := db.Exec(context.Background(), `
+		UPDATE cfdi_documents
+		SET status = 'stamped', uuid = $1, stamped_xml = $2, xml_url = $3, pdf_url = $4, stamped_at = $5,
+			error_message = '', updated_at = NOW()
+		WHERE id = $6
+	`, uuid, string(stampedXML), xmlURL, pdfURL, stampedAt, id)
+	if err != nil {
+		return fmt.Errorf("error guardando timbrado del CFDI: %w", err)
+	}
+	return nil
+}
+
+// MarkCFDIError registra un error de timbrado para reintentarlo después
+func MarkCFDIError(db *pgxpool.Pool, id int, message string) error {
+	_, err := db.Exec(context.Background(), `
+		UPDATE cfdi_documents SET status = 'error', error_message = $1, updated_at = NOW() WHERE id = $2
+	`, message, id)
+	if err != nil {
+		return fmt.Errorf("error guardando error del CFDI: %w", err)
+	}
+	return nil
+}
+
+// UpdateCFDICancellation registra el resultado de una solicitud de cancelación: cancel_pending mientras
+// el receptor no la acepta, cancelled cuando el SAT la confirma o stamped si fue rechazada
+func UpdateCFDICancellation(db *pgxpool.Pool, id int, status, reason, replacementUUID string) error {
+	result, err := db.Exec(context.Background(), `
+		UPDATE cfdi_documents
+		SET status = $1, cancel_reason = $2, replacement_uuid = $3,
+			cancelled_at = CASE WHEN $1 = 'cancelled' THEN NOW() ELSE NULL END,
+			updated_at = NOW()
+		WHERE id = $4 AND status IN ('stamped', 'cancel_pending')
+	`, status, reason, replacementUUID, id)
+	if err != nil {
+		return fmt.Errorf("error cancelando CFDI: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("el CFDI no está timbrado")
+	}
+	return nil
+}
+
+// GetCFDIStampedXML obtiene el XML timbrado guardado en la base de datos
+func GetCFDIStampedXML(db *pgxpool.Pool, id int) ([]byte, error) {
+	var data string
+	err := db.QueryRow(context.Background(), `SELECT stamped_xml FROM cfdi_documents WHERE id = $1`, id).Scan(&data)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("CFDI no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo XML del CFDI: %w", err)
+	}
+	if data == "" {
+		return nil, fmt.Errorf("el CFDI no está timbrado")
+	}
+	return []byte(data), nil
+}
@@ -0,0 +1,463 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createCouponTables crea las tablas de cupones, los cupones aplicados a carritos y sus usos
+func createCouponTables() error {
+	couponsTable := `
+	CREATE TABLE IF NOT EXISTS coupons (
+		id SERIAL PRIMARY KEY,
+		code VARCHAR(50) NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		type VARCHAR(20) NOT NULL,
+		value DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (value >= 0),
+		min_subtotal DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		max_discount DECIMAL(10, 2),
+		product_ids INTEGER[] NOT NULL DEFAULT '{}',
+		category_ids INTEGER[] NOT NULL DEFAULT '{}',
+		usage_limit INTEGER,
+		per_customer_limit INTEGER,
+		starts_at TIMESTAMPTZ,
+		ends_at TIMESTAMPTZ,
+		stackable BOOLEAN NOT NULL DEFAULT FALSE,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	`
+	_, err := Pool.Exec(context.Background(), couponsTable)
+	if err != nil {
+		return fmt.Errorf("error creating coupons table: %w", err)
+	}
+
+	cartCouponsTable := `
+	CREATE TABLE IF NOT EXISTS cart_coupons (
+		cart_id INTEGER NOT NULL,
+		coupon_id INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (cart_id, coupon_id),
+		FOREIGN KEY(cart_id) REFERENCES carts(id) ON DELETE CASCADE,
+		FOREIGN KEY(coupon_id) REFERENCES coupons(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), cartCouponsTable)
+	if err != nil {
+		return fmt.Errorf("error creating cart_coupons table: %w", err)
+	}
+
+	redemptionsTable := `
+	CREATE TABLE IF NOT EXISTS coupon_redemptions (
+		id SERIAL PRIMARY KEY,
+		coupon_id INTEGER NOT NULL,
+		order_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		code VARCHAR(50) NOT NULL,
+		discount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		shipping_discount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (coupon_id, order_id),
+		FOREIGN KEY(coupon_id) REFERENCES coupons(id) ON DELETE RESTRICT,
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), redemptionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating coupon_redemptions table: %w", err)
+	}
+
+	migrations := []string{
+		`CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_user ON coupon_redemptions(coupon_id, user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_created_at ON coupon_redemptions(created_at)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating coupon columns: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// NormalizeCouponCode deja el código como se guarda: sin espacios y en mayúsculas
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Los usos de pedidos cancelados no cuentan para los límites
+const couponUsesQuery = `
+	SELECT COUNT(*) FROM coupon_redemptions cr
+	JOIN orders o ON o.id = cr.order_id
+	WHERE cr.coupon_id = c.id AND o.status <> 'cancelled'`
+
+const couponColumns = `c.id, c.code, c.description, c.type, c.value, c.min_subtotal, c.max_discount, c.product_ids,
+	c.category_ids, c.usage_limit, c.per_customer_limit, c.starts_at, c.ends_at, c.stackable, c.is_active,
+	(` + couponUsesQuery + `), c.created_at, c.updated_at`
+
+func scanCoupon(row pgx.Row) (*models.Coupon, error) {
+	var c models.Coupon
+	err := row.Scan(&c.ID, &c.Code, &c.Description, &c.Type, &c.Value, &c.MinSubtotal, &c.MaxDiscount, &c.ProductIDs,
+		&c.CategoryIDs, &c.UsageLimit, &c.PerCustomerLimit, &c.StartsAt, &c.EndsAt, &c.Stackable, &c.IsActive,
+		&c.TimesUsed, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func scanCoupons(rows pgx.Rows) ([]models.Coupon, error) {
+	defer rows.Close()
+	coupons := []models.Coupon{}
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando cupón: %w", err)
+		}
+		coupons = append(coupons, *c)
+	}
+	return coupons, nil
+}
+
+// GetCoupons lista los cupones, opcionalmente solo los activos
+func GetCoupons(db *pgxpool.Pool, activeOnly bool) ([]models.Coupon, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons c`
+	if activeOnly {
+		query += " WHERE c.is_active = true"
+	}
+	query += " ORDER BY c.created_at DESC"
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo cupones: %w", err)
+	}
+	return scanCoupons(rows)
+}
+
+// GetCouponByID obtiene un cupón por su ID
+func GetCouponByID(db *pgxpool.Pool, couponID int) (*models.Coupon, error) {
+	c, err := scanCoupon(db.QueryRow(context.Background(),
+		`SELECT `+couponColumns+` FROM coupons c WHERE c.id = $1`, couponID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("cupón no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo cupón: %w", err)
+	}
+	return c, nil
+}
+
+// GetCouponByCode obtiene un cupón por su código (sin distinguir mayúsculas)
+func GetCouponByCode(db *pgxpool.Pool, code string) (*models.Coupon, error) {
+	c, err := scanCoupon(db.QueryRow(context.Background(),
+		`SELECT `+couponColumns+` FROM coupons c WHERE c.code = $1`, NormalizeCouponCode(code)))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("cupón no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo cupón: %w", err)
+	}
+	return c, nil
+}
+
+// CreateCoupon crea un cupón
+func CreateCoupon(db *pgxpool.Pool, c *models.Coupon) error {
+	c.Code = NormalizeCouponCode(c.Code)
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO coupons (code, description, type, value, min_subtotal, max_discount, product_ids, category_ids,
+			usage_limit, per_customer_limit, starts_at, ends_at, stackable, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, created_at, updated_at
+	`, c.Code, c.Description, c.Type, c.Value, c.MinSubtotal, c.MaxDiscount, intArray(c.ProductIDs), intArray(c.CategoryIDs),
+		c.UsageLimit, c.PerCustomerLimit, c.StartsAt, c.EndsAt, c.Stackable, c.IsActive,
+	).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("ya existe un cupón con el código %s", c.Code)
+		}
+		return fmt.Errorf("error creando cupón: %w", err)
+	}
+	return nil
+}
+
+// UpdateCoupon actualiza un cupón existente
+func UpdateCoupon(db *pgxpool.Pool, c *models.Coupon) error {
+	c.Code = NormalizeCouponCode(c.Code)
+	err := db.QueryRow(context.Background(), `
+		UPDATE coupons
+		SET code = $1, description = $2, type = $3, value = $4, min_subtotal = $5, max_discount = $6,
+			product_ids = $7, category_ids = $8, usage_limit = $9, per_customer_limit = $10, starts_at = $11,
+			ends_at = $12, stackable = $13, is_active = $14, updated_at = NOW()
+		WHERE id = $15
+		RETURNING updated_at
+	`, c.Code, c.Description, c.Type, c.Value, c.MinSubtotal, c.MaxDiscount, intArray(c.ProductIDs), intArray(c.CategoryIDs),
+		c.UsageLimit, c.PerCustomerLimit, c.StartsAt, c.EndsAt, c.Stackable, c.IsActive, c.ID,
+	).Scan(&c.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("cupón no encontrado")
+		}
+		if strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("ya existe un cupón con el código %s", c.Code)
+		}
+		return fmt.Errorf("error actualizando cupón: %w", err)
+	}
+	return nil
+}
+
+// DeleteCoupon elimina un cupón. Si ya se usó en algún pedido solo se desactiva para conservar
+// el historial; el resultado indica si se desactivó en lugar de eliminarse.
+func DeleteCoupon(db *pgxpool.Pool, couponID int) (bool, error) {
+	var used bool
+	err := db.QueryRow(context.Background(),
+		`SELECT EXISTS(SELECT 1 FROM coupon_redemptions WHERE coupon_id = $1)`, couponID).Scan(&used)
+	if err != nil {
+		return false, fmt.Errorf("error revisando usos del cupón: %w", err)
+	}
+
+	query := `DELETE FROM coupons WHERE id = $1`
+	if used {
+		query = `UPDATE coupons SET is_active = false, updated_at = NOW() WHERE id = $1`
+	}
+	result, err := db.Exec(context.Background(), query, couponID)
+	if err != nil {
+		return false, fmt.Errorf("error eliminando cupón: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return false, fmt.Errorf("cupón no encontrado")
+	}
+	return used, nil
+}
+
+// intArray evita guardar NULL en las columnas INTEGER[] cuando la lista está vacía
+func intArray(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
+
+// ===== CUPONES DEL CARRITO =====
+
+// GetCartCoupons obtiene los cupones aplicados a un carrito en el orden en que se agregaron
+func GetCartCoupons(db *pgxpool.Pool, cartID int) ([]models.Coupon, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+couponColumns+`
+		FROM cart_coupons cc
+		JOIN coupons c ON c.id = cc.coupon_id
+		WHERE cc.cart_id = $1
+		ORDER BY cc.created_at, c.id
+	`, cartID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo cupones del carrito: %w", err)
+	}
+	return scanCoupons(rows)
+}
+
+// AddCartCoupon aplica un cupón al carrito
+func AddCartCoupon(db *pgxpool.Pool, cartID, couponID int) error {
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO cart_coupons (cart_id, coupon_id) VALUES ($1, $2)
+		ON CONFLICT (cart_id, coupon_id) DO NOTHING
+	`, cartID, couponID)
+	if err != nil {
+		return fmt.Errorf("error aplicando cupón al carrito: %w", err)
+	}
+	return nil
+}
+
+// RemoveCartCoupon quita un cupón del carrito
+func RemoveCartCoupon(db *pgxpool.Pool, cartID, couponID int) error {
+	result, err := db.Exec(context.Background(),
+		`DELETE FROM cart_coupons WHERE cart_id = $1 AND coupon_id = $2`, cartID, couponID)
+	if err != nil {
+		return fmt.Errorf("error quitando cupón del carrito: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("el cupón no está aplicado al carrito")
+	}
+	return nil
+}
+
+// ClearCartCoupons quita todos los cupones del carrito
+func ClearCartCoupons(db *pgxpool.Pool, cartID int) error {
+	_, err := db.Exec(context.Background(), `DELETE FROM cart_coupons WHERE cart_id = $1`, cartID)
+	if err != nil {
+		return fmt.Errorf("error quitando cupones del carrito: %w", err)
+	}
+	return nil
+}
+
+// ===== USOS =====
+
+// CountCustomerCouponUses cuenta los usos de un cupón por un cliente, sin pedidos cancelados. Incluye
+// los que hizo como invitado con el email de su cuenta.
+func CountCustomerCouponUses(db *pgxpool.Pool, couponID, userID int) (int, error) {
+	var count int
+	err := db.QueryRow(context.Background(), `
+		SELECT COUNT(*) FROM coupon_redemptions cr
+		JOIN orders o ON o.id = cr.order_id
+		WHERE cr.coupon_id = $1 AND o.status <> 'cancelled'
+			AND (cr.user_id = $2 OR cr.guest_email = (SELECT LOWER(email) FROM users WHERE id = $2))
+	`, couponID, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error contando usos del cupón: %w", err)
+	}
+	return count, nil
+}
+
+// RedeemCoupons registra el uso de los cupones de un pedido. Cada cupón se bloquea y sus límites
+// se vuelven a revisar dentro de la transacción, así dos pedidos simultáneos no pueden superar el
+// límite total ni el del cliente. Si algún límite se alcanzó no se registra ningún uso.
+//
+// El cliente se identifica por su cuenta o por su email: los usos como invitado se guardan con el
+// email normalizado y cuentan junto con los de la cuenta que tenga ese email, así el límite por
+// cliente no se salta comprando como invitado.
+func RedeemCoupons(db *pgxpool.Pool, redemptions []models.CouponRedemption) error {
+	if len(redemptions) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	customerID := redemptions[0].UserID
+	customerEmail := strings.ToLower(strings.TrimSpace(redemptions[0].GuestEmail))
+	if customerID != 0 {
+		err = tx.QueryRow(ctx, `SELECT LOWER(email) FROM users WHERE id = $1`, customerID).Scan(&customerEmail)
+	} else if customerEmail != "" {
+		err = tx.QueryRow(ctx, `SELECT id FROM users WHERE LOWER(email) = $1`, customerEmail).Scan(&customerID)
+	}
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("error obteniendo cliente del cupón: %w", err)
+	}
+
+	for i := range redemptions {
+		r := &redemptions[i]
+		var usageLimit, perCustomerLimit *int
+		err := tx.QueryRow(ctx, `SELECT usage_limit, per_customer_limit FROM coupons WHERE id = $1 FOR UPDATE`, r.CouponID).
+			Scan(&usageLimit, &perCustomerLimit)
+		if err != nil {
+			return fmt.Errorf("error bloqueando cupón: %w", err)
+		}
+
+		var total, byCustomer int
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(*), COUNT(*) FILTER (WHERE cr.user_id = $2 OR cr.guest_email = $3)
+			FROM coupon_redemptions cr
+			JOIN orders o ON o.id = cr.order_id
+			WHERE cr.coupon_id = $1 AND o.status <> 'cancelled'
+		`, r.CouponID, customerID, customerEmail).Scan(&total, &byCustomer)
+		if err != nil {
+			return fmt.Errorf("error contando usos del cupón: %w", err)
+		}
+		if usageLimit != nil && total >= *usageLimit {
+			return fmt.Errorf("el cupón %s ya alcanzó su límite de usos", r.Code)
+		}
+		if perCustomerLimit != nil && byCustomer >= *perCustomerLimit {
+			return fmt.Errorf("ya usaste el cupón %s el máximo de veces permitido", r.Code)
+		}
+
+		// Solo los usos como invitado guardan el email; los de una cuenta van por user_id
+		guestEmail := ""
+		if r.UserID == 0 {
+			guestEmail = customerEmail
+			r.GuestEmail = customerEmail
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO coupon_redemptions (coupon_id, order_id, user_id, guest_email, code, discount, shipping_discount)
+			VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5, $6, $7)
+			RETURNING id, created_at
+		`, r.CouponID, r.OrderID, r.UserID, guestEmail, r.Code, r.Discount, r.ShippingDiscount).Scan(&r.ID, &r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("error registrando uso del cupón: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando usos de cupones: %w", err)
+	}
+	return nil
+}
+
+// GetCouponRedemptions lista los usos de un cupón, los más recientes primero
+func GetCouponRedemptions(db *pgxpool.Pool, couponID, limit int) ([]models.CouponRedemption, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT cr.id, cr.coupon_id, cr.order_id, o.order_number, COALESCE(cr.user_id, 0), COALESCE(cr.guest_email, ''),
+			cr.code, cr.discount, cr.shipping_discount, cr.created_at
+		FROM coupon_redemptions cr
+		JOIN orders o ON o.id = cr.order_id
+		WHERE cr.coupon_id = $1
+		ORDER BY cr.created_at DESC
+		LIMIT $2
+	`, couponID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo usos del cupón: %w", err)
+	}
+	defer rows.Close()
+
+	redemptions := []models.CouponRedemption{}
+	for rows.Next() {
+		var r models.CouponRedemption
+		if err := rows.Scan(&r.ID, &r.CouponID, &r.OrderID, &r.OrderNumber, &r.UserID, &r.GuestEmail, &r.Code,
+			&r.Discount, &r.ShippingDiscount, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando uso del cupón: %w", err)
+		}
+		redemptions = append(redemptions, r)
+	}
+	return redemptions, nil
+}
+
+// CouponReportRow resume los usos de un cupón en un periodo
+type CouponReportRow struct {
+	CouponID         int     `json:"coupon_id"`
+	Code             string  `json:"code"`
+	Type             string  `json:"type"`
+	Redemptions      int     `json:"redemptions"`
+	Customers        int     `json:"customers"`
+	Discount         float64 `json:"discount"`
+	ShippingDiscount float64 `json:"shipping_discount"`
+	Revenue          float64 `json:"revenue"` // Total cobrado en los pedidos que usaron el cupón
+}
+
+// GetCouponReport resume los usos de cada cupón entre dos fechas, sin pedidos cancelados
+func GetCouponReport(db *pgxpool.Pool, from, to time.Time) ([]CouponReportRow, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT c.id, c.code, c.type, COUNT(*), COUNT(DISTINCT cr.user_id),
+			COALESCE(SUM(cr.discount), 0), COALESCE(SUM(cr.shipping_discount), 0), COALESCE(SUM(o.total), 0)
+		FROM coupon_redemptions cr
+		JOIN coupons c ON c.id = cr.coupon_id
+		JOIN orders o ON o.id = cr.order_id
+		WHERE cr.created_at >= $1 AND cr.created_at < $2 AND o.status <> 'cancelled'
+		GROUP BY c.id, c.code, c.type
+		ORDER BY COUNT(*) DESC, c.code
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error generando reporte de cupones: %w", err)
+	}
+	defer rows.Close()
+
+	report := []CouponReportRow{}
+	for rows.Next() {
+		var r CouponReportRow
+		if err := rows.Scan(&r.CouponID, &r.Code, &r.Type, &r.Redemptions, &r.Customers, &r.Discount,
+			&r.ShippingDiscount, &r.Revenue); err != nil {
+			return nil, fmt.Errorf("error escaneando reporte de cupones: %w", err)
+		}
+		report = append(report, r)
+	}
+	return report, nil
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// createGuestCartTables adapta la tabla de carritos para los carritos de invitado, que no tienen
+// usuario y se identifican por un token aleatorio hasta que vencen
+func createGuestCartTables() error {
+	migrations := []string{
+		`ALTER TABLE carts ALTER COLUMN user_id DROP NOT NULL`,
+		`ALTER TABLE carts ADD COLUMN IF NOT EXISTS guest_token VARCHAR(64) UNIQUE`,
+		`ALTER TABLE carts ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+		`CREATE INDEX IF NOT EXISTS idx_carts_guest_expires_at ON carts(expires_at) WHERE user_id IS NULL`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating guest carts: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateGuestCart crea un carrito de invitado que vence tras ttl sin actividad
+func CreateGuestCart(db *pgxpool.Pool, guestToken string, ttl time.Duration) (int, error) {
+	var cartID int
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO carts (guest_token, expires_at) VALUES ($1, $2) RETURNING id
+	`, guestToken, time.Now().Add(ttl)).Scan(&cartID)
+	if err != nil {
+		return 0, fmt.Errorf("error creando carrito de invitado: %w", err)
+	}
+	return cartID, nil
+}
+
+// FindGuestCart obtiene el carrito de invitado vigente y extiende su vencimiento
+func FindGuestCart(db *pgxpool.Pool, guestToken string, ttl time.Duration) (int, error) {
+	var cartID int
+	err := db.QueryRow(context.Background(), `
+		UPDATE carts SET expires_at = $2, updated_at = NOW()
+		WHERE guest_token = $1 AND user_id IS NULL AND expires_at > NOW()
+		RETURNING id
+	`, guestToken, time.Now().Add(ttl)).Scan(&cartID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("carrito no encontrado")
+		}
+		return 0, fmt.Errorf("error obteniendo carrito de invitado: %w", err)
+	}
+	return cartID, nil
+}
+
+// DeleteExpiredGuestCarts elimina los carritos de invitado vencidos con sus items y cupones
+func DeleteExpiredGuestCarts(db *pgxpool.Pool) (int64, error) {
+	result, err := db.Exec(context.Background(), `DELETE FROM carts WHERE user_id IS NULL AND expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("error eliminando carritos de invitado vencidos: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+// CartMergeResult resume la fusión del carrito de invitado con el del usuario
+type CartMergeResult struct {
+	MergedItems       int   `json:"merged_items"`
+	LimitedProductIDs []int `json:"limited_product_ids"` // Cantidad recortada al stock disponible
+	SkippedProductIDs []int `json:"skipped_product_ids"` // Productos inactivos o agotados que no se pasaron
+}
+
+// MergeGuestCart pasa los items del carrito de invitado al carrito del usuario sumando las
+// cantidades sin superar el stock, conserva sus cupones y elimina el carrito de invitado. Si el
+// carrito de invitado no existe o ya venció no hace nada.
+func MergeGuestCart(db *pgxpool.Pool, guestToken string, userID int) (*CartMergeResult, error) {
+	ctx := context.Background()
+	result := &CartMergeResult{LimitedProductIDs: []int{}, SkippedProductIDs: []int{}}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var guestCartID int
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM carts WHERE guest_token = $1 AND user_id IS NULL AND expires_at > NOW() FOR UPDATE
+	`, guestToken).Scan(&guestCartID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return result, nil
+		}
+		return nil, fmt.Errorf("error obteniendo carrito de invitado: %w", err)
+	}
+
+	var userCartID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO carts (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = NOW()
+		RETURNING id
+	`, userID).Scan(&userCartID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo carrito del usuario: %w", err)
+	}
+
+	type guestItem struct {
+		productID, quantity, existing, stock int
+		variantID                            *int
+		seenPrice                            *float64
+		isActive                             bool
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT gi.product_id, gi.variant_id, gi.quantity, COALESCE(ui.quantity, 0), gi.seen_price,
+			COALESCE(v.stock, p.stock), p.is_active AND COALESCE(v.is_active, TRUE)
+		FROM cart_items gi
+		JOIN products p ON p.id = gi.product_id
+		LEFT JOIN product_variants v ON v.id = gi.variant_id
+		LEFT JOIN cart_items ui ON ui.cart_id = $2 AND ui.product_id = gi.product_id
+			AND ui.variant_id IS NOT DISTINCT FROM gi.variant_id
+		WHERE gi.cart_id = $1
+		ORDER BY gi.created_at
+	`, guestCartID, userCartID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo items del carrito de invitado: %w", err)
+	}
+	var items []guestItem
+	for rows.Next() {
+		var item guestItem
+		if err := rows.Scan(&item.productID, &item.variantID, &item.quantity, &item.existing, &item.seenPrice, &item.stock, &item.isActive); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando item del carrito de invitado: %w", err)
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	for _, item := range items {
+		if !item.isActive || item.stock <= 0 {
+			result.SkippedProductIDs = append(result.SkippedProductIDs, item.productID)
+			continue
+		}
+		quantity := item.existing + item.quantity
+		if quantity > item.stock {
+			quantity = item.stock
+			result.LimitedProductIDs = append(result.LimitedProductIDs, item.productID)
+		}
+		// Nunca se reduce lo que el usuario ya tenía en su carrito
+		if quantity <= item.existing {
+			continue
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, seen_price)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (cart_id, product_id, COALESCE(variant_id, 0)) DO UPDATE SET quantity = $4, updated_at = NOW()
+		`, userCartID, item.productID, item.variantID, quantity, item.seenPrice)
+		if err != nil {
+			return nil, fmt.Errorf("error pasando item al carrito del usuario: %w", err)
+		}
+		result.MergedItems++
+	}
+
+	// Los cupones se vuelven a validar al calcular el carrito
+	_, err = tx.Exec(ctx, `
+		INSERT INTO cart_coupons (cart_id, coupon_id)
+		SELECT $1, coupon_id FROM cart_coupons WHERE cart_id = $2
+		ON CONFLICT DO NOTHING
+	`, userCartID, guestCartID)
+	if err != nil {
+		return nil, fmt.Errorf("error pasando cupones al carrito del usuario: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM carts WHERE id = $1`, guestCartID); err != nil {
+		return nil, fmt.Errorf("error eliminando carrito de invitado: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando fusión de carritos: %w", err)
+	}
+	return result, nil
+}
+
+// GetCartItemCartID obtiene el carrito al que pertenece un item
+func GetCartItemCartID(db *pgxpool.Pool, cartItemID int) (int, error) {
+	var cartID int
+	err := db.QueryRow(context.Background(), `SELECT cart_id FROM cart_items WHERE id = $1`, cartItemID).Scan(&cartID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("item no encontrado")
+		}
+		return 0, fmt.Errorf("error obteniendo item del carrito: %w", err)
+	}
+	return cartID, nil
+}
+
+// ClearCartItems elimina todos los items de un carrito
+func ClearCartItems(db *pgxpool.Pool, cartID int) error {
+	_, err := db.Exec(context.Background(), "DELETE FROM cart_items WHERE cart_id = $1", cartID)
+	if err != nil {
+		return fmt.Errorf("error limpiando carrito: %w", err)
+	}
+	return nil
+}
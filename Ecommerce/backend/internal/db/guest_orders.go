@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/ordernumber"
+)
+
+// createGuestOrderTables permite pedidos sin usuario, ligados solo al email del invitado
+func createGuestOrderTables() error {
+	migrations := []string{
+		`ALTER TABLE orders ALTER COLUMN user_id DROP NOT NULL`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS guest_email VARCHAR(255)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_guest_email ON orders(LOWER(guest_email)) WHERE user_id IS NULL`,
+		`ALTER TABLE coupon_redemptions ALTER COLUMN user_id DROP NOT NULL`,
+		`ALTER TABLE coupon_redemptions ADD COLUMN IF NOT EXISTS guest_email VARCHAR(255)`,
+		`CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_guest ON coupon_redemptions(coupon_id, guest_email) WHERE guest_email IS NOT NULL`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating guest orders: %w", err)
+		}
+	}
+	return nil
+}
+
+// FindGuestOrderID busca un pedido de invitado por su número y el email con el que se hizo
+func FindGuestOrderID(db *pgxpool.Pool, email, orderNumber string) (int, error) {
+	var orderID int
+	err := db.QueryRow(context.Background(), `
+		SELECT id FROM orders
+		WHERE user_id IS NULL AND LOWER(guest_email) = LOWER($1)
+		  AND (order_number = $2 OR legacy_order_number = $2)
+	`, strings.TrimSpace(email), ordernumber.Normalize(orderNumber)).Scan(&orderID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("pedido no encontrado")
+		}
+		return 0, fmt.Errorf("error buscando pedido de invitado: %w", err)
+	}
+	return orderID, nil
+}
+
+// ClaimGuestOrders asigna al usuario los pedidos de invitado hechos con su email, junto con los
+// canjes de cupones de esos pedidos. Devuelve cuántos pedidos se reclamaron.
+func ClaimGuestOrders(db *pgxpool.Pool, userID int, email string) (int64, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE orders SET user_id = $1, updated_at = NOW()
+		WHERE user_id IS NULL AND LOWER(guest_email) = LOWER($2)
+		RETURNING id
+	`, userID, strings.TrimSpace(email))
+	if err != nil {
+		return 0, fmt.Errorf("error reclamando pedidos de invitado: %w", err)
+	}
+	var orderIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error escaneando pedido reclamado: %w", err)
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE coupon_redemptions SET user_id = $1 WHERE user_id IS NULL AND order_id = ANY($2)
+	`, userID, orderIDs)
+	if err != nil {
+		return 0, fmt.Errorf("error reclamando canjes de cupones: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error confirmando pedidos reclamados: %w", err)
+	}
+	return int64(len(orderIDs)), nil
+}
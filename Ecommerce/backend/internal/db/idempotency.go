@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createIdempotencyTables crea la tabla de claves de idempotencia
+func createIdempotencyTables() error {
+	table := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope VARCHAR(255) NOT NULL,
+		idempotency_key VARCHAR(255) NOT NULL,
+		fingerprint VARCHAR(64) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'in_progress',
+		response_status INTEGER NOT NULL DEFAULT 0,
+		response_body BYTEA,
+		content_type VARCHAR(100) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (scope, idempotency_key)
+	);
+	`
+	if _, err := Pool.Exec(context.Background(), table); err != nil {
+		return fmt.Errorf("error creating idempotency_keys table: %w", err)
+	}
+	if _, err := Pool.Exec(context.Background(), "CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);"); err != nil {
+		return fmt.Errorf("error creating idempotency index: %w", err)
+	}
+	if _, err := Pool.Exec(context.Background(), "ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ NOT NULL DEFAULT NOW()"); err != nil {
+		return fmt.Errorf("error migrating idempotency_keys: %w", err)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey reserva la clave para procesar la petición durante lease. Si la clave ya existe
+// y no ha expirado devuelve claimed=false junto con el registro existente. Una reserva in_progress
+// cuyo lease venció (el proceso se cayó a mitad de la petición) la retoma un reintento con el mismo
+// body en lugar de bloquear la clave hasta que expire.
+func ClaimIdempotencyKey(db *pgxpool.Pool, rec *models.IdempotencyRecord, ttl, lease time.Duration) (bool, *models.IdempotencyRecord, error) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// Una clave expirada se puede reutilizar
+	if _, err := db.Exec(ctx, `DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2 AND expires_at < $3`,
+		rec.Scope, rec.Key, now); err != nil {
+		return false, nil, fmt.Errorf("error liberando clave expirada: %w", err)
+	}
+
+	result, err := db.Exec(ctx, `
+		INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, status, locked_until, created_at, expires_at)
+		VALUES ($1, $2, $3, 'in_progress', $4, $5, $6)
+		ON CONFLICT (scope, idempotency_key) DO UPDATE
+		SET locked_until = EXCLUDED.locked_until, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.status = 'in_progress' AND idempotency_keys.locked_until < EXCLUDED.created_at
+			AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+	`, rec.Scope, rec.Key, rec.Fingerprint, now.Add(lease), now, now.Add(ttl))
+	if err != nil {
+		return false, nil, fmt.Errorf("error reservando clave de idempotencia: %w", err)
+	}
+	if result.RowsAffected() == 1 {
+		rec.Status = "in_progress"
+		rec.LockedUntil = now.Add(lease)
+		rec.CreatedAt = now
+		rec.ExpiresAt = now.Add(ttl)
+		return true, rec, nil
+	}
+
+	existing, err := GetIdempotencyKey(db, rec.Scope, rec.Key)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, existing, nil
+}
+
+// ExtendIdempotencyLease renueva la reserva de una petición que sigue en curso
+func ExtendIdempotencyLease(db *pgxpool.Pool, scope, key string, lease time.Duration) error {
+	_, err := db.Exec(context.Background(), `
+		UPDATE idempotency_keys SET locked_until = $1
+		WHERE scope = $2 AND idempotency_key = $3 AND status = 'in_progress'
+	`, time.Now().Add(lease), scope, key)
+	if err != nil {
+		return fmt.Errorf("error renovando clave de idempotencia: %w", err)
+	}
+	return nil
+}
+
+// GetIdempotencyKey obtiene el registro de una clave de idempotencia
+func GetIdempotencyKey(db *pgxpool.Pool, scope, key string) (*models.IdempotencyRecord, error) {
+	var rec models.IdempotencyRecord
+	err := db.QueryRow(context.Background(), `
+		SELECT scope, idempotency_key, fingerprint, status, response_status, COALESCE(response_body, ''::bytea), content_type,
+			locked_until, created_at, expires_at
+		FROM idempotency_keys
+		WHERE scope = $1 AND idempotency_key = $2
+	`, scope, key).Scan(&rec.Scope, &rec.Key, &rec.Fingerprint, &rec.Status, &rec.ResponseStatus, &rec.ResponseBody,
+		&rec.ContentType, &rec.LockedUntil, &rec.CreatedAt, &rec.ExpiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("clave de idempotencia no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo clave de idempotencia: %w", err)
+	}
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey guarda la respuesta para repetirla en reintentos
+func CompleteIdempotencyKey(db *pgxpool.Pool, rec *models.IdempotencyRecord) error {
+	_, err := db.Exec(context.Background(), `
+		UPDATE idempotency_keys
+		SET status = 'completed', response_status = $1, response_body = $2, content_type = $3
+		WHERE scope = $4 AND idempotency_key = $5
+	`, rec.ResponseStatus, rec.ResponseBody, rec.ContentType, rec.Scope, rec.Key)
+	if err != nil {
+		return fmt.Errorf("error guardando respuesta idempotente: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey elimina una clave para que la petición se pueda reintentar
+func ReleaseIdempotencyKey(db *pgxpool.Pool, scope, key string) error {
+	_, err := db.Exec(context.Background(), `DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2`, scope, key)
+	if err != nil {
+		return fmt.Errorf("error liberando clave de idempotencia: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys elimina las claves vencidas
+func DeleteExpiredIdempotencyKeys(db *pgxpool.Pool) (int64, error) {
+	result, err := db.Exec(context.Background(), `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("error eliminando claves de idempotencia vencidas: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
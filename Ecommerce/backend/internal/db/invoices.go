@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createInvoiceTables crea las tablas de facturas y de folios por serie
+func createInvoiceTables() error {
+	sequencesTable := `
+	CREATE TABLE IF NOT EXISTS invoice_sequences (
+		series VARCHAR(10) PRIMARY KEY,
+		last_number INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err := Pool.Exec(context.Background(), sequencesTable)
+	if err != nil {
+		return fmt.Errorf("error creating invoice_sequences table: %w", err)
+	}
+
+	invoicesTable := `
+	CREATE TABLE IF NOT EXISTS invoices (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL,
+		type VARCHAR(20) NOT NULL,
+		series VARCHAR(10) NOT NULL,
+		number INTEGER NOT NULL,
+		customer_name VARCHAR(255) NOT NULL DEFAULT '',
+		customer_email VARCHAR(255) NOT NULL DEFAULT '',
+		customer_tax_id VARCHAR(50) NOT NULL DEFAULT '',
+		payment_reference VARCHAR(255) NOT NULL DEFAULT '',
+		issued_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (order_id, type),
+		UNIQUE (series, number),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE RESTRICT
+	);
+	`
+	_, err = Pool.Exec(context.Background(), invoicesTable)
+	if err != nil {
+		return fmt.Errorf("error creating invoices table: %w", err)
+	}
+
+	return nil
+}
+
+const invoiceColumns = `id, order_id, type, series, number, customer_name, customer_email, customer_tax_id, payment_reference, issued_at`
+
+func scanInvoice(row pgx.Row) (*models.Invoice, error) {
+	var inv models.Invoice
+	err := row.Scan(&inv.ID, &inv.OrderID, &inv.Type, &inv.Series, &inv.Number, &inv.CustomerName, &inv.CustomerEmail,
+		&inv.CustomerTaxID, &inv.PaymentReference, &inv.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.FullNumber = fmt.Sprintf("%s-%06d", inv.Series, inv.Number)
+	return &inv, nil
+}
+
+// GetOrderInvoice obtiene la factura o recibo emitido para un pedido
+func GetOrderInvoice(db *pgxpool.Pool, orderID int, invoiceType string) (*models.Invoice, error) {
+	inv, err := scanInvoice(db.QueryRow(context.Background(),
+		`SELECT `+invoiceColumns+` FROM invoices WHERE order_id = $1 AND type = $2`, orderID, invoiceType))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("factura no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo factura: %w", err)
+	}
+	return inv, nil
+}
+
+// IssueInvoice emite la factura o recibo de un pedido con el siguiente folio de la serie. El folio
+// se toma en la misma transacción que el registro, así que un error no deja huecos en la numeración.
+// Si el pedido ya tenía documento de ese tipo, devuelve el existente.
+func IssueInvoice(db *pgxpool.Pool, inv *models.Invoice) (*models.Invoice, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Serializa la emisión por pedido para no emitir dos documentos del mismo tipo
+	if _, err := tx.Exec(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, inv.OrderID); err != nil {
+		return nil, fmt.Errorf("error bloqueando pedido: %w", err)
+	}
+	existing, err := scanInvoice(tx.QueryRow(ctx,
+		`SELECT `+invoiceColumns+` FROM invoices WHERE order_id = $1 AND type = $2`, inv.OrderID, inv.Type))
+	if err == nil {
+		return existing, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo factura: %w", err)
+	}
+
+	inv.Number, err = nextFolio(ctx, tx, inv.Series)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO invoices (order_id, type, series, number, customer_name, customer_email, customer_tax_id, payment_reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, issued_at
+	`, inv.OrderID, inv.Type, inv.Series, inv.Number, inv.CustomerName, inv.CustomerEmail, inv.CustomerTaxID, inv.PaymentReference,
+	).Scan(&inv.ID, &inv.IssuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error emitiendo factura: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando factura: %w", err)
+	}
+	inv.FullNumber = fmt.Sprintf("%s-%06d", inv.Series, inv.Number)
+	return inv, nil
+}
+
+// nextFolio toma el siguiente folio de la serie. La fila de la serie queda bloqueada hasta el
+// commit, así que un rollback devuelve el folio y la numeración no tiene huecos.
+func nextFolio(ctx context.Context, tx pgx.Tx, series string) (int, error) {
+	var number int
+	err := tx.QueryRow(ctx, `
+		INSERT INTO invoice_sequences (series, last_number) VALUES ($1, 1)
+		ON CONFLICT (series) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number
+	`, series).Scan(&number)
+	if err != nil {
+		return 0, fmt.Errorf("error asignando folio: %w", err)
+	}
+	return number, nil
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createOrderHistoryTables crea la tabla de historial de estados de pedidos
+func createOrderHistoryTables() error {
+	historyTable := `
+	CREATE TABLE IF NOT EXISTS order_status_history (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL,
+		from_status VARCHAR(30) NOT NULL DEFAULT '',
+		to_status VARCHAR(30) NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		actor VARCHAR(20) NOT NULL DEFAULT 'system',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE
+	);
+	`
+	_, err := Pool.Exec(context.Background(), historyTable)
+	if err != nil {
+		return fmt.Errorf("error creating order_status_history table: %w", err)
+	}
+
+	_, err = Pool.Exec(context.Background(), "CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id);")
+	if err != nil {
+		return fmt.Errorf("error creating index: %w", err)
+	}
+
+	return nil
+}
+
+// AddOrderHistory registra un cambio de estado en el historial del pedido
+func AddOrderHistory(db *pgxpool.Pool, entry *models.OrderStatusHistory) error {
+	query := `
+		INSERT INTO order_status_history (order_id, from_status, to_status, note, actor)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	err := db.QueryRow(context.Background(), query,
+		entry.OrderID, entry.FromStatus, entry.ToStatus, entry.Note, entry.Actor,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error guardando historial del pedido: %w", err)
+	}
+	return nil
+}
+
+// GetOrderHistory obtiene el historial de estados de un pedido
+func GetOrderHistory(db *pgxpool.Pool, orderID int) ([]models.OrderStatusHistory, error) {
+	query := `
+		SELECT id, order_id, from_status, to_status, note, actor, created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := db.Query(context.Background(), query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo historial del pedido: %w", err)
+	}
+	defer rows.Close()
+
+	var history []models.OrderStatusHistory
+	for rows.Next() {
+		var h models.OrderStatusHistory
+		if err := rows.Scan(&h.ID, &h.OrderID, &h.FromStatus, &h.ToStatus, &h.Note, &h.Actor, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando historial: %w", err)
+		}
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// GetUnpaidOrdersCreatedBefore obtiene los pedidos pendientes de pago creados antes de la fecha indicada.
+// Si onlyWithoutReminder es true, solo devuelve los que aún no recibieron recordatorio; si no, son los
+// pedidos a cancelar y se omiten los que fallaron hace menos de expirationRetryAfter, para que unos
+// pocos pedidos que no se pueden cancelar no bloqueen al resto de la cola.
+func GetUnpaidOrdersCreatedBefore(db *pgxpool.Pool, before time.Time, onlyWithoutReminder bool) ([]models.Order, error) {
+	query := `
+		SELECT id, COALESCE(user_id, 0), order_number, status, total, currency, payment_status, created_at, updated_at
+		FROM orders
+		WHERE status = 'pending' AND payment_status IN ('pending', 'failed') AND created_at < $1
+	`
+	args := []interface{}{before}
+	if onlyWithoutReminder {
+		query += " AND payment_reminder_sent_at IS NULL ORDER BY created_at ASC"
+	} else {
+		query += ` AND (expiration_failed_at IS NULL OR expiration_failed_at < $2)
+			ORDER BY expiration_failed_at ASC NULLS FIRST, created_at ASC`
+		args = append(args, time.Now().Add(-expirationRetryAfter))
+	}
+	query += " LIMIT 200"
+
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo pedidos sin pagar: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(
+			&order.ID, &order.UserID, &order.OrderNumber, &order.Status, &order.Total,
+			&order.Currency, &order.PaymentStatus, &order.CreatedAt, &order.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando pedido: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// expirationRetryAfter es la espera antes de reintentar la cancelación de un pedido que falló
+const expirationRetryAfter = time.Hour
+
+// MarkOrderExpirationFailed registra que no se pudo cancelar el pedido vencido; se reintenta pasado
+// expirationRetryAfter
+func MarkOrderExpirationFailed(db *pgxpool.Pool, orderID int) error {
+	_, err := db.Exec(context.Background(), `UPDATE orders SET expiration_failed_at = NOW() WHERE id = $1`, orderID)
+	if err != nil {
+		return fmt.Errorf("error marcando fallo de cancelación: %w", err)
+	}
+	return nil
+}
+
+// MarkPaymentReminderSent marca que ya se envió el recordatorio de pago del pedido
+func MarkPaymentReminderSent(db *pgxpool.Pool, orderID int) error {
+	_, err := db.Exec(context.Background(), `UPDATE orders SET payment_reminder_sent_at = NOW() WHERE id = $1`, orderID)
+	if err != nil {
+		return fmt.Errorf("error marcando recordatorio de pago: %w", err)
+	}
+	return nil
+}
+
+// MarkOrderPaid marca el pedido como pagado salvo que esté cancelado. Devuelve false si lo está: su
+// stock ya se repuso y el cobro hay que revisarlo a mano. La condición va en el mismo UPDATE para que
+// no se cruce con CancelUnpaidOrder, que solo cancela pedidos sin pagar.
+func MarkOrderPaid(db *pgxpool.Pool, orderID int) (bool, error) {
+	result, err := db.Exec(context.Background(), `
+		UPDATE orders
+		SET payment_status = 'paid', updated_at = NOW()
+		WHERE id = $1 AND status <> 'cancelled'
+	`, orderID)
+	if err != nil {
+		return false, fmt.Errorf("error actualizando estado de pago: %w", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// CancelUnpaidOrder cancela un pedido pendiente de pago, repone el stock y registra el historial.
+// Devuelve false si el pedido ya no estaba pendiente (por ejemplo, se pagó mientras tanto).
+func CancelUnpaidOrder(db *pgxpool.Pool, orderID int, note, actor string) (bool, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE orders
+		SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND status = 'pending' AND payment_status IN ('pending', 'failed')
+	`, orderID)
+	if err != nil {
+		return false, fmt.Errorf("error cancelando pedido: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	// Reponer el stock de los productos del pedido (y de sus variantes)
+	// y avisar a los suscritos de los que estaban agotados
+	_, err = tx.Exec(ctx, `
+		WITH variant_stock AS (
+			UPDATE product_variants v
+			SET stock = v.stock + oi.quantity, updated_at = NOW()
+			FROM (
+				SELECT variant_id, SUM(quantity) AS quantity FROM order_items
+				WHERE order_id = $1 AND variant_id IS NOT NULL GROUP BY variant_id
+			) oi
+			WHERE v.id = oi.variant_id
+		), stock AS (
+			UPDATE products p
+			SET stock = p.stock + oi.quantity, updated_at = NOW()
+			FROM (SELECT product_id, SUM(quantity) AS quantity FROM order_items WHERE order_id = $1 GROUP BY product_id) oi
+			WHERE p.id = oi.product_id
+			RETURNING p.id, p.price, p.stock, p.is_active, oi.quantity
+		)
+		INSERT INTO product_alert_events (product_id, kind, old_price, new_price)
+		SELECT id, 'back_in_stock', price, price FROM stock
+		WHERE is_active AND stock > 0 AND stock - quantity <= 0
+	`, orderID)
+	if err != nil {
+		return false, fmt.Errorf("error reponiendo stock: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, note, actor)
+		VALUES ($1, 'pending', 'cancelled', $2, $3)
+	`, orderID, note, actor)
+	if err != nil {
+		return false, fmt.Errorf("error guardando historial del pedido: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("error confirmando cancelación: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/ordernumber"
+)
+
+// createOrderNumberTables crea la secuencia de números de pedido y migra los números antiguos
+func createOrderNumberTables() error {
+	migrations := []string{
+		`CREATE SEQUENCE IF NOT EXISTS order_number_seq START 1`,
+		// Se conserva el número anterior para que los clientes puedan seguir buscándolo
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS legacy_order_number VARCHAR(50)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_legacy_order_number ON orders(legacy_order_number)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating order numbers: %w", err)
+		}
+	}
+
+	return migrateLegacyOrderNumbers(Pool, ordernumber.FromEnv())
+}
+
+// migrateLegacyOrderNumbers renumera los pedidos con el formato ORD-<unix>-<user_id> en orden de creación
+func migrateLegacyOrderNumbers(db *pgxpool.Pool, gen *ordernumber.Generator) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, created_at FROM orders
+		WHERE order_number LIKE 'ORD-%' AND legacy_order_number IS NULL
+		ORDER BY created_at ASC, id ASC
+		FOR UPDATE
+	`)
+	if err != nil {
+		return fmt.Errorf("error obteniendo pedidos a migrar: %w", err)
+	}
+	type legacyOrder struct {
+		id        int
+		createdAt time.Time
+	}
+	var orders []legacyOrder
+	for rows.Next() {
+		var o legacyOrder
+		if err := rows.Scan(&o.id, &o.createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("error escaneando pedido a migrar: %w", err)
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if len(orders) == 0 {
+		return nil
+	}
+
+	for _, o := range orders {
+		var seq int64
+		if err := tx.QueryRow(ctx, `SELECT nextval('order_number_seq')`).Scan(&seq); err != nil {
+			return fmt.Errorf("error obteniendo secuencia de pedidos: %w", err)
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE orders SET legacy_order_number = order_number, order_number = $1 WHERE id = $2
+		`, gen.Format(o.createdAt.Year(), seq), o.id)
+		if err != nil {
+			return fmt.Errorf("error migrando número del pedido %d: %w", o.id, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando migración de números de pedido: %w", err)
+	}
+	fmt.Printf("Números de pedido migrados: %d\n", len(orders))
+	return nil
+}
+
+// NextOrderNumber genera el siguiente número de pedido a partir de la secuencia
+func NextOrderNumber(db *pgxpool.Pool, gen *ordernumber.Generator) (string, error) {
+	var seq int64
+	if err := db.QueryRow(context.Background(), `SELECT nextval('order_number_seq')`).Scan(&seq); err != nil {
+		return "", fmt.Errorf("error generando número de pedido: %w", err)
+	}
+	return gen.Format(time.Now().Year(), seq), nil
+}
+
+// GetOrderIDByNumber obtiene el ID de un pedido por su número actual o anterior
+func GetOrderIDByNumber(db *pgxpool.Pool, orderNumber string) (int, error) {
+	var orderID int
+	err := db.QueryRow(context.Background(), `
+		SELECT id FROM orders WHERE order_number = $1 OR legacy_order_number = $1 LIMIT 1
+	`, ordernumber.Normalize(orderNumber)).Scan(&orderID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("pedido no encontrado")
+		}
+		return 0, fmt.Errorf("error buscando pedido: %w", err)
+	}
+	return orderID, nil
+}
@@ -279,23 +279,6 @@ func createTables() error {
 		return fmt.Errorf("error creating payments table: %w", err)
 	}
 
-	// Crear tabla de favoritos
-	favoritesTable := `
-	CREATE TABLE IF NOT EXISTS favorites (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		product_id INTEGER NOT NULL,
-		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-		UNIQUE(user_id, product_id),
-		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
-		FOREIGN KEY(product_id) REFERENCES products(id) ON DELETE CASCADE
-	);
-	`
-	_, err = Pool.Exec(context.Background(), favoritesTable)
-	if err != nil {
-		return fmt.Errorf("error creating favorites table: %w", err)
-	}
-
 	// Crear tabla de direcciones
 	addressesTable := `
 	CREATE TABLE IF NOT EXISTS addresses (
@@ -342,11 +325,121 @@ func createTables() error {
 		}
 	}
 
+	// Tablas de conciliación de pagos
+	if err := createReconciliationTables(); err != nil {
+		return err
+	}
+
+	// Historial de estados de pedidos
+	if err := createOrderHistoryTables(); err != nil {
+		return err
+	}
+
+	// Tasas de impuesto
+	if err := createTaxTables(); err != nil {
+		return err
+	}
+
+	// Zonas y métodos de envío
+	if err := createShippingTables(); err != nil {
+		return err
+	}
+
+	// Envíos con paquetería y rastreo
+	if err := createShipmentTables(); err != nil {
+		return err
+	}
+
+	// Devoluciones y reembolsos
+	if err := createReturnTables(); err != nil {
+		return err
+	}
+
+	// Secuencia de números de pedido
+	if err := createOrderNumberTables(); err != nil {
+		return err
+	}
+
+	// Claves de idempotencia
+	if err := createIdempotencyTables(); err != nil {
+		return err
+	}
+
+	// Facturas y recibos
+	if err := createInvoiceTables(); err != nil {
+		return err
+	}
+
+	// Facturación electrónica (CFDI)
+	if err := createCFDITables(); err != nil {
+		return err
+	}
+
+	// Cupones y códigos promocionales
+	if err := createCouponTables(); err != nil {
+		return err
+	}
+
+	// Promociones automáticas
+	if err := createPromotionTables(); err != nil {
+		return err
+	}
+
+	// Carritos de invitado
+	if err := createGuestCartTables(); err != nil {
+		return err
+	}
+
+	// Pedidos de invitado
+	if err := createGuestOrderTables(); err != nil {
+		return err
+	}
+
+	// Recuperación de carritos abandonados
+	if err := createCartRecoveryTables(); err != nil {
+		return err
+	}
+
+	// Listas de deseos (reemplazan a la tabla de favoritos)
+	if err := createWishlistTables(); err != nil {
+		return err
+	}
+
+	// Alertas de vuelta de stock y bajada de precio
+	if err := createProductAlertTables(); err != nil {
+		return err
+	}
+
 	// Agregar columnas nuevas si no existen (para migración)
 	if err := addNewColumnsIfNotExist(); err != nil {
 		return fmt.Errorf("error adding new columns: %w", err)
 	}
 
+	// Búsqueda de texto completo (usa columnas que agrega la migración anterior)
+	if err := createProductSearchTables(); err != nil {
+		return err
+	}
+
+	// Atributos, reseñas y subcategorías para los filtros del catálogo
+	if err := createCatalogFilterTables(); err != nil {
+		return err
+	}
+
+	// Opciones y variantes de producto, enlazadas con el carrito y los pedidos
+	if err := createProductVariantTables(); err != nil {
+		return err
+	}
+
+	// Árbol de categorías (usa parent_id de la migración de filtros)
+	if err := createCategoryTreeTables(); err != nil {
+		return err
+	}
+
+	// Galería de imágenes, modelos 3D y videos de producto
+	if err := createProductMediaTables(); err != nil {
+		return err
+	}
+
 	fmt.Println("Tablas creadas/verificadas exitosamente")
 	return nil
 }
@@ -407,6 +500,8 @@ func addNewColumnsIfNotExist() error {
 		{"shipping_address", "JSONB"},
 		{"billing_address", "JSONB"},
 		{"notes", "TEXT"},
+		{"payment_reminder_sent_at", "TIMESTAMPTZ"},
+		{"expiration_failed_at", "TIMESTAMPTZ"},
 	}
 
 	for _, col := range orderColumns {
@@ -479,6 +574,12 @@ func addNewColumnsIfNotExist() error {
 		return err
 	}
 
+	// Migración: precio que el cliente vio por última vez en cada item del carrito, para avisarle si cambia
+	_, err = Pool.Exec(context.Background(), `ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS seen_price DECIMAL(10, 2)`)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -733,10 +834,12 @@ func FindOrCreateCartByUserID(db *pgxpool.Pool, userID int) (int, error) {
 // GetCartContents obtiene todos los items de un carrito con sus detalles de producto.
 func GetCartContents(db *pgxpool.Pool, cartID int) ([]models.CartItem, error) {
 	query := `
-		SELECT ci.id, ci.product_id, ci.quantity, ci.created_at, ci.updated_at,
-			   p.name, p.price, p.image_url
+		SELECT ci.id, ci.product_id, ci.variant_id, ci.quantity, ci.seen_price, ci.created_at, ci.updated_at,
+			   COALESCE(p.name || ' (' || v.label || ')', p.name), COALESCE(v.price, p.price),
+			   COALESCE(v.image_urls[1], p.image_url)
 		FROM cart_items ci
 		JOIN products p ON ci.product_id = p.id
+		LEFT JOIN product_variants v ON v.id = ci.variant_id
 		WHERE ci.cart_id = $1
 		ORDER BY ci.created_at DESC
 	`
@@ -751,7 +854,7 @@ func GetCartContents(db *pgxpool.Pool, cartID int) ([]models.CartItem, error) {
 		var item models.CartItem
 		var product models.Product
 		err := rows.Scan(
-			&item.ID, &item.ProductID, &item.Quantity,
+			&item.ID, &item.ProductID, &item.VariantID, &item.Quantity, &item.SeenPrice,
 			&item.CreatedAt, &item.UpdatedAt,
 			&product.Name, &product.Price, &product.ImageURL,
 		)
@@ -764,31 +867,67 @@ func GetCartContents(db *pgxpool.Pool, cartID int) ([]models.CartItem, error) {
 	return items, nil
 }
 
-// AddItemToCart agrega un producto al carrito o actualiza su cantidad si ya existe.
-func AddItemToCart(db *pgxpool.Pool, cartID, productID, quantity int) error {
-	// Obtener stock actual del producto
+// AddItemToCart agrega un producto (o una de sus variantes) al carrito o actualiza su cantidad si ya
+// existe. Los productos con variantes solo se pueden agregar eligiendo una.
+func AddItemToCart(db *pgxpool.Pool, cartID, productID int, variantID *int, quantity int) error {
+	// Obtener stock y precio actuales del producto o de la variante
 	var stock int
-	err := db.QueryRow(context.Background(), "SELECT stock FROM products WHERE id = $1", productID).Scan(&stock)
-	if err != nil {
-		return fmt.Errorf("error obteniendo stock del producto: %w", err)
+	var price float64
+	if variantID != nil {
+		var isActive bool
+		err := db.QueryRow(context.Background(), `
+			SELECT v.stock, COALESCE(v.price, p.price), v.is_active AND p.is_active
+			FROM product_variants v
+			JOIN products p ON p.id = v.product_id
+			WHERE v.id = $1 AND v.product_id = $2
+		`, *variantID, productID).Scan(&stock, &price, &isActive)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("variante no encontrada")
+			}
+			return fmt.Errorf("error obteniendo stock de la variante: %w", err)
+		}
+		if !isActive {
+			return fmt.Errorf("variante no disponible")
+		}
+	} else {
+		hasVariants, err := ProductHasVariants(db, productID)
+		if err != nil {
+			return fmt.Errorf("error obteniendo variantes del producto: %w", err)
+		}
+		if hasVariants {
+			return fmt.Errorf("el producto requiere elegir una variante")
+		}
+		err = db.QueryRow(context.Background(), "SELECT stock, price FROM products WHERE id = $1", productID).Scan(&stock, &price)
+		if err != nil {
+			return fmt.Errorf("error obteniendo stock del producto: %w", err)
+		}
 	}
 
 	// Obtener cantidad ya en el carrito
 	var currentQty int
-	db.QueryRow(context.Background(), "SELECT quantity FROM cart_items WHERE cart_id = $1 AND product_id = $2", cartID, productID).Scan(&currentQty)
+	db.QueryRow(context.Background(), "SELECT quantity FROM cart_items WHERE cart_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM $3",
+		cartID, productID, variantID).Scan(&currentQty)
 
 	if quantity+currentQty > stock {
 		return fmt.Errorf("No hay suficiente stock disponible")
 	}
 
-	// ON CONFLICT se encarga de actualizar la cantidad si el producto ya está en el carrito
+	// ON CONFLICT se encarga de actualizar la cantidad si el producto ya está en el carrito; el
+	// cliente acaba de ver el precio actual, así que también se actualiza el precio visto
 	query := `
-		INSERT INTO cart_items (cart_id, product_id, quantity)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (cart_id, product_id) DO UPDATE
-		SET quantity = cart_items.quantity + $3, updated_at = NOW()
+		INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, seen_price)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (cart_id, product_id, COALESCE(variant_id, 0)) DO UPDATE
+		SET quantity = cart_items.quantity + $4, seen_price = $5, updated_at = NOW()
 	`
-	_, err = db.Exec(context.Background(), query, cartID, productID, quantity)
+	_, err := db.Exec(context.Background(), query, cartID, productID, variantID, quantity, price)
+	return err
+}
+
+// SetCartItemSeenPrice guarda el precio que el cliente vio para un item del carrito
+func SetCartItemSeenPrice(db *pgxpool.Pool, cartItemID int, price float64) error {
+	_, err := db.Exec(context.Background(), "UPDATE cart_items SET seen_price = $1 WHERE id = $2", price, cartItemID)
 	return err
 }
 
@@ -817,17 +956,21 @@ func RemoveItemFromCart(db *pgxpool.Pool, cartItemID int) error {
 func GetProductByID(db *pgxpool.Pool, productID int) (*models.Product, error) {
 	var p models.Product
 	query := `
-        SELECT id, name, description, price, category_id, created_at, image_url, dimensions, stock, is_active, model_url
+        SELECT id, name, description, price, category_id, created_at, image_url, dimensions, weight, sku, stock, is_active, model_url,
+            image_variants
         FROM products
         WHERE id = $1
     `
+	var imageVariants []models.ImageVariant
 	err := db.QueryRow(context.Background(), query, productID).Scan(
 		&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &p.CreatedAt,
-		&p.ImageURL, &p.Dimensions, &p.Stock, &p.IsActive, &p.ModelURL,
+		&p.ImageURL, &p.Dimensions, &p.Weight, &p.SKU, &p.Stock, &p.IsActive, &p.ModelURL,
+		&imageVariants,
 	)
 	if err != nil {
 		return nil, err
 	}
+	p.ImageSet = models.NewImageSet(imageVariants)
 	return &p, nil
 }
 
@@ -847,8 +990,8 @@ func CreateOrder(db *pgxpool.Pool, order *models.Order) error {
 	}
 
 	query := `
-		INSERT INTO orders (user_id, order_number, status, subtotal, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO orders (user_id, order_number, status, subtotal, discount, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, shipping_method, guest_email)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NULLIF($15, ''))
 		RETURNING id, created_at
 	`
 
@@ -857,6 +1000,7 @@ func CreateOrder(db *pgxpool.Pool, order *models.Order) error {
 		order.OrderNumber,
 		order.Status,
 		order.Subtotal,
+		order.Discount,
 		order.Tax,
 		order.Shipping,
 		order.Total,
@@ -865,6 +1009,8 @@ func CreateOrder(db *pgxpool.Pool, order *models.Order) error {
 		shippingAddrJSON,
 		billingAddrJSON,
 		order.Notes,
+		order.ShippingMethod,
+		order.GuestEmail,
 	).Scan(&order.ID, &order.CreatedAt)
 
 	if err != nil {
@@ -877,7 +1023,7 @@ func CreateOrder(db *pgxpool.Pool, order *models.Order) error {
 // GetOrderByID obtiene un pedido por su ID
 func GetOrderByID(db *pgxpool.Pool, orderID int) (*models.Order, error) {
 	query := `
-		SELECT id, user_id, order_number, status, subtotal, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, created_at, updated_at
+		SELECT id, COALESCE(user_id, 0), COALESCE(guest_email, ''), order_number, status, subtotal, discount, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, shipping_method, created_at, updated_at
 		FROM orders
 		WHERE id = $1
 	`
@@ -888,9 +1034,11 @@ func GetOrderByID(db *pgxpool.Pool, orderID int) (*models.Order, error) {
 	err := db.QueryRow(context.Background(), query, orderID).Scan(
 		&order.ID,
 		&order.UserID,
+		&order.GuestEmail,
 		&order.OrderNumber,
 		&order.Status,
 		&order.Subtotal,
+		&order.Discount,
 		&order.Tax,
 		&order.Shipping,
 		&order.Total,
@@ -899,6 +1047,7 @@ func GetOrderByID(db *pgxpool.Pool, orderID int) (*models.Order, error) {
 		&shippingAddrJSON,
 		&billingAddrJSON,
 		&order.Notes,
+		&order.ShippingMethod,
 		&order.CreatedAt,
 		&order.UpdatedAt,
 	)
@@ -931,7 +1080,7 @@ func GetOrderByID(db *pgxpool.Pool, orderID int) (*models.Order, error) {
 // GetUserOrders obtiene todos los pedidos de un usuario
 func GetUserOrders(db *pgxpool.Pool, userID int) ([]models.Order, error) {
 	query := `
-		SELECT id, user_id, order_number, status, subtotal, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, created_at, updated_at
+		SELECT id, user_id, order_number, status, subtotal, discount, tax, shipping, total, currency, payment_status, shipping_address, billing_address, notes, created_at, updated_at
 		FROM orders
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -954,6 +1103,7 @@ func GetUserOrders(db *pgxpool.Pool, userID int) ([]models.Order, error) {
 			&order.OrderNumber,
 			&order.Status,
 			&order.Subtotal,
+			&order.Discount,
 			&order.Tax,
 			&order.Shipping,
 			&order.Total,
@@ -1167,18 +1317,30 @@ func GetOrderPayments(db *pgxpool.Pool, orderID int) ([]models.Payment, error) {
 
 // SaveOrderItem guarda un item de pedido
 func SaveOrderItem(db *pgxpool.Pool, orderItem *models.OrderItem) error {
+	taxBreakdownJSON, err := json.Marshal(orderItem.TaxBreakdown)
+	if err != nil {
+		return fmt.Errorf("error marshalling tax breakdown: %w", err)
+	}
+
 	query := `
-		INSERT INTO order_items (order_id, product_id, quantity, price, subtotal)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO order_items (order_id, product_id, quantity, price, subtotal, discount, tax_amount, tax_breakdown,
+			variant_id, variant_label, variant_sku)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING id
 	`
 
-	err := db.QueryRow(context.Background(), query,
+	err = db.QueryRow(context.Background(), query,
 		orderItem.OrderID,
 		orderItem.ProductID,
 		orderItem.Quantity,
 		orderItem.Price,
 		orderItem.Subtotal,
+		orderItem.Discount,
+		orderItem.TaxAmount,
+		taxBreakdownJSON,
+		orderItem.VariantID,
+		orderItem.VariantLabel,
+		orderItem.VariantSKU,
 	).Scan(&orderItem.ID)
 
 	if err != nil {
@@ -1191,7 +1353,8 @@ func SaveOrderItem(db *pgxpool.Pool, orderItem *models.OrderItem) error {
 // GetOrderItems obtiene todos los items de una orden específica
 func GetOrderItems(db *pgxpool.Pool, orderID int) ([]models.OrderItem, error) {
 	query := `
-		SELECT id, order_id, product_id, quantity, price
+		SELECT id, order_id, product_id, quantity, price, subtotal, discount, tax_amount, tax_breakdown,
+			variant_id, variant_label, variant_sku
 		FROM order_items
 		WHERE order_id = $1
 	`
@@ -1204,10 +1367,15 @@ func GetOrderItems(db *pgxpool.Pool, orderID int) ([]models.OrderItem, error) {
 	var orderItems []models.OrderItem
 	for rows.Next() {
 		var item models.OrderItem
-		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price)
+		var taxBreakdownJSON []byte
+		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &item.Subtotal, &item.Discount, &item.TaxAmount, &taxBreakdownJSON,
+			&item.VariantID, &item.VariantLabel, &item.VariantSKU)
 		if err != nil {
 			return nil, fmt.Errorf("error escaneando item de la orden: %w", err)
 		}
+		if len(taxBreakdownJSON) > 0 {
+			json.Unmarshal(taxBreakdownJSON, &item.TaxBreakdown)
+		}
 		orderItems = append(orderItems, item)
 	}
 	return orderItems, nil
@@ -1517,9 +1685,5 @@ func ClearCart(db *pgxpool.Pool, userID int) error {
 	if err != nil {
 		return fmt.Errorf("error obteniendo carrito: %w", err)
 	}
-	_, err = db.Exec(context.Background(), "DELETE FROM cart_items WHERE cart_id = $1", cartID)
-	if err != nil {
-		return fmt.Errorf("error limpiando carrito: %w", err)
-	}
-	return nil
+	return ClearCartItems(db, cartID)
 }
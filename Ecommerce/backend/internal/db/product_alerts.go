@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createProductAlertTables crea las suscripciones a alertas de producto, la cola de cambios que
+// las disparan (product_alert_events) y el registro de avisos enviados, que sirve para no repetir
+// avisos y limitar cuántos recibe cada usuario
+func createProductAlertTables() error {
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS product_alerts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			kind VARCHAR(20) NOT NULL CHECK (kind IN ('back_in_stock', 'price_drop')),
+			target_price DECIMAL(10, 2),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, product_id, kind)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_alerts_product ON product_alerts(product_id, kind)`,
+		`CREATE TABLE IF NOT EXISTS product_alert_events (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			kind VARCHAR(20) NOT NULL,
+			old_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+			new_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			processed_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_alert_events_pending ON product_alert_events(id) WHERE processed_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS product_alert_deliveries (
+			id SERIAL PRIMARY KEY,
+			event_id INTEGER NOT NULL REFERENCES product_alert_events(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			kind VARCHAR(20) NOT NULL,
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (event_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_alert_deliveries_user ON product_alert_deliveries(user_id, sent_at)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating product alerts: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetUserProductAlerts lista las alertas a las que está suscrito el usuario
+func GetUserProductAlerts(db *pgxpool.Pool, userID int) ([]models.ProductAlert, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT a.id, a.user_id, a.product_id, a.kind, a.target_price, a.created_at,
+			p.name, p.price, p.stock
+		FROM product_alerts a
+		JOIN products p ON p.id = a.product_id
+		WHERE a.user_id = $1
+		ORDER BY a.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []models.ProductAlert{}
+	for rows.Next() {
+		var a models.ProductAlert
+		if err := rows.Scan(&a.ID, &a.UserID, &a.ProductID, &a.Kind, &a.TargetPrice, &a.CreatedAt,
+			&a.ProductName, &a.CurrentPrice, &a.Stock); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// UpsertProductAlert suscribe al usuario a una alerta del producto; si ya existe actualiza el
+// precio objetivo
+func UpsertProductAlert(db *pgxpool.Pool, a *models.ProductAlert) error {
+	return db.QueryRow(context.Background(), `
+		INSERT INTO product_alerts (user_id, product_id, kind, target_price)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, product_id, kind) DO UPDATE SET target_price = EXCLUDED.target_price
+		RETURNING id, created_at
+	`, a.UserID, a.ProductID, a.Kind, a.TargetPrice).Scan(&a.ID, &a.CreatedAt)
+}
+
+// DeleteProductAlert elimina una alerta del usuario
+func DeleteProductAlert(db *pgxpool.Pool, userID, alertID int) error {
+	result, err := db.Exec(context.Background(),
+		`DELETE FROM product_alerts WHERE id = $1 AND user_id = $2`, alertID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("alerta no encontrada")
+	}
+	return nil
+}
+
+// QueueProductAlertEvent registra un cambio de producto que puede disparar alertas. Solo se encolan
+// los cruces de umbral: stock que pasa de agotado a disponible y bajadas de precio.
+func QueueProductAlertEvent(db *pgxpool.Pool, before, after *models.Product) error {
+	if !after.IsActive {
+		return nil
+	}
+	if before.Stock <= 0 && after.Stock > 0 {
+		if _, err := db.Exec(context.Background(), `
+			INSERT INTO product_alert_events (product_id, kind, old_price, new_price)
+			VALUES ($1, $2, $3, $3)
+		`, after.ID, models.ProductAlertBackInStock, after.Price); err != nil {
+			return err
+		}
+	}
+	if after.Price < before.Price {
+		if _, err := db.Exec(context.Background(), `
+			INSERT INTO product_alert_events (product_id, kind, old_price, new_price)
+			VALUES ($1, $2, $3, $4)
+		`, after.ID, models.ProductAlertPriceDrop, before.Price, after.Price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProductAlertEvent es un cambio de producto pendiente de avisar
+type ProductAlertEvent struct {
+	ID          int
+	ProductID   int
+	ProductName string
+	Kind        string
+	OldPrice    float64
+	NewPrice    float64
+	// Estado actual del producto, para no avisar de algo que ya cambió
+	CurrentPrice float64
+	Stock        int
+	IsActive     bool
+}
+
+// GetPendingProductAlertEvents obtiene los cambios pendientes más antiguos
+func GetPendingProductAlertEvents(db *pgxpool.Pool, limit int) ([]ProductAlertEvent, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT e.id, e.product_id, p.name, e.kind, e.old_price, e.new_price, p.price, p.stock, p.is_active
+		FROM product_alert_events e
+		JOIN products p ON p.id = e.product_id
+		WHERE e.processed_at IS NULL
+		ORDER BY e.id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []ProductAlertEvent
+	for rows.Next() {
+		var e ProductAlertEvent
+		if err := rows.Scan(&e.ID, &e.ProductID, &e.ProductName, &e.Kind, &e.OldPrice, &e.NewPrice,
+			&e.CurrentPrice, &e.Stock, &e.IsActive); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// MarkProductAlertEventProcessed marca un cambio como ya avisado
+func MarkProductAlertEventProcessed(db *pgxpool.Pool, eventID int) error {
+	_, err := db.Exec(context.Background(),
+		`UPDATE product_alert_events SET processed_at = NOW() WHERE id = $1`, eventID)
+	return err
+}
+
+// ProductAlertRecipientsFilter agrupa las reglas para elegir a quién avisar de un cambio
+type ProductAlertRecipientsFilter struct {
+	Favorites      bool          // Avisar también a quien tiene el producto en favoritos
+	MinDropPercent float64       // Bajada mínima para avisar sin precio objetivo
+	Cooldown       time.Duration // Tiempo mínimo entre avisos del mismo producto y tipo a un usuario
+	DailyLimit     int           // Avisos máximos por usuario en 24 horas
+	Limit          int           // Usuarios por lote
+}
+
+// GetProductAlertRecipients obtiene los usuarios a los que toca avisar de un cambio: los suscritos
+// al producto y, si se indica, quienes lo tienen en favoritos. Descarta a quien ya recibió el aviso,
+// lo recibió hace poco, llegó a su límite diario o desactivó ese tipo de notificación.
+func GetProductAlertRecipients(db *pgxpool.Pool, e *ProductAlertEvent, notificationType string, f ProductAlertRecipientsFilter) ([]int, error) {
+	now := time.Now()
+	rows, err := db.Query(context.Background(), `
+		WITH subscribers AS (
+			SELECT user_id, target_price FROM product_alerts WHERE product_id = $1 AND kind = $2
+			UNION ALL
+			SELECT w.user_id, NULL
+			FROM wishlist_items wi
+			JOIN wishlists w ON w.id = wi.wishlist_id
+			WHERE $3 AND wi.product_id = $1 AND w.kind = 'favorites'
+		)
+		SELECT s.user_id
+		FROM subscribers s
+		JOIN users u ON u.id = s.user_id
+		WHERE ($2 <> 'price_drop'
+				OR (s.target_price IS NULL AND $5::numeric <= $4::numeric * (1 - $6::numeric / 100))
+				OR (s.target_price IS NOT NULL AND $5::numeric <= s.target_price))
+			AND NOT EXISTS (
+				SELECT 1 FROM notification_preferences np
+				WHERE np.user_id = s.user_id AND np.type = $7 AND NOT np.email_enabled AND NOT np.in_app_enabled)
+			AND NOT EXISTS (
+				SELECT 1 FROM product_alert_deliveries d
+				WHERE d.user_id = s.user_id AND d.product_id = $1 AND d.kind = $2
+					AND (d.event_id = $8 OR d.sent_at > $9))
+			AND (SELECT COUNT(*) FROM product_alert_deliveries d
+				WHERE d.user_id = s.user_id AND d.sent_at > $10) < $11
+		GROUP BY s.user_id
+		ORDER BY s.user_id
+		LIMIT $12
+	`, e.ProductID, e.Kind, f.Favorites, e.OldPrice, e.NewPrice, f.MinDropPercent, notificationType,
+		e.ID, now.Add(-f.Cooldown), now.Add(-24*time.Hour), f.DailyLimit, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// RecordProductAlertDelivery registra el aviso antes de enviarlo; devuelve false si ya existía
+func RecordProductAlertDelivery(db *pgxpool.Pool, e *ProductAlertEvent, userID int) (bool, error) {
+	var id int
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO product_alert_deliveries (event_id, user_id, product_id, kind)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id, user_id) DO NOTHING
+		RETURNING id
+	`, e.ID, userID, e.ProductID, e.Kind).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteProductAlertDelivery libera un aviso que no se pudo enviar para reintentarlo
+func DeleteProductAlertDelivery(db *pgxpool.Pool, eventID, userID int) error {
+	_, err := db.Exec(context.Background(),
+		`DELETE FROM product_alert_deliveries WHERE event_id = $1 AND user_id = $2`, eventID, userID)
+	return err
+}
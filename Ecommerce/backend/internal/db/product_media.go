@@ -0,0 +1,347 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createProductMediaTables crea la galería de producto y pasa a ella la imagen y el modelo 3D que
+// ya tenían los productos
+func createProductMediaTables() error {
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS product_media (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			kind VARCHAR(10) NOT NULL CHECK (kind IN ('image', 'model', 'video')),
+			url TEXT NOT NULL,
+			storage_key TEXT,
+			alt_text VARCHAR(255) NOT NULL DEFAULT '',
+			position INTEGER NOT NULL DEFAULT 0,
+			is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+			content_type VARCHAR(100),
+			size_bytes BIGINT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_media_product ON product_media(product_id, position)`,
+		// Una sola imagen principal por producto
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_product_media_primary ON product_media(product_id) WHERE is_primary`,
+		`INSERT INTO product_media (product_id, kind, url, is_primary)
+			SELECT p.id, 'image', p.image_url, TRUE FROM products p
+			WHERE COALESCE(p.image_url, '') <> ''
+			  AND NOT EXISTS (SELECT 1 FROM product_media m WHERE m.product_id = p.id AND m.kind = 'image')`,
+		`INSERT INTO product_media (product_id, kind, url, position)
+			SELECT p.id, 'model', p.model_url, 1 FROM products p
+			WHERE COALESCE(p.model_url, '') <> ''
+			  AND NOT EXISTS (SELECT 1 FROM product_media m WHERE m.product_id = p.id AND m.kind = 'model')`,
+		// Versiones generadas por el procesamiento de imágenes; la de la imagen principal se copia
+		// en el producto para los listados
+		`ALTER TABLE product_media ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE product_media ADD COLUMN IF NOT EXISTS storage_keys TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS image_variants JSONB NOT NULL DEFAULT '[]'`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating product media: %w", err)
+		}
+	}
+	return nil
+}
+
+const productMediaColumns = `id, product_id, kind, url, storage_key, alt_text, position, is_primary, content_type, size_bytes,
+	created_at, variants, storage_keys`
+
+func scanProductMedia(row pgx.Row) (*models.ProductMedia, error) {
+	var m models.ProductMedia
+	err := row.Scan(&m.ID, &m.ProductID, &m.Kind, &m.URL, &m.StorageKey, &m.AltText, &m.Position,
+		&m.IsPrimary, &m.ContentType, &m.SizeBytes, &m.CreatedAt, &m.Variants, &m.StorageKeys)
+	if err != nil {
+		return nil, err
+	}
+	m.ImageSet = models.NewImageSet(m.Variants)
+	return &m, nil
+}
+
+// GetProductMedia obtiene la galería de un producto en orden
+func GetProductMedia(db *pgxpool.Pool, productID int) ([]models.ProductMedia, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+productMediaColumns+` FROM product_media
+		WHERE product_id = $1
+		ORDER BY position, id
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo media del producto: %w", err)
+	}
+	defer rows.Close()
+
+	media := []models.ProductMedia{}
+	for rows.Next() {
+		m, err := scanProductMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando media: %w", err)
+		}
+		media = append(media, *m)
+	}
+	return media, rows.Err()
+}
+
+// AddProductMedia agrega un elemento al final de la galería. La primera imagen del producto pasa a
+// ser la principal; si se pide IsPrimary reemplaza a la anterior.
+func AddProductMedia(db *pgxpool.Pool, m *models.ProductMedia) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear el producto serializa los cambios de su galería
+	var hasPrimary bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM product_media WHERE product_id = p.id AND is_primary)
+		FROM products p WHERE p.id = $1 FOR UPDATE
+	`, m.ProductID).Scan(&hasPrimary)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("producto no encontrado")
+		}
+		return fmt.Errorf("error obteniendo producto: %w", err)
+	}
+
+	if m.Kind != models.ProductMediaImage {
+		m.IsPrimary = false
+	} else if !hasPrimary {
+		m.IsPrimary = true
+	}
+	if m.IsPrimary && hasPrimary {
+		if _, err := tx.Exec(ctx, `UPDATE product_media SET is_primary = FALSE WHERE product_id = $1 AND is_primary`, m.ProductID); err != nil {
+			return fmt.Errorf("error cambiando imagen principal: %w", err)
+		}
+	}
+
+	if m.Variants == nil {
+		m.Variants = []models.ImageVariant{}
+	}
+	if m.StorageKeys == nil {
+		m.StorageKeys = []string{}
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO product_media (product_id, kind, url, storage_key, alt_text, position, is_primary, content_type, size_bytes,
+			variants, storage_keys)
+		VALUES ($1, $2, $3, $4, $5,
+			(SELECT COALESCE(MAX(position) + 1, 0) FROM product_media WHERE product_id = $1), $6, $7, $8, $9, $10)
+		RETURNING id, position, created_at
+	`, m.ProductID, m.Kind, m.URL, m.StorageKey, m.AltText, m.IsPrimary, m.ContentType, m.SizeBytes,
+		m.Variants, m.StorageKeys).Scan(&m.ID, &m.Position, &m.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error guardando media: %w", err)
+	}
+
+	if err := syncProductMediaURLs(ctx, tx, m.ProductID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando media: %w", err)
+	}
+	m.ImageSet = models.NewImageSet(m.Variants)
+	return nil
+}
+
+// UpdateProductMedia cambia el texto alternativo y, si isPrimary, convierte la imagen en la
+// principal del producto
+func UpdateProductMedia(db *pgxpool.Pool, productID, mediaID int, altText *string, isPrimary bool) (*models.ProductMedia, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	m, err := scanProductMedia(tx.QueryRow(ctx, `
+		SELECT `+productMediaColumns+` FROM product_media WHERE id = $1 AND product_id = $2 FOR UPDATE
+	`, mediaID, productID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("media no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo media: %w", err)
+	}
+	if isPrimary && m.Kind != models.ProductMediaImage {
+		return nil, fmt.Errorf("solo una imagen puede ser la principal")
+	}
+
+	if altText != nil {
+		m.AltText = *altText
+	}
+	if isPrimary && !m.IsPrimary {
+		if _, err := tx.Exec(ctx, `UPDATE product_media SET is_primary = FALSE WHERE product_id = $1 AND is_primary`, productID); err != nil {
+			return nil, fmt.Errorf("error cambiando imagen principal: %w", err)
+		}
+		m.IsPrimary = true
+	}
+	_, err = tx.Exec(ctx, `UPDATE product_media SET alt_text = $1, is_primary = $2 WHERE id = $3`, m.AltText, m.IsPrimary, m.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error actualizando media: %w", err)
+	}
+
+	if err := syncProductMediaURLs(ctx, tx, productID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando media: %w", err)
+	}
+	return m, nil
+}
+
+// ReorderProductMedia ordena la galería según mediaIDs, que debe incluir todo el media del producto
+func ReorderProductMedia(db *pgxpool.Pool, productID int, mediaIDs []int) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE product_media m SET position = o.position - 1
+		FROM unnest($2::int[]) WITH ORDINALITY AS o(id, position)
+		WHERE m.id = o.id AND m.product_id = $1
+	`, productID, mediaIDs)
+	if err != nil {
+		return fmt.Errorf("error ordenando media: %w", err)
+	}
+	var total int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM product_media WHERE product_id = $1`, productID).Scan(&total); err != nil {
+		return fmt.Errorf("error contando media: %w", err)
+	}
+	if int(result.RowsAffected()) != len(mediaIDs) || total != len(mediaIDs) {
+		return fmt.Errorf("orden de media inválido")
+	}
+
+	if err := syncProductMediaURLs(ctx, tx, productID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando orden de media: %w", err)
+	}
+	return nil
+}
+
+// DeleteProductMedia quita un elemento de la galería y lo devuelve para borrar su archivo. Si era
+// la imagen principal, la siguiente imagen ocupa su lugar.
+func DeleteProductMedia(db *pgxpool.Pool, productID, mediaID int) (*models.ProductMedia, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	m, err := scanProductMedia(tx.QueryRow(ctx, `
+		DELETE FROM product_media WHERE id = $1 AND product_id = $2
+		RETURNING `+productMediaColumns, mediaID, productID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("media no encontrado")
+		}
+		return nil, fmt.Errorf("error eliminando media: %w", err)
+	}
+	if m.IsPrimary {
+		_, err = tx.Exec(ctx, `
+			UPDATE product_media SET is_primary = TRUE
+			WHERE id = (SELECT id FROM product_media WHERE product_id = $1 AND kind = 'image' ORDER BY position, id LIMIT 1)
+		`, productID)
+		if err != nil {
+			return nil, fmt.Errorf("error cambiando imagen principal: %w", err)
+		}
+	}
+
+	if err := syncProductMediaURLs(ctx, tx, productID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando eliminación de media: %w", err)
+	}
+	return m, nil
+}
+
+// DeleteAllProductMedia vacía la galería de un producto y devuelve lo borrado para eliminar sus
+// archivos
+func DeleteAllProductMedia(db *pgxpool.Pool, productID int) ([]models.ProductMedia, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `DELETE FROM product_media WHERE product_id = $1 RETURNING `+productMediaColumns, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error eliminando media del producto: %w", err)
+	}
+	media := []models.ProductMedia{}
+	for rows.Next() {
+		m, err := scanProductMedia(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando media: %w", err)
+		}
+		media = append(media, *m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := syncProductMediaURLs(ctx, tx, productID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error confirmando eliminación de media: %w", err)
+	}
+	return media, nil
+}
+
+// UnusedMediaKeys devuelve las claves de S3 que ya no usa ningún elemento de la galería. Las
+// claves dependen del contenido, así que la misma imagen subida dos veces comparte archivos.
+func UnusedMediaKeys(db *pgxpool.Pool, keys []string) ([]string, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT k FROM unnest($1::text[]) AS k
+		WHERE NOT EXISTS (SELECT 1 FROM product_media WHERE storage_key = k OR storage_keys @> ARRAY[k])
+	`, keys)
+	if err != nil {
+		return nil, fmt.Errorf("error comprobando archivos de media: %w", err)
+	}
+	defer rows.Close()
+
+	unused := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error escaneando archivo de media: %w", err)
+		}
+		unused = append(unused, key)
+	}
+	return unused, rows.Err()
+}
+
+// syncProductMediaURLs copia la imagen principal (con sus versiones) y el primer modelo 3D de la
+// galería en products.image_url, image_variants y model_url, que son los que usan el catálogo, el
+// carrito y los emails
+func syncProductMediaURLs(ctx context.Context, tx pgx.Tx, productID int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE products SET
+			image_url = (SELECT url FROM product_media WHERE product_id = $1 AND is_primary),
+			image_variants = COALESCE((SELECT variants FROM product_media WHERE product_id = $1 AND is_primary), '[]'),
+			model_url = (SELECT url FROM product_media WHERE product_id = $1 AND kind = 'model' ORDER BY position, id LIMIT 1),
+			updated_at = NOW()
+		WHERE id = $1
+	`, productID)
+	if err != nil {
+		return fmt.Errorf("error actualizando imagen del producto: %w", err)
+	}
+	return nil
+}
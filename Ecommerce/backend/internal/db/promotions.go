@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createPromotionTables crea las tablas de promociones automáticas y de las aplicadas a cada pedido
+func createPromotionTables() error {
+	promotionsTable := `
+	CREATE TABLE IF NOT EXISTS promotions (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(150) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		type VARCHAR(30) NOT NULL,
+		priority INTEGER NOT NULL DEFAULT 0,
+		exclusive BOOLEAN NOT NULL DEFAULT FALSE,
+		product_ids INTEGER[] NOT NULL DEFAULT '{}',
+		category_ids INTEGER[] NOT NULL DEFAULT '{}',
+		buy_quantity INTEGER NOT NULL DEFAULT 0,
+		get_quantity INTEGER NOT NULL DEFAULT 0,
+		percent DECIMAL(5, 2) NOT NULL DEFAULT 0,
+		tiers JSONB NOT NULL DEFAULT '[]',
+		bundle_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		starts_at TIMESTAMPTZ,
+		ends_at TIMESTAMPTZ,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	`
+	_, err := Pool.Exec(context.Background(), promotionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating promotions table: %w", err)
+	}
+
+	// Se guarda el nombre para conservar el historial aunque la promoción se elimine
+	orderPromotionsTable := `
+	CREATE TABLE IF NOT EXISTS order_promotions (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL,
+		promotion_id INTEGER,
+		name VARCHAR(150) NOT NULL,
+		type VARCHAR(30) NOT NULL,
+		discount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+		FOREIGN KEY(promotion_id) REFERENCES promotions(id) ON DELETE SET NULL
+	);
+	`
+	_, err = Pool.Exec(context.Background(), orderPromotionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating order_promotions table: %w", err)
+	}
+
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_promotions_active ON promotions(is_active, priority DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_promotions_order_id ON order_promotions(order_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_promotions_promotion_id ON order_promotions(promotion_id)`,
+	}
+	for _, idx := range indexes {
+		if _, err := Pool.Exec(context.Background(), idx); err != nil {
+			return fmt.Errorf("error creating promotion indexes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const promotionColumns = `id, name, description, type, priority, exclusive, product_ids, category_ids, buy_quantity,
+	get_quantity, percent, tiers, bundle_price, starts_at, ends_at, is_active, created_at, updated_at`
+
+func scanPromotion(row pgx.Row) (*models.Promotion, error) {
+	var p models.Promotion
+	var tiersJSON []byte
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Type, &p.Priority, &p.Exclusive, &p.ProductIDs, &p.CategoryIDs,
+		&p.BuyQuantity, &p.GetQuantity, &p.Percent, &tiersJSON, &p.BundlePrice, &p.StartsAt, &p.EndsAt, &p.IsActive,
+		&p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(tiersJSON) > 0 {
+		json.Unmarshal(tiersJSON, &p.Tiers)
+	}
+	return &p, nil
+}
+
+func queryPromotions(db *pgxpool.Pool, query string, args ...interface{}) ([]models.Promotion, error) {
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo promociones: %w", err)
+	}
+	defer rows.Close()
+
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando promoción: %w", err)
+		}
+		promotions = append(promotions, *p)
+	}
+	return promotions, nil
+}
+
+// GetPromotions lista las promociones en orden de evaluación
+func GetPromotions(db *pgxpool.Pool, activeOnly bool) ([]models.Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM promotions`
+	if activeOnly {
+		query += " WHERE is_active = true"
+	}
+	query += " ORDER BY priority DESC, id"
+	return queryPromotions(db, query)
+}
+
+// GetCurrentPromotions obtiene las promociones activas y vigentes en este momento
+func GetCurrentPromotions(db *pgxpool.Pool) ([]models.Promotion, error) {
+	return queryPromotions(db, `
+		SELECT `+promotionColumns+` FROM promotions
+		WHERE is_active = true
+		  AND (starts_at IS NULL OR starts_at <= NOW())
+		  AND (ends_at IS NULL OR ends_at > NOW())
+		ORDER BY priority DESC, id
+	`)
+}
+
+// GetPromotionByID obtiene una promoción por su ID
+func GetPromotionByID(db *pgxpool.Pool, promotionID int) (*models.Promotion, error) {
+	p, err := scanPromotion(db.QueryRow(context.Background(),
+		`SELECT `+promotionColumns+` FROM promotions WHERE id = $1`, promotionID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("promoción no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo promoción: %w", err)
+	}
+	return p, nil
+}
+
+// CreatePromotion crea una promoción
+func CreatePromotion(db *pgxpool.Pool, p *models.Promotion) error {
+	tiersJSON, err := json.Marshal(promotionTiers(p.Tiers))
+	if err != nil {
+		return fmt.Errorf("error marshalling tiers: %w", err)
+	}
+	err = db.QueryRow(context.Background(), `
+		INSERT INTO promotions (name, description, type, priority, exclusive, product_ids, category_ids, buy_quantity,
+			get_quantity, percent, tiers, bundle_price, starts_at, ends_at, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at, updated_at
+	`, p.Name, p.Description, p.Type, p.Priority, p.Exclusive, intArray(p.ProductIDs), intArray(p.CategoryIDs),
+		p.BuyQuantity, p.GetQuantity, p.Percent, tiersJSON, p.BundlePrice, p.StartsAt, p.EndsAt, p.IsActive,
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando promoción: %w", err)
+	}
+	return nil
+}
+
+// UpdatePromotion actualiza una promoción existente
+func UpdatePromotion(db *pgxpool.Pool, p *models.Promotion) error {
+	tiersJSON, err := json.Marshal(promotionTiers(p.Tiers))
+	if err != nil {
+		return fmt.Errorf("error marshalling tiers: %w", err)
+	}
+	err = db.QueryRow(context.Background(), `
+		UPDATE promotions
+		SET name = $1, description = $2, type = $3, priority = $4, exclusive = $5, product_ids = $6,
+			category_ids = $7, buy_quantity = $8, get_quantity = $9, percent = $10, tiers = $11,
+			bundle_price = $12, starts_at = $13, ends_at = $14, is_active = $15, updated_at = NOW()
+		WHERE id = $16
+		RETURNING updated_at
+	`, p.Name, p.Description, p.Type, p.Priority, p.Exclusive, intArray(p.ProductIDs), intArray(p.CategoryIDs),
+		p.BuyQuantity, p.GetQuantity, p.Percent, tiersJSON, p.BundlePrice, p.StartsAt, p.EndsAt, p.IsActive, p.ID,
+	).Scan(&p.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("promoción no encontrada")
+		}
+		return fmt.Errorf("error actualizando promoción: %w", err)
+	}
+	return nil
+}
+
+// DeletePromotion elimina una promoción; los pedidos conservan el nombre y el descuento aplicado
+func DeletePromotion(db *pgxpool.Pool, promotionID int) error {
+	result, err := db.Exec(context.Background(), `DELETE FROM promotions WHERE id = $1`, promotionID)
+	if err != nil {
+		return fmt.Errorf("error eliminando promoción: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("promoción no encontrada")
+	}
+	return nil
+}
+
+func promotionTiers(tiers []models.PromotionTier) []models.PromotionTier {
+	if tiers == nil {
+		return []models.PromotionTier{}
+	}
+	return tiers
+}
+
+// OrderPromotion es una promoción aplicada a un pedido
+type OrderPromotion struct {
+	PromotionID *int    `json:"promotion_id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Discount    float64 `json:"discount"`
+}
+
+// SaveOrderPromotions guarda las promociones aplicadas a un pedido
+func SaveOrderPromotions(db *pgxpool.Pool, orderID int, promotions []OrderPromotion) error {
+	for _, p := range promotions {
+		_, err := db.Exec(context.Background(), `
+			INSERT INTO order_promotions (order_id, promotion_id, name, type, discount)
+			VALUES ($1, $2, $3, $4, $5)
+		`, orderID, p.PromotionID, p.Name, p.Type, p.Discount)
+		if err != nil {
+			return fmt.Errorf("error guardando promoción del pedido: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetOrderPromotions obtiene las promociones aplicadas a un pedido
+func GetOrderPromotions(db *pgxpool.Pool, orderID int) ([]OrderPromotion, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT promotion_id, name, type, discount FROM order_promotions WHERE order_id = $1 ORDER BY id
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo promociones del pedido: %w", err)
+	}
+	defer rows.Close()
+
+	promotions := []OrderPromotion{}
+	for rows.Next() {
+		var p OrderPromotion
+		if err := rows.Scan(&p.PromotionID, &p.Name, &p.Type, &p.Discount); err != nil {
+			return nil, fmt.Errorf("error escaneando promoción del pedido: %w", err)
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, nil
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createReconciliationTables crea las tablas de la conciliación de pagos
+func createReconciliationTables() error {
+	runsTable := `
+	CREATE TABLE IF NOT EXISTS reconciliation_runs (
+		id SERIAL PRIMARY KEY,
+		trigger VARCHAR(20) NOT NULL DEFAULT 'scheduler',
+		status VARCHAR(20) NOT NULL DEFAULT 'running',
+		window_start TIMESTAMPTZ NOT NULL,
+		intents_checked INTEGER NOT NULL DEFAULT 0,
+		charges_checked INTEGER NOT NULL DEFAULT 0,
+		fixed_count INTEGER NOT NULL DEFAULT 0,
+		discrepancy_count INTEGER NOT NULL DEFAULT 0,
+		error_message TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		finished_at TIMESTAMPTZ
+	);
+	`
+	_, err := Pool.Exec(context.Background(), runsTable)
+	if err != nil {
+		return fmt.Errorf("error creating reconciliation_runs table: %w", err)
+	}
+
+	discrepanciesTable := `
+	CREATE TABLE IF NOT EXISTS reconciliation_discrepancies (
+		id SERIAL PRIMARY KEY,
+		run_id INTEGER NOT NULL,
+		kind VARCHAR(50) NOT NULL,
+		payment_intent_id VARCHAR(255) NOT NULL DEFAULT '',
+		charge_id VARCHAR(255) NOT NULL DEFAULT '',
+		order_id INTEGER,
+		payment_id INTEGER,
+		provider_status VARCHAR(50) NOT NULL DEFAULT '',
+		local_status VARCHAR(50) NOT NULL DEFAULT '',
+		provider_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		local_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		action TEXT NOT NULL DEFAULT 'none',
+		resolved BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(run_id) REFERENCES reconciliation_runs(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), discrepanciesTable)
+	if err != nil {
+		return fmt.Errorf("error creating reconciliation_discrepancies table: %w", err)
+	}
+
+	_, err = Pool.Exec(context.Background(), "CREATE INDEX IF NOT EXISTS idx_reconciliation_discrepancies_run_id ON reconciliation_discrepancies(run_id);")
+	if err != nil {
+		return fmt.Errorf("error creating index: %w", err)
+	}
+
+	return nil
+}
+
+// CreateReconciliationRun registra el inicio de una conciliación
+func CreateReconciliationRun(db *pgxpool.Pool, run *models.ReconciliationRun) error {
+	query := `
+		INSERT INTO reconciliation_runs (trigger, status, window_start, started_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err := db.QueryRow(context.Background(), query, run.Trigger, run.Status, run.WindowStart, run.StartedAt).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("error creando ejecución de conciliación: %w", err)
+	}
+	return nil
+}
+
+// FinishReconciliationRun guarda el resultado final de una conciliación
+func FinishReconciliationRun(db *pgxpool.Pool, run *models.ReconciliationRun) error {
+	query := `
+		UPDATE reconciliation_runs
+		SET status = $1, intents_checked = $2, charges_checked = $3, fixed_count = $4,
+			discrepancy_count = $5, error_message = $6, finished_at = $7
+		WHERE id = $8
+	`
+	_, err := db.Exec(context.Background(), query,
+		run.Status, run.IntentsChecked, run.ChargesChecked, run.FixedCount,
+		run.DiscrepancyCount, run.ErrorMessage, run.FinishedAt, run.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error finalizando ejecución de conciliación: %w", err)
+	}
+	return nil
+}
+
+// SaveReconciliationDiscrepancy guarda una discrepancia encontrada
+func SaveReconciliationDiscrepancy(db *pgxpool.Pool, d *models.ReconciliationDiscrepancy) error {
+	query := `
+		INSERT INTO reconciliation_discrepancies (run_id, kind, payment_intent_id, charge_id, order_id, payment_id,
+			provider_status, local_status, provider_amount, local_amount, action, resolved)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at
+	`
+	err := db.QueryRow(context.Background(), query,
+		d.RunID, d.Kind, d.PaymentIntentID, d.ChargeID, d.OrderID, d.PaymentID,
+		d.ProviderStatus, d.LocalStatus, d.ProviderAmount, d.LocalAmount, d.Action, d.Resolved,
+	).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error guardando discrepancia: %w", err)
+	}
+	return nil
+}
+
+const reconciliationRunColumns = `id, trigger, status, window_start, intents_checked, charges_checked, fixed_count,
+	discrepancy_count, error_message, started_at, finished_at`
+
+func scanReconciliationRun(row pgx.Row) (*models.ReconciliationRun, error) {
+	var run models.ReconciliationRun
+	err := row.Scan(
+		&run.ID, &run.Trigger, &run.Status, &run.WindowStart, &run.IntentsChecked, &run.ChargesChecked,
+		&run.FixedCount, &run.DiscrepancyCount, &run.ErrorMessage, &run.StartedAt, &run.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// GetLatestReconciliationRun obtiene la conciliación más reciente
+func GetLatestReconciliationRun(db *pgxpool.Pool) (*models.ReconciliationRun, error) {
+	query := `SELECT ` + reconciliationRunColumns + ` FROM reconciliation_runs ORDER BY started_at DESC LIMIT 1`
+	run, err := scanReconciliationRun(db.QueryRow(context.Background(), query))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("no hay conciliaciones registradas")
+		}
+		return nil, fmt.Errorf("error obteniendo conciliación: %w", err)
+	}
+	return run, nil
+}
+
+// GetReconciliationRunByID obtiene una conciliación por su ID
+func GetReconciliationRunByID(db *pgxpool.Pool, runID int) (*models.ReconciliationRun, error) {
+	query := `SELECT ` + reconciliationRunColumns + ` FROM reconciliation_runs WHERE id = $1`
+	run, err := scanReconciliationRun(db.QueryRow(context.Background(), query, runID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("conciliación no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo conciliación: %w", err)
+	}
+	return run, nil
+}
+
+// GetReconciliationRuns obtiene las conciliaciones más recientes
+func GetReconciliationRuns(db *pgxpool.Pool, limit int) ([]models.ReconciliationRun, error) {
+	query := `SELECT ` + reconciliationRunColumns + ` FROM reconciliation_runs ORDER BY started_at DESC LIMIT $1`
+	rows, err := db.Query(context.Background(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo conciliaciones: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.ReconciliationRun
+	for rows.Next() {
+		run, err := scanReconciliationRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando conciliación: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+	return runs, nil
+}
+
+// GetReconciliationDiscrepancies obtiene las discrepancias de una conciliación
+func GetReconciliationDiscrepancies(db *pgxpool.Pool, runID int, unresolvedOnly bool) ([]models.ReconciliationDiscrepancy, error) {
+	query := `
+		SELECT id, run_id, kind, payment_intent_id, charge_id, order_id, payment_id, provider_status,
+			   local_status, provider_amount, local_amount, action, resolved, created_at
+		FROM reconciliation_discrepancies
+		WHERE run_id = $1
+	`
+	if unresolvedOnly {
+		query += " AND resolved = false"
+	}
+	query += " ORDER BY id ASC"
+
+	rows, err := db.Query(context.Background(), query, runID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo discrepancias: %w", err)
+	}
+	defer rows.Close()
+
+	var discrepancies []models.ReconciliationDiscrepancy
+	for rows.Next() {
+		var d models.ReconciliationDiscrepancy
+		err := rows.Scan(
+			&d.ID, &d.RunID, &d.Kind, &d.PaymentIntentID, &d.ChargeID, &d.OrderID, &d.PaymentID,
+			&d.ProviderStatus, &d.LocalStatus, &d.ProviderAmount, &d.LocalAmount, &d.Action,
+			&d.Resolved, &d.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando discrepancia: %w", err)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	return discrepancies, nil
+}
+
+// GetPendingStripePayments obtiene los pagos de Stripe pendientes creados en la ventana indicada
+func GetPendingStripePayments(db *pgxpool.Pool, since, until time.Time) ([]models.Payment, error) {
+	query := `
+		SELECT id, order_id, payment_method, amount, currency, status, stripe_payment_intent_id, stripe_customer_id, transaction_id, error_message, created_at, updated_at
+		FROM payments
+		WHERE status = 'pending' AND stripe_payment_intent_id <> '' AND created_at >= $1 AND created_at < $2
+		ORDER BY created_at ASC
+	`
+	rows, err := db.Query(context.Background(), query, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo pagos pendientes: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		var payment models.Payment
+		err := rows.Scan(
+			&payment.ID,
+			&payment.OrderID,
+			&payment.PaymentMethod,
+			&payment.Amount,
+			&payment.Currency,
+			&payment.Status,
+			&payment.StripePaymentIntentID,
+			&payment.StripeCustomerID,
+			&payment.TransactionID,
+			&payment.ErrorMessage,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando pago: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// GetPaidOrderIDsSince obtiene los pedidos marcados como pagados desde la fecha indicada
+func GetPaidOrderIDsSince(db *pgxpool.Pool, since time.Time) ([]int, error) {
+	rows, err := db.Query(context.Background(),
+		`SELECT id FROM orders WHERE payment_status = 'paid' AND updated_at >= $1 ORDER BY id ASC`, since)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo pedidos pagados: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error escaneando pedido: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
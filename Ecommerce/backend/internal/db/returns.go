@@ -0,0 +1,436 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createReturnTables crea las tablas de devoluciones (RMA) y reembolsos
+func createReturnTables() error {
+	returnsTable := `
+	CREATE TABLE IF NOT EXISTS return_requests (
+		id SERIAL PRIMARY KEY,
+		rma_number VARCHAR(30) UNIQUE,
+		order_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'requested',
+		reason_code VARCHAR(30) NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		admin_note TEXT NOT NULL DEFAULT '',
+		refund_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		refund_status VARCHAR(20) NOT NULL DEFAULT 'none',
+		refund_id VARCHAR(255) NOT NULL DEFAULT '',
+		approved_at TIMESTAMPTZ,
+		received_at TIMESTAMPTZ,
+		restocked_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	`
+	_, err := Pool.Exec(context.Background(), returnsTable)
+	if err != nil {
+		return fmt.Errorf("error creating return_requests table: %w", err)
+	}
+
+	itemsTable := `
+	CREATE TABLE IF NOT EXISTS return_items (
+		id SERIAL PRIMARY KEY,
+		return_id INTEGER NOT NULL,
+		order_item_id INTEGER NOT NULL,
+		quantity INTEGER NOT NULL CHECK (quantity > 0),
+		unit_refund DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		condition VARCHAR(20) NOT NULL DEFAULT '',
+		restocked_quantity INTEGER NOT NULL DEFAULT 0,
+		UNIQUE (return_id, order_item_id),
+		FOREIGN KEY(return_id) REFERENCES return_requests(id) ON DELETE CASCADE,
+		FOREIGN KEY(order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), itemsTable)
+	if err != nil {
+		return fmt.Errorf("error creating return_items table: %w", err)
+	}
+
+	refundsTable := `
+	CREATE TABLE IF NOT EXISTS refunds (
+		id SERIAL PRIMARY KEY,
+		payment_id INTEGER NOT NULL,
+		order_id INTEGER NOT NULL,
+		return_id INTEGER,
+		provider_refund_id VARCHAR(255) NOT NULL DEFAULT '',
+		amount DECIMAL(10, 2) NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'pending',
+		reason VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE,
+		FOREIGN KEY(return_id) REFERENCES return_requests(id) ON DELETE SET NULL
+	);
+	`
+	_, err = Pool.Exec(context.Background(), refundsTable)
+	if err != nil {
+		return fmt.Errorf("error creating refunds table: %w", err)
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_return_requests_order_id ON return_requests(order_id);",
+		"CREATE INDEX IF NOT EXISTS idx_return_requests_status ON return_requests(status);",
+		"CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds(payment_id);",
+	}
+	for _, idx := range indexes {
+		if _, err := Pool.Exec(context.Background(), idx); err != nil {
+			return fmt.Errorf("error creating return indexes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const returnColumns = `id, COALESCE(rma_number, ''), order_id, user_id, status, reason_code, comment, admin_note,
+	refund_amount, refund_status, refund_id, approved_at, received_at, restocked_at, created_at, updated_at`
+
+func scanReturn(row pgx.Row) (*models.ReturnRequest, error) {
+	var r models.ReturnRequest
+	err := row.Scan(&r.ID, &r.RMANumber, &r.OrderID, &r.UserID, &r.Status, &r.ReasonCode, &r.Comment, &r.AdminNote,
+		&r.RefundAmount, &r.RefundStatus, &r.RefundID, &r.ApprovedAt, &r.ReceivedAt, &r.RestockedAt, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateReturnRequest guarda una solicitud de devolución. Valida en una transacción que las
+// cantidades no excedan lo comprado menos lo ya solicitado en otras devoluciones no rechazadas.
+func CreateReturnRequest(db *pgxpool.Pool, ret *models.ReturnRequest) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear el pedido para serializar devoluciones concurrentes
+	if _, err := tx.Exec(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, ret.OrderID); err != nil {
+		return fmt.Errorf("error bloqueando pedido: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT oi.id, oi.quantity - COALESCE((
+			SELECT SUM(ri.quantity)
+			FROM return_items ri
+			JOIN return_requests rr ON rr.id = ri.return_id
+			WHERE ri.order_item_id = oi.id AND rr.status <> 'rejected'
+		), 0), oi.price, oi.discount, oi.tax_amount, oi.quantity
+		FROM order_items oi
+		WHERE oi.order_id = $1
+	`, ret.OrderID)
+	if err != nil {
+		return fmt.Errorf("error calculando cantidades retornables: %w", err)
+	}
+	returnable := make(map[int]int)
+	unitRefund := make(map[int]float64)
+	for rows.Next() {
+		var id, available, ordered int
+		var price, discount, taxAmount float64
+		if err := rows.Scan(&id, &available, &price, &discount, &taxAmount, &ordered); err != nil {
+			rows.Close()
+			return fmt.Errorf("error escaneando cantidades retornables: %w", err)
+		}
+		returnable[id] = available
+		unitRefund[id] = price
+		if ordered > 0 {
+			// Se reembolsa lo que realmente se pagó: sin la parte del descuento de cupones
+			unitRefund[id] = price + (taxAmount-discount)/float64(ordered)
+		}
+	}
+	rows.Close()
+
+	ret.RefundAmount = 0
+	for i := range ret.Items {
+		item := &ret.Items[i]
+		available, ok := returnable[item.OrderItemID]
+		if !ok {
+			return fmt.Errorf("el item %d no pertenece al pedido", item.OrderItemID)
+		}
+		if item.Quantity <= 0 || item.Quantity > available {
+			return fmt.Errorf("cantidad inválida para el item %d: se pueden devolver %d", item.OrderItemID, available)
+		}
+		returnable[item.OrderItemID] -= item.Quantity
+		item.UnitRefund = float64(int64(unitRefund[item.OrderItemID]*100+0.5)) / 100
+		ret.RefundAmount += item.UnitRefund * float64(item.Quantity)
+	}
+	ret.RefundAmount = float64(int64(ret.RefundAmount*100+0.5)) / 100
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO return_requests (order_id, user_id, status, reason_code, comment, refund_amount)
+		VALUES ($1, $2, 'requested', $3, $4, $5)
+		RETURNING id, status, refund_status, created_at, updated_at
+	`, ret.OrderID, ret.UserID, ret.ReasonCode, ret.Comment, ret.RefundAmount,
+	).Scan(&ret.ID, &ret.Status, &ret.RefundStatus, &ret.CreatedAt, &ret.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando devolución: %w", err)
+	}
+
+	ret.RMANumber = fmt.Sprintf("RMA-%06d", ret.ID)
+	if _, err := tx.Exec(ctx, `UPDATE return_requests SET rma_number = $1 WHERE id = $2`, ret.RMANumber, ret.ID); err != nil {
+		return fmt.Errorf("error asignando número de devolución: %w", err)
+	}
+
+	for i := range ret.Items {
+		item := &ret.Items[i]
+		item.ReturnID = ret.ID
+		err := tx.QueryRow(ctx, `
+			INSERT INTO return_items (return_id, order_item_id, quantity, unit_refund)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (return_id, order_item_id) DO UPDATE SET quantity = return_items.quantity + EXCLUDED.quantity
+			RETURNING id
+		`, ret.ID, item.OrderItemID, item.Quantity, item.UnitRefund).Scan(&item.ID)
+		if err != nil {
+			return fmt.Errorf("error guardando items de la devolución: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando devolución: %w", err)
+	}
+	return nil
+}
+
+// GetReturnByID obtiene una devolución con sus items
+func GetReturnByID(db *pgxpool.Pool, returnID int) (*models.ReturnRequest, error) {
+	ret, err := scanReturn(db.QueryRow(context.Background(), `SELECT `+returnColumns+` FROM return_requests WHERE id = $1`, returnID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("devolución no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo devolución: %w", err)
+	}
+	items, err := GetReturnItems(db, ret.ID)
+	if err != nil {
+		return nil, err
+	}
+	ret.Items = items
+	return ret, nil
+}
+
+func queryReturns(db *pgxpool.Pool, query string, args ...interface{}) ([]models.ReturnRequest, error) {
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo devoluciones: %w", err)
+	}
+	defer rows.Close()
+
+	var returns []models.ReturnRequest
+	for rows.Next() {
+		r, err := scanReturn(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando devolución: %w", err)
+		}
+		returns = append(returns, *r)
+	}
+	rows.Close()
+
+	for i := range returns {
+		items, err := GetReturnItems(db, returns[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		returns[i].Items = items
+	}
+	return returns, nil
+}
+
+// GetOrderReturns obtiene las devoluciones de un pedido
+func GetOrderReturns(db *pgxpool.Pool, orderID int) ([]models.ReturnRequest, error) {
+	return queryReturns(db, `SELECT `+returnColumns+` FROM return_requests WHERE order_id = $1 ORDER BY created_at DESC`, orderID)
+}
+
+// GetReturnRequests obtiene las devoluciones, opcionalmente filtradas por estado
+func GetReturnRequests(db *pgxpool.Pool, status string, limit int) ([]models.ReturnRequest, error) {
+	if status != "" {
+		return queryReturns(db, `SELECT `+returnColumns+` FROM return_requests WHERE status = $1 ORDER BY created_at DESC LIMIT $2`, status, limit)
+	}
+	return queryReturns(db, `SELECT `+returnColumns+` FROM return_requests ORDER BY created_at DESC LIMIT $1`, limit)
+}
+
+// GetReturnItems obtiene los items de una devolución
+func GetReturnItems(db *pgxpool.Pool, returnID int) ([]models.ReturnItem, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT ri.id, ri.return_id, ri.order_item_id, oi.product_id, COALESCE(p.name, ''), ri.quantity,
+			ri.unit_refund, ri.condition, ri.restocked_quantity
+		FROM return_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		LEFT JOIN products p ON p.id = oi.product_id
+		WHERE ri.return_id = $1
+		ORDER BY ri.id ASC
+	`, returnID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo items de la devolución: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.ReturnItem
+	for rows.Next() {
+		var item models.ReturnItem
+		if err := rows.Scan(&item.ID, &item.ReturnID, &item.OrderItemID, &item.ProductID, &item.ProductName, &item.Quantity,
+			&item.UnitRefund, &item.Condition, &item.RestockedQuantity); err != nil {
+			return nil, fmt.Errorf("error escaneando item de la devolución: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+const updateReturnRequestQuery = `
+	UPDATE return_requests
+	SET status = $1, admin_note = $2, refund_amount = $3, refund_status = $4, refund_id = $5,
+		approved_at = $6, received_at = $7, restocked_at = $8, updated_at = NOW()
+	WHERE id = $9`
+
+// UpdateReturnRequest guarda el estado, la nota y el reembolso de una devolución
+func UpdateReturnRequest(db *pgxpool.Pool, ret *models.ReturnRequest) error {
+	result, err := db.Exec(context.Background(), updateReturnRequestQuery, ret.Status, ret.AdminNote, ret.RefundAmount,
+		ret.RefundStatus, ret.RefundID, ret.ApprovedAt, ret.ReceivedAt, ret.RestockedAt, ret.ID)
+	if err != nil {
+		return fmt.Errorf("error actualizando devolución: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("devolución no encontrada")
+	}
+	return nil
+}
+
+// UpdateReturnRequestFrom guarda la devolución solo si sigue en fromStatus y fromRefundStatus. Es el
+// paso que da derecho a reembolsar: si dos peticiones aprueban o reintentan a la vez, solo una lo
+// consigue y la otra recibe false.
+func UpdateReturnRequestFrom(db *pgxpool.Pool, ret *models.ReturnRequest, fromStatus, fromRefundStatus string) (bool, error) {
+	result, err := db.Exec(context.Background(), updateReturnRequestQuery+` AND status = $10 AND refund_status = $11`,
+		ret.Status, ret.AdminNote, ret.RefundAmount, ret.RefundStatus, ret.RefundID, ret.ApprovedAt, ret.ReceivedAt,
+		ret.RestockedAt, ret.ID, fromStatus, fromRefundStatus)
+	if err != nil {
+		return false, fmt.Errorf("error actualizando devolución: %w", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// SetReturnItemCondition registra el resultado de la inspección de un item devuelto
+func SetReturnItemCondition(db *pgxpool.Pool, returnID, itemID int, condition string) error {
+	result, err := db.Exec(context.Background(),
+		`UPDATE return_items SET condition = $1 WHERE id = $2 AND return_id = $3`, condition, itemID, returnID)
+	if err != nil {
+		return fmt.Errorf("error actualizando item de la devolución: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("item de devolución no encontrado")
+	}
+	return nil
+}
+
+// RestockReturn repone al inventario los items en condición vendible y marca la devolución como
+// reingresada. Devuelve las unidades repuestas.
+func RestockReturn(db *pgxpool.Pool, returnID int) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE return_requests SET status = 'restocked', restocked_at = $1, updated_at = NOW()
+		WHERE id = $2 AND status = 'received'
+	`, time.Now(), returnID)
+	if err != nil {
+		return 0, fmt.Errorf("error actualizando devolución: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return 0, fmt.Errorf("la devolución no está en estado recibido")
+	}
+
+	var restocked int
+	err = tx.QueryRow(ctx, `
+		WITH restock AS (
+			UPDATE return_items
+			SET restocked_quantity = quantity
+			WHERE return_id = $1 AND condition = 'sellable' AND restocked_quantity < quantity
+			RETURNING order_item_id, quantity
+		), variant_stock AS (
+			UPDATE product_variants v
+			SET stock = v.stock + r.quantity, updated_at = NOW()
+			FROM (
+				SELECT oi.variant_id, SUM(restock.quantity) AS quantity
+				FROM restock JOIN order_items oi ON oi.id = restock.order_item_id
+				WHERE oi.variant_id IS NOT NULL
+				GROUP BY oi.variant_id
+			) r
+			WHERE v.id = r.variant_id
+		), stock AS (
+			UPDATE products p
+			SET stock = p.stock + r.quantity, updated_at = NOW()
+			FROM (
+				SELECT oi.product_id, SUM(restock.quantity) AS quantity
+				FROM restock JOIN order_items oi ON oi.id = restock.order_item_id
+				GROUP BY oi.product_id
+			) r
+			WHERE p.id = r.product_id
+			RETURNING p.id, p.price, p.stock, p.is_active, r.quantity
+		), alerts AS (
+			-- Avisar a los suscritos si el producto estaba agotado
+			INSERT INTO product_alert_events (product_id, kind, old_price, new_price)
+			SELECT id, 'back_in_stock', price, price FROM stock
+			WHERE is_active AND stock > 0 AND stock - quantity <= 0
+		)
+		SELECT COALESCE(SUM(quantity), 0) FROM restock
+	`, returnID).Scan(&restocked)
+	if err != nil {
+		return 0, fmt.Errorf("error reponiendo stock: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error confirmando reingreso: %w", err)
+	}
+	return restocked, nil
+}
+
+// CreateRefund registra un reembolso antes de enviarlo al procesador de pagos
+func CreateRefund(db *pgxpool.Pool, refund *models.Refund) error {
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO refunds (payment_id, order_id, return_id, provider_refund_id, amount, status, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, refund.PaymentID, refund.OrderID, refund.ReturnID, refund.ProviderRefundID, refund.Amount, refund.Status, refund.Reason,
+	).Scan(&refund.ID, &refund.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error registrando reembolso: %w", err)
+	}
+	return nil
+}
+
+// UpdateRefundResult guarda la respuesta del procesador de pagos para un reembolso
+func UpdateRefundResult(db *pgxpool.Pool, refundID int, providerRefundID, status string) error {
+	_, err := db.Exec(context.Background(),
+		`UPDATE refunds SET provider_refund_id = $1, status = $2 WHERE id = $3`, providerRefundID, status, refundID)
+	if err != nil {
+		return fmt.Errorf("error actualizando reembolso: %w", err)
+	}
+	return nil
+}
+
+// GetRefundedAmount suma los reembolsos exitosos o en proceso de un pago
+func GetRefundedAmount(db *pgxpool.Pool, paymentID int) (float64, error) {
+	var amount float64
+	err := db.QueryRow(context.Background(),
+		`SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE payment_id = $1 AND status IN ('pending', 'succeeded')`, paymentID,
+	).Scan(&amount)
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo reembolsos: %w", err)
+	}
+	return amount, nil
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// createProductSearchTables prepara la búsqueda de texto completo de productos. products.search_vector
+// combina, por peso, el nombre (A), el SKU (B), la descripción (C) y el nombre de la categoría (D)
+// en español e inglés y sin acentos; un trigger lo mantiene al día. El índice de trigramas sobre el
+// nombre sirve para las sugerencias y para tolerar errores de escritura.
+func createProductSearchTables() error {
+	migrations := []string{
+		`CREATE EXTENSION IF NOT EXISTS unaccent`,
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		// unaccent() no es IMMUTABLE y no se puede usar en índices; esta versión fija el diccionario
+		`CREATE OR REPLACE FUNCTION immutable_unaccent(text) RETURNS text
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+			AS $$ SELECT public.unaccent('public.unaccent'::regdictionary, $1) $$`,
+		// ts_headline devuelve el texto tal cual con las etiquetas de resaltado; se escapa antes para
+		// que un nombre o descripción con HTML no llegue como marcado al cliente
+		`CREATE OR REPLACE FUNCTION html_escape(text) RETURNS text
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+			AS $$ SELECT replace(replace(replace(replace(replace($1,
+				'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;') $$`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'es_unaccent') THEN
+				CREATE TEXT SEARCH CONFIGURATION es_unaccent (COPY = spanish);
+				ALTER TEXT SEARCH CONFIGURATION es_unaccent
+					ALTER MAPPING FOR hword, hword_part, word WITH unaccent, spanish_stem;
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'en_unaccent') THEN
+				CREATE TEXT SEARCH CONFIGURATION en_unaccent (COPY = english);
+				ALTER TEXT SEARCH CONFIGURATION en_unaccent
+					ALTER MAPPING FOR hword, hword_part, word WITH unaccent, english_stem;
+			END IF;
+		END
+		$$`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE OR REPLACE FUNCTION products_search_vector(p_name text, p_sku text, p_description text, p_category text)
+			RETURNS tsvector LANGUAGE sql IMMUTABLE PARALLEL SAFE
+			AS $$ SELECT
+				setweight(to_tsvector('es_unaccent', COALESCE(p_name, '')), 'A') ||
+				setweight(to_tsvector('en_unaccent', COALESCE(p_name, '')), 'A') ||
+				setweight(to_tsvector('simple', COALESCE(p_sku, '')), 'B') ||
+				setweight(to_tsvector('es_unaccent', COALESCE(p_description, '')), 'C') ||
+				setweight(to_tsvector('en_unaccent', COALESCE(p_description, '')), 'C') ||
+				setweight(to_tsvector('es_unaccent', COALESCE(p_category, '')), 'D') $$`,
+		`CREATE OR REPLACE FUNCTION products_search_vector_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			NEW.search_vector := products_search_vector(NEW.name, NEW.sku, NEW.description,
+				(SELECT name FROM categories WHERE id = NEW.category_id));
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS products_search_vector_update ON products`,
+		`CREATE TRIGGER products_search_vector_update
+			BEFORE INSERT OR UPDATE OF name, sku, description, category_id ON products
+			FOR EACH ROW EXECUTE FUNCTION products_search_vector_trigger()`,
+		// Al renombrar una categoría se recalculan sus productos
+		`CREATE OR REPLACE FUNCTION categories_search_vector_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			UPDATE products SET category_id = category_id WHERE category_id = NEW.id;
+			RETURN NEW;
+		END
+		$$`,
+		`DROP TRIGGER IF EXISTS categories_search_vector_update ON categories`,
+		`CREATE TRIGGER categories_search_vector_update
+			AFTER UPDATE OF name ON categories
+			FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+			EXECUTE FUNCTION categories_search_vector_trigger()`,
+		`UPDATE products SET name = name WHERE search_vector IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (immutable_unaccent(lower(name)) gin_trgm_ops)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating product search: %w", err)
+		}
+	}
+	return nil
+}
+
+// productSearchQuery es la consulta de texto completo en español, inglés y SKU a partir de lo que
+// escribió el usuario (admite "frases", OR y -exclusiones)
+const productSearchQuery = `(websearch_to_tsquery('es_unaccent', %[1]s) || websearch_to_tsquery('en_unaccent', %[1]s) || websearch_to_tsquery('simple', %[1]s))`
+
+// productSearchCondition filtra los productos que coinciden con la búsqueda del parámetro n: por
+// texto completo o, para tolerar errores de escritura, por parecido del nombre
+func productSearchCondition(n int) string {
+	param := fmt.Sprintf("$%d", n)
+	return fmt.Sprintf("(p.search_vector @@ %s OR immutable_unaccent(lower(%s)) <%% immutable_unaccent(lower(p.name)))",
+		fmt.Sprintf(productSearchQuery, param), param)
+}
+
+// productSearchRank ordena por relevancia: el rango de texto completo pondera nombre > SKU >
+// descripción > categoría y el parecido del nombre desempata y rescata los errores de escritura
+func productSearchRank(n int) string {
+	param := fmt.Sprintf("$%d", n)
+	return fmt.Sprintf("(ts_rank_cd(p.search_vector, %s) + word_similarity(immutable_unaccent(lower(%s)), immutable_unaccent(lower(p.name))))",
+		fmt.Sprintf(productSearchQuery, param), param)
+}
+
+// productSearchHeadline resalta con <mark> los términos buscados en el nombre y un fragmento de la
+// descripción. El texto se escapa como HTML antes de resaltarlo, así <mark> es el único marcado.
+func productSearchHeadline(n int) string {
+	query := fmt.Sprintf(productSearchQuery, fmt.Sprintf("$%d", n))
+	return fmt.Sprintf(`ts_headline('es_unaccent', html_escape(p.name), %[1]s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		CASE WHEN p.description IS NULL OR p.description = '' THEN NULL
+			ELSE ts_headline('es_unaccent', html_escape(p.description), %[1]s, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=8, FragmentDelimiter=" … "')
+		END`, query)
+}
+
+// SearchBenchmarkResult compara el tiempo medio de una búsqueda con ILIKE y con texto completo
+type SearchBenchmarkResult struct {
+	Term         string        `json:"term"`
+	ILikeTime    time.Duration `json:"ilike_time"`
+	ILikeRows    int           `json:"ilike_rows"`
+	FullText     time.Duration `json:"full_text_time"`
+	FullTextRows int           `json:"full_text_rows"`
+}
+
+// BenchmarkProductSearch inserta products productos de prueba dentro de una transacción, mide
+// cada término con la búsqueda anterior (ILIKE) y con la de texto completo, y deshace todo al
+// terminar. Bloquea la tabla de productos mientras dura: usar solo en desarrollo.
+func BenchmarkProductSearch(db *pgxpool.Pool, products, runs int, terms []string) ([]SearchBenchmarkResult, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := seedSearchBenchmark(ctx, tx, products); err != nil {
+		return nil, err
+	}
+
+	var results []SearchBenchmarkResult
+	for _, term := range terms {
+		r := SearchBenchmarkResult{Term: term}
+		if r.ILikeTime, r.ILikeRows, err = timeQuery(ctx, tx, runs, searchBenchILike, "%"+term+"%"); err != nil {
+			return nil, fmt.Errorf("error midiendo ILIKE: %w", err)
+		}
+		if r.FullText, r.FullTextRows, err = timeQuery(ctx, tx, runs, searchBenchFullText(), strings.TrimSpace(term)); err != nil {
+			return nil, fmt.Errorf("error midiendo texto completo: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// searchBenchILike es la búsqueda anterior al texto completo, para comparar
+const searchBenchILike = `SELECT p.id FROM products p WHERE p.is_active = true
+	AND (p.name ILIKE $1 OR p.description ILIKE $1) ORDER BY p.created_at DESC LIMIT 12`
+
+// searchBenchFullText es la búsqueda de texto completo con el orden por relevancia
+func searchBenchFullText() string {
+	return fmt.Sprintf(`SELECT p.id FROM products p WHERE p.is_active = true AND %s
+		ORDER BY %s DESC LIMIT 12`, productSearchCondition(1), productSearchRank(1))
+}
+
+// seedSearchBenchmark inserta products productos de prueba en la transacción y actualiza las
+// estadísticas para que el planificador use los índices
+func seedSearchBenchmark(ctx context.Context, tx pgx.Tx, products int) error {
+	_, err := tx.Exec(ctx, `
+		WITH words AS (
+			SELECT ARRAY['Lámpara', 'Mesa', 'Silla', 'Sofá', 'Estantería', 'Cojín', 'Espejo', 'Alfombra',
+				'Jarrón', 'Reloj', 'Cómoda', 'Escritorio', 'Lamp', 'Chair', 'Table', 'Shelf'] AS nouns,
+				ARRAY['nórdica', 'de roble', 'de mármol', 'industrial', 'rústica', 'moderna', 'vintage',
+				'de cerámica', 'minimalista', 'de latón', 'plegable', 'extensible'] AS adjectives
+		)
+		INSERT INTO products (name, description, price, stock, sku, is_active)
+		SELECT
+			nouns[1 + (i % array_length(nouns, 1))] || ' ' || adjectives[1 + ((i / 7) % array_length(adjectives, 1))] || ' ' || i,
+			'Pieza ' || adjectives[1 + ((i / 3) % array_length(adjectives, 1))] || ' diseñada para salón, dormitorio u oficina. Acabado ' ||
+				adjectives[1 + ((i / 11) % array_length(adjectives, 1))] || '.',
+			10 + (i % 500),
+			i % 20,
+			'BENCH-' || i,
+			true
+		FROM words, generate_series(1, $1) AS i
+	`, products)
+	if err != nil {
+		return fmt.Errorf("error insertando productos de prueba: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `ANALYZE products`); err != nil {
+		return fmt.Errorf("error analizando productos: %w", err)
+	}
+	return nil
+}
+
+// timeQuery ejecuta la consulta runs veces y devuelve el tiempo medio y las filas obtenidas
+func timeQuery(ctx context.Context, tx pgx.Tx, runs int, query string, arg string) (time.Duration, int, error) {
+	var total time.Duration
+	count := 0
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		rows, err := tx.Query(ctx, query, arg)
+		if err != nil {
+			return 0, 0, err
+		}
+		count = 0
+		for rows.Next() {
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, 0, err
+		}
+		total += time.Since(start)
+	}
+	return total / time.Duration(runs), count, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Estas pruebas necesitan PostgreSQL con unaccent y pg_trgm. Se ejecutan contra TEST_DATABASE_URL
+// (crea las tablas si no existen) y se omiten si no está definida:
+//
+//	TEST_DATABASE_URL=postgres://... go test ./internal/db -run Search -bench Search
+//
+// Los benchmarks insertan productos de prueba en una transacción que se deshace al terminar.
+// Para comparar con más datos o más términos está también `server search-bench -products 100000`.
+
+// connectTestDB conecta Pool a la base de pruebas o salta la prueba
+func connectTestDB(tb testing.TB) {
+	tb.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("TEST_DATABASE_URL no definida")
+	}
+	if Pool != nil {
+		return
+	}
+	tb.Setenv("DATABASE_URL", url)
+	if err := Connect(); err != nil {
+		tb.Fatalf("Connect: %v", err)
+	}
+}
+
+func TestProductSearchHeadlineEscapesHTML(t *testing.T) {
+	connectTestDB(t)
+
+	tests := []struct {
+		name, description string
+		wantName          string
+	}{
+		{
+			name:        `<img src=x onerror="alert(1)"> Lámpara`,
+			description: `<script>alert('x')</script> Lámpara nórdica de latón para salón, dormitorio u oficina & más`,
+			wantName:    `&lt;img src=x onerror=&quot;alert(1)&quot;&gt; <mark>Lámpara</mark>`,
+		},
+		{
+			name:        `Lámpara "Tom & Jerry"`,
+			description: "",
+			wantName:    `<mark>Lámpara</mark> &quot;Tom &amp; Jerry&quot;`,
+		},
+	}
+
+	query := `SELECT ` + productSearchHeadline(1) + ` FROM (SELECT $2::text AS name, $3::text AS description) p`
+	for _, tt := range tests {
+		var name string
+		var snippet *string
+		if err := Pool.QueryRow(context.Background(), query, "lampara", tt.name, tt.description).Scan(&name, &snippet); err != nil {
+			t.Fatalf("headline: %v", err)
+		}
+		if name != tt.wantName {
+			t.Errorf("nombre resaltado %q, se esperaba %q", name, tt.wantName)
+		}
+		if tt.description == "" {
+			if snippet != nil {
+				t.Errorf("fragmento %q de una descripción vacía", *snippet)
+			}
+			continue
+		}
+		if snippet == nil {
+			t.Fatal("sin fragmento de la descripción")
+		}
+		// Fuera de <mark> no debe quedar ninguna etiqueta
+		rest := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(*snippet)
+		if strings.ContainsAny(rest, "<>") || !strings.Contains(*snippet, "<mark>") {
+			t.Errorf("fragmento sin escapar o sin resaltar: %q", *snippet)
+		}
+	}
+}
+
+// searchBenchProducts es el tamaño del catálogo de prueba de los benchmarks; por defecto 100k, el
+// tamaño con el que se midió la búsqueda (go test ./internal/db -bench Search -args -products 20000)
+var searchBenchProducts = flag.Int("products", 100000, "productos de prueba de BenchmarkSearch")
+
+func BenchmarkSearch(b *testing.B) {
+	connectTestDB(b)
+	ctx := context.Background()
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		b.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := seedSearchBenchmark(ctx, tx, *searchBenchProducts); err != nil {
+		b.Fatal(err)
+	}
+
+	fullText := searchBenchFullText()
+	for _, term := range []string{"lampara", "mesa de roble", "lampra", "BENCH-4242"} {
+		b.Run("ilike/"+term, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := timeQuery(ctx, tx, 1, searchBenchILike, "%"+term+"%"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("fulltext/"+term, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := timeQuery(ctx, tx, 1, fullText, term); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createShipmentTables crea las tablas de envíos con paquetería y eventos de rastreo
+func createShipmentTables() error {
+	shipmentsTable := `
+	CREATE TABLE IF NOT EXISTS shipments (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL,
+		carrier VARCHAR(50) NOT NULL,
+		service VARCHAR(50) NOT NULL DEFAULT '',
+		tracking_number VARCHAR(100) NOT NULL,
+		label_url TEXT NOT NULL DEFAULT '',
+		status VARCHAR(30) NOT NULL DEFAULT 'label_created',
+		cost DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		last_event TEXT NOT NULL DEFAULT '',
+		last_checked_at TIMESTAMPTZ,
+		shipped_at TIMESTAMPTZ,
+		delivered_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (carrier, tracking_number),
+		FOREIGN KEY(order_id) REFERENCES orders(id) ON DELETE CASCADE
+	);
+	`
+	_, err := Pool.Exec(context.Background(), shipmentsTable)
+	if err != nil {
+		return fmt.Errorf("error creating shipments table: %w", err)
+	}
+
+	eventsTable := `
+	CREATE TABLE IF NOT EXISTS tracking_events (
+		id SERIAL PRIMARY KEY,
+		shipment_id INTEGER NOT NULL,
+		status VARCHAR(30) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		location VARCHAR(255) NOT NULL DEFAULT '',
+		occurred_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (shipment_id, status, occurred_at),
+		FOREIGN KEY(shipment_id) REFERENCES shipments(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), eventsTable)
+	if err != nil {
+		return fmt.Errorf("error creating tracking_events table: %w", err)
+	}
+
+	itemsTable := `
+	CREATE TABLE IF NOT EXISTS shipment_items (
+		id SERIAL PRIMARY KEY,
+		shipment_id INTEGER NOT NULL,
+		order_item_id INTEGER NOT NULL,
+		quantity INTEGER NOT NULL CHECK (quantity > 0),
+		UNIQUE (shipment_id, order_item_id),
+		FOREIGN KEY(shipment_id) REFERENCES shipments(id) ON DELETE CASCADE,
+		FOREIGN KEY(order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), itemsTable)
+	if err != nil {
+		return fmt.Errorf("error creating shipment_items table: %w", err)
+	}
+
+	migrations := []string{
+		"CREATE INDEX IF NOT EXISTS idx_shipment_items_order_item_id ON shipment_items(order_item_id);",
+		"CREATE INDEX IF NOT EXISTS idx_shipments_order_id ON shipments(order_id);",
+		"CREATE INDEX IF NOT EXISTS idx_shipments_status ON shipments(status);",
+		// La columna tracking se usaba en UpdateOrderTracking pero nunca se creaba
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking VARCHAR(100)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating shipment columns: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const shipmentColumns = `id, order_id, carrier, service, tracking_number, label_url, status, cost, last_event,
+	last_checked_at, shipped_at, delivered_at, created_at, updated_at`
+
+func scanShipment(row pgx.Row) (*models.Shipment, error) {
+	var s models.Shipment
+	err := row.Scan(&s.ID, &s.OrderID, &s.Carrier, &s.Service, &s.TrackingNumber, &s.LabelURL, &s.Status,
+		&s.Cost, &s.LastEvent, &s.LastCheckedAt, &s.ShippedAt, &s.DeliveredAt, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func queryShipments(db *pgxpool.Pool, query string, args ...interface{}) ([]models.Shipment, error) {
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo envíos: %w", err)
+	}
+	defer rows.Close()
+
+	var shipments []models.Shipment
+	for rows.Next() {
+		s, err := scanShipment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando envío: %w", err)
+		}
+		shipments = append(shipments, *s)
+	}
+	return shipments, nil
+}
+
+// CreateShipment guarda un nuevo envío con sus items. Valida en una transacción que las
+// cantidades no excedan lo pendiente de enviar de cada item del pedido.
+func CreateShipment(db *pgxpool.Pool, shipment *models.Shipment) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear el pedido para serializar la creación de envíos concurrentes
+	if _, err := tx.Exec(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, shipment.OrderID); err != nil {
+		return fmt.Errorf("error bloqueando pedido: %w", err)
+	}
+
+	if len(shipment.Items) > 0 {
+		remaining, err := unfulfilledQuantities(ctx, tx, shipment.OrderID)
+		if err != nil {
+			return err
+		}
+		for _, item := range shipment.Items {
+			available, ok := remaining[item.OrderItemID]
+			if !ok {
+				return fmt.Errorf("el item %d no pertenece al pedido", item.OrderItemID)
+			}
+			if item.Quantity <= 0 || item.Quantity > available {
+				return fmt.Errorf("cantidad inválida para el item %d: pendiente %d", item.OrderItemID, available)
+			}
+			remaining[item.OrderItemID] -= item.Quantity
+		}
+	}
+
+	query := `
+		INSERT INTO shipments (order_id, carrier, service, tracking_number, label_url, status, cost, last_event)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(ctx, query,
+		shipment.OrderID, shipment.Carrier, shipment.Service, shipment.TrackingNumber, shipment.LabelURL,
+		shipment.Status, shipment.Cost, shipment.LastEvent,
+	).Scan(&shipment.ID, &shipment.CreatedAt, &shipment.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando envío: %w", err)
+	}
+
+	for i := range shipment.Items {
+		item := &shipment.Items[i]
+		item.ShipmentID = shipment.ID
+		err := tx.QueryRow(ctx, `
+			INSERT INTO shipment_items (shipment_id, order_item_id, quantity)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (shipment_id, order_item_id) DO UPDATE SET quantity = shipment_items.quantity + EXCLUDED.quantity
+			RETURNING id
+		`, shipment.ID, item.OrderItemID, item.Quantity).Scan(&item.ID)
+		if err != nil {
+			return fmt.Errorf("error guardando items del envío: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando envío: %w", err)
+	}
+	return nil
+}
+
+// unfulfilledQuantities devuelve la cantidad pendiente de enviar por item del pedido
+func unfulfilledQuantities(ctx context.Context, tx pgx.Tx, orderID int) (map[int]int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT oi.id, oi.quantity - COALESCE((
+			SELECT SUM(si.quantity)
+			FROM shipment_items si
+			JOIN shipments s ON s.id = si.shipment_id
+			WHERE si.order_item_id = oi.id AND s.status <> 'cancelled'
+		), 0)
+		FROM order_items oi
+		WHERE oi.order_id = $1
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error calculando cantidades pendientes: %w", err)
+	}
+	defer rows.Close()
+
+	remaining := make(map[int]int)
+	for rows.Next() {
+		var id, qty int
+		if err := rows.Scan(&id, &qty); err != nil {
+			return nil, fmt.Errorf("error escaneando cantidades pendientes: %w", err)
+		}
+		remaining[id] = qty
+	}
+	return remaining, nil
+}
+
+// GetUnfulfilledItems obtiene los items del pedido que aún no se han incluido en un envío
+func GetUnfulfilledItems(db *pgxpool.Pool, orderID int) ([]models.ShipmentItem, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT oi.id, oi.product_id, COALESCE(p.name, ''), oi.quantity - COALESCE((
+			SELECT SUM(si.quantity)
+			FROM shipment_items si
+			JOIN shipments s ON s.id = si.shipment_id
+			WHERE si.order_item_id = oi.id AND s.status <> 'cancelled'
+		), 0) AS pending
+		FROM order_items oi
+		LEFT JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1
+		ORDER BY oi.id ASC
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo items pendientes de envío: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.ShipmentItem
+	for rows.Next() {
+		var item models.ShipmentItem
+		if err := rows.Scan(&item.OrderItemID, &item.ProductID, &item.ProductName, &item.Quantity); err != nil {
+			return nil, fmt.Errorf("error escaneando item pendiente: %w", err)
+		}
+		if item.Quantity > 0 {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// GetShipmentItems obtiene los items incluidos en un envío
+func GetShipmentItems(db *pgxpool.Pool, shipmentID int) ([]models.ShipmentItem, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT si.id, si.shipment_id, si.order_item_id, oi.product_id, COALESCE(p.name, ''), si.quantity
+		FROM shipment_items si
+		JOIN order_items oi ON oi.id = si.order_item_id
+		LEFT JOIN products p ON p.id = oi.product_id
+		WHERE si.shipment_id = $1
+		ORDER BY si.id ASC
+	`, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo items del envío: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.ShipmentItem
+	for rows.Next() {
+		var item models.ShipmentItem
+		if err := rows.Scan(&item.ID, &item.ShipmentID, &item.OrderItemID, &item.ProductID, &item.ProductName, &item.Quantity); err != nil {
+			return nil, fmt.Errorf("error escaneando item del envío: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// UpdateShipmentDetails actualiza la paquetería y el número de rastreo de un envío
+func UpdateShipmentDetails(db *pgxpool.Pool, shipment *models.Shipment) error {
+	result, err := db.Exec(context.Background(), `
+		UPDATE shipments SET carrier = $1, service = $2, tracking_number = $3, updated_at = NOW()
+		WHERE id = $4
+	`, shipment.Carrier, shipment.Service, shipment.TrackingNumber, shipment.ID)
+	if err != nil {
+		return fmt.Errorf("error actualizando envío: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("envío no encontrado")
+	}
+	return nil
+}
+
+// GetShipmentByID obtiene un envío por su ID
+func GetShipmentByID(db *pgxpool.Pool, shipmentID int) (*models.Shipment, error) {
+	s, err := scanShipment(db.QueryRow(context.Background(), `SELECT `+shipmentColumns+` FROM shipments WHERE id = $1`, shipmentID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("envío no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo envío: %w", err)
+	}
+	return s, nil
+}
+
+// GetShipmentByTracking obtiene un envío por paquetería y número de rastreo
+func GetShipmentByTracking(db *pgxpool.Pool, carrier, trackingNumber string) (*models.Shipment, error) {
+	s, err := scanShipment(db.QueryRow(context.Background(),
+		`SELECT `+shipmentColumns+` FROM shipments WHERE carrier = $1 AND tracking_number = $2`, carrier, trackingNumber))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("envío no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo envío: %w", err)
+	}
+	return s, nil
+}
+
+// GetOrderShipments obtiene los envíos de un pedido con sus items
+func GetOrderShipments(db *pgxpool.Pool, orderID int) ([]models.Shipment, error) {
+	shipments, err := queryShipments(db, `SELECT `+shipmentColumns+` FROM shipments WHERE order_id = $1 ORDER BY created_at ASC`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shipments {
+		items, err := GetShipmentItems(db, shipments[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		shipments[i].Items = items
+	}
+	return shipments, nil
+}
+
+// GetActiveShipments obtiene los envíos que aún no terminan, empezando por los revisados hace más tiempo
+func GetActiveShipments(db *pgxpool.Pool, limit int) ([]models.Shipment, error) {
+	return queryShipments(db, `
+		SELECT `+shipmentColumns+` FROM shipments
+		WHERE status NOT IN ('delivered', 'cancelled')
+		ORDER BY last_checked_at ASC NULLS FIRST
+		LIMIT $1
+	`, limit)
+}
+
+// UpdateShipmentStatus guarda el estado de rastreo de un envío
+func UpdateShipmentStatus(db *pgxpool.Pool, shipment *models.Shipment) error {
+	query := `
+		UPDATE shipments
+		SET status = $1, last_event = $2, last_checked_at = $3, shipped_at = $4, delivered_at = $5, updated_at = NOW()
+		WHERE id = $6
+	`
+	result, err := db.Exec(context.Background(), query,
+		shipment.Status, shipment.LastEvent, shipment.LastCheckedAt, shipment.ShippedAt, shipment.DeliveredAt, shipment.ID)
+	if err != nil {
+		return fmt.Errorf("error actualizando envío: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("envío no encontrado")
+	}
+	return nil
+}
+
+// AddTrackingEvent guarda un evento de rastreo. Devuelve false si el evento ya existía.
+func AddTrackingEvent(db *pgxpool.Pool, event *models.TrackingEvent) (bool, error) {
+	query := `
+		INSERT INTO tracking_events (shipment_id, status, description, location, occurred_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (shipment_id, status, occurred_at) DO NOTHING
+		RETURNING id, created_at
+	`
+	err := db.QueryRow(context.Background(), query,
+		event.ShipmentID, event.Status, event.Description, event.Location, event.OccurredAt,
+	).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("error guardando evento de rastreo: %w", err)
+	}
+	return true, nil
+}
+
+// GetShipmentEvents obtiene los eventos de rastreo de un envío
+func GetShipmentEvents(db *pgxpool.Pool, shipmentID int) ([]models.TrackingEvent, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, shipment_id, status, description, location, occurred_at, created_at
+		FROM tracking_events
+		WHERE shipment_id = $1
+		ORDER BY occurred_at ASC
+	`, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo eventos de rastreo: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.TrackingEvent
+	for rows.Next() {
+		var e models.TrackingEvent
+		if err := rows.Scan(&e.ID, &e.ShipmentID, &e.Status, &e.Description, &e.Location, &e.OccurredAt, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando evento de rastreo: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createShippingTables crea las tablas de zonas y métodos de envío
+func createShippingTables() error {
+	zonesTable := `
+	CREATE TABLE IF NOT EXISTS shipping_zones (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		country VARCHAR(2) NOT NULL DEFAULT '',
+		state VARCHAR(100) NOT NULL DEFAULT '',
+		postal_prefix VARCHAR(20) NOT NULL DEFAULT '',
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	`
+	_, err := Pool.Exec(context.Background(), zonesTable)
+	if err != nil {
+		return fmt.Errorf("error creating shipping_zones table: %w", err)
+	}
+
+	methodsTable := `
+	CREATE TABLE IF NOT EXISTS shipping_methods (
+		id SERIAL PRIMARY KEY,
+		zone_id INTEGER NOT NULL,
+		code VARCHAR(30) NOT NULL DEFAULT 'standard',
+		name VARCHAR(100) NOT NULL,
+		rule_type VARCHAR(20) NOT NULL DEFAULT 'flat',
+		base_cost DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		per_kg DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		tiers JSONB NOT NULL DEFAULT '[]',
+		free_over DECIMAL(10, 2),
+		max_weight DECIMAL(10, 2),
+		min_days INTEGER NOT NULL DEFAULT 0,
+		max_days INTEGER NOT NULL DEFAULT 0,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		FOREIGN KEY(zone_id) REFERENCES shipping_zones(id) ON DELETE CASCADE
+	);
+	`
+	_, err = Pool.Exec(context.Background(), methodsTable)
+	if err != nil {
+		return fmt.Errorf("error creating shipping_methods table: %w", err)
+	}
+
+	migrations := []string{
+		"CREATE INDEX IF NOT EXISTS idx_shipping_methods_zone_id ON shipping_methods(zone_id);",
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_method VARCHAR(100) NOT NULL DEFAULT ''`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating shipping columns: %w", err)
+		}
+	}
+
+	// Zona por defecto con envío estándar gratuito, equivalente al comportamiento anterior
+	var count int
+	if err := Pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM shipping_zones").Scan(&count); err != nil {
+		return fmt.Errorf("error counting shipping zones: %w", err)
+	}
+	if count == 0 {
+		var zoneID int
+		err = Pool.QueryRow(context.Background(),
+			`INSERT INTO shipping_zones (name) VALUES ('Todas las zonas') RETURNING id`).Scan(&zoneID)
+		if err != nil {
+			return fmt.Errorf("error seeding default shipping zone: %w", err)
+		}
+		_, err = Pool.Exec(context.Background(), `
+			INSERT INTO shipping_methods (zone_id, code, name, rule_type, base_cost, min_days, max_days)
+			VALUES ($1, 'standard', 'Envío estándar', 'flat', 0, 3, 7)
+		`, zoneID)
+		if err != nil {
+			return fmt.Errorf("error seeding default shipping method: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const shippingMethodColumns = `id, zone_id, code, name, rule_type, base_cost, per_kg, tiers, free_over, max_weight,
+	min_days, max_days, sort_order, is_active, created_at, updated_at`
+
+func scanShippingMethod(row pgx.Row) (*models.ShippingMethod, error) {
+	var m models.ShippingMethod
+	var tiersJSON []byte
+	err := row.Scan(&m.ID, &m.ZoneID, &m.Code, &m.Name, &m.RuleType, &m.BaseCost, &m.PerKg, &tiersJSON,
+		&m.FreeOver, &m.MaxWeight, &m.MinDays, &m.MaxDays, &m.SortOrder, &m.IsActive, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(tiersJSON) > 0 {
+		if err := json.Unmarshal(tiersJSON, &m.Tiers); err != nil {
+			return nil, fmt.Errorf("error leyendo rangos de tarifa: %w", err)
+		}
+	}
+	return &m, nil
+}
+
+// GetShippingZones obtiene las zonas de envío con sus métodos
+func GetShippingZones(db *pgxpool.Pool, activeOnly bool) ([]models.ShippingZone, error) {
+	query := `SELECT id, name, country, state, postal_prefix, is_active, created_at, updated_at FROM shipping_zones`
+	if activeOnly {
+		query += " WHERE is_active = true"
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo zonas de envío: %w", err)
+	}
+	defer rows.Close()
+
+	var zones []models.ShippingZone
+	index := make(map[int]int)
+	for rows.Next() {
+		var z models.ShippingZone
+		if err := rows.Scan(&z.ID, &z.Name, &z.Country, &z.State, &z.PostalPrefix, &z.IsActive, &z.CreatedAt, &z.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando zona de envío: %w", err)
+		}
+		index[z.ID] = len(zones)
+		zones = append(zones, z)
+	}
+	rows.Close()
+
+	methodsQuery := `SELECT ` + shippingMethodColumns + ` FROM shipping_methods`
+	if activeOnly {
+		methodsQuery += " WHERE is_active = true"
+	}
+	methodsQuery += " ORDER BY sort_order, id"
+
+	methodRows, err := db.Query(context.Background(), methodsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo métodos de envío: %w", err)
+	}
+	defer methodRows.Close()
+
+	for methodRows.Next() {
+		m, err := scanShippingMethod(methodRows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando método de envío: %w", err)
+		}
+		if i, ok := index[m.ZoneID]; ok {
+			zones[i].Methods = append(zones[i].Methods, *m)
+		}
+	}
+	return zones, nil
+}
+
+// CreateShippingZone crea una nueva zona de envío
+func CreateShippingZone(db *pgxpool.Pool, zone *models.ShippingZone) error {
+	query := `
+		INSERT INTO shipping_zones (name, country, state, postal_prefix, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`
+	err := db.QueryRow(context.Background(), query, zone.Name, zone.Country, zone.State, zone.PostalPrefix, zone.IsActive).
+		Scan(&zone.ID, &zone.CreatedAt, &zone.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando zona de envío: %w", err)
+	}
+	return nil
+}
+
+// UpdateShippingZone actualiza una zona de envío
+func UpdateShippingZone(db *pgxpool.Pool, zone *models.ShippingZone) error {
+	query := `
+		UPDATE shipping_zones
+		SET name = $1, country = $2, state = $3, postal_prefix = $4, is_active = $5, updated_at = NOW()
+		WHERE id = $6
+		RETURNING created_at, updated_at
+	`
+	err := db.QueryRow(context.Background(), query, zone.Name, zone.Country, zone.State, zone.PostalPrefix, zone.IsActive, zone.ID).
+		Scan(&zone.CreatedAt, &zone.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("zona de envío no encontrada")
+		}
+		return fmt.Errorf("error actualizando zona de envío: %w", err)
+	}
+	return nil
+}
+
+// DeleteShippingZone elimina una zona de envío y sus métodos
+func DeleteShippingZone(db *pgxpool.Pool, zoneID int) error {
+	result, err := db.Exec(context.Background(), `DELETE FROM shipping_zones WHERE id = $1`, zoneID)
+	if err != nil {
+		return fmt.Errorf("error eliminando zona de envío: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("zona de envío no encontrada")
+	}
+	return nil
+}
+
+// GetShippingMethodByID obtiene un método de envío por su ID
+func GetShippingMethodByID(db *pgxpool.Pool, methodID int) (*models.ShippingMethod, error) {
+	m, err := scanShippingMethod(db.QueryRow(context.Background(), `SELECT `+shippingMethodColumns+` FROM shipping_methods WHERE id = $1`, methodID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("método de envío no encontrado")
+		}
+		return nil, fmt.Errorf("error obteniendo método de envío: %w", err)
+	}
+	return m, nil
+}
+
+// CreateShippingMethod crea un método de envío en una zona
+func CreateShippingMethod(db *pgxpool.Pool, method *models.ShippingMethod) error {
+	tiersJSON, err := json.Marshal(tiersOrEmpty(method.Tiers))
+	if err != nil {
+		return fmt.Errorf("error serializando rangos de tarifa: %w", err)
+	}
+
+	query := `
+		INSERT INTO shipping_methods (zone_id, code, name, rule_type, base_cost, per_kg, tiers, free_over, max_weight,
+			min_days, max_days, sort_order, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at
+	`
+	err = db.QueryRow(context.Background(), query,
+		method.ZoneID, method.Code, method.Name, method.RuleType, method.BaseCost, method.PerKg, tiersJSON,
+		method.FreeOver, method.MaxWeight, method.MinDays, method.MaxDays, method.SortOrder, method.IsActive,
+	).Scan(&method.ID, &method.CreatedAt, &method.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando método de envío: %w", err)
+	}
+	return nil
+}
+
+// UpdateShippingMethod actualiza un método de envío
+func UpdateShippingMethod(db *pgxpool.Pool, method *models.ShippingMethod) error {
+	tiersJSON, err := json.Marshal(tiersOrEmpty(method.Tiers))
+	if err != nil {
+		return fmt.Errorf("error serializando rangos de tarifa: %w", err)
+	}
+
+	query := `
+		UPDATE shipping_methods
+		SET zone_id = $1, code = $2, name = $3, rule_type = $4, base_cost = $5, per_kg = $6, tiers = $7,
+			free_over = $8, max_weight = $9, min_days = $10, max_days = $11, sort_order = $12, is_active = $13,
+			updated_at = NOW()
+		WHERE id = $14
+		RETURNING created_at, updated_at
+	`
+	err = db.QueryRow(context.Background(), query,
+		method.ZoneID, method.Code, method.Name, method.RuleType, method.BaseCost, method.PerKg, tiersJSON,
+		method.FreeOver, method.MaxWeight, method.MinDays, method.MaxDays, method.SortOrder, method.IsActive, method.ID,
+	).Scan(&method.CreatedAt, &method.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("método de envío no encontrado")
+		}
+		return fmt.Errorf("error actualizando método de envío: %w", err)
+	}
+	return nil
+}
+
+// DeleteShippingMethod elimina un método de envío
+func DeleteShippingMethod(db *pgxpool.Pool, methodID int) error {
+	result, err := db.Exec(context.Background(), `DELETE FROM shipping_methods WHERE id = $1`, methodID)
+	if err != nil {
+		return fmt.Errorf("error eliminando método de envío: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("método de envío no encontrado")
+	}
+	return nil
+}
+
+func tiersOrEmpty(tiers []models.ShippingTier) []models.ShippingTier {
+	if tiers == nil {
+		return []models.ShippingTier{}
+	}
+	return tiers
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createTaxTables crea la tabla de tasas de impuesto y las columnas fiscales relacionadas
+func createTaxTables() error {
+	taxRatesTable := `
+	CREATE TABLE IF NOT EXISTS tax_rates (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		country VARCHAR(2) NOT NULL DEFAULT '',
+		state VARCHAR(100) NOT NULL DEFAULT '',
+		postal_prefix VARCHAR(20) NOT NULL DEFAULT '',
+		tax_class VARCHAR(30) NOT NULL DEFAULT 'standard',
+		rate DECIMAL(7, 5) NOT NULL CHECK (rate >= 0),
+		inclusive BOOLEAN NOT NULL DEFAULT FALSE,
+		priority INTEGER NOT NULL DEFAULT 1,
+		is_active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	`
+	_, err := Pool.Exec(context.Background(), taxRatesTable)
+	if err != nil {
+		return fmt.Errorf("error creating tax_rates table: %w", err)
+	}
+
+	migrations := []string{
+		"CREATE INDEX IF NOT EXISTS idx_tax_rates_country ON tax_rates(country);",
+		`ALTER TABLE categories ADD COLUMN IF NOT EXISTS tax_class VARCHAR(30) NOT NULL DEFAULT 'standard'`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS tax_exempt BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(10, 2) NOT NULL DEFAULT 0`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_breakdown JSONB`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating tax columns: %w", err)
+		}
+	}
+
+	// Tasa por defecto equivalente al IVA fijo que se usaba antes (16% sobre el subtotal)
+	var count int
+	if err := Pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM tax_rates").Scan(&count); err != nil {
+		return fmt.Errorf("error counting tax rates: %w", err)
+	}
+	if count == 0 {
+		_, err = Pool.Exec(context.Background(),
+			`INSERT INTO tax_rates (name, country, tax_class, rate, inclusive, priority) VALUES ('IVA', '', 'standard', 0.16, FALSE, 1)`)
+		if err != nil {
+			return fmt.Errorf("error seeding default tax rate: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const taxRateColumns = `id, name, country, state, postal_prefix, tax_class, rate, inclusive, priority, is_active, created_at, updated_at`
+
+func scanTaxRate(row pgx.Row) (*models.TaxRate, error) {
+	var r models.TaxRate
+	err := row.Scan(&r.ID, &r.Name, &r.Country, &r.State, &r.PostalPrefix, &r.TaxClass, &r.Rate,
+		&r.Inclusive, &r.Priority, &r.IsActive, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetTaxRates obtiene las tasas de impuesto configuradas
+func GetTaxRates(db *pgxpool.Pool, activeOnly bool) ([]models.TaxRate, error) {
+	query := `SELECT ` + taxRateColumns + ` FROM tax_rates`
+	if activeOnly {
+		query += " WHERE is_active = true"
+	}
+	query += " ORDER BY country, state, postal_prefix, tax_class, priority"
+
+	rows, err := db.Query(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo tasas de impuesto: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []models.TaxRate
+	for rows.Next() {
+		r, err := scanTaxRate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando tasa de impuesto: %w", err)
+		}
+		rates = append(rates, *r)
+	}
+	return rates, nil
+}
+
+// GetTaxRateByID obtiene una tasa de impuesto por su ID
+func GetTaxRateByID(db *pgxpool.Pool, rateID int) (*models.TaxRate, error) {
+	r, err := scanTaxRate(db.QueryRow(context.Background(), `SELECT `+taxRateColumns+` FROM tax_rates WHERE id = $1`, rateID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("tasa de impuesto no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo tasa de impuesto: %w", err)
+	}
+	return r, nil
+}
+
+// CreateTaxRate crea una nueva tasa de impuesto
+func CreateTaxRate(db *pgxpool.Pool, rate *models.TaxRate) error {
+	query := `
+		INSERT INTO tax_rates (name, country, state, postal_prefix, tax_class, rate, inclusive, priority, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`
+	err := db.QueryRow(context.Background(), query,
+		rate.Name, rate.Country, rate.State, rate.PostalPrefix, rate.TaxClass, rate.Rate,
+		rate.Inclusive, rate.Priority, rate.IsActive,
+	).Scan(&rate.ID, &rate.CreatedAt, &rate.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando tasa de impuesto: %w", err)
+	}
+	return nil
+}
+
+// UpdateTaxRate actualiza una tasa de impuesto existente
+func UpdateTaxRate(db *pgxpool.Pool, rate *models.TaxRate) error {
+	query := `
+		UPDATE tax_rates
+		SET name = $1, country = $2, state = $3, postal_prefix = $4, tax_class = $5, rate = $6,
+			inclusive = $7, priority = $8, is_active = $9, updated_at = NOW()
+		WHERE id = $10
+		RETURNING updated_at
+	`
+	err := db.QueryRow(context.Background(), query,
+		rate.Name, rate.Country, rate.State, rate.PostalPrefix, rate.TaxClass, rate.Rate,
+		rate.Inclusive, rate.Priority, rate.IsActive, rate.ID,
+	).Scan(&rate.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("tasa de impuesto no encontrada")
+		}
+		return fmt.Errorf("error actualizando tasa de impuesto: %w", err)
+	}
+	return nil
+}
+
+// DeleteTaxRate elimina una tasa de impuesto
+func DeleteTaxRate(db *pgxpool.Pool, rateID int) error {
+	result, err := db.Exec(context.Background(), `DELETE FROM tax_rates WHERE id = $1`, rateID)
+	if err != nil {
+		return fmt.Errorf("error eliminando tasa de impuesto: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("tasa de impuesto no encontrada")
+	}
+	return nil
+}
+
+// GetCategoryTaxClasses devuelve la clase fiscal de cada categoría
+func GetCategoryTaxClasses(db *pgxpool.Pool) (map[int]string, error) {
+	rows, err := db.Query(context.Background(), `SELECT id, tax_class FROM categories`)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo clases fiscales: %w", err)
+	}
+	defer rows.Close()
+
+	classes := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var class string
+		if err := rows.Scan(&id, &class); err != nil {
+			return nil, fmt.Errorf("error escaneando clase fiscal: %w", err)
+		}
+		classes[id] = class
+	}
+	return classes, nil
+}
+
+// UpdateCategoryTaxClass cambia la clase fiscal de una categoría
+func UpdateCategoryTaxClass(db *pgxpool.Pool, categoryID int, taxClass string) error {
+	result, err := db.Exec(context.Background(), `UPDATE categories SET tax_class = $1 WHERE id = $2`, taxClass, categoryID)
+	if err != nil {
+		return fmt.Errorf("error actualizando clase fiscal: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("categoría no encontrada")
+	}
+	return nil
+}
+
+// IsUserTaxExempt indica si el usuario está exento de impuestos
+func IsUserTaxExempt(db *pgxpool.Pool, userID int) (bool, error) {
+	var exempt bool
+	err := db.QueryRow(context.Background(), `SELECT tax_exempt FROM users WHERE id = $1`, userID).Scan(&exempt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, fmt.Errorf("usuario no encontrado")
+		}
+		return false, fmt.Errorf("error obteniendo exención fiscal: %w", err)
+	}
+	return exempt, nil
+}
+
+// SetUserTaxExempt marca o desmarca a un usuario como exento de impuestos
+func SetUserTaxExempt(db *pgxpool.Pool, userID int, exempt bool) error {
+	result, err := db.Exec(context.Background(), `UPDATE users SET tax_exempt = $1, updated_at = NOW() WHERE id = $2`, exempt, userID)
+	if err != nil {
+		return fmt.Errorf("error actualizando exención fiscal: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("usuario no encontrado")
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// MaxProductVariants limita las combinaciones que genera la matriz de variantes de un producto
+const MaxProductVariants = 100
+
+// createProductVariantTables crea las opciones y variantes de producto y enlaza los items del
+// carrito y de los pedidos con su variante. Un producto puede estar en el carrito una vez por
+// variante, por eso la unicidad de cart_items pasa a incluirla.
+func createProductVariantTables() error {
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS product_options (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			option_values TEXT[] NOT NULL DEFAULT '{}',
+			UNIQUE (product_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS product_variants (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			sku VARCHAR(100) UNIQUE,
+			label VARCHAR(200) NOT NULL,
+			options JSONB NOT NULL DEFAULT '{}',
+			option_key TEXT NOT NULL,
+			price DECIMAL(10, 2),
+			stock INTEGER NOT NULL DEFAULT 0,
+			weight DECIMAL(10, 2),
+			image_urls TEXT[] NOT NULL DEFAULT '{}',
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (product_id, option_key)
+		)`,
+		`ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE CASCADE`,
+		`ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_line ON cart_items (cart_id, product_id, COALESCE(variant_id, 0))`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE SET NULL`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_label VARCHAR(200)`,
+		`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_sku VARCHAR(100)`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating product variants: %w", err)
+		}
+	}
+	return nil
+}
+
+const productVariantColumns = `v.id, v.product_id, v.sku, v.label, v.options, v.price, COALESCE(v.price, p.price),
+	v.stock, v.weight, v.image_urls, v.is_active, v.position, v.created_at, v.updated_at`
+
+func scanProductVariant(row pgx.Row) (*models.ProductVariant, error) {
+	var v models.ProductVariant
+	var options []byte
+	err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Label, &options, &v.PriceOverride, &v.Price,
+		&v.Stock, &v.Weight, &v.ImageURLs, &v.IsActive, &v.Position, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &v.Options); err != nil {
+		return nil, fmt.Errorf("error leyendo opciones de la variante: %w", err)
+	}
+	v.Available = v.IsActive && v.Stock > 0
+	return &v, nil
+}
+
+// GetProductOptions obtiene las opciones de un producto con sus valores en orden
+func GetProductOptions(db *pgxpool.Pool, productID int) ([]models.ProductOption, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT id, name, position, option_values FROM product_options
+		WHERE product_id = $1
+		ORDER BY position, id
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo opciones del producto: %w", err)
+	}
+	defer rows.Close()
+
+	options := []models.ProductOption{}
+	for rows.Next() {
+		var o models.ProductOption
+		if err := rows.Scan(&o.ID, &o.Name, &o.Position, &o.Values); err != nil {
+			return nil, fmt.Errorf("error escaneando opción del producto: %w", err)
+		}
+		options = append(options, o)
+	}
+	return options, rows.Err()
+}
+
+// GetProductVariants obtiene las variantes de un producto; activeOnly deja fuera las desactivadas
+func GetProductVariants(db *pgxpool.Pool, productID int, activeOnly bool) ([]models.ProductVariant, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+productVariantColumns+`
+		FROM product_variants v
+		JOIN products p ON p.id = v.product_id
+		WHERE v.product_id = $1 AND (v.is_active OR NOT $2)
+		ORDER BY v.position, v.id
+	`, productID, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo variantes del producto: %w", err)
+	}
+	defer rows.Close()
+
+	variants := []models.ProductVariant{}
+	for rows.Next() {
+		v, err := scanProductVariant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando variante: %w", err)
+		}
+		variants = append(variants, *v)
+	}
+	return variants, rows.Err()
+}
+
+// GetProductVariant obtiene una variante de un producto
+func GetProductVariant(db *pgxpool.Pool, productID, variantID int) (*models.ProductVariant, error) {
+	v, err := scanProductVariant(db.QueryRow(context.Background(), `
+		SELECT `+productVariantColumns+`
+		FROM product_variants v
+		JOIN products p ON p.id = v.product_id
+		WHERE v.id = $1 AND v.product_id = $2
+	`, variantID, productID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("variante no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo variante: %w", err)
+	}
+	return v, nil
+}
+
+// ProductHasVariants indica si el producto tiene variantes activas y hay que elegir una para comprarlo
+func ProductHasVariants(db *pgxpool.Pool, productID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1 AND is_active)`, productID).Scan(&exists)
+	return exists, err
+}
+
+// GetCartProduct obtiene el producto tal como se vende en una línea del carrito: con variante, el
+// precio, stock, SKU, peso e imagen son los de la variante y el nombre la incluye. Un producto con
+// variantes pedido sin variante se devuelve como no disponible.
+func GetCartProduct(db *pgxpool.Pool, productID int, variantID *int) (*models.Product, *models.ProductVariant, error) {
+	product, err := GetProductByID(db, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if variantID == nil {
+		hasVariants, err := ProductHasVariants(db, productID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hasVariants {
+			product.IsActive = false
+		}
+		return product, nil, nil
+	}
+
+	variant, err := GetProductVariant(db, productID, *variantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	product.Name = product.Name + " (" + variant.Label + ")"
+	product.Price = variant.Price
+	product.Stock = variant.Stock
+	product.IsActive = product.IsActive && variant.IsActive
+	if variant.SKU != nil {
+		product.SKU = variant.SKU
+	}
+	if variant.Weight != nil {
+		product.Weight = variant.Weight
+	}
+	if len(variant.ImageURLs) > 0 {
+		product.ImageURL = &variant.ImageURLs[0]
+	}
+	return product, variant, nil
+}
+
+// VariantCombination es una fila de la matriz de variantes: un valor de cada opción, en orden
+type VariantCombination []string
+
+// VariantMatrix calcula todas las combinaciones de valores de las opciones (producto cartesiano)
+func VariantMatrix(options []models.ProductOption) []VariantCombination {
+	combinations := []VariantCombination{{}}
+	for _, o := range options {
+		var next []VariantCombination
+		for _, c := range combinations {
+			for _, value := range o.Values {
+				combination := make(VariantCombination, len(c), len(c)+1)
+				copy(combination, c)
+				next = append(next, append(combination, value))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+var skuUnsafe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// GenerateProductVariants reemplaza las opciones del producto y crea una variante por cada
+// combinación de valores que no exista. Las que ya existían conservan SKU, precio y stock; las que
+// dejan de estar en la matriz se desactivan (no se borran porque los pedidos las referencian).
+// Las nuevas toman el stock indicado y un SKU derivado del del producto. Devuelve las creadas.
+func GenerateProductVariants(db *pgxpool.Pool, productID int, options []models.ProductOption, stock int) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var baseSKU *string
+	if err := tx.QueryRow(ctx, `SELECT sku FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&baseSKU); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("producto no encontrado")
+		}
+		return 0, fmt.Errorf("error obteniendo producto: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM product_options WHERE product_id = $1`, productID); err != nil {
+		return 0, fmt.Errorf("error reemplazando opciones: %w", err)
+	}
+	for i, o := range options {
+		_, err := tx.Exec(ctx, `INSERT INTO product_options (product_id, name, position, option_values) VALUES ($1, $2, $3, $4)`,
+			productID, o.Name, i, o.Values)
+		if err != nil {
+			return 0, fmt.Errorf("error guardando opción %s: %w", o.Name, err)
+		}
+	}
+
+	created := 0
+	keys := []string{}
+	for position, combination := range VariantMatrix(options) {
+		values := map[string]string{}
+		keyParts := make([]string, len(combination))
+		for i, value := range combination {
+			values[options[i].Name] = value
+			keyParts[i] = options[i].Name + "=" + value
+		}
+		key := strings.Join(keyParts, "|")
+		keys = append(keys, key)
+		optionsJSON, err := json.Marshal(values)
+		if err != nil {
+			return 0, fmt.Errorf("error serializando opciones: %w", err)
+		}
+		var sku *string
+		if baseSKU != nil && *baseSKU != "" {
+			s := *baseSKU + "-" + strings.Trim(skuUnsafe.ReplaceAllString(strings.ToUpper(strings.Join(combination, "-")), "-"), "-")
+			sku = &s
+		}
+
+		var inserted bool
+		err = tx.QueryRow(ctx, `
+			INSERT INTO product_variants (product_id, sku, label, options, option_key, stock, position)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (product_id, option_key) DO UPDATE
+			SET label = EXCLUDED.label, position = EXCLUDED.position, is_active = TRUE, updated_at = NOW()
+			RETURNING xmax = 0
+		`, productID, sku, strings.Join(combination, " / "), optionsJSON, key, stock, position).Scan(&inserted)
+		if err != nil {
+			return 0, fmt.Errorf("error guardando variante %s: %w", key, err)
+		}
+		if inserted {
+			created++
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE product_variants SET is_active = FALSE, updated_at = NOW()
+		WHERE product_id = $1 AND is_active AND option_key <> ALL($2)
+	`, productID, keys)
+	if err != nil {
+		return 0, fmt.Errorf("error desactivando variantes: %w", err)
+	}
+	if err := syncProductStock(ctx, tx, productID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error confirmando variantes: %w", err)
+	}
+	return created, nil
+}
+
+// UpdateProductVariant guarda SKU, precio propio, stock, peso, imágenes y estado de una variante y
+// recalcula el stock del producto
+func UpdateProductVariant(db *pgxpool.Pool, v *models.ProductVariant) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE product_variants
+		SET sku = $1, price = $2, stock = $3, weight = $4, image_urls = $5, is_active = $6, updated_at = NOW()
+		WHERE id = $7 AND product_id = $8
+	`, v.SKU, v.PriceOverride, v.Stock, v.Weight, v.ImageURLs, v.IsActive, v.ID, v.ProductID)
+	if err != nil {
+		return fmt.Errorf("error actualizando variante: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("variante no encontrada")
+	}
+	if err := syncProductStock(ctx, tx, v.ProductID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando variante: %w", err)
+	}
+	return nil
+}
+
+// syncProductStock deja el stock de un producto con variantes como la suma del de sus variantes
+// activas, que es el que usan el catálogo y los filtros
+func syncProductStock(ctx context.Context, tx pgx.Tx, productID int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE products p
+		SET stock = v.stock, updated_at = NOW()
+		FROM (SELECT SUM(stock) AS stock FROM product_variants WHERE product_id = $1 AND is_active) v
+		WHERE p.id = $1 AND v.stock IS NOT NULL
+	`, productID)
+	if err != nil {
+		return fmt.Errorf("error recalculando stock del producto: %w", err)
+	}
+	return nil
+}
+
+// DecrementVariantStock descuenta las unidades vendidas de una variante
+func DecrementVariantStock(db *pgxpool.Pool, variantID, quantity int) error {
+	_, err := db.Exec(context.Background(),
+		`UPDATE product_variants SET stock = stock - $1, updated_at = NOW() WHERE id = $2`, quantity, variantID)
+	return err
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// createWishlistTables crea las listas de deseos y pasa la antigua tabla de favoritos a la lista
+// de favoritos de cada usuario
+func createWishlistTables() error {
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS wishlists (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			kind VARCHAR(20) NOT NULL DEFAULT 'custom',
+			visibility VARCHAR(10) NOT NULL DEFAULT 'private',
+			share_token VARCHAR(64) UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_wishlists_user_id ON wishlists(user_id)`,
+		// Una sola lista de favoritos y una de guardados para después por usuario
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlists_user_kind ON wishlists(user_id, kind) WHERE kind <> 'custom'`,
+		`CREATE TABLE IF NOT EXISTS wishlist_items (
+			id SERIAL PRIMARY KEY,
+			wishlist_id INTEGER NOT NULL REFERENCES wishlists(id) ON DELETE CASCADE,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+			note VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (wishlist_id, product_id)
+		)`,
+		`DO $$
+		BEGIN
+			IF to_regclass('favorites') IS NOT NULL THEN
+				INSERT INTO wishlists (user_id, name, kind)
+				SELECT DISTINCT user_id, 'Favoritos', 'favorites' FROM favorites
+				ON CONFLICT (user_id, kind) WHERE kind <> 'custom' DO NOTHING;
+
+				INSERT INTO wishlist_items (wishlist_id, product_id, created_at)
+				SELECT w.id, f.product_id, f.created_at
+				FROM favorites f
+				JOIN wishlists w ON w.user_id = f.user_id AND w.kind = 'favorites'
+				ON CONFLICT (wishlist_id, product_id) DO NOTHING;
+
+				DROP TABLE favorites;
+			END IF;
+		END $$`,
+	}
+	for _, m := range migrations {
+		if _, err := Pool.Exec(context.Background(), m); err != nil {
+			return fmt.Errorf("error migrating wishlists: %w", err)
+		}
+	}
+	return nil
+}
+
+const wishlistColumns = `w.id, w.user_id, w.name, w.kind, w.visibility, w.share_token, w.created_at, w.updated_at,
+	(SELECT COUNT(*) FROM wishlist_items wi WHERE wi.wishlist_id = w.id)`
+
+func scanWishlist(row pgx.Row) (*models.Wishlist, error) {
+	var w models.Wishlist
+	err := row.Scan(&w.ID, &w.UserID, &w.Name, &w.Kind, &w.Visibility, &w.ShareToken, &w.CreatedAt, &w.UpdatedAt, &w.ItemCount)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// GetOrCreateSpecialWishlist obtiene la lista de favoritos o de guardados para después del
+// usuario, creándola la primera vez
+func GetOrCreateSpecialWishlist(db *pgxpool.Pool, userID int, kind string) (int, error) {
+	name := "Favoritos"
+	if kind == models.WishlistKindSaved {
+		name = "Guardados para después"
+	}
+	var wishlistID int
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO wishlists (user_id, name, kind) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, kind) WHERE kind <> 'custom' DO UPDATE SET kind = EXCLUDED.kind
+		RETURNING id
+	`, userID, name, kind).Scan(&wishlistID)
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo lista %s: %w", kind, err)
+	}
+	return wishlistID, nil
+}
+
+// GetUserWishlists lista las listas del usuario con su número de items
+func GetUserWishlists(db *pgxpool.Pool, userID int) ([]models.Wishlist, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT `+wishlistColumns+`
+		FROM wishlists w
+		WHERE w.user_id = $1
+		ORDER BY CASE w.kind WHEN 'favorites' THEN 0 WHEN 'saved_for_later' THEN 1 ELSE 2 END, w.created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo listas de deseos: %w", err)
+	}
+	defer rows.Close()
+
+	wishlists := []models.Wishlist{}
+	for rows.Next() {
+		w, err := scanWishlist(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando lista de deseos: %w", err)
+		}
+		wishlists = append(wishlists, *w)
+	}
+	return wishlists, nil
+}
+
+// GetWishlistByID obtiene una lista de deseos
+func GetWishlistByID(db *pgxpool.Pool, wishlistID int) (*models.Wishlist, error) {
+	w, err := scanWishlist(db.QueryRow(context.Background(), `
+		SELECT `+wishlistColumns+` FROM wishlists w WHERE w.id = $1
+	`, wishlistID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("lista no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo lista de deseos: %w", err)
+	}
+	return w, nil
+}
+
+// GetWishlistByShareToken obtiene una lista compartida o pública por su enlace
+func GetWishlistByShareToken(db *pgxpool.Pool, token string) (*models.Wishlist, error) {
+	w, err := scanWishlist(db.QueryRow(context.Background(), `
+		SELECT `+wishlistColumns+` FROM wishlists w WHERE w.share_token = $1 AND w.visibility <> 'private'
+	`, token))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("lista no encontrada")
+		}
+		return nil, fmt.Errorf("error obteniendo lista de deseos: %w", err)
+	}
+	return w, nil
+}
+
+// CreateWishlist crea una lista con nombre
+func CreateWishlist(db *pgxpool.Pool, w *models.Wishlist) error {
+	err := db.QueryRow(context.Background(), `
+		INSERT INTO wishlists (user_id, name, kind, visibility, share_token)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`, w.UserID, w.Name, w.Kind, w.Visibility, w.ShareToken).Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando lista de deseos: %w", err)
+	}
+	return nil
+}
+
+// UpdateWishlist cambia el nombre, la visibilidad y el enlace de una lista
+func UpdateWishlist(db *pgxpool.Pool, w *models.Wishlist) error {
+	result, err := db.Exec(context.Background(), `
+		UPDATE wishlists SET name = $2, visibility = $3, share_token = $4, updated_at = NOW()
+		WHERE id = $1
+	`, w.ID, w.Name, w.Visibility, w.ShareToken)
+	if err != nil {
+		return fmt.Errorf("error actualizando lista de deseos: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("lista no encontrada")
+	}
+	return nil
+}
+
+// DeleteWishlist elimina una lista con sus items
+func DeleteWishlist(db *pgxpool.Pool, wishlistID int) error {
+	result, err := db.Exec(context.Background(), `DELETE FROM wishlists WHERE id = $1`, wishlistID)
+	if err != nil {
+		return fmt.Errorf("error eliminando lista de deseos: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("lista no encontrada")
+	}
+	return nil
+}
+
+// GetWishlistItems obtiene los items de una lista con los datos actuales del producto. Con
+// activeOnly se omiten los productos que ya no se venden.
+func GetWishlistItems(db *pgxpool.Pool, wishlistID int, activeOnly bool) ([]models.WishlistItem, error) {
+	rows, err := db.Query(context.Background(), `
+		SELECT wi.id, wi.wishlist_id, w.user_id, wi.product_id, wi.quantity, wi.note, wi.created_at,
+			p.id, p.name, p.description, p.price, p.category_id, p.created_at, p.image_url, p.dimensions,
+			p.weight, p.sku, p.stock, p.is_active, p.model_url
+		FROM wishlist_items wi
+		JOIN wishlists w ON w.id = wi.wishlist_id
+		JOIN products p ON p.id = wi.product_id
+		WHERE wi.wishlist_id = $1 AND (NOT $2 OR p.is_active)
+		ORDER BY wi.created_at DESC
+	`, wishlistID, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo items de la lista: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.WishlistItem{}
+	for rows.Next() {
+		var item models.WishlistItem
+		var p models.Product
+		if err := rows.Scan(&item.ID, &item.WishlistID, &item.UserID, &item.ProductID, &item.Quantity, &item.Note, &item.CreatedAt,
+			&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &p.CreatedAt, &p.ImageURL, &p.Dimensions,
+			&p.Weight, &p.SKU, &p.Stock, &p.IsActive, &p.ModelURL); err != nil {
+			return nil, fmt.Errorf("error escaneando item de la lista: %w", err)
+		}
+		item.Product = &p
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// AddWishlistItem agrega un producto a la lista o actualiza su cantidad y nota
+func AddWishlistItem(db *pgxpool.Pool, wishlistID, productID, quantity int, note string) error {
+	_, err := db.Exec(context.Background(), `
+		INSERT INTO wishlist_items (wishlist_id, product_id, quantity, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (wishlist_id, product_id) DO UPDATE SET quantity = $3, note = $4
+	`, wishlistID, productID, quantity, note)
+	if err != nil {
+		return fmt.Errorf("error agregando producto a la lista: %w", err)
+	}
+	_, err = db.Exec(context.Background(), `UPDATE wishlists SET updated_at = NOW() WHERE id = $1`, wishlistID)
+	return err
+}
+
+// RemoveWishlistItem quita un producto de la lista
+func RemoveWishlistItem(db *pgxpool.Pool, wishlistID, productID int) error {
+	result, err := db.Exec(context.Background(), `
+		DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2
+	`, wishlistID, productID)
+	if err != nil {
+		return fmt.Errorf("error quitando producto de la lista: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("item no encontrado")
+	}
+	return nil
+}
+
+// MoveCartItemToWishlist pasa un item del carrito a la lista (guardar para después) con su cantidad
+func MoveCartItemToWishlist(db *pgxpool.Pool, cartItemID, wishlistID int) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var productID, quantity int
+	err = tx.QueryRow(ctx, `DELETE FROM cart_items WHERE id = $1 RETURNING product_id, quantity`, cartItemID).Scan(&productID, &quantity)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("item no encontrado")
+		}
+		return fmt.Errorf("error quitando item del carrito: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO wishlist_items (wishlist_id, product_id, quantity)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (wishlist_id, product_id) DO UPDATE SET quantity = $3
+	`, wishlistID, productID, quantity)
+	if err != nil {
+		return fmt.Errorf("error guardando item para después: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error confirmando movimiento al guardado: %w", err)
+	}
+	return nil
+}
+
+// MoveWishlistItemToCart pasa un producto de la lista al carrito sin superar el stock y lo quita de
+// la lista. Devuelve la cantidad que quedó en el carrito. Los productos con variantes no se mueven
+// porque hay que elegir una.
+func MoveWishlistItemToCart(db *pgxpool.Pool, wishlistID, productID, cartID int) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var quantity, stock int
+	var price float64
+	var isActive bool
+	err = tx.QueryRow(ctx, `
+		SELECT wi.quantity, p.stock, p.price, p.is_active
+		FROM wishlist_items wi
+		JOIN products p ON p.id = wi.product_id
+		WHERE wi.wishlist_id = $1 AND wi.product_id = $2
+		FOR UPDATE OF wi
+	`, wishlistID, productID).Scan(&quantity, &stock, &price, &isActive)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("item no encontrado")
+		}
+		return 0, fmt.Errorf("error obteniendo item de la lista: %w", err)
+	}
+	if !isActive || stock <= 0 {
+		return 0, fmt.Errorf("producto no disponible")
+	}
+	var hasVariants bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1 AND is_active)`, productID).Scan(&hasVariants)
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo variantes del producto: %w", err)
+	}
+	if hasVariants {
+		return 0, fmt.Errorf("el producto requiere elegir una variante")
+	}
+
+	var inCart int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO cart_items (cart_id, product_id, quantity, seen_price)
+		VALUES ($1, $2, LEAST($3, $4), $5)
+		ON CONFLICT (cart_id, product_id, COALESCE(variant_id, 0)) DO UPDATE
+		SET quantity = LEAST(cart_items.quantity + $3, $4), seen_price = $5, updated_at = NOW()
+		RETURNING quantity
+	`, cartID, productID, quantity, stock, price).Scan(&inCart)
+	if err != nil {
+		return 0, fmt.Errorf("error agregando al carrito: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2`, wishlistID, productID); err != nil {
+		return 0, fmt.Errorf("error quitando item de la lista: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error confirmando movimiento al carrito: %w", err)
+	}
+	return inCart, nil
+}
@@ -0,0 +1,52 @@
+package discount
+
+import "math"
+
+// Cents convierte un importe a centavos
+func Cents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
+
+// Amount convierte centavos a importe
+func Amount(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// Allocate reparte un descuento (en centavos) entre varias líneas en proporción a sus pesos, sin
+// pasar del tope de cada una. Los centavos que sobran del redondeo se asignan uno a uno en orden.
+func Allocate(amount int64, weights, caps []int64) []int64 {
+	shares := make([]int64, len(weights))
+	var base int64
+	for _, w := range weights {
+		base += w
+	}
+	if base <= 0 || amount <= 0 {
+		return shares
+	}
+
+	var assigned int64
+	for i, w := range weights {
+		shares[i] = amount * w / base
+		if shares[i] > caps[i] {
+			shares[i] = caps[i]
+		}
+		assigned += shares[i]
+	}
+	for left := amount - assigned; left > 0; {
+		progressed := false
+		for i := range shares {
+			if left == 0 {
+				break
+			}
+			if weights[i] > 0 && shares[i] < caps[i] {
+				shares[i]++
+				left--
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	return shares
+}
@@ -2,8 +2,11 @@ package email
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	resend "github.com/resendlabs/resend-go"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
@@ -27,6 +30,12 @@ type EmailService struct {
 	client *resend.Client
 }
 
+// Attachment es un archivo adjunto a un email
+type Attachment struct {
+	Filename string
+	Content  []byte
+}
+
 func NewEmailService() *EmailService {
 	apiKey := os.Getenv("RESEND_API_KEY")
 	if apiKey == "" {
@@ -88,7 +97,7 @@ func (s *EmailService) SendOrderConfirmation(user *models.User, order *models.Or
 			</div>
 		</body>
 		</html>
-	`, user.Email, order.ID, order.Total, order.Status, order.CreatedAt.Format("02/01/2006 15:04"))
+	`, user.Email, order.OrderNumber, order.Total, order.Status, order.CreatedAt.Format("02/01/2006 15:04"))
 
 	fromEmail := os.Getenv("EMAIL_FROM")
 	if fromEmail == "" {
@@ -112,7 +121,8 @@ func (s *EmailService) SendOrderConfirmation(user *models.User, order *models.Or
 	return nil
 }
 
-func (s *EmailService) SendPaymentConfirmation(user *models.User, payment *models.Payment) error {
+// SendPaymentConfirmation envía la confirmación de pago; los adjuntos (factura y recibo) son opcionales
+func (s *EmailService) SendPaymentConfirmation(user *models.User, payment *models.Payment, attachments ...Attachment) error {
 	if s.client == nil {
 		return fmt.Errorf("servicio de email no configurado")
 	}
@@ -145,7 +155,7 @@ func (s *EmailService) SendPaymentConfirmation(user *models.User, payment *model
 					
 					<div class="payment-details">
 						<h3>Detalles del Pago</h3>
-						<p><strong>ID de Pago:</strong> %s</p>
+						<p><strong>ID de Pago:</strong> %d</p>
 						<p><strong>Monto:</strong> $%.2f</p>
 						<p><strong>Estado:</strong> %s</p>
 						<p><strong>Método:</strong> %s</p>
@@ -174,6 +184,9 @@ func (s *EmailService) SendPaymentConfirmation(user *models.User, payment *model
 		Subject: subject,
 		Html:    htmlContent,
 	}
+	for _, a := range attachments {
+		params.Attachments = append(params.Attachments, resend.Attachment{Filename: a.Filename, Content: string(a.Content)})
+	}
 
 	_, err := s.client.Emails.Send(params)
 	if err != nil {
@@ -274,6 +287,141 @@ func (s *EmailService) SendEmail(to, subject, htmlContent string) error {
 	return nil
 }
 
+// SendGuestOrderLink envía al invitado el enlace para consultar y pagar su pedido sin cuenta
+func (s *EmailService) SendGuestOrderLink(to, orderNumber, link string) error {
+	if s.client == nil {
+		return fmt.Errorf("servicio de email no configurado")
+	}
+
+	subject := fmt.Sprintf("Tu pedido %s", orderNumber)
+
+	htmlContent := fmt.Sprintf(`
+		<!DOCTYPE html>
+		<html>
+		<head>
+			<meta charset="utf-8">
+			<title>Tu Pedido</title>
+			<style>
+				body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+				.container { max-width: 600px; margin: 0 auto; padding: 20px; }
+				.header { background: #4CAF50; color: white; padding: 20px; text-align: center; }
+				.content { padding: 20px; background: #f9f9f9; }
+				.button { display: inline-block; padding: 10px 20px; background: #4CAF50; color: white; text-decoration: none; border-radius: 5px; }
+				.footer { text-align: center; padding: 20px; color: #666; }
+			</style>
+		</head>
+		<body>
+			<div class="container">
+				<div class="header">
+					<h1>Pedido %s</h1>
+				</div>
+				<div class="content">
+					<h2>Hola,</h2>
+					<p>Recibimos tu pedido. Desde este enlace puedes completar el pago y consultar su estado en cualquier momento:</p>
+					<p><a href="%s" class="button">Ver mi pedido</a></p>
+					<p>Si no puedes hacer clic en el botón, copia y pega el siguiente enlace en tu navegador:</p>
+					<p>%s</p>
+					<p>Si creas una cuenta con este mismo email, tus pedidos aparecerán en tu historial.</p>
+				</div>
+				<div class="footer">
+					<p>Este es un email automático, por favor no respondas a este mensaje.</p>
+				</div>
+			</div>
+		</body>
+		</html>
+	`, orderNumber, link, link)
+
+	return s.SendEmail(to, subject, htmlContent)
+}
+
+// CartReminder son los datos de un recordatorio de carrito abandonado
+type CartReminder struct {
+	Name          string   // Nombre del cliente; vacío usa un saludo genérico
+	Step          int      // Número de recordatorio, empezando en 1
+	Items         []string // Líneas del carrito ya formateadas ("2 × Producto")
+	Total         float64
+	RestoreLink   string
+	CouponCode    string // Opcional
+	CouponPercent float64
+	CouponEndsAt  time.Time
+	SettingsLink  string // Dónde dejar de recibir estos emails
+}
+
+// SendCartReminder envía un recordatorio de carrito abandonado con el enlace para recuperarlo
+func (s *EmailService) SendCartReminder(to string, r CartReminder) error {
+	if s.client == nil {
+		return fmt.Errorf("servicio de email no configurado")
+	}
+
+	subject := "Dejaste productos en tu carrito"
+	if r.Step > 1 {
+		subject = "Tu carrito te sigue esperando"
+	}
+	if r.CouponCode != "" {
+		subject = fmt.Sprintf("%.0f%% de descuento para completar tu compra", r.CouponPercent)
+	}
+
+	greeting := "Hola,"
+	if r.Name != "" {
+		greeting = fmt.Sprintf("Hola %s,", html.EscapeString(r.Name))
+	}
+	var items strings.Builder
+	for _, item := range r.Items {
+		items.WriteString("<li>" + html.EscapeString(item) + "</li>")
+	}
+	coupon := ""
+	if r.CouponCode != "" {
+		coupon = fmt.Sprintf(`
+					<div class="coupon">
+						<p>Usa el cupón <strong>%s</strong> y obtén un %.0f%% de descuento.</p>
+						<p>Válido hasta el %s. Se aplica automáticamente al abrir el enlace.</p>
+					</div>`, r.CouponCode, r.CouponPercent, r.CouponEndsAt.Format("02/01/2006 15:04"))
+	}
+
+	htmlContent := fmt.Sprintf(`
+		<!DOCTYPE html>
+		<html>
+		<head>
+			<meta charset="utf-8">
+			<title>Tu Carrito</title>
+			<style>
+				body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+				.container { max-width: 600px; margin: 0 auto; padding: 20px; }
+				.header { background: #4CAF50; color: white; padding: 20px; text-align: center; }
+				.content { padding: 20px; background: #f9f9f9; }
+				.cart { background: white; padding: 15px; margin: 20px 0; border-left: 4px solid #4CAF50; }
+				.coupon { background: #fff8e1; padding: 15px; margin: 20px 0; border-left: 4px solid #ffb300; }
+				.button { display: inline-block; padding: 10px 20px; background: #4CAF50; color: white; text-decoration: none; border-radius: 5px; }
+				.footer { text-align: center; padding: 20px; color: #666; font-size: 12px; }
+			</style>
+		</head>
+		<body>
+			<div class="container">
+				<div class="header">
+					<h1>Tu carrito te espera</h1>
+				</div>
+				<div class="content">
+					<h2>%s</h2>
+					<p>Guardamos los productos que dejaste en tu carrito:</p>
+					<div class="cart">
+						<ul>%s</ul>
+						<p><strong>Total estimado:</strong> $%.2f</p>
+					</div>
+					%s
+					<p><a href="%s" class="button">Volver a mi carrito</a></p>
+					<p>Los precios y la disponibilidad pueden cambiar hasta que confirmes el pedido.</p>
+				</div>
+				<div class="footer">
+					<p>Recibes este email porque aceptaste comunicaciones de marketing. <a href="%s">Cambiar preferencias</a></p>
+				</div>
+			</div>
+		</body>
+		</html>
+	`, greeting, items.String(), r.Total, coupon, r.RestoreLink, r.SettingsLink)
+
+	return s.SendEmail(to, subject, htmlContent)
+}
+
 func SendVerificationEmail(to, token string) error {
 	if DefaultEmailService == nil {
 		return fmt.Errorf("servicio de email no inicializado")
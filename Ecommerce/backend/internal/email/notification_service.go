@@ -15,15 +15,21 @@ import (
 
 // NotificationService maneja el envío de notificaciones
 type NotificationService struct {
-	db       *pgxpool.Pool
-	emailSvc *EmailService
+	db             *pgxpool.Pool
+	emailSvc       *EmailService
+	guestOrderLink OrderLinkFunc
 }
 
-// NewNotificationService crea una nueva instancia del servicio de notificaciones
-func NewNotificationService(db *pgxpool.Pool, emailSvc *EmailService) *NotificationService {
+// OrderLinkFunc arma el enlace mágico de un pedido de invitado para sus avisos por email
+type OrderLinkFunc func(order *models.Order) (string, error)
+
+// NewNotificationService crea una nueva instancia del servicio de notificaciones. guestOrderLink
+// (auth.GuestOrderLink) da el enlace de los avisos a invitados; si es nil van sin enlace.
+func NewNotificationService(db *pgxpool.Pool, emailSvc *EmailService, guestOrderLink OrderLinkFunc) *NotificationService {
 	return &NotificationService{
-		db:       db,
-		emailSvc: emailSvc,
+		db:             db,
+		emailSvc:       emailSvc,
+		guestOrderLink: guestOrderLink,
 	}
 }
 
@@ -40,6 +46,15 @@ type NotificationData struct {
 
 // CreateNotification crea y envía una notificación
 func (ns *NotificationService) CreateNotification(ctx context.Context, userID int, notificationType, title, message string, data NotificationData, priority string, isAdmin bool) error {
+	// Los pedidos de invitado no tienen usuario ni bandeja de notificaciones: el aviso va al email
+	// con el que se hizo el pedido
+	if userID == 0 {
+		if data.OrderID == nil || isAdmin {
+			return nil
+		}
+		return ns.sendGuestOrderEmail(*data.OrderID, notificationType, title, message, data, priority)
+	}
+
 	// Serializar datos adicionales
 	dataJSON, err := json.Marshal(data)
 	if err != nil {
@@ -95,6 +110,15 @@ func (ns *NotificationService) sendNotificationEmail(userID int, notification *m
 		log.Printf("Error obteniendo usuario para notificación: %v", err)
 		return
 	}
+	ns.deliverNotificationEmail(user.Email, notification, data)
+}
+
+// deliverNotificationEmail arma y envía el email de una notificación
+func (ns *NotificationService) deliverNotificationEmail(to string, notification *models.Notification, data NotificationData) {
+	if ns.emailSvc == nil {
+		log.Printf("Servicio de email no configurado, no se envió la notificación %q a %s", notification.Title, to)
+		return
+	}
 
 	// Determinar asunto según tipo
 	var subject string
@@ -106,6 +130,8 @@ func (ns *NotificationService) sendNotificationEmail(userID int, notification *m
 		subject = "Confirmación de pago"
 	case "stock":
 		subject = "Producto disponible"
+	case "price":
+		subject = "Bajó de precio un producto que te interesa"
 	case "security":
 		subject = "Alerta de seguridad"
 	case "admin":
@@ -116,7 +142,7 @@ func (ns *NotificationService) sendNotificationEmail(userID int, notification *m
 
 	// Preparar datos del template
 	templateData := map[string]interface{}{
-		"UserName":    user.Email,
+		"UserName":    to,
 		"Title":       notification.Title,
 		"Message":     notification.Message,
 		"Priority":    notification.Priority,
@@ -131,11 +157,44 @@ func (ns *NotificationService) sendNotificationEmail(userID int, notification *m
 	htmlContent := generateNotificationEmailHTML(notification, templateData)
 
 	// Enviar email
-	if err := ns.emailSvc.SendEmail(user.Email, subject, htmlContent); err != nil {
+	if err := ns.emailSvc.SendEmail(to, subject, htmlContent); err != nil {
 		log.Printf("Error enviando notificación por email: %v", err)
 	}
 }
 
+// sendGuestOrderEmail envía una notificación de pedido al email del invitado, con el enlace mágico
+// del pedido en lugar del enlace a la cuenta. Si el pedido ya se reclamó para una cuenta no se envía:
+// las notificaciones siguientes van al usuario.
+func (ns *NotificationService) sendGuestOrderEmail(orderID int, notificationType, title, message string, data NotificationData, priority string) error {
+	order, err := db.GetOrderByID(ns.db, orderID)
+	if err != nil {
+		return fmt.Errorf("error obteniendo pedido de invitado: %w", err)
+	}
+	if order.UserID != 0 || order.GuestEmail == "" {
+		return nil
+	}
+
+	data.ActionURL = nil
+	if ns.guestOrderLink != nil {
+		link, err := ns.guestOrderLink(order)
+		if err != nil {
+			log.Printf("Error generando enlace del pedido %d: %v", order.ID, err)
+		} else {
+			data.ActionURL = &link
+		}
+	}
+
+	notification := &models.Notification{
+		Type:      notificationType,
+		Title:     title,
+		Message:   message,
+		Priority:  priority,
+		CreatedAt: time.Now(),
+	}
+	go ns.deliverNotificationEmail(order.GuestEmail, notification, data)
+	return nil
+}
+
 // generateNotificationEmailHTML genera el HTML del email de notificación
 func generateNotificationEmailHTML(notification *models.Notification, data map[string]interface{}) string {
 	priorityColor := "blue"
@@ -210,6 +269,10 @@ func (ns *NotificationService) CreateOrderNotification(ctx context.Context, user
 		title = "Pedido Confirmado"
 		message = fmt.Sprintf("Tu pedido #%s ha sido confirmado y está siendo procesado.", orderNumber)
 		priority = "medium"
+	case "partially_shipped":
+		title = "Pedido Enviado Parcialmente"
+		message = fmt.Sprintf("Parte de tu pedido #%s ya fue enviada. El resto llegará en otro paquete.", orderNumber)
+		priority = "high"
 	case "shipped":
 		title = "Pedido Enviado"
 		message = fmt.Sprintf("Tu pedido #%s ha sido enviado. ¡Pronto llegará a tu puerta!", orderNumber)
@@ -242,6 +305,10 @@ func (ns *NotificationService) CreateOrderTrackingNotification(ctx context.Conte
 		title = "¡Tu Pedido Está En Camino!"
 		message = fmt.Sprintf("Tu pedido #%s ha sido enviado y está en camino. Número de seguimiento: %s", orderNumber, tracking)
 		priority = "high"
+	case "delivered":
+		title = "¡Tu Pedido Fue Entregado!"
+		message = fmt.Sprintf("Tu pedido #%s fue entregado. Número de seguimiento: %s. ¡Gracias por tu compra!", orderNumber, tracking)
+		priority = "medium"
 	default:
 		title = "Información de Seguimiento Actualizada"
 		message = fmt.Sprintf("Se ha actualizado la información de seguimiento de tu pedido #%s. Número de seguimiento: %s", orderNumber, tracking)
@@ -256,6 +323,112 @@ func (ns *NotificationService) CreateOrderTrackingNotification(ctx context.Conte
 	return ns.CreateNotification(ctx, userID, "order", title, message, data, priority, false)
 }
 
+// CreateShipmentNotification avisa que un paquete del pedido salió o fue entregado, con su contenido
+func (ns *NotificationService) CreateShipmentNotification(ctx context.Context, userID int, orderID int, orderNumber, status, tracking string, contents []string, partial bool) error {
+	var title, message string
+	var priority string
+
+	detail := ""
+	if len(contents) > 0 {
+		detail = " Contenido del paquete: " + strings.Join(contents, ", ") + "."
+	}
+
+	switch status {
+	case "delivered":
+		title = "¡Paquete Entregado!"
+		message = fmt.Sprintf("Se entregó un paquete de tu pedido #%s (seguimiento %s).%s", orderNumber, tracking, detail)
+		priority = "medium"
+	default:
+		title = "¡Un Paquete Está En Camino!"
+		message = fmt.Sprintf("Enviamos un paquete de tu pedido #%s. Número de seguimiento: %s.%s", orderNumber, tracking, detail)
+		priority = "high"
+	}
+	if partial {
+		message += " El resto de tu pedido llegará en otro paquete."
+	}
+
+	data := NotificationData{
+		OrderID:   &orderID,
+		ActionURL: stringPtr("/mi-cuenta?tab=orders"),
+	}
+
+	return ns.CreateNotification(ctx, userID, "order", title, message, data, priority, false)
+}
+
+// CreateReturnNotification avisa al cliente de cada paso de su devolución
+func (ns *NotificationService) CreateReturnNotification(ctx context.Context, userID int, orderID int, orderNumber, rmaNumber, status, note string, refundAmount float64, currency string) error {
+	var title, message string
+	var priority string
+
+	switch status {
+	case "requested":
+		title = "Solicitud de Devolución Recibida"
+		message = fmt.Sprintf("Recibimos tu solicitud de devolución %s del pedido #%s. Te avisaremos cuando sea revisada.", rmaNumber, orderNumber)
+		priority = "medium"
+	case "approved":
+		title = "Devolución Aprobada"
+		message = fmt.Sprintf("Tu devolución %s del pedido #%s fue aprobada. Reembolsaremos %.2f %s a tu método de pago original.", rmaNumber, orderNumber, refundAmount, currency)
+		priority = "high"
+	case "rejected":
+		title = "Devolución Rechazada"
+		message = fmt.Sprintf("Tu devolución %s del pedido #%s fue rechazada.", rmaNumber, orderNumber)
+		priority = "high"
+	case "received":
+		title = "Devolución Recibida en Almacén"
+		message = fmt.Sprintf("Recibimos los productos de tu devolución %s del pedido #%s y los estamos revisando.", rmaNumber, orderNumber)
+		priority = "medium"
+	case "restocked":
+		title = "Devolución Completada"
+		message = fmt.Sprintf("Tu devolución %s del pedido #%s fue procesada por completo. ¡Gracias!", rmaNumber, orderNumber)
+		priority = "low"
+	case "refund_failed":
+		title = "Problema con tu Reembolso"
+		message = fmt.Sprintf("No pudimos procesar el reembolso de tu devolución %s. Nuestro equipo lo revisará y te contactará.", rmaNumber)
+		priority = "high"
+	default:
+		title = "Actualización de tu Devolución"
+		message = fmt.Sprintf("Tu devolución %s del pedido #%s fue actualizada.", rmaNumber, orderNumber)
+		priority = "medium"
+	}
+	if note != "" {
+		message += " Nota: " + note
+	}
+
+	data := NotificationData{
+		OrderID:   &orderID,
+		ActionURL: stringPtr("/mi-cuenta?tab=orders"),
+	}
+
+	return ns.CreateNotification(ctx, userID, "order", title, message, data, priority, false)
+}
+
+// CreatePaymentReminderNotification recuerda al cliente que su pedido sigue pendiente de pago
+func (ns *NotificationService) CreatePaymentReminderNotification(ctx context.Context, userID int, orderID int, orderNumber string, deadline time.Time) error {
+	title := "Tu Pedido Espera el Pago"
+	message := fmt.Sprintf("Tu pedido #%s sigue pendiente de pago. Si no se completa antes del %s será cancelado automáticamente.",
+		orderNumber, deadline.Format("02/01/2006 15:04"))
+
+	data := NotificationData{
+		OrderID:   &orderID,
+		ActionURL: stringPtr("/mi-cuenta?tab=orders"),
+	}
+
+	return ns.CreateNotification(ctx, userID, "order", title, message, data, "high", false)
+}
+
+// CreateOrderExpiredNotification avisa al cliente que su pedido se canceló por falta de pago
+func (ns *NotificationService) CreateOrderExpiredNotification(ctx context.Context, userID int, orderID int, orderNumber string) error {
+	title := "Pedido Cancelado por Falta de Pago"
+	message := fmt.Sprintf("Tu pedido #%s fue cancelado porque no recibimos el pago a tiempo. Los productos volvieron a estar disponibles; puedes realizar un nuevo pedido cuando quieras.", orderNumber)
+
+	data := NotificationData{
+		OrderID:   &orderID,
+		ActionURL: stringPtr("/mi-cuenta?tab=orders"),
+	}
+
+	return ns.CreateNotification(ctx, userID, "order", title, message, data, "high", false)
+}
+
 // CreatePaymentNotification crea una notificación de pago
 func (ns *NotificationService) CreatePaymentNotification(ctx context.Context, userID int, orderID int, amount, currency string, status string) error {
 	title := "Confirmación de Pago"
@@ -326,6 +499,22 @@ func (ns *NotificationService) CreateStockNotification(ctx context.Context, user
 	return ns.CreateNotification(ctx, userID, "stock", title, message, data, "medium", false)
 }
 
+// CreatePriceDropNotification avisa que bajó el precio de un producto que el usuario sigue
+func (ns *NotificationService) CreatePriceDropNotification(ctx context.Context, userID int, productID int, productName string, oldPrice, newPrice float64) error {
+	title := "Bajada de Precio"
+	message := fmt.Sprintf("%s bajó de $%.2f a $%.2f. ¡Aprovecha antes de que cambie!", productName, oldPrice, newPrice)
+
+	amount := fmt.Sprintf("%.2f", newPrice)
+	data := NotificationData{
+		ProductID:   &productID,
+		ProductName: &productName,
+		Amount:      &amount,
+		ActionURL:   stringPtr(fmt.Sprintf("/productos/%d", productID)),
+	}
+
+	return ns.CreateNotification(ctx, userID, "price", title, message, data, "medium", false)
+}
+
 // CreateLowStockAdminNotification notifica a los admins sobre stock bajo
 func (ns *NotificationService) CreateLowStockAdminNotification(ctx context.Context, productID int, productName string, currentStock int) error {
 	event := "Stock Bajo"
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ===== FACTURACIÓN ELECTRÓNICA (CFDI) =====
+
+// GetCFDIDocuments lista los CFDI emitidos, filtrando opcionalmente por estado
+func (h *AdminHandler) GetCFDIDocuments(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	docs, err := db.GetCFDIDocuments(h.DB, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo facturas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": docs})
+}
+
+// GetCFDIDocument obtiene un CFDI
+func (h *AdminHandler) GetCFDIDocument(c *gin.Context) {
+	doc, ok := h.cfdiFromParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": doc})
+}
+
+// GetCFDIXML descarga el XML timbrado de un CFDI
+func (h *AdminHandler) GetCFDIXML(c *gin.Context) {
+	doc, ok := h.cfdiFromParam(c)
+	if !ok {
+		return
+	}
+	writeCFDIXML(c, h.DB, doc)
+}
+
+// IssueOrderCFDI emite (o reintenta) el CFDI de un pedido con un perfil fiscal del cliente
+func (h *AdminHandler) IssueOrderCFDI(c *gin.Context) {
+	orderID, err := resolveOrderID(h.DB, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	var req struct {
+		BillingProfileID int `json:"billing_profile_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	profile, err := db.GetBillingProfileByID(h.DB, req.BillingProfileID)
+	if err != nil || profile.UserID != order.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El perfil fiscal no pertenece al cliente del pedido"})
+		return
+	}
+
+	doc, err := h.CFDI.Issue(c.Request.Context(), order, profile)
+	if err != nil {
+		if doc == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "cfdi": doc})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": doc})
+}
+
+// CancelCFDI solicita la cancelación de un CFDI ante el SAT
+func (h *AdminHandler) CancelCFDI(c *gin.Context) {
+	doc, ok := h.cfdiFromParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Motivo           string `json:"motivo" binding:"required"`
+		FolioSustitucion string `json:"folio_sustitucion"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	updated, err := h.CFDI.Cancel(c.Request.Context(), doc, req.Motivo, req.FolioSustitucion)
+	if err != nil {
+		if updated != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "cfdi": updated})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": updated})
+}
+
+// cfdiFromParam obtiene el CFDI indicado en la URL
+func (h *AdminHandler) cfdiFromParam(c *gin.Context) (*models.CFDIDocument, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de factura inválido"})
+		return nil, false
+	}
+	doc, err := db.GetCFDIByID(h.DB, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Factura no encontrada"})
+		return nil, false
+	}
+	return doc, true
+}
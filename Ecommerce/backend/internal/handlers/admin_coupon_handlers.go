@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ===== CUPONES =====
+
+// CouponRequest representa los datos de un cupón
+type CouponRequest struct {
+	Code             string     `json:"code" binding:"required"`
+	Description      string     `json:"description"`
+	Type             string     `json:"type" binding:"required"`
+	Value            float64    `json:"value"`
+	MinSubtotal      float64    `json:"min_subtotal"`
+	MaxDiscount      *float64   `json:"max_discount"`
+	ProductIDs       []int      `json:"product_ids"`
+	CategoryIDs      []int      `json:"category_ids"`
+	UsageLimit       *int       `json:"usage_limit"`
+	PerCustomerLimit *int       `json:"per_customer_limit"`
+	StartsAt         *time.Time `json:"starts_at"`
+	EndsAt           *time.Time `json:"ends_at"`
+	Stackable        bool       `json:"stackable"`
+	IsActive         *bool      `json:"is_active"`
+}
+
+// toModel valida la solicitud y la convierte en un cupón
+func (r *CouponRequest) toModel() (*models.Coupon, string) {
+	code := db.NormalizeCouponCode(r.Code)
+	if len(code) < 3 || len(code) > 50 || strings.ContainsAny(code, " \t") {
+		return nil, "El código debe tener entre 3 y 50 caracteres y no llevar espacios"
+	}
+	if !coupon.ValidType(r.Type) {
+		return nil, "Tipo de cupón inválido (percent, fixed o free_shipping)"
+	}
+	switch r.Type {
+	case coupon.TypePercent:
+		if r.Value <= 0 || r.Value > 100 {
+			return nil, "El porcentaje debe estar entre 0 y 100"
+		}
+	case coupon.TypeFixed:
+		if r.Value <= 0 {
+			return nil, "El importe del descuento debe ser mayor que 0"
+		}
+	case coupon.TypeFreeShipping:
+		r.Value = 0
+	}
+	if r.MinSubtotal < 0 || (r.MaxDiscount != nil && *r.MaxDiscount < 0) {
+		return nil, "Los importes no pueden ser negativos"
+	}
+	if (r.UsageLimit != nil && *r.UsageLimit <= 0) || (r.PerCustomerLimit != nil && *r.PerCustomerLimit <= 0) {
+		return nil, "Los límites de uso deben ser mayores que 0"
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return nil, "La fecha de fin debe ser posterior a la de inicio"
+	}
+	isActive := true
+	if r.IsActive != nil {
+		isActive = *r.IsActive
+	}
+
+	return &models.Coupon{
+		Code:             code,
+		Description:      strings.TrimSpace(r.Description),
+		Type:             r.Type,
+		Value:            r.Value,
+		MinSubtotal:      r.MinSubtotal,
+		MaxDiscount:      r.MaxDiscount,
+		ProductIDs:       r.ProductIDs,
+		CategoryIDs:      r.CategoryIDs,
+		UsageLimit:       r.UsageLimit,
+		PerCustomerLimit: r.PerCustomerLimit,
+		StartsAt:         r.StartsAt,
+		EndsAt:           r.EndsAt,
+		Stackable:        r.Stackable,
+		IsActive:         isActive,
+	}, ""
+}
+
+// GetCoupons lista los cupones; con ?active=true solo los activos
+func (h *AdminHandler) GetCoupons(c *gin.Context) {
+	coupons, err := db.GetCoupons(h.DB, c.Query("active") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo cupones: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
+}
+
+// GetCoupon obtiene un cupón
+func (h *AdminHandler) GetCoupon(c *gin.Context) {
+	couponID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cupón inválido"})
+		return
+	}
+	cp, err := db.GetCouponByID(h.DB, couponID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cupón no encontrado"})
+		return
+	}
+	c.JSON(http.StatusOK, cp)
+}
+
+// CreateCoupon crea un cupón
+func (h *AdminHandler) CreateCoupon(c *gin.Context) {
+	var req CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	cp, msg := req.toModel()
+	if cp == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := db.CreateCoupon(h.DB, cp); err != nil {
+		if strings.HasPrefix(err.Error(), "ya existe") {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando cupón: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, cp)
+}
+
+// UpdateCoupon actualiza un cupón existente
+func (h *AdminHandler) UpdateCoupon(c *gin.Context) {
+	couponID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cupón inválido"})
+		return
+	}
+
+	var req CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	cp, msg := req.toModel()
+	if cp == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	cp.ID = couponID
+
+	if err := db.UpdateCoupon(h.DB, cp); err != nil {
+		switch {
+		case err.Error() == "cupón no encontrado":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cupón no encontrado"})
+		case strings.HasPrefix(err.Error(), "ya existe"):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando cupón: " + err.Error()})
+		}
+		return
+	}
+
+	updated, err := db.GetCouponByID(h.DB, couponID)
+	if err != nil {
+		c.JSON(http.StatusOK, cp)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteCoupon elimina un cupón; si ya se usó solo se desactiva
+func (h *AdminHandler) DeleteCoupon(c *gin.Context) {
+	couponID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cupón inválido"})
+		return
+	}
+
+	deactivated, err := db.DeleteCoupon(h.DB, couponID)
+	if err != nil {
+		if err.Error() == "cupón no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cupón no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando cupón: " + err.Error()})
+		return
+	}
+	if deactivated {
+		c.JSON(http.StatusOK, gin.H{"message": "El cupón ya se usó en pedidos, se desactivó en lugar de eliminarse"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cupón eliminado exitosamente"})
+}
+
+// GetCouponRedemptions lista los pedidos en los que se usó un cupón
+func (h *AdminHandler) GetCouponRedemptions(c *gin.Context) {
+	couponID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cupón inválido"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	redemptions, err := db.GetCouponRedemptions(h.DB, couponID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo usos: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redemptions": redemptions})
+}
+
+// GetCouponReport resume los usos de los cupones entre ?from= y ?to= (YYYY-MM-DD, últimos 30 días por defecto)
+func (h *AdminHandler) GetCouponReport(c *gin.Context) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'from' inválida (YYYY-MM-DD)"})
+			return
+		}
+		from = parsed
+	}
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'to' inválida (YYYY-MM-DD)"})
+			return
+		}
+		to = parsed.AddDate(0, 0, 1) // Incluye el día completo
+	}
+
+	report, err := db.GetCouponReport(h.DB, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando reporte: " + err.Error()})
+		return
+	}
+	var redemptions int
+	var discount, shippingDiscount, revenue float64
+	for _, row := range report {
+		redemptions += row.Redemptions
+		discount += row.Discount
+		shippingDiscount += row.ShippingDiscount
+		revenue += row.Revenue
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"coupons": report,
+		"totals": gin.H{
+			"redemptions":       redemptions,
+			"discount":          discount,
+			"shipping_discount": shippingDiscount,
+			"revenue":           revenue,
+		},
+	})
+}
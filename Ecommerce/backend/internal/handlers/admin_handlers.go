@@ -4,28 +4,46 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/cfdi"
 	"github.com/tuusuario/ecommerce-backend/internal/db"
 	"github.com/tuusuario/ecommerce-backend/internal/email"
-	"github.com/tuusuario/ecommerce-backend/internal/lib"
+	"github.com/tuusuario/ecommerce-backend/internal/imaging"
+	"github.com/tuusuario/ecommerce-backend/internal/invoice"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
 )
 
 type AdminHandler struct {
 	DB              *pgxpool.Pool
 	NotificationSvc *email.NotificationService
+	Carrier         shipping.Carrier
+	Tracking        *shipping.TrackingService
+	Payments        payments.Provider
+	Invoices        *invoice.Service
+	CFDI            *cfdi.Service
+	Images          *imaging.Pipeline
 }
 
 func NewAdminHandler(db *pgxpool.Pool) *AdminHandler {
-	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService)
+	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink)
 	return &AdminHandler{
 		DB:              db,
 		NotificationSvc: notificationSvc,
+		Carrier:         shipping.CarrierFromEnv(),
+		Tracking:        shipping.NewTrackingService(db),
+		Payments:        payments.NewStripeProvider(),
+		Invoices:        invoice.NewService(db),
+		CFDI:            cfdi.NewService(db),
+		Images:          imaging.FromEnv(),
 	}
 }
 
@@ -81,34 +99,36 @@ func (h *AdminHandler) CreateProduct(c *gin.Context) {
 			weightPtr = &weightF
 		}
 
-		// Manejar imagen
+		// Manejar imagen: se procesa antes de crear el producto, así una imagen inválida no deja un
+		// producto a medias
 		var imageURL *string
+		var image *models.ProductMedia
 		imageFile, err := c.FormFile("image")
 		if err == nil && imageFile != nil {
-			src, _ := imageFile.Open()
+			src, err := imageFile.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo imagen"})
+				return
+			}
 			defer src.Close()
-			key := "images/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + imageFile.Filename
-			url, err := lib.UploadFileToS3(src, imageFile, key)
+			image, err = h.processProductImage(src)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo imagen a S3: " + err.Error()})
+				respondImageError(c, err)
 				return
 			}
-			imageURL = &url
+			imageURL = &image.URL
 		}
 
 		// Manejar modelo 3D
 		var modelURL *string
+		var modelKey string
 		modelFile, err := c.FormFile("model3d")
 		if err == nil && modelFile != nil {
-			src, _ := modelFile.Open()
-			defer src.Close()
-			key := "models/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + modelFile.Filename
-			url, err := lib.UploadFileToS3(src, modelFile, key)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo modelo 3D a S3: " + err.Error()})
+			url, key, ok := uploadProductModel(c, modelFile)
+			if !ok {
 				return
 			}
-			modelURL = &url
+			modelURL, modelKey = &url, key
 		}
 
 		var descriptionPtr *string
@@ -139,10 +159,29 @@ func (h *AdminHandler) CreateProduct(c *gin.Context) {
 
 		createdProduct, err := db.CreateProduct(h.DB, &product)
 		if err != nil {
+			if image != nil {
+				h.deleteMediaObject(*image)
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando producto: " + err.Error()})
 			return
 		}
 
+		// Los archivos subidos pasan a la galería del producto
+		if image != nil {
+			image.ProductID = createdProduct.ID
+			image.IsPrimary = true
+			h.registerUploadedMedia(image)
+			createdProduct.ImageSet = image.ImageSet
+		}
+		if modelURL != nil {
+			h.registerUploadedMedia(&models.ProductMedia{
+				ProductID:  createdProduct.ID,
+				Kind:       models.ProductMediaModel,
+				URL:        *modelURL,
+				StorageKey: &modelKey,
+			})
+		}
+
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "Producto creado exitosamente",
 			"product": createdProduct,
@@ -256,35 +295,36 @@ func (h *AdminHandler) UpdateProduct(c *gin.Context) {
 	}
 
 	imageURL := existingProduct.ImageURL // Mantener la imagen existente por defecto
+	var image *models.ProductMedia
 
 	// Manejar nueva imagen si se proporciona
 	imageFile, err := c.FormFile("image")
 	if err == nil && imageFile != nil {
-		src, _ := imageFile.Open()
+		src, err := imageFile.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo imagen"})
+			return
+		}
 		defer src.Close()
-		key := "images/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + imageFile.Filename
-		url, err := lib.UploadFileToS3(src, imageFile, key)
+		image, err = h.processProductImage(src)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo imagen a S3: " + err.Error()})
+			respondImageError(c, err)
 			return
 		}
-		imageURL = &url // ✅ Usar la URL de S3 directamente
+		imageURL = &image.URL
 	}
 
 	modelURL := existingProduct.ModelURL // Mantener el modelo existente por defecto
+	var modelKey string
 
 	// Manejar nuevo modelo 3D si se proporciona
 	modelFile, err := c.FormFile("model3d")
 	if err == nil && modelFile != nil {
-		src, _ := modelFile.Open()
-		defer src.Close()
-		key := "models/" + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + modelFile.Filename
-		url, err := lib.UploadFileToS3(src, modelFile, key)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo modelo 3D a S3: " + err.Error()})
+		url, key, ok := uploadProductModel(c, modelFile)
+		if !ok {
 			return
 		}
-		modelURL = &url // ✅ Usar la URL directamente sin agregar "/"
+		modelURL, modelKey = &url, key
 	}
 
 	var descriptionPtr *string
@@ -301,6 +341,11 @@ func (h *AdminHandler) UpdateProduct(c *gin.Context) {
 		skuPtr = existingProduct.SKU
 	}
 
+	// Con variantes el stock del producto es la suma del de sus variantes y se edita en cada una
+	if hasVariants, err := db.ProductHasVariants(h.DB, productID); err == nil && hasVariants {
+		stock = existingProduct.Stock
+	}
+
 	product := models.Product{
 		ID:          productID,
 		Name:        name,
@@ -321,10 +366,53 @@ func (h *AdminHandler) UpdateProduct(c *gin.Context) {
 
 	updatedProduct, err := db.UpdateProduct(h.DB, &product)
 	if err != nil {
+		if image != nil {
+			h.deleteMediaObject(*image)
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando producto: " + err.Error()})
 		return
 	}
 
+	// La imagen nueva pasa a ser la principal de la galería (la anterior se conserva en ella) y el
+	// modelo nuevo reemplaza a los anteriores
+	if image != nil {
+		image.ProductID = productID
+		image.IsPrimary = true
+		h.registerUploadedMedia(image)
+		updatedProduct.ImageSet = image.ImageSet
+	}
+	if modelKey != "" {
+		// Si se vuelve a subir el mismo modelo tiene la misma clave: se conserva y no se borra el archivo
+		unchanged := false
+		if media, err := db.GetProductMedia(h.DB, productID); err == nil {
+			for _, m := range media {
+				if m.Kind != models.ProductMediaModel {
+					continue
+				}
+				if m.StorageKey != nil && *m.StorageKey == modelKey {
+					unchanged = true
+					continue
+				}
+				if deleted, err := db.DeleteProductMedia(h.DB, productID, m.ID); err == nil {
+					h.deleteMediaObject(*deleted)
+				}
+			}
+		}
+		if !unchanged {
+			h.registerUploadedMedia(&models.ProductMedia{
+				ProductID:  productID,
+				Kind:       models.ProductMediaModel,
+				URL:        *modelURL,
+				StorageKey: &modelKey,
+			})
+		}
+	}
+
+	// Encolar los avisos de vuelta de stock o bajada de precio para los suscritos
+	if err := db.QueueProductAlertEvent(h.DB, existingProduct, updatedProduct); err != nil {
+		log.Printf("Error encolando alertas del producto %d: %v", productID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Producto actualizado exitosamente",
 		"product": updatedProduct,
@@ -345,9 +433,245 @@ func (h *AdminHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
+	// Los archivos de la galería se borran de S3; el producto queda desactivado sin imagen
+	media, err := db.DeleteAllProductMedia(h.DB, productID)
+	if err != nil {
+		log.Printf("Error eliminando media del producto %d: %v", productID, err)
+	}
+	for _, m := range media {
+		h.deleteMediaObject(m)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Producto eliminado exitosamente"})
 }
 
+// GetProductAttributes obtiene los atributos filtrables de un producto
+func (h *AdminHandler) GetProductAttributes(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	attributes, err := db.GetProductAttributes(h.DB, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo atributos: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attributes": attributes})
+}
+
+// SetProductAttributes reemplaza los atributos filtrables de un producto (ej. Material: Roble). Un
+// atributo puede repetirse con varios valores.
+func (h *AdminHandler) SetProductAttributes(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	var req struct {
+		Attributes []models.ProductAttribute `json:"attributes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if _, err := db.GetProductByID(h.DB, productID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+		return
+	}
+	for i := range req.Attributes {
+		a := &req.Attributes[i]
+		a.Name, a.Value = strings.TrimSpace(a.Name), strings.TrimSpace(a.Value)
+		if a.Name == "" || a.Value == "" || len(a.Name) > 50 || len(a.Value) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cada atributo necesita nombre (máx. 50) y valor (máx. 100)"})
+			return
+		}
+	}
+	if err := db.SetProductAttributes(h.DB, productID, req.Attributes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error guardando atributos: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Atributos actualizados exitosamente", "attributes": req.Attributes})
+}
+
+// GetProductVariants obtiene las opciones y todas las variantes de un producto, incluidas las
+// desactivadas
+func (h *AdminHandler) GetProductVariants(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	options, err := db.GetProductOptions(h.DB, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo opciones: " + err.Error()})
+		return
+	}
+	variants, err := db.GetProductVariants(h.DB, productID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo variantes: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"options": options, "variants": variants})
+}
+
+// GenerateProductVariants define las opciones del producto (ej. Talla: S, M, L y Color: Rojo, Azul)
+// y genera la matriz de variantes. Las combinaciones existentes se conservan y las que sobran se
+// desactivan; las nuevas empiezan con el stock indicado.
+func (h *AdminHandler) GenerateProductVariants(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	var req struct {
+		Options []models.ProductOption `json:"options" binding:"required"`
+		Stock   int                    `json:"stock" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if len(req.Options) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Indica al menos una opción con sus valores"})
+		return
+	}
+
+	combinations := 1
+	names := map[string]bool{}
+	for i := range req.Options {
+		o := &req.Options[i]
+		o.Name = strings.TrimSpace(o.Name)
+		if o.Name == "" || len(o.Name) > 50 || names[strings.ToLower(o.Name)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cada opción necesita un nombre único (máx. 50)"})
+			return
+		}
+		names[strings.ToLower(o.Name)] = true
+
+		seen := map[string]bool{}
+		values := []string{}
+		for _, v := range o.Values {
+			v = strings.TrimSpace(v)
+			if v == "" || len(v) > 50 || seen[strings.ToLower(v)] {
+				continue
+			}
+			seen[strings.ToLower(v)] = true
+			values = append(values, v)
+		}
+		if len(values) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La opción " + o.Name + " no tiene valores"})
+			return
+		}
+		o.Values = values
+		combinations *= len(values)
+		if combinations > db.MaxProductVariants {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Demasiadas combinaciones (máx. " + strconv.Itoa(db.MaxProductVariants) + ")"})
+			return
+		}
+	}
+
+	created, err := db.GenerateProductVariants(h.DB, productID, req.Options, req.Stock)
+	if err != nil {
+		if err.Error() == "producto no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando variantes: " + err.Error()})
+		return
+	}
+	variants, err := db.GetProductVariants(h.DB, productID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo variantes: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Variantes generadas exitosamente",
+		"created":  created,
+		"variants": variants,
+	})
+}
+
+// UpdateProductVariant actualiza SKU, precio propio (null usa el del producto), stock, peso,
+// imágenes y estado de una variante
+func (h *AdminHandler) UpdateProductVariant(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	variantID, err := strconv.Atoi(c.Param("variantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de variante inválido"})
+		return
+	}
+	var req struct {
+		SKU       *string  `json:"sku"`
+		Price     *float64 `json:"price"`
+		Stock     int      `json:"stock" binding:"min=0"`
+		Weight    *float64 `json:"weight"`
+		ImageURLs []string `json:"image_urls"`
+		IsActive  *bool    `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if (req.Price != nil && *req.Price <= 0) || (req.Weight != nil && *req.Weight < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Precio o peso inválido"})
+		return
+	}
+
+	variant, err := db.GetProductVariant(h.DB, productID, variantID)
+	if err != nil {
+		if err.Error() == "variante no encontrada" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variante no encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo variante: " + err.Error()})
+		return
+	}
+	before, err := db.GetProductByID(h.DB, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo producto: " + err.Error()})
+		return
+	}
+
+	if req.SKU != nil {
+		if sku := strings.TrimSpace(*req.SKU); sku != "" {
+			variant.SKU = &sku
+		} else {
+			variant.SKU = nil
+		}
+	}
+	variant.PriceOverride = req.Price
+	variant.Stock = req.Stock
+	variant.Weight = req.Weight
+	if req.ImageURLs != nil {
+		variant.ImageURLs = req.ImageURLs
+	}
+	if req.IsActive != nil {
+		variant.IsActive = *req.IsActive
+	}
+	if err := db.UpdateProductVariant(h.DB, variant); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando variante: " + err.Error()})
+		return
+	}
+
+	// Si el producto vuelve a tener stock se avisa a los suscritos
+	if after, err := db.GetProductByID(h.DB, productID); err == nil {
+		if err := db.QueueProductAlertEvent(h.DB, before, after); err != nil {
+			log.Printf("Error encolando alertas del producto %d: %v", productID, err)
+		}
+	}
+
+	variant, err = db.GetProductVariant(h.DB, productID, variantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo variante: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Variante actualizada exitosamente", "variant": variant})
+}
+
 // GetAllProducts obtiene todos los productos (con paginación)
 func (h *AdminHandler) GetAllProducts(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -441,13 +765,51 @@ func (h *AdminHandler) UpdateCategory(c *gin.Context) {
 
 	updatedCategory, err := db.UpdateCategory(h.DB, &category)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando categoría: " + err.Error()})
+		respondCategoryError(c, "Error actualizando categoría: ", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, updatedCategory)
 }
 
+// MoveCategory mueve una categoría con todas sus subcategorías bajo otra (parent_id null la deja
+// en la raíz)
+func (h *AdminHandler) MoveCategory(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de categoría inválido"})
+		return
+	}
+	var req struct {
+		ParentID *int `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	category, err := db.MoveCategory(h.DB, categoryID, req.ParentID)
+	if err != nil {
+		respondCategoryError(c, "Error moviendo categoría: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, category)
+}
+
+// respondCategoryError traduce los errores de validación del árbol de categorías
+func respondCategoryError(c *gin.Context, prefix string, err error) {
+	switch err.Error() {
+	case "categoría no encontrada":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Categoría no encontrada"})
+	case "categoría padre no encontrada":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La categoría padre no existe"})
+	case "categoría padre inválida":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Una categoría no puede quedar dentro de sí misma ni de sus subcategorías"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": prefix + err.Error()})
+	}
+}
+
 // DeleteCategory elimina una categoría
 func (h *AdminHandler) DeleteCategory(c *gin.Context) {
 	categoryID, err := strconv.Atoi(c.Param("id"))
@@ -592,9 +954,9 @@ func (h *AdminHandler) GetAllOrders(c *gin.Context) {
 
 // Ver detalles de un pedido
 func (h *AdminHandler) GetOrderByID(c *gin.Context) {
-	orderID, err := strconv.Atoi(c.Param("id"))
+	orderID, err := resolveOrderID(h.DB, c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
 		return
 	}
 	order, err := db.GetOrderByIDAdmin(h.DB, orderID)
@@ -677,3 +1039,41 @@ func (h *AdminHandler) UpdateOrder(c *gin.Context) {
 	order, _ := db.GetOrderByIDAdmin(h.DB, orderID)
 	c.JSON(http.StatusOK, gin.H{"order": order, "message": "Pedido actualizado"})
 }
+
+// ===== CONCILIACIÓN DE PAGOS =====
+
+// GetReconciliationReport devuelve el reporte de la última conciliación (o de ?run_id=)
+func (h *AdminHandler) GetReconciliationReport(c *gin.Context) {
+	var run *models.ReconciliationRun
+	var err error
+
+	if runIDParam := c.Query("run_id"); runIDParam != "" {
+		runID, convErr := strconv.Atoi(runIDParam)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de conciliación inválido"})
+			return
+		}
+		run, err = db.GetReconciliationRunByID(h.DB, runID)
+	} else {
+		run, err = db.GetLatestReconciliationRun(h.DB)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	unresolvedOnly := c.Query("unresolved") == "true"
+	discrepancies, err := db.GetReconciliationDiscrepancies(h.DB, run.ID, unresolvedOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo discrepancias: " + err.Error()})
+		return
+	}
+	run.Discrepancies = discrepancies
+
+	history, err := db.GetReconciliationRuns(h.DB, 10)
+	if err != nil {
+		log.Printf("Error obteniendo historial de conciliaciones: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"run": run, "history": history})
+}
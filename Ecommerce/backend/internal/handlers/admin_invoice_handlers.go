@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/invoice"
+)
+
+// ===== FACTURAS =====
+
+// GetOrderInvoice descarga la factura en PDF de cualquier pedido pagado
+func (h *AdminHandler) GetOrderInvoice(c *gin.Context) {
+	h.orderDocument(c, invoice.TypeInvoice)
+}
+
+// GetOrderReceipt descarga el recibo de pago en PDF de cualquier pedido pagado
+func (h *AdminHandler) GetOrderReceipt(c *gin.Context) {
+	h.orderDocument(c, invoice.TypeReceipt)
+}
+
+func (h *AdminHandler) orderDocument(c *gin.Context, kind string) {
+	orderID, err := resolveOrderID(h.DB, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	writeInvoicePDF(c, h.Invoices, order, kind)
+}
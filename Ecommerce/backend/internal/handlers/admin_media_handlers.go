@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/imaging"
+	"github.com/tuusuario/ecommerce-backend/internal/lib"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Tamaño máximo de cada tipo de media. El de las imágenes lo fija el procesamiento (IMAGE_MAX_MB).
+var maxProductMediaSize = map[string]int64{
+	models.ProductMediaModel: 50 << 20,
+	models.ProductMediaVideo: 60 << 20,
+}
+
+// Carpetas de S3 de las versiones procesadas de las imágenes de producto y de los modelos 3D del
+// formulario de producto
+const (
+	productImagesPrefix = "products/images"
+	productModelsPrefix = "products/models"
+)
+
+// Tipos de archivo aceptados en la galería, por extensión
+var productMediaTypes = map[string]struct{ kind, contentType string }{
+	".jpg":  {models.ProductMediaImage, "image/jpeg"},
+	".jpeg": {models.ProductMediaImage, "image/jpeg"},
+	".png":  {models.ProductMediaImage, "image/png"},
+	".webp": {models.ProductMediaImage, "image/webp"},
+	".gif":  {models.ProductMediaImage, "image/gif"},
+	".glb":  {models.ProductMediaModel, "model/gltf-binary"},
+	".gltf": {models.ProductMediaModel, "model/gltf+json"},
+	".mp4":  {models.ProductMediaVideo, "video/mp4"},
+	".webm": {models.ProductMediaVideo, "video/webm"},
+}
+
+// GetProductMedia obtiene la galería de un producto
+func (h *AdminHandler) GetProductMedia(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	media, err := db.GetProductMedia(h.DB, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo media: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"media": media})
+}
+
+// UploadProductMedia agrega a la galería un archivo (campo "file": imagen, modelo .glb/.gltf o
+// video .mp4/.webm) o un video externo (campo "url"). Acepta "alt_text" e "is_primary".
+func (h *AdminHandler) UploadProductMedia(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	media := &models.ProductMedia{
+		ProductID: productID,
+		AltText:   strings.TrimSpace(c.PostForm("alt_text")),
+		IsPrimary: c.PostForm("is_primary") == "true",
+	}
+	if len(media.AltText) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El texto alternativo no puede superar 255 caracteres"})
+		return
+	}
+
+	if url := strings.TrimSpace(c.PostForm("url")); url != "" {
+		if !strings.HasPrefix(url, "https://") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La URL del video debe ser https"})
+			return
+		}
+		media.Kind = models.ProductMediaVideo
+		media.URL = url
+	} else {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Se requiere un archivo o una URL"})
+			return
+		}
+		ext := strings.ToLower(filepath.Ext(file.Filename))
+		fileType, ok := productMediaTypes[ext]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de archivo no permitido"})
+			return
+		}
+		if fileType.kind == models.ProductMediaImage {
+			src, err := file.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo archivo"})
+				return
+			}
+			defer src.Close()
+			processed, err := h.processProductImage(src)
+			if err != nil {
+				respondImageError(c, err)
+				return
+			}
+			processed.ProductID = media.ProductID
+			processed.AltText = media.AltText
+			processed.IsPrimary = media.IsPrimary
+			h.saveProductMedia(c, processed)
+			return
+		}
+		if file.Size > maxProductMediaSize[fileType.kind] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El archivo supera el tamaño máximo"})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo archivo"})
+			return
+		}
+		defer src.Close()
+		// Los videos deben serlo de verdad, no solo por la extensión
+		if fileType.kind == models.ProductMediaVideo {
+			head := make([]byte, 512)
+			n, _ := io.ReadFull(src, head)
+			if !strings.HasPrefix(http.DetectContentType(head[:n]), fileType.kind+"/") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "El contenido del archivo no coincide con su tipo"})
+				return
+			}
+			if _, err := src.Seek(0, io.SeekStart); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error leyendo archivo"})
+				return
+			}
+		}
+
+		key := "products/" + strconv.Itoa(productID) + "/media/" + strconv.FormatInt(time.Now().UnixNano(), 10) + ext
+		file.Header.Set("Content-Type", fileType.contentType)
+		url, err := lib.UploadFileToS3(src, file, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo archivo a S3: " + err.Error()})
+			return
+		}
+		media.Kind = fileType.kind
+		media.URL = url
+		media.StorageKey = &key
+		media.ContentType = &fileType.contentType
+		media.SizeBytes = &file.Size
+	}
+
+	h.saveProductMedia(c, media)
+}
+
+// saveProductMedia guarda en la galería un elemento ya subido y responde; si falla borra sus archivos
+func (h *AdminHandler) saveProductMedia(c *gin.Context, media *models.ProductMedia) {
+	if err := db.AddProductMedia(h.DB, media); err != nil {
+		if media.StorageKey != nil || len(media.StorageKeys) > 0 {
+			h.deleteMediaObject(*media)
+		}
+		if err.Error() == "producto no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error guardando media: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Media agregado exitosamente", "media": media})
+}
+
+// UpdateProductMedia cambia el texto alternativo de un elemento o lo marca como imagen principal
+func (h *AdminHandler) UpdateProductMedia(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	mediaID, err := strconv.Atoi(c.Param("mediaID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de media inválido"})
+		return
+	}
+	var req struct {
+		AltText   *string `json:"alt_text"`
+		IsPrimary bool    `json:"is_primary"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.AltText != nil {
+		alt := strings.TrimSpace(*req.AltText)
+		if len(alt) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El texto alternativo no puede superar 255 caracteres"})
+			return
+		}
+		req.AltText = &alt
+	}
+
+	media, err := db.UpdateProductMedia(h.DB, productID, mediaID, req.AltText, req.IsPrimary)
+	if err != nil {
+		switch err.Error() {
+		case "media no encontrado":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Media no encontrado"})
+		case "solo una imagen puede ser la principal":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Solo una imagen puede ser la principal"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando media: " + err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Media actualizado exitosamente", "media": media})
+}
+
+// ReorderProductMedia ordena la galería con la lista completa de IDs en el nuevo orden
+func (h *AdminHandler) ReorderProductMedia(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	var req struct {
+		MediaIDs []int `json:"media_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if err := db.ReorderProductMedia(h.DB, productID, req.MediaIDs); err != nil {
+		if err.Error() == "orden de media inválido" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La lista debe incluir todo el media del producto una sola vez"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error ordenando media: " + err.Error()})
+		return
+	}
+	media, err := db.GetProductMedia(h.DB, productID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo media: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Media ordenado exitosamente", "media": media})
+}
+
+// DeleteProductMedia quita un elemento de la galería y borra su archivo de S3
+func (h *AdminHandler) DeleteProductMedia(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	mediaID, err := strconv.Atoi(c.Param("mediaID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de media inválido"})
+		return
+	}
+	media, err := db.DeleteProductMedia(h.DB, productID, mediaID)
+	if err != nil {
+		if err.Error() == "media no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Media no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando media: " + err.Error()})
+		return
+	}
+	h.deleteMediaObject(*media)
+	c.JSON(http.StatusOK, gin.H{"message": "Media eliminado exitosamente"})
+}
+
+// processProductImage procesa una imagen subida y sube a S3 todas sus versiones. El elemento
+// devuelto apunta a la versión "card" en JPEG, que es la que usan el catálogo y los emails. Las
+// claves dependen solo del contenido, así que no llevan el producto.
+func (h *AdminHandler) processProductImage(r io.Reader) (*models.ProductMedia, error) {
+	result, err := h.Images.Process(r)
+	if err != nil {
+		return nil, err
+	}
+
+	media := &models.ProductMedia{Kind: models.ProductMediaImage}
+	for _, v := range result.Variants {
+		key := v.Key(productImagesPrefix)
+		url, err := lib.UploadBytesToS3(v.Data, key, v.ContentType)
+		if err != nil {
+			h.deleteMediaObject(*media)
+			return nil, fmt.Errorf("error subiendo imagen a S3: %w", err)
+		}
+		media.StorageKeys = append(media.StorageKeys, key)
+		media.Variants = append(media.Variants, models.ImageVariant{
+			Size:   v.Size,
+			Format: v.Format,
+			Width:  v.Width,
+			Height: v.Height,
+			URL:    url,
+		})
+		if v.Size == "card" && v.Format == imaging.FormatJPEG {
+			size := int64(len(v.Data))
+			media.URL = url
+			media.StorageKey = &media.StorageKeys[len(media.StorageKeys)-1]
+			media.ContentType = &v.ContentType
+			media.SizeBytes = &size
+		}
+	}
+	if media.URL == "" && len(media.Variants) > 0 {
+		media.URL = media.Variants[0].URL
+	}
+	return media, nil
+}
+
+// respondImageError responde con 400 a las imágenes rechazadas por el procesamiento
+func respondImageError(c *gin.Context, err error) {
+	switch err {
+	case imaging.ErrTooLarge, imaging.ErrUnsupported, imaging.ErrDimensions, imaging.ErrInvalid:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error procesando imagen: " + err.Error()})
+	}
+}
+
+// uploadProductModel sube el modelo 3D del formulario de producto y responde el error si falla. La
+// clave sale del contenido, como las versiones de las imágenes: el nombre del archivo lo elige el
+// cliente y solo se usa para saber si es .glb o .gltf.
+func uploadProductModel(c *gin.Context, file *multipart.FileHeader) (url, key string, ok bool) {
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	fileType, valid := productMediaTypes[ext]
+	if !valid || fileType.kind != models.ProductMediaModel {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El modelo 3D debe ser un archivo .glb o .gltf"})
+		return "", "", false
+	}
+	limit := maxProductMediaSize[models.ProductMediaModel]
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo modelo 3D"})
+		return "", "", false
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, limit+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo modelo 3D"})
+		return "", "", false
+	}
+	if int64(len(data)) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El modelo 3D supera el tamaño máximo"})
+		return "", "", false
+	}
+
+	sum := sha256.Sum256(data)
+	key = productModelsPrefix + "/" + hex.EncodeToString(sum[:])[:16] + ext
+	url, err = lib.UploadBytesToS3(data, key, fileType.contentType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error subiendo modelo 3D a S3: " + err.Error()})
+		return "", "", false
+	}
+	return url, key, true
+}
+
+// registerUploadedMedia agrega a la galería un archivo subido con el formulario de producto, para
+// que la galería siga siendo la fuente de image_url y model_url
+func (h *AdminHandler) registerUploadedMedia(media *models.ProductMedia) {
+	if err := db.AddProductMedia(h.DB, media); err != nil {
+		log.Printf("Error registrando media del producto %d: %v", media.ProductID, err)
+	}
+}
+
+// deleteMediaObject borra de S3 los archivos de un elemento de la galería. Los elementos migrados de
+// image_url no tienen clave y se deduce de la URL; las URLs externas no se tocan. Las versiones de
+// las imágenes se nombran por su contenido y se conservan si otro elemento sube la misma imagen. Un
+// fallo solo se registra: el elemento ya no existe y el archivo queda huérfano.
+func (h *AdminHandler) deleteMediaObject(media models.ProductMedia) {
+	keys := append([]string{}, media.StorageKeys...)
+	if media.StorageKey != nil {
+		keys = append(keys, *media.StorageKey)
+	} else if k, ok := lib.S3KeyFromURL(media.URL); ok {
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	unused, err := db.UnusedMediaKeys(h.DB, keys)
+	if err != nil {
+		log.Printf("Error comprobando archivos de media: %v", err)
+		return
+	}
+	seen := map[string]bool{}
+	for _, key := range unused {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := lib.DeleteObjectFromS3(key); err != nil {
+			log.Printf("Error borrando %s de S3: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/promotion"
+)
+
+// ===== PROMOCIONES =====
+
+// PromotionRequest representa los datos de una promoción automática
+type PromotionRequest struct {
+	Name        string                 `json:"name" binding:"required"`
+	Description string                 `json:"description"`
+	Type        string                 `json:"type" binding:"required"`
+	Priority    int                    `json:"priority"`
+	Exclusive   bool                   `json:"exclusive"`
+	ProductIDs  []int                  `json:"product_ids"`
+	CategoryIDs []int                  `json:"category_ids"`
+	BuyQuantity int                    `json:"buy_quantity"`
+	GetQuantity int                    `json:"get_quantity"`
+	Percent     float64                `json:"percent"`
+	Tiers       []models.PromotionTier `json:"tiers"`
+	BundlePrice float64                `json:"bundle_price"`
+	StartsAt    *time.Time             `json:"starts_at"`
+	EndsAt      *time.Time             `json:"ends_at"`
+	IsActive    *bool                  `json:"is_active"`
+}
+
+// toModel valida la solicitud según el tipo de promoción y la convierte en el modelo
+func (r *PromotionRequest) toModel() (*models.Promotion, string) {
+	if !promotion.ValidType(r.Type) {
+		return nil, "Tipo de promoción inválido (buy_x_get_y, tiered, category_percent o bundle)"
+	}
+	p := &models.Promotion{
+		Name:        strings.TrimSpace(r.Name),
+		Description: strings.TrimSpace(r.Description),
+		Type:        r.Type,
+		Priority:    r.Priority,
+		Exclusive:   r.Exclusive,
+		ProductIDs:  r.ProductIDs,
+		CategoryIDs: r.CategoryIDs,
+		StartsAt:    r.StartsAt,
+		EndsAt:      r.EndsAt,
+		IsActive:    true,
+	}
+	if r.IsActive != nil {
+		p.IsActive = *r.IsActive
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return nil, "La fecha de fin debe ser posterior a la de inicio"
+	}
+
+	// Solo se guardan los campos que usa cada tipo
+	switch r.Type {
+	case promotion.TypeBuyXGetY:
+		if r.BuyQuantity <= 0 || r.GetQuantity <= 0 {
+			return nil, "buy_quantity y get_quantity deben ser mayores que 0"
+		}
+		percent := r.Percent
+		if percent == 0 {
+			percent = 100
+		}
+		if percent < 0 || percent > 100 {
+			return nil, "El porcentaje debe estar entre 0 y 100"
+		}
+		p.BuyQuantity, p.GetQuantity, p.Percent = r.BuyQuantity, r.GetQuantity, percent
+	case promotion.TypeTiered:
+		if len(r.Tiers) == 0 {
+			return nil, "La promoción por monto necesita al menos un escalón"
+		}
+		for _, t := range r.Tiers {
+			if t.MinSubtotal < 0 || t.Percent < 0 || t.Percent > 100 || t.Amount < 0 || (t.Percent == 0) == (t.Amount == 0) {
+				return nil, "Cada escalón necesita un subtotal mínimo y un porcentaje (0-100) o un importe, no ambos"
+			}
+		}
+		p.Tiers = append([]models.PromotionTier(nil), r.Tiers...)
+		sort.Slice(p.Tiers, func(i, j int) bool { return p.Tiers[i].MinSubtotal < p.Tiers[j].MinSubtotal })
+	case promotion.TypeCategoryPercent:
+		if len(r.CategoryIDs) == 0 {
+			return nil, "Indica al menos una categoría"
+		}
+		if r.Percent <= 0 || r.Percent > 100 {
+			return nil, "El porcentaje debe estar entre 0 y 100"
+		}
+		p.Percent = r.Percent
+	case promotion.TypeBundle:
+		seen := map[int]bool{}
+		for _, id := range r.ProductIDs {
+			seen[id] = true
+		}
+		if len(seen) < 2 || len(seen) != len(r.ProductIDs) {
+			return nil, "El paquete necesita al menos dos productos distintos"
+		}
+		if r.BundlePrice <= 0 {
+			return nil, "El precio del paquete debe ser mayor que 0"
+		}
+		p.CategoryIDs = nil
+		p.BundlePrice = r.BundlePrice
+	}
+	return p, ""
+}
+
+// GetPromotions lista las promociones en el orden en que se evalúan
+func (h *AdminHandler) GetPromotions(c *gin.Context) {
+	promotions, err := db.GetPromotions(h.DB, c.Query("active") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo promociones: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": promotions})
+}
+
+// GetPromotion obtiene una promoción
+func (h *AdminHandler) GetPromotion(c *gin.Context) {
+	promotionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de promoción inválido"})
+		return
+	}
+	p, err := db.GetPromotionByID(h.DB, promotionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promoción no encontrada"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// CreatePromotion crea una promoción automática
+func (h *AdminHandler) CreatePromotion(c *gin.Context) {
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	p, msg := req.toModel()
+	if p == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := db.CreatePromotion(h.DB, p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando promoción: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+// UpdatePromotion actualiza una promoción existente
+func (h *AdminHandler) UpdatePromotion(c *gin.Context) {
+	promotionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de promoción inválido"})
+		return
+	}
+
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	p, msg := req.toModel()
+	if p == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	p.ID = promotionID
+
+	if err := db.UpdatePromotion(h.DB, p); err != nil {
+		if err.Error() == "promoción no encontrada" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promoción no encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando promoción: " + err.Error()})
+		return
+	}
+
+	updated, err := db.GetPromotionByID(h.DB, promotionID)
+	if err != nil {
+		c.JSON(http.StatusOK, p)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeletePromotion elimina una promoción
+func (h *AdminHandler) DeletePromotion(c *gin.Context) {
+	promotionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de promoción inválido"})
+		return
+	}
+
+	if err := db.DeletePromotion(h.DB, promotionID); err != nil {
+		if err.Error() == "promoción no encontrada" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promoción no encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando promoción: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Promoción eliminada exitosamente"})
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
+)
+
+// ===== DEVOLUCIONES =====
+
+// GetReturnRequests lista las devoluciones, opcionalmente filtradas con ?status=
+func (h *AdminHandler) GetReturnRequests(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	returns, err := db.GetReturnRequests(h.DB, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo devoluciones: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"returns": returns})
+}
+
+// GetReturnRequest obtiene una devolución con sus items
+func (h *AdminHandler) GetReturnRequest(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"return": ret})
+}
+
+// ApproveReturn aprueba una devolución y reembolsa el monto al método de pago original
+func (h *AdminHandler) ApproveReturn(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	if ret.Status != "requested" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se pueden aprobar devoluciones solicitadas"})
+		return
+	}
+
+	// La aprobación se guarda solo si la devolución sigue solicitada, y deja el reembolso en curso para
+	// que un reintento simultáneo no cree un segundo reembolso
+	now := time.Now()
+	fromRefundStatus := ret.RefundStatus
+	ret.Status = "approved"
+	ret.AdminNote = req.Note
+	ret.ApprovedAt = &now
+	ret.RefundStatus = "pending"
+	approved, err := db.UpdateReturnRequestFrom(h.DB, ret, "requested", fromRefundStatus)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error aprobando devolución: " + err.Error()})
+		return
+	}
+	if !approved {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se pueden aprobar devoluciones solicitadas"})
+		return
+	}
+
+	order, err := db.GetOrderByID(h.DB, ret.OrderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	if err := h.NotificationSvc.CreateReturnNotification(context.Background(), ret.UserID, order.ID, order.OrderNumber, ret.RMANumber, "approved", req.Note, ret.RefundAmount, order.Currency); err != nil {
+		log.Printf("Error notificando aprobación de devolución %s: %v", ret.RMANumber, err)
+	}
+
+	refundErr := h.refundReturn(c.Request.Context(), ret, order)
+	response := gin.H{"return": ret}
+	if refundErr != nil {
+		response["refund_error"] = refundErr.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RetryReturnRefund reintenta el reembolso de una devolución aprobada cuyo reembolso falló
+func (h *AdminHandler) RetryReturnRefund(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	if ret.Status == "requested" || ret.Status == "rejected" {
+		c.JSON(http.StatusConflict, gin.H{"error": "La devolución no está aprobada"})
+		return
+	}
+	if ret.RefundStatus != "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "El reembolso no está en estado fallido"})
+		return
+	}
+	order, err := db.GetOrderByID(h.DB, ret.OrderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+
+	// Tomar el reintento: solo una petición pasa el reembolso de failed a pending
+	ret.RefundStatus = "pending"
+	claimed, err := db.UpdateReturnRequestFrom(h.DB, ret, ret.Status, "failed")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando devolución: " + err.Error()})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "El reembolso no está en estado fallido"})
+		return
+	}
+	if err := h.refundReturn(c.Request.Context(), ret, order); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "return": ret})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"return": ret})
+}
+
+// refundReturn reembolsa la devolución contra el pago exitoso del pedido y actualiza pago y pedido
+func (h *AdminHandler) refundReturn(ctx context.Context, ret *models.ReturnRequest, order *models.Order) error {
+	fail := func(err error) error {
+		ret.RefundStatus = "failed"
+		if uerr := db.UpdateReturnRequest(h.DB, ret); uerr != nil {
+			log.Printf("Error guardando estado de reembolso de %s: %v", ret.RMANumber, uerr)
+		}
+		if nerr := h.NotificationSvc.CreateReturnNotification(context.Background(), ret.UserID, order.ID, order.OrderNumber, ret.RMANumber, "refund_failed", "", ret.RefundAmount, order.Currency); nerr != nil {
+			log.Printf("Error notificando fallo de reembolso de %s: %v", ret.RMANumber, nerr)
+		}
+		if nerr := h.NotificationSvc.CreateAdminNotification(context.Background(), "Reembolso fallido",
+			fmt.Sprintf("Devolución %s del pedido #%s: %v", ret.RMANumber, order.OrderNumber, err), "high"); nerr != nil {
+			log.Printf("Error notificando fallo de reembolso al administrador: %v", nerr)
+		}
+		return err
+	}
+
+	orderPayments, err := db.GetOrderPayments(h.DB, order.ID)
+	if err != nil {
+		return fail(fmt.Errorf("error obteniendo pagos del pedido: %w", err))
+	}
+	var payment *models.Payment
+	for i := range orderPayments {
+		if orderPayments[i].Status == "succeeded" && orderPayments[i].StripePaymentIntentID != "" {
+			payment = &orderPayments[i]
+			break
+		}
+	}
+	if payment == nil {
+		return fail(fmt.Errorf("el pedido no tiene un pago exitoso que reembolsar"))
+	}
+
+	refunded, err := db.GetRefundedAmount(h.DB, payment.ID)
+	if err != nil {
+		return fail(err)
+	}
+	amount := ret.RefundAmount
+	if remaining := payment.Amount - refunded; amount > remaining {
+		amount = remaining
+	}
+	if amount <= 0 {
+		return fail(fmt.Errorf("el pago ya fue reembolsado por completo"))
+	}
+
+	returnID := ret.ID
+	refund := &models.Refund{
+		PaymentID: payment.ID,
+		OrderID:   order.ID,
+		ReturnID:  &returnID,
+		Amount:    amount,
+		Status:    "pending",
+		Reason:    "return " + ret.RMANumber,
+	}
+	if err := db.CreateRefund(h.DB, refund); err != nil {
+		return fail(err)
+	}
+
+	// La clave de idempotencia por registro evita reembolsar dos veces si Stripe responde tarde
+	result, err := h.Payments.RefundPayment(ctx, payment.StripePaymentIntentID, payments.ToCents(amount), ret.RMANumber, fmt.Sprintf("refund-%d", refund.ID))
+	if err != nil {
+		db.UpdateRefundResult(h.DB, refund.ID, "", "failed")
+		return fail(err)
+	}
+
+	status := "pending"
+	switch result.Status {
+	case "succeeded":
+		status = "succeeded"
+	case "failed", "canceled":
+		status = "failed"
+	}
+	if err := db.UpdateRefundResult(h.DB, refund.ID, result.ID, status); err != nil {
+		log.Printf("Error guardando reembolso %s: %v", result.ID, err)
+	}
+	if status == "failed" {
+		return fail(fmt.Errorf("el procesador rechazó el reembolso %s", result.ID))
+	}
+
+	ret.RefundAmount = amount
+	ret.RefundStatus = status
+	ret.RefundID = result.ID
+	if err := db.UpdateReturnRequest(h.DB, ret); err != nil {
+		log.Printf("Error guardando reembolso de %s: %v", ret.RMANumber, err)
+	}
+
+	// Reembolso total: pago y pedido quedan reembolsados; parcial: el pedido queda parcialmente reembolsado
+	paymentStatus := "partially_refunded"
+	if payments.ToCents(refunded+amount) >= payments.ToCents(payment.Amount) {
+		paymentStatus = "refunded"
+		payment.Status = "refunded"
+		payment.UpdatedAt = time.Now()
+		if err := db.UpdatePayment(h.DB, payment); err != nil {
+			log.Printf("Error marcando pago %d como reembolsado: %v", payment.ID, err)
+		}
+	}
+	if err := db.UpdateOrderPaymentStatus(h.DB, order.ID, paymentStatus); err != nil {
+		log.Printf("Error actualizando estado de pago del pedido %d: %v", order.ID, err)
+	}
+	if err := db.AddOrderHistory(h.DB, &models.OrderStatusHistory{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   order.Status,
+		Note:       fmt.Sprintf("Reembolso %s de %.2f %s por devolución %s", result.ID, amount, order.Currency, ret.RMANumber),
+		Actor:      "admin",
+	}); err != nil {
+		log.Printf("Error guardando historial del pedido %d: %v", order.ID, err)
+	}
+	return nil
+}
+
+// RejectReturn rechaza una devolución solicitada
+func (h *AdminHandler) RejectReturn(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Note string `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Debes indicar el motivo del rechazo"})
+		return
+	}
+	if ret.Status != "requested" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se pueden rechazar devoluciones solicitadas"})
+		return
+	}
+
+	ret.Status = "rejected"
+	ret.AdminNote = req.Note
+	if err := db.UpdateReturnRequest(h.DB, ret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rechazando devolución: " + err.Error()})
+		return
+	}
+	h.notifyReturn(ret, req.Note)
+	c.JSON(http.StatusOK, gin.H{"return": ret})
+}
+
+// ReceiveReturn registra la recepción e inspección de los productos devueltos
+func (h *AdminHandler) ReceiveReturn(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Note  string `json:"note"`
+		Items []struct {
+			ID        int    `json:"id" binding:"required"`
+			Condition string `json:"condition" binding:"required"`
+		} `json:"items"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+			return
+		}
+	}
+	if ret.Status != "approved" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se pueden recibir devoluciones aprobadas"})
+		return
+	}
+
+	// Por defecto los productos se consideran vendibles salvo que la inspección indique lo contrario
+	conditions := make(map[int]string, len(ret.Items))
+	for _, item := range ret.Items {
+		conditions[item.ID] = "sellable"
+	}
+	for _, item := range req.Items {
+		if _, ok := conditions[item.ID]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("El item %d no pertenece a la devolución", item.ID)})
+			return
+		}
+		if item.Condition != "sellable" && item.Condition != "damaged" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La condición debe ser sellable o damaged"})
+			return
+		}
+		conditions[item.ID] = item.Condition
+	}
+	for itemID, condition := range conditions {
+		if err := db.SetReturnItemCondition(h.DB, ret.ID, itemID, condition); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error guardando inspección: " + err.Error()})
+			return
+		}
+	}
+
+	now := time.Now()
+	ret.Status = "received"
+	ret.ReceivedAt = &now
+	if req.Note != "" {
+		ret.AdminNote = req.Note
+	}
+	if err := db.UpdateReturnRequest(h.DB, ret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando devolución: " + err.Error()})
+		return
+	}
+	if items, err := db.GetReturnItems(h.DB, ret.ID); err == nil {
+		ret.Items = items
+	}
+	h.notifyReturn(ret, "")
+	c.JSON(http.StatusOK, gin.H{"return": ret})
+}
+
+// RestockReturn repone al inventario los productos devueltos en condición vendible
+func (h *AdminHandler) RestockReturn(c *gin.Context) {
+	ret, ok := h.returnFromParam(c)
+	if !ok {
+		return
+	}
+	if ret.Status != "received" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se pueden reingresar devoluciones recibidas"})
+		return
+	}
+	restocked, err := db.RestockReturn(h.DB, ret.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reponiendo inventario: " + err.Error()})
+		return
+	}
+
+	ret, err = db.GetReturnByID(h.DB, ret.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.notifyReturn(ret, "")
+	c.JSON(http.StatusOK, gin.H{"return": ret, "restocked_units": restocked})
+}
+
+// returnFromParam obtiene la devolución del parámetro :id
+func (h *AdminHandler) returnFromParam(c *gin.Context) (*models.ReturnRequest, bool) {
+	returnID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de devolución inválido"})
+		return nil, false
+	}
+	ret, err := db.GetReturnByID(h.DB, returnID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Devolución no encontrada"})
+		return nil, false
+	}
+	return ret, true
+}
+
+// notifyReturn avisa al cliente del estado actual de su devolución
+func (h *AdminHandler) notifyReturn(ret *models.ReturnRequest, note string) {
+	order, err := db.GetOrderByID(h.DB, ret.OrderID)
+	if err != nil {
+		log.Printf("Error obteniendo pedido %d para notificar devolución: %v", ret.OrderID, err)
+		return
+	}
+	if err := h.NotificationSvc.CreateReturnNotification(context.Background(), ret.UserID, order.ID, order.OrderNumber, ret.RMANumber, ret.Status, note, ret.RefundAmount, order.Currency); err != nil {
+		log.Printf("Error notificando devolución %s: %v", ret.RMANumber, err)
+	}
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/lib"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
+)
+
+// ===== GUÍAS Y RASTREO =====
+
+// CreateShipmentItemRequest indica cuánto de un item del pedido va en el paquete
+type CreateShipmentItemRequest struct {
+	OrderItemID int `json:"order_item_id" binding:"required"`
+	Quantity    int `json:"quantity" binding:"required,min=1"`
+}
+
+// CreateShipmentRequest crea un paquete (fulfillment) del pedido. Sin items incluye todo lo pendiente;
+// con tracking_number se registra un envío manual sin generar guía con la paquetería.
+type CreateShipmentRequest struct {
+	Service        string                      `json:"service"`
+	Items          []CreateShipmentItemRequest `json:"items"`
+	Carrier        string                      `json:"carrier"`
+	TrackingNumber string                      `json:"tracking_number"`
+}
+
+// CreateOrderShipment crea un paquete del pedido y, si no es manual, genera la guía y la guarda en S3
+func (h *AdminHandler) CreateOrderShipment(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+	var req CreateShipmentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+			return
+		}
+	}
+
+	order, err := db.GetOrderByIDAdmin(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	if order.Status == "cancelled" || order.Status == "delivered" {
+		c.JSON(http.StatusConflict, gin.H{"error": "No se puede generar una guía para un pedido " + order.Status})
+		return
+	}
+	if order.ShippingAddress == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El pedido no tiene dirección de envío"})
+		return
+	}
+
+	// Items del paquete: los indicados o todo lo pendiente de enviar
+	var packageItems []models.ShipmentItem
+	if len(req.Items) > 0 {
+		for _, it := range req.Items {
+			packageItems = append(packageItems, models.ShipmentItem{OrderItemID: it.OrderItemID, Quantity: it.Quantity})
+		}
+	} else {
+		packageItems, err = db.GetUnfulfilledItems(h.DB, orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo items pendientes: " + err.Error()})
+			return
+		}
+		if len(packageItems) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Todos los items del pedido ya fueron enviados"})
+			return
+		}
+	}
+
+	shipment := &models.Shipment{
+		OrderID:   order.ID,
+		Status:    shipping.StatusLabelCreated,
+		LastEvent: "Guía generada",
+		Items:     packageItems,
+	}
+
+	if req.TrackingNumber != "" {
+		shipment.Carrier = strings.TrimSpace(req.Carrier)
+		if shipment.Carrier == "" {
+			shipment.Carrier = "manual"
+		}
+		shipment.Service = req.Service
+		shipment.TrackingNumber = strings.TrimSpace(req.TrackingNumber)
+		shipment.LastEvent = "Envío registrado manualmente"
+	} else {
+		label, err := h.Carrier.CreateShipment(c.Request.Context(), shipping.ShipmentRequest{
+			OrderID:     order.ID,
+			OrderNumber: order.OrderNumber,
+			Service:     req.Service,
+			From:        shipping.OriginAddressFromEnv(),
+			To:          order.ShippingAddress,
+			Items:       h.packageShippingItems(order, packageItems),
+		})
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Error generando guía: " + err.Error()})
+			return
+		}
+
+		key := fmt.Sprintf("labels/%d/%s.%s", order.ID, label.TrackingNumber, label.Extension)
+		labelURL, err := lib.UploadBytesToS3(label.Data, key, label.ContentType)
+		if err != nil {
+			// La guía ya existe en la paquetería; se guarda el envío aunque falle la subida
+			log.Printf("Error subiendo guía %s a S3: %v", label.TrackingNumber, err)
+		}
+		shipment.Carrier = h.Carrier.Name()
+		shipment.Service = label.Service
+		shipment.TrackingNumber = label.TrackingNumber
+		shipment.LabelURL = labelURL
+		shipment.Cost = label.Cost
+	}
+
+	if err := db.CreateShipment(h.DB, shipment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error guardando envío: " + err.Error()})
+		return
+	}
+	if items, err := db.GetShipmentItems(h.DB, shipment.ID); err == nil {
+		shipment.Items = items
+	}
+	if err := db.UpdateOrderTracking(h.DB, order.ID, shipment.TrackingNumber); err != nil {
+		log.Printf("Error guardando tracking del pedido %d: %v", order.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, shipment)
+}
+
+// packageShippingItems arma los items de envío (peso y dimensiones) de los items del paquete
+func (h *AdminHandler) packageShippingItems(order *models.Order, packageItems []models.ShipmentItem) []shipping.Item {
+	orderItems := make(map[int]models.OrderItem, len(order.Items))
+	for _, oi := range order.Items {
+		orderItems[oi.ID] = oi
+	}
+
+	items := make([]shipping.Item, 0, len(packageItems))
+	for _, pi := range packageItems {
+		oi, ok := orderItems[pi.OrderItemID]
+		if !ok {
+			continue
+		}
+		product, err := db.GetProductByID(h.DB, oi.ProductID)
+		if err != nil {
+			items = append(items, shipping.Item{ProductID: oi.ProductID, Quantity: pi.Quantity, UnitPrice: oi.Price})
+			continue
+		}
+		items = append(items, shipping.ItemFromProduct(product, pi.Quantity))
+	}
+	return items
+}
+
+// validShipmentStatuses son los estados que un administrador puede asignar a un envío
+var validShipmentStatuses = map[string]bool{
+	shipping.StatusLabelCreated:   true,
+	shipping.StatusInTransit:      true,
+	shipping.StatusOutForDelivery: true,
+	shipping.StatusDelivered:      true,
+	shipping.StatusException:      true,
+}
+
+// UpdateShipment actualiza paquetería, rastreo o estado de un envío; el estado del pedido se recalcula
+func (h *AdminHandler) UpdateShipment(c *gin.Context) {
+	shipment, ok := h.shipmentFromParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Carrier        string `json:"carrier"`
+		Service        string `json:"service"`
+		TrackingNumber string `json:"tracking_number"`
+		Status         string `json:"status"`
+		Note           string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.Status != "" && !validShipmentStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estado de envío inválido"})
+		return
+	}
+	if shipment.Status == shipping.StatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "El envío está cancelado"})
+		return
+	}
+
+	if req.Carrier != "" || req.Service != "" || req.TrackingNumber != "" {
+		if req.Carrier != "" {
+			shipment.Carrier = strings.TrimSpace(req.Carrier)
+		}
+		if req.Service != "" {
+			shipment.Service = req.Service
+		}
+		if req.TrackingNumber != "" {
+			shipment.TrackingNumber = strings.TrimSpace(req.TrackingNumber)
+		}
+		if err := db.UpdateShipmentDetails(h.DB, shipment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando envío: " + err.Error()})
+			return
+		}
+	}
+
+	if req.Status != "" && req.Status != shipment.Status {
+		description := req.Note
+		if description == "" {
+			description = "Estado actualizado por administrador"
+		}
+		info := &shipping.TrackingInfo{
+			TrackingNumber: shipment.TrackingNumber,
+			Status:         req.Status,
+			Events: []shipping.TrackingUpdate{
+				{Status: req.Status, Description: description, OccurredAt: time.Now().Truncate(time.Second)},
+			},
+		}
+		if err := h.Tracking.Apply(c.Request.Context(), shipment, info); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando estado: " + err.Error()})
+			return
+		}
+	}
+
+	if items, err := db.GetShipmentItems(h.DB, shipment.ID); err == nil {
+		shipment.Items = items
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// GetOrderShipments lista los envíos de un pedido con sus eventos de rastreo
+func (h *AdminHandler) GetOrderShipments(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+	shipments, err := db.GetOrderShipments(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo envíos: " + err.Error()})
+		return
+	}
+	for i := range shipments {
+		events, err := db.GetShipmentEvents(h.DB, shipments[i].ID)
+		if err != nil {
+			log.Printf("Error obteniendo eventos del envío %d: %v", shipments[i].ID, err)
+			continue
+		}
+		shipments[i].Events = events
+	}
+	c.JSON(http.StatusOK, gin.H{"shipments": shipments})
+}
+
+// CancelShipment cancela la guía con la paquetería
+func (h *AdminHandler) CancelShipment(c *gin.Context) {
+	shipment, ok := h.shipmentFromParam(c)
+	if !ok {
+		return
+	}
+	if shipment.Status == shipping.StatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "El envío ya está cancelado"})
+		return
+	}
+	if shipment.Carrier == h.Carrier.Name() {
+		if err := h.Carrier.Cancel(c.Request.Context(), shipment.TrackingNumber); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se pudo cancelar la guía: " + err.Error()})
+			return
+		}
+	}
+	// Al cancelar, sus items vuelven a quedar pendientes de enviar y el pedido se recalcula
+	info := &shipping.TrackingInfo{
+		TrackingNumber: shipment.TrackingNumber,
+		Status:         shipping.StatusCancelled,
+		Events: []shipping.TrackingUpdate{
+			{Status: shipping.StatusCancelled, Description: "Guía cancelada", OccurredAt: time.Now().Truncate(time.Second)},
+		},
+	}
+	if err := h.Tracking.Apply(c.Request.Context(), shipment, info); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando envío: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// RefreshShipment consulta el rastreo de un envío en la paquetería
+func (h *AdminHandler) RefreshShipment(c *gin.Context) {
+	shipment, ok := h.shipmentFromParam(c)
+	if !ok {
+		return
+	}
+	if shipment.Carrier != h.Carrier.Name() {
+		c.JSON(http.StatusConflict, gin.H{"error": "El envío no se puede consultar con la paquetería configurada: " + shipment.Carrier})
+		return
+	}
+	if err := h.Tracking.Refresh(c.Request.Context(), h.Carrier, shipment); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := db.GetShipmentEvents(h.DB, shipment.ID)
+	if err == nil {
+		shipment.Events = events
+	}
+	c.JSON(http.StatusOK, shipment)
+}
+
+// shipmentFromParam obtiene el envío del parámetro :id
+func (h *AdminHandler) shipmentFromParam(c *gin.Context) (*models.Shipment, bool) {
+	shipmentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de envío inválido"})
+		return nil, false
+	}
+	shipment, err := db.GetShipmentByID(h.DB, shipmentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Envío no encontrado"})
+		return nil, false
+	}
+	return shipment, true
+}
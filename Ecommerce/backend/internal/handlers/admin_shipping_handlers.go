@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ===== ENVÍOS =====
+
+// validShippingRuleTypes son las reglas de tarifa soportadas
+var validShippingRuleTypes = map[string]bool{"flat": true, "weight": true, "price": true}
+
+// GetShippingZones lista las zonas de envío con sus métodos
+func (h *AdminHandler) GetShippingZones(c *gin.Context) {
+	zones, err := db.GetShippingZones(h.DB, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo zonas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"zones": zones})
+}
+
+// bindShippingZone lee y valida una zona del body
+func bindShippingZone(c *gin.Context) (*models.ShippingZone, bool) {
+	var req struct {
+		Name         string `json:"name" binding:"required"`
+		Country      string `json:"country"`
+		State        string `json:"state"`
+		PostalPrefix string `json:"postal_prefix"`
+		IsActive     *bool  `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return nil, false
+	}
+	country := strings.ToUpper(strings.TrimSpace(req.Country))
+	if country != "" && len(country) != 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El país debe ser un código ISO de 2 letras"})
+		return nil, false
+	}
+	zone := &models.ShippingZone{
+		Name:         strings.TrimSpace(req.Name),
+		Country:      country,
+		State:        strings.TrimSpace(req.State),
+		PostalPrefix: strings.TrimSpace(req.PostalPrefix),
+		IsActive:     req.IsActive == nil || *req.IsActive,
+	}
+	return zone, true
+}
+
+// CreateShippingZone crea una zona de envío
+func (h *AdminHandler) CreateShippingZone(c *gin.Context) {
+	zone, ok := bindShippingZone(c)
+	if !ok {
+		return
+	}
+	if err := db.CreateShippingZone(h.DB, zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando zona: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, zone)
+}
+
+// UpdateShippingZone actualiza una zona de envío
+func (h *AdminHandler) UpdateShippingZone(c *gin.Context) {
+	zoneID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de zona inválido"})
+		return
+	}
+	zone, ok := bindShippingZone(c)
+	if !ok {
+		return
+	}
+	zone.ID = zoneID
+	if err := db.UpdateShippingZone(h.DB, zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando zona: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, zone)
+}
+
+// DeleteShippingZone elimina una zona de envío y sus métodos
+func (h *AdminHandler) DeleteShippingZone(c *gin.Context) {
+	zoneID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de zona inválido"})
+		return
+	}
+	if err := db.DeleteShippingZone(h.DB, zoneID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando zona: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Zona eliminada exitosamente"})
+}
+
+// bindShippingMethod lee y valida un método de envío del body
+func bindShippingMethod(c *gin.Context) (*models.ShippingMethod, bool) {
+	var req struct {
+		ZoneID    int                   `json:"zone_id"`
+		Code      string                `json:"code"`
+		Name      string                `json:"name"`
+		RuleType  string                `json:"rule_type"`
+		BaseCost  float64               `json:"base_cost"`
+		PerKg     float64               `json:"per_kg"`
+		Tiers     []models.ShippingTier `json:"tiers"`
+		FreeOver  *float64              `json:"free_over"`
+		MaxWeight *float64              `json:"max_weight"`
+		MinDays   int                   `json:"min_days"`
+		MaxDays   int                   `json:"max_days"`
+		SortOrder int                   `json:"sort_order"`
+		IsActive  *bool                 `json:"is_active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return nil, false
+	}
+	method := models.ShippingMethod{
+		ZoneID:    req.ZoneID,
+		Code:      req.Code,
+		Name:      req.Name,
+		RuleType:  req.RuleType,
+		BaseCost:  req.BaseCost,
+		PerKg:     req.PerKg,
+		Tiers:     req.Tiers,
+		FreeOver:  req.FreeOver,
+		MaxWeight: req.MaxWeight,
+		MinDays:   req.MinDays,
+		MaxDays:   req.MaxDays,
+		SortOrder: req.SortOrder,
+		IsActive:  req.IsActive == nil || *req.IsActive,
+	}
+
+	method.Name = strings.TrimSpace(method.Name)
+	method.Code = strings.TrimSpace(method.Code)
+	if method.Code == "" {
+		method.Code = "standard"
+	}
+	if method.RuleType == "" {
+		method.RuleType = "flat"
+	}
+	if method.ZoneID == 0 || method.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "zone_id y name son requeridos"})
+		return nil, false
+	}
+	if !validShippingRuleTypes[method.RuleType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rule_type debe ser flat, weight o price"})
+		return nil, false
+	}
+	if method.BaseCost < 0 || method.PerKg < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Los costos no pueden ser negativos"})
+		return nil, false
+	}
+	for _, t := range method.Tiers {
+		if t.Cost < 0 || t.Min < 0 || (t.Max != 0 && t.Max <= t.Min) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rango de tarifa inválido"})
+			return nil, false
+		}
+	}
+	return &method, true
+}
+
+// CreateShippingMethod crea un método de envío
+func (h *AdminHandler) CreateShippingMethod(c *gin.Context) {
+	method, ok := bindShippingMethod(c)
+	if !ok {
+		return
+	}
+	if err := db.CreateShippingMethod(h.DB, method); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando método de envío: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, method)
+}
+
+// UpdateShippingMethod actualiza un método de envío
+func (h *AdminHandler) UpdateShippingMethod(c *gin.Context) {
+	methodID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de método inválido"})
+		return
+	}
+	method, ok := bindShippingMethod(c)
+	if !ok {
+		return
+	}
+	method.ID = methodID
+	if err := db.UpdateShippingMethod(h.DB, method); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando método de envío: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, method)
+}
+
+// DeleteShippingMethod elimina un método de envío
+func (h *AdminHandler) DeleteShippingMethod(c *gin.Context) {
+	methodID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de método inválido"})
+		return
+	}
+	if err := db.DeleteShippingMethod(h.DB, methodID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando método de envío: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Método de envío eliminado exitosamente"})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/tax"
+)
+
+// ===== IMPUESTOS =====
+
+// TaxRateRequest representa los datos de una tasa de impuesto
+type TaxRateRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	Country      string  `json:"country"`
+	State        string  `json:"state"`
+	PostalPrefix string  `json:"postal_prefix"`
+	TaxClass     string  `json:"tax_class"`
+	Rate         float64 `json:"rate"`
+	Inclusive    bool    `json:"inclusive"`
+	Priority     int     `json:"priority"`
+	IsActive     *bool   `json:"is_active"`
+}
+
+// toModel valida la solicitud y la convierte en una tasa
+func (r *TaxRateRequest) toModel() (*models.TaxRate, string) {
+	if r.Rate < 0 || r.Rate > 1 {
+		return nil, "La tasa debe estar entre 0 y 1 (ej. 0.16 para 16%)"
+	}
+	country := strings.ToUpper(strings.TrimSpace(r.Country))
+	if country != "" && len(country) != 2 {
+		return nil, "El país debe ser un código ISO de 2 letras"
+	}
+	taxClass := strings.TrimSpace(r.TaxClass)
+	if taxClass == "" {
+		taxClass = tax.DefaultTaxClass
+	}
+	priority := r.Priority
+	if priority == 0 {
+		priority = 1
+	}
+	isActive := true
+	if r.IsActive != nil {
+		isActive = *r.IsActive
+	}
+
+	return &models.TaxRate{
+		Name:         strings.TrimSpace(r.Name),
+		Country:      country,
+		State:        strings.TrimSpace(r.State),
+		PostalPrefix: strings.TrimSpace(r.PostalPrefix),
+		TaxClass:     taxClass,
+		Rate:         r.Rate,
+		Inclusive:    r.Inclusive,
+		Priority:     priority,
+		IsActive:     isActive,
+	}, ""
+}
+
+// GetTaxRates lista todas las tasas de impuesto
+func (h *AdminHandler) GetTaxRates(c *gin.Context) {
+	rates, err := db.GetTaxRates(h.DB, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo tasas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rates": rates})
+}
+
+// CreateTaxRate crea una nueva tasa de impuesto
+func (h *AdminHandler) CreateTaxRate(c *gin.Context) {
+	var req TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	rate, msg := req.toModel()
+	if rate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := db.CreateTaxRate(h.DB, rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando tasa: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rate)
+}
+
+// UpdateTaxRate actualiza una tasa de impuesto existente
+func (h *AdminHandler) UpdateTaxRate(c *gin.Context) {
+	rateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de tasa inválido"})
+		return
+	}
+
+	var req TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	rate, msg := req.toModel()
+	if rate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	rate.ID = rateID
+
+	if err := db.UpdateTaxRate(h.DB, rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando tasa: " + err.Error()})
+		return
+	}
+
+	updated, err := db.GetTaxRateByID(h.DB, rateID)
+	if err != nil {
+		c.JSON(http.StatusOK, rate)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteTaxRate elimina una tasa de impuesto
+func (h *AdminHandler) DeleteTaxRate(c *gin.Context) {
+	rateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de tasa inválido"})
+		return
+	}
+
+	if err := db.DeleteTaxRate(h.DB, rateID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando tasa: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tasa eliminada exitosamente"})
+}
+
+// GetTaxClasses lista las clases fiscales asignadas a cada categoría
+func (h *AdminHandler) GetTaxClasses(c *gin.Context) {
+	classes, err := db.GetCategoryTaxClasses(h.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo clases fiscales: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"categories": classes})
+}
+
+// UpdateCategoryTaxClass asigna la clase fiscal de una categoría
+func (h *AdminHandler) UpdateCategoryTaxClass(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de categoría inválido"})
+		return
+	}
+
+	var req struct {
+		TaxClass string `json:"tax_class" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	if err := db.UpdateCategoryTaxClass(h.DB, categoryID, strings.TrimSpace(req.TaxClass)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando clase fiscal: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Clase fiscal actualizada", "category_id": categoryID, "tax_class": req.TaxClass})
+}
+
+// SetUserTaxExempt marca o desmarca a un cliente como exento de impuestos
+func (h *AdminHandler) SetUserTaxExempt(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+
+	var req struct {
+		TaxExempt bool `json:"tax_exempt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	if err := db.SetUserTaxExempt(h.DB, userID, req.TaxExempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando exención fiscal: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Exención fiscal actualizada", "user_id": userID, "tax_exempt": req.TaxExempt})
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/cfdi"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// BillingProfileHandler maneja los perfiles fiscales usados para emitir CFDI
+type BillingProfileHandler struct {
+	DB *pgxpool.Pool
+}
+
+// NewBillingProfileHandler crea una nueva instancia del handler de perfiles fiscales
+func NewBillingProfileHandler(db *pgxpool.Pool) *BillingProfileHandler {
+	return &BillingProfileHandler{DB: db}
+}
+
+// BillingProfileRequest es el body para crear o actualizar un perfil fiscal
+type BillingProfileRequest struct {
+	RFC           string `json:"rfc" binding:"required"`
+	RazonSocial   string `json:"razon_social" binding:"required"`
+	RegimenFiscal string `json:"regimen_fiscal" binding:"required"`
+	UsoCFDI       string `json:"uso_cfdi" binding:"required"`
+	CodigoPostal  string `json:"codigo_postal" binding:"required"`
+	Email         string `json:"email"`
+	IsDefault     bool   `json:"is_default"`
+}
+
+// profile normaliza los datos del request como los pide el SAT
+func (r *BillingProfileRequest) profile(userID int) *models.BillingProfile {
+	return &models.BillingProfile{
+		UserID:        userID,
+		RFC:           cfdi.NormalizeRFC(r.RFC),
+		RazonSocial:   cfdi.NormalizeRazonSocial(r.RazonSocial),
+		RegimenFiscal: strings.TrimSpace(r.RegimenFiscal),
+		UsoCFDI:       strings.ToUpper(strings.TrimSpace(r.UsoCFDI)),
+		CodigoPostal:  strings.TrimSpace(r.CodigoPostal),
+		Email:         strings.TrimSpace(r.Email),
+		IsDefault:     r.IsDefault,
+	}
+}
+
+// GetBillingProfiles obtiene los perfiles fiscales del usuario
+func (h *BillingProfileHandler) GetBillingProfiles(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	profiles, err := db.GetUserBillingProfiles(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo perfiles fiscales: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"billing_profiles": profiles})
+}
+
+// CreateBillingProfile crea un perfil fiscal validado contra los catálogos del SAT
+func (h *BillingProfileHandler) CreateBillingProfile(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	var req BillingProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	profile := req.profile(userID)
+	if err := cfdi.ValidateProfile(profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.CreateBillingProfile(h.DB, profile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando perfil fiscal: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"billing_profile": profile})
+}
+
+// UpdateBillingProfile actualiza un perfil fiscal del usuario
+func (h *BillingProfileHandler) UpdateBillingProfile(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	profileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de perfil fiscal inválido"})
+		return
+	}
+
+	var req BillingProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	profile := req.profile(userID)
+	profile.ID = profileID
+	if err := cfdi.ValidateProfile(profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.UpdateBillingProfile(h.DB, profile); err != nil {
+		if err.Error() == "perfil fiscal no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Perfil fiscal no encontrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando perfil fiscal: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"billing_profile": profile})
+}
+
+// DeleteBillingProfile elimina un perfil fiscal que no se haya usado para facturar
+func (h *BillingProfileHandler) DeleteBillingProfile(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	profileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de perfil fiscal inválido"})
+		return
+	}
+
+	if err := db.DeleteBillingProfile(h.DB, userID, profileID); err != nil {
+		if err.Error() == "perfil fiscal no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Perfil fiscal no encontrado"})
+			return
+		}
+		if strings.Contains(err.Error(), "ya se usó") {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando perfil fiscal: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Perfil fiscal eliminado"})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+)
+
+// cartRecoveryWindowFromEnv lee CART_RECOVERY_ATTRIBUTION: cuánto tiempo después de un
+// recordatorio se le atribuye el pedido (7 días por defecto)
+func cartRecoveryWindowFromEnv() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("CART_RECOVERY_ATTRIBUTION"))); err == nil && d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// RestoreCart procesa el enlace de un recordatorio de carrito abandonado: registra la apertura,
+// aplica el cupón del recordatorio si lo hay y responde con el carrito como GetCart. El carrito es
+// del usuario, así que si no inició sesión con esa cuenta se le pide hacerlo.
+func (h *Handler) RestoreCart(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	recoveryID, userID, err := auth.ParseCartRestoreToken(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El enlace para recuperar el carrito no es válido o expiró"})
+		return
+	}
+	click, err := db.MarkCartRecoveryClicked(h.DB, recoveryID)
+	if err != nil {
+		if err.Error() == "recordatorio no encontrado" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El enlace para recuperar el carrito no es válido o expiró"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recuperando carrito: " + err.Error()})
+		return
+	}
+
+	if c.GetInt("user_id") != userID {
+		c.JSON(http.StatusOK, gin.H{
+			"login_required": true,
+			"message":        "Inicia sesión para recuperar tu carrito",
+		})
+		return
+	}
+
+	if click.CouponID != nil {
+		if err := db.AddCartCoupon(h.DB, click.CartID, *click.CouponID); err != nil {
+			log.Printf("Error aplicando cupón de recuperación al carrito %d: %v", click.CartID, err)
+		}
+	}
+	h.GetCart(c)
+}
+
+// GetCartRecoveryReport resume los recordatorios de carrito abandonado enviados entre ?from= y
+// ?to= (YYYY-MM-DD, últimos 30 días por defecto) y los pedidos que recuperaron
+func (h *AdminHandler) GetCartRecoveryReport(c *gin.Context) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'from' inválida (YYYY-MM-DD)"})
+			return
+		}
+		from = parsed
+	}
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'to' inválida (YYYY-MM-DD)"})
+			return
+		}
+		to = parsed.AddDate(0, 0, 1) // Incluye el día completo
+	}
+
+	report, err := db.GetCartRecoveryReport(h.DB, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando reporte: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from,
+		"to":     to,
+		"report": report,
+	})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// RequestOrderCFDI emite el CFDI de un pedido pagado con el perfil fiscal indicado o el predeterminado
+func (h *OrderHandler) RequestOrderCFDI(c *gin.Context) {
+	order, ok := h.ownedOrder(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		BillingProfileID int `json:"billing_profile_id"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	var profile *models.BillingProfile
+	var err error
+	if req.BillingProfileID > 0 {
+		profile, err = db.GetBillingProfileByID(h.DB, req.BillingProfileID)
+		if err == nil && profile.UserID != order.UserID {
+			err = fmt.Errorf("perfil fiscal no encontrado")
+		}
+	} else {
+		profile, err = db.GetDefaultBillingProfile(h.DB, order.UserID)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Registra un perfil fiscal para solicitar tu factura"})
+		return
+	}
+
+	doc, err := h.CFDI.Issue(c.Request.Context(), order, profile)
+	if err != nil {
+		if doc == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "No se pudo timbrar la factura, intenta más tarde", "cfdi": doc})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": doc})
+}
+
+// GetOrderCFDIs obtiene los CFDI emitidos para un pedido del usuario
+func (h *OrderHandler) GetOrderCFDIs(c *gin.Context) {
+	order, ok := h.ownedOrder(c)
+	if !ok {
+		return
+	}
+	docs, err := db.GetOrderCFDIs(h.DB, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo facturas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cfdi": docs})
+}
+
+// GetOrderCFDIXML descarga el XML timbrado de un CFDI del pedido
+func (h *OrderHandler) GetOrderCFDIXML(c *gin.Context) {
+	order, ok := h.ownedOrder(c)
+	if !ok {
+		return
+	}
+	cfdiID, err := strconv.Atoi(c.Param("cfdiID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de factura inválido"})
+		return
+	}
+	doc, err := db.GetCFDIByID(h.DB, cfdiID)
+	if err != nil || doc.OrderID != order.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Factura no encontrada"})
+		return
+	}
+	writeCFDIXML(c, h.DB, doc)
+}
+
+// ownedOrder obtiene el pedido de la URL y verifica que pertenece al usuario autenticado
+func (h *OrderHandler) ownedOrder(c *gin.Context) (*models.Order, bool) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return nil, false
+	}
+	orderID, err := resolveOrderID(h.DB, c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return nil, false
+	}
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return nil, false
+	}
+	if order.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permisos para ver este pedido"})
+		return nil, false
+	}
+	return order, true
+}
+
+// writeCFDIXML envía el XML timbrado guardado en la base de datos
+func writeCFDIXML(c *gin.Context, pool *pgxpool.Pool, doc *models.CFDIDocument) {
+	data, err := db.GetCFDIStampedXML(pool, doc.ID)
+	if err != nil {
+		log.Printf("Error obteniendo XML del CFDI %d: %v", doc.ID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "La factura no está timbrada"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%d-%s.xml"`, doc.Series, doc.Folio, doc.UUID))
+	c.Data(http.StatusOK, "application/xml", data)
+}
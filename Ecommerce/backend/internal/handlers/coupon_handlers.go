@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ApplyCartCoupon aplica un código promocional al carrito y devuelve el descuento resultante
+func (h *Handler) ApplyCartCoupon(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	cp, err := db.GetCouponByCode(h.DB, req.Code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El cupón no existe"})
+		return
+	}
+	// Los usos por cliente solo se pueden contar con una cuenta
+	if userID == 0 && cp.PerCustomerLimit != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Inicia sesión para usar este cupón"})
+		return
+	}
+
+	cartID, err := h.cartForRequest(c, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo carrito"})
+		return
+	}
+	var items []models.CartItem
+	if cartID != 0 {
+		items, err = db.GetCartContents(h.DB, cartID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo items del carrito"})
+			return
+		}
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
+		return
+	}
+	lines, err := h.Pricing.CartItems(items)
+	if err != nil {
+		log.Printf("Error preparando líneas del carrito %d: %v", cartID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo productos del carrito"})
+		return
+	}
+
+	applied, err := db.GetCartCoupons(h.DB, cartID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo cupones del carrito"})
+		return
+	}
+	for _, other := range applied {
+		if other.ID == cp.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "El cupón ya está aplicado al carrito"})
+			return
+		}
+	}
+
+	// El cupón nuevo se evalúa después de los que ya estaban, así las reglas de combinación lo rechazan a él
+	quote, err := h.Pricing.Price(lines, append(applied, *cp), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error validando cupón: " + err.Error()})
+		return
+	}
+	for _, r := range quote.RejectedCoupons {
+		if r.Code == cp.Code {
+			c.JSON(http.StatusBadRequest, gin.H{"error": r.Reason})
+			return
+		}
+	}
+
+	if err := db.AddCartCoupon(h.DB, cartID, cp.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error aplicando cupón"})
+		return
+	}
+	removeRejectedCoupons(h.DB, cartID, applied, quote.RejectedCoupons)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cupón aplicado",
+		"pricing": quote,
+	})
+}
+
+// RemoveCartCoupon quita del carrito el cupón indicado en ?code=; sin código quita todos
+func (h *Handler) RemoveCartCoupon(c *gin.Context) {
+	cartID, err := h.cartForRequest(c, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo carrito"})
+		return
+	}
+	if cartID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El cupón no está aplicado al carrito"})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		if err := db.ClearCartCoupons(h.DB, cartID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error quitando cupones"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Cupones eliminados del carrito"})
+		return
+	}
+
+	cp, err := db.GetCouponByCode(h.DB, code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El cupón no está aplicado al carrito"})
+		return
+	}
+	if err := db.RemoveCartCoupon(h.DB, cartID, cp.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cupón eliminado del carrito"})
+}
+
+// removeRejectedCoupons quita del carrito los cupones que dejaron de ser válidos
+func removeRejectedCoupons(pool *pgxpool.Pool, cartID int, applied []models.Coupon, rejected []coupon.Rejected) {
+	for _, r := range rejected {
+		for _, cp := range applied {
+			if cp.Code != r.Code {
+				continue
+			}
+			if err := db.RemoveCartCoupon(pool, cartID, cp.ID); err != nil {
+				log.Printf("Error quitando cupón %s del carrito %d: %v", cp.Code, cartID, err)
+			}
+		}
+	}
+}
@@ -1,14 +1,17 @@
 package handlers
 
 import (
-	"context"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
 )
 
+// FavoritesHandler maneja los favoritos y las listas de deseos del usuario. Los favoritos son la
+// lista de deseos de tipo favorites.
 type FavoritesHandler struct {
 	DB *pgxpool.Pool
 }
@@ -31,7 +34,14 @@ func (h *FavoritesHandler) AddFavorite(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := h.DB.Exec(context.Background(), "INSERT INTO favorites (user_id, product_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (user_id, product_id) DO NOTHING", userID, req.ProductID, time.Now())
+	if _, err := db.GetProductByID(h.DB, req.ProductID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+		return
+	}
+	wishlistID, err := db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindFavorites)
+	if err == nil {
+		err = db.AddWishlistItem(h.DB, wishlistID, req.ProductID, 1, "")
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al añadir a favoritos"})
 		return
@@ -46,34 +56,38 @@ func (h *FavoritesHandler) RemoveFavorite(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
 		return
 	}
-	productID := c.Param("product_id")
-	_, err := h.DB.Exec(context.Background(), "DELETE FROM favorites WHERE user_id = $1 AND product_id = $2", userID, productID)
+	productID, err := strconv.Atoi(c.Param("product_id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	wishlistID, err := db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindFavorites)
+	if err == nil {
+		err = db.RemoveWishlistItem(h.DB, wishlistID, productID)
+	}
+	if err != nil && err.Error() != "item no encontrado" {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al quitar de favoritos"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Producto quitado de favoritos"})
 }
 
-// Listar favoritos del usuario
+// Listar favoritos del usuario con los datos actuales de cada producto (precio y stock)
 func (h *FavoritesHandler) ListFavorites(c *gin.Context) {
 	userID := c.GetInt("user_id")
 	if userID == 0 {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
 		return
 	}
-	rows, err := h.DB.Query(context.Background(), `SELECT product_id FROM favorites WHERE user_id = $1`, userID)
+	wishlistID, err := db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindFavorites)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener favoritos"})
 		return
 	}
-	defer rows.Close()
-	var productIDs []int
-	for rows.Next() {
-		var pid int
-		if err := rows.Scan(&pid); err == nil {
-			productIDs = append(productIDs, pid)
-		}
+	items, err := db.GetWishlistItems(h.DB, wishlistID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener favoritos"})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"favorites": productIDs})
+	c.JSON(http.StatusOK, gin.H{"favorites": items})
 }
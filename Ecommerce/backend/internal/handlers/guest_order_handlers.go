@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// GuestOrderRequest representa el checkout de un invitado: los datos del pedido más su email
+type GuestOrderRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	CreateOrderRequest
+}
+
+// CreateGuestOrder crea un pedido desde el carrito de invitado (cabecera X-Cart-Token). El pedido
+// queda ligado al email y se responde con el token de acceso para pagarlo; el mismo enlace se
+// envía por email para consultar el pedido más tarde.
+func (h *OrderHandler) CreateGuestOrder(c *gin.Context) {
+	var req GuestOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	guestEmail := strings.ToLower(strings.TrimSpace(req.Email))
+
+	guestID, err := auth.ParseCartToken(c.GetHeader(auth.CartTokenHeader))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
+		return
+	}
+	cartID, err := db.FindGuestCart(h.DB, guestID, h.GuestCartTTL)
+	if err != nil {
+		if err.Error() == "carrito no encontrado" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
+			return
+		}
+		log.Printf("Error obteniendo carrito de invitado: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo carrito"})
+		return
+	}
+
+	order, ok := h.placeOrder(c, cartID, 0, guestEmail, req.CreateOrderRequest)
+	if !ok {
+		return
+	}
+
+	token, err := auth.GenerateOrderAccessToken(order.ID, guestEmail, h.OrderLinkTTL)
+	if err != nil {
+		log.Printf("Error generando token de acceso al pedido %d: %v", order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando acceso al pedido"})
+		return
+	}
+	go h.sendGuestOrderLink(order, token)
+
+	log.Printf("Pedido de invitado %d creado exitosamente", order.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"order":        createdOrderJSON(order),
+		"access_token": token,
+		"message":      "Pedido creado exitosamente",
+	})
+}
+
+// GetGuestOrder muestra el pedido a quien tiene el enlace mágico (token en ?token= o X-Order-Token)
+func (h *OrderHandler) GetGuestOrder(c *gin.Context) {
+	orderID, err := resolveOrderID(h.DB, c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+
+	if !canAccessOrder(c, order) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El enlace del pedido no es válido o expiró"})
+		return
+	}
+
+	h.respondOrderDetails(c, order)
+}
+
+// LookupGuestOrder reenvía el enlace del pedido al email con el que se hizo. Responde siempre lo
+// mismo para no revelar qué pedidos existen.
+func (h *OrderHandler) LookupGuestOrder(c *gin.Context) {
+	var req struct {
+		Email       string `json:"email" binding:"required,email"`
+		OrderNumber string `json:"order_number" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	// Rate limiting por IP para que no se use para enviar emails ni adivinar números de pedido
+	rateLimitKey := c.ClientIP() + ":order-lookup"
+	if !auth.OrderLookupLimiter.IsAllowed(rateLimitKey) {
+		resetTime := auth.OrderLookupLimiter.GetResetTime(rateLimitKey)
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Demasiados intentos. Intenta de nuevo más tarde.",
+			"retry_after": resetTime.Format(time.RFC3339),
+		})
+		return
+	}
+
+	response := gin.H{"message": "Si el pedido existe, te enviamos un enlace para consultarlo"}
+
+	orderID, err := db.FindGuestOrderID(h.DB, req.Email, req.OrderNumber)
+	if err != nil {
+		if err.Error() != "pedido no encontrado" {
+			log.Printf("Error buscando pedido de invitado: %v", err)
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil {
+		log.Printf("Error obteniendo pedido de invitado %d: %v", orderID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	token, err := auth.GenerateOrderAccessToken(order.ID, order.GuestEmail, h.OrderLinkTTL)
+	if err != nil {
+		log.Printf("Error generando token de acceso al pedido %d: %v", order.ID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	go h.sendGuestOrderLink(order, token)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// sendGuestOrderLink envía al invitado el enlace mágico de su pedido
+func (h *OrderHandler) sendGuestOrderLink(order *models.Order, token string) {
+	if h.EmailService == nil {
+		log.Printf("Servicio de email no configurado, no se envió el enlace del pedido %d", order.ID)
+		return
+	}
+	link := auth.GuestOrderURL(order.ID, token)
+	if err := h.EmailService.SendGuestOrderLink(order.GuestEmail, order.OrderNumber, link); err != nil {
+		log.Printf("Error enviando enlace del pedido %d: %v", order.ID, err)
+	}
+}
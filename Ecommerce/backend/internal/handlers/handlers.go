@@ -5,22 +5,27 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
 	"github.com/tuusuario/ecommerce-backend/internal/db"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/pricing"
 )
 
 type Handler struct {
-	DB *pgxpool.Pool
+	DB           *pgxpool.Pool
+	Pricing      *pricing.Engine
+	GuestCartTTL time.Duration // Tiempo sin actividad tras el que vence un carrito de invitado
 }
 
 func NewHandler(db *pgxpool.Pool) *Handler {
-	return &Handler{DB: db}
+	return &Handler{DB: db, Pricing: pricing.NewEngine(db), GuestCartTTL: guestCartTTLFromEnv()}
 }
 
 // ---------------------------
@@ -51,23 +56,23 @@ func (h *Handler) CreateProduct(c *gin.Context) {
 	c.JSON(http.StatusCreated, p)
 }
 
-// Obtener productos
+// Obtener productos. Filtros: search, category_id o categories=1,2 (incluyen subcategorías),
+// min_price, max_price, in_stock=true, min_rating, attr[Nombre]=valor1,valor2 y featured=true. La
+// respuesta incluye los conteos de cada filtro (facets) salvo con facets=false.
 func (h *Handler) GetProducts(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "12")) // Default a 12 para que se vea bien en grillas de 3 o 4
-	categoryID, _ := strconv.Atoi(c.DefaultQuery("category_id", "0"))
-	sortBy := c.DefaultQuery("sort_by", "created_at")
+	// created_at, price, name o relevance (por defecto al buscar)
+	sortBy := c.Query("sort_by")
 	order := c.DefaultQuery("order", "desc")
-	search := c.DefaultQuery("search", "")
 
-	// Filtrar por productos destacados
-	featuredParam := c.Query("featured")
-	var featuredOnly bool
-	if featuredParam == "true" {
-		featuredOnly = true
+	filter, err := productFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	products, total, err := db.GetPublicProducts(h.DB, page, limit, categoryID, sortBy, order, search, featuredOnly)
+	products, total, err := db.GetPublicProducts(h.DB, page, limit, filter, sortBy, order)
 	if err != nil {
 		log.Printf("Error al obtener productos: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos"})
@@ -77,12 +82,83 @@ func (h *Handler) GetProducts(c *gin.Context) {
 	if products == nil {
 		products = []models.Product{}
 	}
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"products": products,
 		"total":    total,
 		"page":     page,
 		"limit":    limit,
-	})
+	}
+	if c.Query("facets") != "false" {
+		facets, err := db.GetCatalogFacets(h.DB, filter)
+		if err != nil {
+			log.Printf("Error al obtener filtros del catálogo: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos"})
+			return
+		}
+		response["facets"] = facets
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// productFilterFromQuery lee los filtros del catálogo de la query
+func productFilterFromQuery(c *gin.Context) (db.ProductFilter, error) {
+	filter := db.ProductFilter{
+		Search:     c.Query("search"),
+		InStock:    c.Query("in_stock") == "true",
+		Featured:   c.Query("featured") == "true",
+		Attributes: map[string][]string{},
+	}
+
+	categories := c.Query("categories")
+	if id := c.Query("category_id"); id != "" && id != "0" {
+		categories += "," + id
+	}
+	for _, part := range strings.Split(categories, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("categoría inválida: %s", part)
+		}
+		filter.CategoryIDs = append(filter.CategoryIDs, id)
+	}
+
+	if v := c.Query("min_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price < 0 {
+			return filter, fmt.Errorf("min_price inválido")
+		}
+		filter.MinPrice = &price
+	}
+	if v := c.Query("max_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price < 0 {
+			return filter, fmt.Errorf("max_price inválido")
+		}
+		filter.MaxPrice = &price
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return filter, fmt.Errorf("min_price no puede ser mayor que max_price")
+	}
+
+	if v := c.Query("min_rating"); v != "" {
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil || rating < 1 || rating > 5 {
+			return filter, fmt.Errorf("min_rating debe estar entre 1 y 5")
+		}
+		filter.MinRating = rating
+	}
+
+	for name, values := range c.QueryMap("attr") {
+		name = strings.TrimSpace(name)
+		for _, value := range strings.Split(values, ",") {
+			if value = strings.TrimSpace(value); value != "" && name != "" {
+				filter.Attributes[name] = append(filter.Attributes[name], value)
+			}
+		}
+	}
+	return filter, nil
 }
 
 // GetProduct obtiene un producto por su ID.
@@ -109,6 +185,27 @@ func (h *Handler) GetProduct(c *gin.Context) {
 		return
 	}
 
+	if product.Attributes, err = db.GetProductAttributes(h.DB, productID); err != nil {
+		log.Printf("Error obteniendo atributos del producto %d: %v", productID, err)
+	}
+	if product.CategoryID != nil {
+		if product.Breadcrumbs, err = db.GetCategoryBreadcrumbs(h.DB, *product.CategoryID); err != nil {
+			log.Printf("Error obteniendo ruta de categorías del producto %d: %v", productID, err)
+		}
+	}
+	if product.Media, err = db.GetProductMedia(h.DB, productID); err != nil {
+		log.Printf("Error obteniendo media del producto %d: %v", productID, err)
+	}
+	// Opciones y variantes activas con su disponibilidad para el selector de la ficha
+	if product.Variants, err = db.GetProductVariants(h.DB, productID, true); err != nil {
+		log.Printf("Error obteniendo variantes del producto %d: %v", productID, err)
+	}
+	if len(product.Variants) > 0 {
+		if product.Options, err = db.GetProductOptions(h.DB, productID); err != nil {
+			log.Printf("Error obteniendo opciones del producto %d: %v", productID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, product)
 }
 
@@ -183,7 +280,7 @@ func (h *Handler) CreateCategory(c *gin.Context) {
 // Obtener categorías
 func (h *Handler) GetCategories(c *gin.Context) {
 	rows, err := h.DB.Query(context.Background(),
-		`SELECT id, name, created_at FROM categories`)
+		`SELECT id, name, parent_id, created_at FROM categories WHERE is_active ORDER BY name`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener categorías"})
 		return
@@ -193,45 +290,35 @@ func (h *Handler) GetCategories(c *gin.Context) {
 	var cats []models.Category
 	for rows.Next() {
 		var cat models.Category // ⚠️ NO uses `c` aquí
-		err := rows.Scan(&cat.ID, &cat.Name, &cat.CreatedAt)
+		err := rows.Scan(&cat.ID, &cat.Name, &cat.ParentID, &cat.CreatedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error leyendo categorías"})
 			return
 		}
+		cat.IsActive = true
 		cats = append(cats, cat)
 	}
 
 	c.JSON(http.StatusOK, cats)
 }
 
-// Obtener categorías con conteo de productos activos
-func (h *Handler) GetCategoriesWithProductCount(c *gin.Context) {
-	rows, err := h.DB.Query(c, `
-		SELECT c.id, c.name, COUNT(p.id) as product_count
-		FROM categories c
-		LEFT JOIN products p ON p.category_id = c.id AND p.is_active = true
-		GROUP BY c.id, c.name
-		ORDER BY c.name ASC
-	`)
+// GetCategoryTree obtiene las categorías activas anidadas, con el conteo de productos de cada
+// categoría y sus subcategorías
+func (h *Handler) GetCategoryTree(c *gin.Context) {
+	tree, err := db.GetCategoryTree(h.DB)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Error obteniendo categorías"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo categorías"})
 		return
 	}
-	defer rows.Close()
+	c.JSON(http.StatusOK, gin.H{"categories": tree})
+}
 
-	var categories []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var name string
-		var count int
-		if err := rows.Scan(&id, &name, &count); err != nil {
-			continue
-		}
-		categories = append(categories, map[string]interface{}{
-			"id":    id,
-			"name":  name,
-			"count": count,
-		})
+// Obtener categorías con conteo de productos activos (incluye los de las subcategorías)
+func (h *Handler) GetCategoriesWithProductCount(c *gin.Context) {
+	categories, err := db.GetCategoriesWithProductCount(h.DB)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Error obteniendo categorías"})
+		return
 	}
 	c.JSON(200, gin.H{"categories": categories})
 }
@@ -336,64 +423,144 @@ func (h *Handler) UpdateUserProfile(c *gin.Context) {
 
 // ---- Cart Handlers ----
 
-func (h *Handler) GetCart(c *gin.Context) {
-	log.Printf("GetCart llamado - verificando user_id")
-	userID, exists := c.Get("user_id")
-	if !exists {
-		log.Printf("user_id no encontrado en contexto")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
+// guestCartTTLFromEnv lee GUEST_CART_TTL (por defecto 14 días)
+func guestCartTTLFromEnv() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("GUEST_CART_TTL"))); err == nil && d > 0 {
+		return d
 	}
+	return 14 * 24 * time.Hour
+}
 
-	log.Printf("user_id encontrado: %v", userID)
-	cartID, err := db.FindOrCreateCartByUserID(h.DB, userID.(int))
+// cartForRequest obtiene el carrito del usuario autenticado o, para invitados, el del token de la
+// cabecera X-Cart-Token. Con create se crea un carrito de invitado si no hay uno vigente y su token
+// se devuelve en la misma cabecera. Devuelve 0 si el invitado todavía no tiene carrito.
+func (h *Handler) cartForRequest(c *gin.Context, create bool) (int, error) {
+	if userID := c.GetInt("user_id"); userID != 0 {
+		return db.FindOrCreateCartByUserID(h.DB, userID)
+	}
+
+	if token := c.GetHeader(auth.CartTokenHeader); token != "" {
+		if guestID, err := auth.ParseCartToken(token); err == nil {
+			cartID, err := db.FindGuestCart(h.DB, guestID, h.GuestCartTTL)
+			if err == nil {
+				c.Header(auth.CartTokenHeader, token)
+				return cartID, nil
+			}
+			if err.Error() != "carrito no encontrado" {
+				return 0, err
+			}
+		}
+	}
+	if !create {
+		return 0, nil
+	}
+
+	guestID, err := auth.NewGuestCartID()
+	if err != nil {
+		return 0, err
+	}
+	cartID, err := db.CreateGuestCart(h.DB, guestID, h.GuestCartTTL)
+	if err != nil {
+		return 0, err
+	}
+	token, err := auth.GenerateCartToken(guestID)
+	if err != nil {
+		return 0, err
+	}
+	c.Header(auth.CartTokenHeader, token)
+	return cartID, nil
+}
+
+// cartItemInCart revisa que el item pertenezca al carrito de quien hace la solicitud
+func (h *Handler) cartItemInCart(c *gin.Context, cartItemID int) bool {
+	cartID, err := h.cartForRequest(c, false)
+	if err != nil || cartID == 0 {
+		return false
+	}
+	itemCartID, err := db.GetCartItemCartID(h.DB, cartItemID)
+	return err == nil && itemCartID == cartID
+}
+
+// GetCart devuelve el carrito calculado en el servidor con el mismo motor de precios del checkout:
+// totales por línea, descuentos, impuestos y envío estimados, total y avisos de cambios. La
+// estimación usa la dirección indicada (address_id o country/state/postal_code) o la
+// dirección predeterminada del usuario; sin dirección tax y shipping vienen en null. Los invitados
+// sin carrito reciben un carrito vacío.
+func (h *Handler) GetCart(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	cartID, err := h.cartForRequest(c, false)
 	if err != nil {
 		log.Printf("Error creando/obteniendo carrito: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get or create cart"})
 		return
 	}
 
-	log.Printf("Carrito obtenido/creado con ID: %d", cartID)
-	items, err := db.GetCartContents(h.DB, cartID)
+	address, ok := h.cartEstimateAddress(c, userID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dirección no encontrada"})
+		return
+	}
+	methodID, _ := strconv.Atoi(c.Query("shipping_method_id"))
+
+	cart, err := h.Pricing.Cart(c.Request.Context(), pricing.CartRequest{
+		CartID:           cartID,
+		UserID:           userID,
+		ShippingAddress:  address,
+		ShippingMethodID: methodID,
+	})
 	if err != nil {
-		log.Printf("Error obteniendo contenido del carrito: %v", err)
+		log.Printf("Error calculando el carrito %d: %v", cartID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cart items"})
 		return
 	}
 
-	// Transformar los datos para que coincidan con lo que espera el frontend
-	var transformedItems []gin.H
-	for _, item := range items {
-		transformedItem := gin.H{
-			"id":         item.ID,
-			"product_id": item.ProductID,
-			"quantity":   item.Quantity,
-			"created_at": item.CreatedAt,
-			"updated_at": item.UpdatedAt,
-		}
+	log.Printf("Carrito devuelto con %d items y %d avisos", len(cart.Items), len(cart.Warnings))
+	c.JSON(http.StatusOK, cart)
+}
 
-		// Agregar datos del producto si existe
-		if item.Product != nil {
-			transformedItem["product_name"] = item.Product.Name
-			transformedItem["price"] = item.Product.Price
-			transformedItem["image_url"] = item.Product.ImageURL
-		} else {
-			// Valores por defecto si no hay datos del producto
-			transformedItem["product_name"] = "Producto desconocido"
-			transformedItem["price"] = 0.0
-			transformedItem["image_url"] = ""
+// cartEstimateAddress elige la dirección para estimar impuestos y envío del carrito; devuelve
+// false si el address_id indicado no es del usuario
+func (h *Handler) cartEstimateAddress(c *gin.Context, userID int) (*models.Address, bool) {
+	if idStr := c.Query("address_id"); idStr != "" && userID != 0 {
+		addressID, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, false
+		}
+		address, err := db.GetAddressByID(h.DB, addressID)
+		if err != nil || address.UserID != userID {
+			return nil, false
 		}
+		return address, true
+	}
+	if country := c.Query("country"); country != "" {
+		return &models.Address{
+			Country:    country,
+			State:      c.Query("state"),
+			PostalCode: c.Query("postal_code"),
+		}, true
+	}
 
-		transformedItems = append(transformedItems, transformedItem)
+	if userID == 0 {
+		return nil, true
 	}
 
-	log.Printf("Carrito devuelto con %d items", len(transformedItems))
-	c.JSON(http.StatusOK, transformedItems)
+	// Las direcciones vienen con la predeterminada primero; se prefiere una de envío
+	addresses, err := db.GetUserAddresses(h.DB, userID)
+	if err != nil || len(addresses) == 0 {
+		return nil, true
+	}
+	for i := range addresses {
+		if addresses[i].Type == "shipping" {
+			return &addresses[i], true
+		}
+	}
+	return &addresses[0], true
 }
 
 type AddToCartRequest struct {
-	ProductID int `json:"product_id" binding:"required"`
-	Quantity  int `json:"quantity" binding:"required,min=1"`
+	ProductID int  `json:"product_id" binding:"required"`
+	VariantID *int `json:"variant_id"` // Obligatorio si el producto tiene variantes
+	Quantity  int  `json:"quantity" binding:"required,min=1"`
 }
 
 func (h *Handler) AddToCart(c *gin.Context) {
@@ -403,24 +570,26 @@ func (h *Handler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
-	cartID, err := db.FindOrCreateCartByUserID(h.DB, userID.(int))
+	cartID, err := h.cartForRequest(c, true)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find or create cart"})
 		return
 	}
 
-	err = db.AddItemToCart(h.DB, cartID, req.ProductID, req.Quantity)
+	err = db.AddItemToCart(h.DB, cartID, req.ProductID, req.VariantID, req.Quantity)
 	if err != nil {
 		if strings.Contains(err.Error(), "stock") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "No hay suficiente stock disponible"})
 			return
 		}
+		switch err.Error() {
+		case "el producto requiere elegir una variante":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Elige una variante del producto"})
+			return
+		case "variante no encontrada", "variante no disponible":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La variante no está disponible"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
 		return
 	}
@@ -446,6 +615,11 @@ func (h *Handler) UpdateCartItem(c *gin.Context) {
 		return
 	}
 
+	if !h.cartItemInCart(c, cartItemID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in cart"})
+		return
+	}
+
 	if err := db.UpdateCartItemQuantity(h.DB, cartItemID, req.Quantity); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
 		return
@@ -462,6 +636,11 @@ func (h *Handler) RemoveCartItem(c *gin.Context) {
 		return
 	}
 
+	if !h.cartItemInCart(c, cartItemID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in cart"})
+		return
+	}
+
 	if err := db.RemoveItemFromCart(h.DB, cartItemID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from cart"})
 		return
@@ -614,14 +793,18 @@ func (h *Handler) GetProductSuggestions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
 }
 
-// ClearCartHandler limpia todos los productos del carrito del usuario autenticado
+// ClearCartHandler limpia todos los productos del carrito del usuario o del invitado
 func (h *Handler) ClearCartHandler(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	cartID, err := h.cartForRequest(c, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error limpiando carrito"})
+		return
+	}
+	if cartID == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Carrito limpiado exitosamente"})
 		return
 	}
-	err := db.ClearCart(h.DB, userID.(int))
+	err = db.ClearCartItems(h.DB, cartID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error limpiando carrito"})
 		return
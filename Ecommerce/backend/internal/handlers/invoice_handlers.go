@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/invoice"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// GetOrderInvoice descarga la factura en PDF de un pedido pagado
+func (h *OrderHandler) GetOrderInvoice(c *gin.Context) {
+	h.orderDocument(c, invoice.TypeInvoice)
+}
+
+// GetOrderReceipt descarga el recibo de pago en PDF de un pedido pagado
+func (h *OrderHandler) GetOrderReceipt(c *gin.Context) {
+	h.orderDocument(c, invoice.TypeReceipt)
+}
+
+func (h *OrderHandler) orderDocument(c *gin.Context, kind string) {
+	order, ok := h.ownedOrder(c)
+	if !ok {
+		return
+	}
+	writeInvoicePDF(c, h.Invoices, order, kind)
+}
+
+// writeInvoicePDF emite el documento si hace falta y lo envía como PDF
+func writeInvoicePDF(c *gin.Context, svc *invoice.Service, order *models.Order, kind string) {
+	if !invoice.Invoiceable(order) {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido aún no está pagado"})
+		return
+	}
+	inv, data, err := svc.Document(order, kind)
+	if err != nil {
+		log.Printf("Error generando %s del pedido %d: %v", kind, order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando el documento"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, invoice.Filename(inv)))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
@@ -284,6 +284,8 @@ func (nh *NotificationHandlers) UpdateNotificationPreferences(c *gin.Context) {
 		"payment":   true,
 		"marketing": true,
 		"security":  true,
+		"stock":     true,
+		"price":     true,
 	}
 
 	if !validTypes[request.Type] {
@@ -10,23 +10,46 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/cfdi"
 	"github.com/tuusuario/ecommerce-backend/internal/db"
 	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/invoice"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/ordernumber"
+	"github.com/tuusuario/ecommerce-backend/internal/pricing"
 )
 
 // OrderHandler maneja todas las operaciones relacionadas con pedidos
 type OrderHandler struct {
 	DB              *pgxpool.Pool
 	NotificationSvc *email.NotificationService
+	ReturnWindow    time.Duration // Plazo para solicitar devoluciones desde la entrega
+	GuestCartTTL    time.Duration // Vigencia de los carritos de invitado
+	OrderLinkTTL    time.Duration // Vigencia de los enlaces de pedido que se envían a los invitados
+	RecoveryWindow  time.Duration // Ventana para atribuir un pedido a un recordatorio de carrito abandonado
+	EmailService    *email.EmailService
+	OrderNumbers    *ordernumber.Generator
+	Invoices        *invoice.Service
+	CFDI            *cfdi.Service
+	Pricing         *pricing.Engine
 }
 
 // NewOrderHandler crea una nueva instancia del handler de pedidos
 func NewOrderHandler(db *pgxpool.Pool) *OrderHandler {
-	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService)
+	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink)
 	return &OrderHandler{
 		DB:              db,
 		NotificationSvc: notificationSvc,
+		ReturnWindow:    returnWindowFromEnv(),
+		GuestCartTTL:    guestCartTTLFromEnv(),
+		OrderLinkTTL:    auth.OrderLinkTTLFromEnv(),
+		RecoveryWindow:  cartRecoveryWindowFromEnv(),
+		EmailService:    email.DefaultEmailService,
+		OrderNumbers:    ordernumber.FromEnv(),
+		Invoices:        invoice.NewService(db),
+		CFDI:            cfdi.NewService(db),
+		Pricing:         pricing.NewEngine(db),
 	}
 }
 
@@ -35,6 +58,8 @@ type CreateOrderRequest struct {
 	ShippingAddress models.Address `json:"shipping_address" binding:"required"`
 	BillingAddress  models.Address `json:"billing_address" binding:"required"`
 	Notes           string         `json:"notes"`
+	// ShippingMethodID es el método elegido en /api/shipping/quote; si se omite se usa el más económico
+	ShippingMethodID int `json:"shipping_method_id"`
 }
 
 // CreateOrderFromCart crea un pedido desde el carrito del usuario
@@ -70,91 +95,133 @@ func (h *OrderHandler) CreateOrderFromCart(c *gin.Context) {
 
 	log.Printf("Carrito obtenido: %d", cartID)
 
-	// Obtener los items del carrito
-	cartItems, err := db.GetCartContents(h.DB, cartID)
-	if err != nil {
-		log.Printf("Error obteniendo items del carrito: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo items del carrito"})
+	order, ok := h.placeOrder(c, cartID, userID, "", req)
+	if !ok {
 		return
 	}
 
-	log.Printf("Items del carrito obtenidos: %d items", len(cartItems))
-
-	if len(cartItems) == 0 {
-		log.Printf("Carrito vacío")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
-		return
+	// Notificación al usuario sobre el pedido creado
+	if err := h.NotificationSvc.CreateOrderNotification(context.Background(), userID, order.ID, "pending", order.OrderNumber); err != nil {
+		log.Printf("Error enviando notificación de pedido al usuario: %v", err)
 	}
 
-	// Calcular totales
-	var subtotal float64
-	var orderItems []models.OrderItem
+	log.Printf("Pedido creado exitosamente")
+	c.JSON(http.StatusCreated, gin.H{
+		"order":   createdOrderJSON(order),
+		"message": "Pedido creado exitosamente",
+	})
+}
 
-	// Validar stock antes de crear la orden
-	for _, item := range cartItems {
-		product, err := db.GetProductByID(h.DB, item.ProductID)
-		if err != nil {
-			log.Printf("Error obteniendo producto %d: %v", item.ProductID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo producto"})
-			return
-		}
-		if item.Quantity > product.Stock {
-			log.Printf("Stock insuficiente para producto %d: solicitado %d, disponible %d", item.ProductID, item.Quantity, product.Stock)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Stock insuficiente para el producto '" + product.Name + "' (disponible: " + strconv.Itoa(product.Stock) + ")"})
-			return
-		}
+// createdOrderJSON resume el pedido recién creado para la respuesta del checkout
+func createdOrderJSON(order *models.Order) gin.H {
+	return gin.H{
+		"id":             order.ID,
+		"order_number":   order.OrderNumber,
+		"discount":       order.Discount,
+		"total":          order.Total,
+		"currency":       order.Currency,
+		"status":         order.Status,
+		"payment_status": order.PaymentStatus,
+		"created_at":     order.CreatedAt,
 	}
+}
 
-	log.Printf("Calculando totales...")
-	for _, item := range cartItems {
-		log.Printf("Procesando item: ProductID=%d, Quantity=%d", item.ProductID, item.Quantity)
-
-		// Obtener el producto para el precio actual
-		product, err := db.GetProductByID(h.DB, item.ProductID)
-		if err != nil {
-			log.Printf("Error obteniendo producto %d: %v", item.ProductID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo producto"})
-			return
-		}
+// placeOrder convierte el carrito en un pedido: lo recalcula con el motor de precios, registra
+// cupones y promociones, guarda los items, descuenta stock, vacía el carrito y avisa a los
+// administradores. Los pedidos de invitado llevan userID 0 y el email del invitado. Si algo falla
+// ya respondió al cliente y devuelve false.
+func (h *OrderHandler) placeOrder(c *gin.Context, cartID, userID int, guestEmail string, req CreateOrderRequest) (*models.Order, bool) {
+	// Revisar el carrito contra el catálogo y calcular descuentos, impuestos y envío con el mismo
+	// motor de precios que GetCart, así el total coincide con el que vio el cliente
+	cart, err := h.Pricing.Cart(c.Request.Context(), pricing.CartRequest{
+		CartID:           cartID,
+		UserID:           userID,
+		ShippingAddress:  &req.ShippingAddress,
+		BillingAddress:   &req.BillingAddress,
+		ShippingMethodID: req.ShippingMethodID,
+	})
+	if err != nil {
+		log.Printf("Error calculando el carrito: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error calculando el total del pedido"})
+		return nil, false
+	}
 
-		log.Printf("Producto obtenido: %s, Precio: %.2f", product.Name, product.Price)
+	// Si cambiaron precios o stock, o algún producto dejó de venderse, el carrito ya quedó ajustado
+	// y el cliente debe revisarlo antes de volver a confirmar
+	if len(cart.Warnings) > 0 {
+		log.Printf("El carrito %d cambió antes del checkout: %d avisos", cartID, len(cart.Warnings))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Tu carrito cambió, revisa los cambios antes de confirmar el pedido",
+			"warnings": cart.Warnings,
+			"cart":     cart,
+		})
+		return nil, false
+	}
 
-		itemSubtotal := product.Price * float64(item.Quantity)
-		subtotal += itemSubtotal
+	log.Printf("Items del carrito obtenidos: %d items", len(cart.Items))
 
-		// Crear item de pedido
-		orderItem := models.OrderItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     product.Price,
-			Subtotal:  itemSubtotal,
-		}
-		orderItems = append(orderItems, orderItem)
+	if len(cart.Items) == 0 {
+		log.Printf("Carrito vacío")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
+		return nil, false
 	}
 
-	log.Printf("Subtotal calculado: %.2f", subtotal)
+	// Si algún cupón dejó de ser válido se quita del carrito y se pide al cliente que revise el nuevo total
+	if len(cart.RejectedCoupons) > 0 {
+		removeRejectedCoupons(h.DB, cartID, cart.CartCoupons, cart.RejectedCoupons)
+		c.JSON(http.StatusBadRequest, gin.H{"error": cart.RejectedCoupons[0].Reason, "rejected_coupons": cart.RejectedCoupons})
+		return nil, false
+	}
+	if cart.ShippingOption == nil {
+		log.Printf("Error cotizando envío: %s", cart.ShippingError)
+		c.JSON(http.StatusBadRequest, gin.H{"error": cart.ShippingError})
+		return nil, false
+	}
 
-	// Calcular impuestos y envío (simplificado)
-	tax := subtotal * 0.16 // 16% IVA
-	shipping := 0.0        // Envío gratuito por ahora
-	total := subtotal + tax + shipping
+	quote := cart.Quote
+	shippingOption := cart.ShippingOption
+	shippingDiscount := cart.ShippingDiscount
+	orderItems := make([]models.OrderItem, len(cart.Items))
+	for i, line := range cart.Items {
+		orderItems[i] = models.OrderItem{
+			ProductID:    line.ProductID,
+			VariantID:    line.VariantID,
+			VariantLabel: line.VariantLabel,
+			VariantSKU:   line.VariantSKU,
+			Quantity:     line.Quantity,
+			Price:        line.Price,
+			Subtotal:     line.Subtotal,
+			Discount:     line.Discount,
+			TaxAmount:    *line.Tax,
+			TaxBreakdown: line.TaxBreakdown,
+		}
+	}
 
-	log.Printf("Totales finales: Tax=%.2f, Shipping=%.2f, Total=%.2f", tax, shipping, total)
+	log.Printf("Totales finales: Subtotal=%.2f, Descuento=%.2f, Tax=%.2f, Shipping=%.2f (%s), Total=%.2f",
+		cart.Subtotal, cart.Discount, *cart.Tax, *cart.Shipping, shippingOption.Name, cart.Total)
 
-	// Generar número de pedido único
-	orderNumber := fmt.Sprintf("ORD-%d-%d", time.Now().Unix(), userID)
+	// Generar número de pedido único a partir de la secuencia
+	orderNumber, err := db.NextOrderNumber(h.DB, h.OrderNumbers)
+	if err != nil {
+		log.Printf("Error generando número de pedido: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando pedido"})
+		return nil, false
+	}
 	log.Printf("Número de pedido generado: %s", orderNumber)
 
 	// Crear el pedido
 	order := &models.Order{
 		UserID:          userID,
+		GuestEmail:      guestEmail,
 		OrderNumber:     orderNumber,
 		Status:          "pending",
-		Subtotal:        subtotal,
-		Tax:             tax,
-		Shipping:        shipping,
-		Total:           total,
-		Currency:        "USD",
+		Subtotal:        cart.Subtotal,
+		Discount:        cart.Discount,
+		Tax:             *cart.Tax,
+		Shipping:        *cart.Shipping,
+		ShippingMethod:  shippingOption.Name,
+		Total:           cart.Total,
+		Currency:        cart.Currency,
 		PaymentStatus:   "pending",
 		ShippingAddress: &req.ShippingAddress,
 		BillingAddress:  &req.BillingAddress,
@@ -169,11 +236,59 @@ func (h *OrderHandler) CreateOrderFromCart(c *gin.Context) {
 	if err != nil {
 		log.Printf("Error creando pedido: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando pedido: " + err.Error()})
-		return
+		return nil, false
 	}
 
 	log.Printf("Pedido creado con ID: %d", order.ID)
 
+	// Registrar el uso de los cupones. Los límites se revisan otra vez bajo bloqueo; si otro pedido
+	// agotó el cupón mientras tanto se cancela este pedido antes de apartar stock
+	if len(quote.Coupons) > 0 {
+		var redemptions []models.CouponRedemption
+		for _, applied := range quote.Coupons {
+			r := models.CouponRedemption{
+				CouponID:   applied.CouponID,
+				OrderID:    order.ID,
+				UserID:     userID,
+				GuestEmail: guestEmail,
+				Code:       applied.Code,
+				Discount:   applied.Discount,
+			}
+			if applied.FreeShipping {
+				r.ShippingDiscount = shippingDiscount
+				shippingDiscount = 0
+			}
+			redemptions = append(redemptions, r)
+		}
+		if err := db.RedeemCoupons(h.DB, redemptions); err != nil {
+			log.Printf("Error registrando cupones del pedido %d: %v", order.ID, err)
+			if _, cancelErr := db.CancelUnpaidOrder(h.DB, order.ID, "Cupón no disponible", "system"); cancelErr != nil {
+				log.Printf("Error cancelando pedido %d: %v", order.ID, cancelErr)
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if err := db.ClearCartCoupons(h.DB, cartID); err != nil {
+			log.Printf("Error quitando cupones del carrito: %v", err)
+		}
+	}
+
+	if len(quote.Promotions) > 0 {
+		var promotions []db.OrderPromotion
+		for _, applied := range quote.Promotions {
+			promotionID := applied.PromotionID
+			promotions = append(promotions, db.OrderPromotion{
+				PromotionID: &promotionID,
+				Name:        applied.Name,
+				Type:        applied.Type,
+				Discount:    applied.Discount,
+			})
+		}
+		if err := db.SaveOrderPromotions(h.DB, order.ID, promotions); err != nil {
+			log.Printf("Error guardando promociones del pedido %d: %v", order.ID, err)
+		}
+	}
+
 	// Guardar los items del pedido
 	log.Printf("Guardando items del pedido...")
 	for i := range orderItems {
@@ -189,6 +304,13 @@ func (h *OrderHandler) CreateOrderFromCart(c *gin.Context) {
 			log.Printf("Item %d guardado correctamente", i+1)
 		}
 
+		// Descontar stock de la variante; el del producto es la suma de sus variantes
+		if orderItems[i].VariantID != nil {
+			if err := db.DecrementVariantStock(h.DB, *orderItems[i].VariantID, orderItems[i].Quantity); err != nil {
+				log.Printf("Error descontando stock para variante %d: %v", *orderItems[i].VariantID, err)
+			}
+		}
+
 		// Descontar stock del producto
 		log.Printf("Intentando descontar stock: producto_id=%d, cantidad=%d", orderItems[i].ProductID, orderItems[i].Quantity)
 		res, err := h.DB.Exec(context.Background(), "UPDATE products SET stock = stock - $1 WHERE id = $2", orderItems[i].Quantity, orderItems[i].ProductID)
@@ -236,53 +358,43 @@ func (h *OrderHandler) CreateOrderFromCart(c *gin.Context) {
 		}
 	}
 
+	// Si el carrito había recibido recordatorios de abandono, el pedido cuenta como recuperado
+	if userID != 0 {
+		if _, err := db.AttributeCartRecovery(h.DB, cartID, order.ID, h.RecoveryWindow); err != nil {
+			log.Printf("Error atribuyendo pedido %d a recordatorios de carrito: %v", order.ID, err)
+		}
+	}
+
 	// Limpiar el carrito después de crear el pedido
 	log.Printf("Limpiando carrito...")
-	for _, item := range cartItems {
+	for _, item := range cart.Items {
 		err = db.RemoveItemFromCart(h.DB, item.ID)
 		if err != nil {
 			log.Printf("Error removiendo item del carrito: %v", err)
 		}
 	}
 
-	// Enviar notificaciones
 	log.Printf("Enviando notificaciones...")
 
-	// Notificación al usuario sobre el pedido creado
-	if err := h.NotificationSvc.CreateOrderNotification(context.Background(), userID, order.ID, "pending", order.OrderNumber); err != nil {
-		log.Printf("Error enviando notificación de pedido al usuario: %v", err)
-	}
-
-	// Obtener información del usuario para la notificación admin
-	user, err := db.GetUserByID(h.DB, userID)
-	if err != nil {
-		log.Printf("Error obteniendo información del usuario: %v", err)
-	} else {
-		// Notificación a los administradores sobre el nuevo pedido
-		userName := "Usuario"
-		if user.Nombre != nil {
-			userName = *user.Nombre
+	// Notificación a los administradores sobre el nuevo pedido
+	customerEmail, customerName := guestEmail, "Invitado"
+	if userID != 0 {
+		user, err := db.GetUserByID(h.DB, userID)
+		if err != nil {
+			log.Printf("Error obteniendo información del usuario: %v", err)
+			return order, true
 		}
-		amount := fmt.Sprintf("%.2f %s", order.Total, order.Currency)
-
-		if err := h.NotificationSvc.CreateNewOrderAdminNotification(context.Background(), order.ID, user.Email, userName, amount); err != nil {
-			log.Printf("Error enviando notificación admin: %v", err)
+		customerEmail, customerName = user.Email, "Usuario"
+		if user.Nombre != nil {
+			customerName = *user.Nombre
 		}
 	}
+	amount := fmt.Sprintf("%.2f %s", order.Total, order.Currency)
+	if err := h.NotificationSvc.CreateNewOrderAdminNotification(context.Background(), order.ID, customerEmail, customerName, amount); err != nil {
+		log.Printf("Error enviando notificación admin: %v", err)
+	}
 
-	log.Printf("Pedido creado exitosamente")
-	c.JSON(http.StatusCreated, gin.H{
-		"order": gin.H{
-			"id":             order.ID,
-			"order_number":   order.OrderNumber,
-			"total":          order.Total,
-			"currency":       order.Currency,
-			"status":         order.Status,
-			"payment_status": order.PaymentStatus,
-			"created_at":     order.CreatedAt,
-		},
-		"message": "Pedido creado exitosamente",
-	})
+	return order, true
 }
 
 // GetUserOrders obtiene todos los pedidos del usuario autenticado
@@ -315,11 +427,10 @@ func (h *OrderHandler) GetOrderDetails(c *gin.Context) {
 		return
 	}
 
-	// Obtener el ID del pedido de la URL
-	orderIDStr := c.Param("orderID")
-	orderID, err := strconv.Atoi(orderIDStr)
+	// Obtener el ID del pedido de la URL (acepta el ID o el número de pedido)
+	orderID, err := resolveOrderID(h.DB, c.Param("orderID"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
 		return
 	}
 
@@ -336,6 +447,13 @@ func (h *OrderHandler) GetOrderDetails(c *gin.Context) {
 		return
 	}
 
+	h.respondOrderDetails(c, order)
+}
+
+// respondOrderDetails responde con el pedido completo: items, último pago, envíos y promociones
+func (h *OrderHandler) respondOrderDetails(c *gin.Context, order *models.Order) {
+	orderID := order.ID
+
 	// Obtener los items del pedido
 	orderItems, err := db.GetOrderItems(h.DB, orderID)
 	if err != nil {
@@ -356,11 +474,33 @@ func (h *OrderHandler) GetOrderDetails(c *gin.Context) {
 		order.Payment = &payments[0] // Tomar el pago más reciente
 	}
 
+	// Paquetes del pedido con su contenido
+	shipments, err := db.GetOrderShipments(h.DB, orderID)
+	if err != nil {
+		log.Printf("Error obteniendo envíos del pedido: %v", err)
+	}
+
+	// Promociones aplicadas al pedido
+	promotions, err := db.GetOrderPromotions(h.DB, orderID)
+	if err != nil {
+		log.Printf("Error obteniendo promociones del pedido: %v", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"order": order,
+		"order":      order,
+		"shipments":  shipments,
+		"promotions": promotions,
 	})
 }
 
+// resolveOrderID interpreta el parámetro como ID numérico o como número de pedido (ej. AX-2026-000123-7)
+func resolveOrderID(pool *pgxpool.Pool, param string) (int, error) {
+	if orderID, err := strconv.Atoi(param); err == nil {
+		return orderID, nil
+	}
+	return db.GetOrderIDByNumber(pool, param)
+}
+
 // CancelOrder cancela un pedido (solo si está pendiente)
 func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	// Obtener el usuario autenticado
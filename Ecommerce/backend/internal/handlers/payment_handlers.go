@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +15,12 @@ import (
 	"github.com/stripe/stripe-go/v74"
 	"github.com/stripe/stripe-go/v74/customer"
 	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
 	"github.com/tuusuario/ecommerce-backend/internal/db"
 	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/invoice"
 	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
 )
 
 // PaymentHandler maneja todas las operaciones relacionadas con pagos
@@ -25,35 +28,31 @@ type PaymentHandler struct {
 	DB              *pgxpool.Pool
 	EmailService    *email.EmailService
 	NotificationSvc *email.NotificationService
+	Invoices        *invoice.Service
 }
 
 // NewPaymentHandler crea una nueva instancia del handler de pagos
 func NewPaymentHandler(db *pgxpool.Pool) *PaymentHandler {
 	// Configurar Stripe con la clave secreta
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-	if stripe.Key == "" {
-		log.Println("⚠️  STRIPE_SECRET_KEY no configurada. Usando clave de prueba.")
-		stripe.Key = "sk_test_..." // Clave de prueba - cambiar en producción
-	} else {
-		log.Println("✅ STRIPE_SECRET_KEY configurada correctamente")
-	}
+	payments.ConfigureStripe()
 
 	// Inicializar servicio de email
 	emailService := email.NewEmailService()
 
-	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService)
+	notificationSvc := email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink)
 
 	return &PaymentHandler{
 		DB:              db,
 		EmailService:    emailService,
 		NotificationSvc: notificationSvc,
+		Invoices:        invoice.NewService(db),
 	}
 }
 
 // CreatePaymentIntentRequest representa la solicitud para crear un PaymentIntent
 type CreatePaymentIntentRequest struct {
-	Amount        int64  `json:"amount" binding:"required,min=1"`         // Monto en centavos
-	Currency      string `json:"currency" binding:"required"`             // Moneda (usd, eur, etc.)
+	Amount        int64  `json:"amount"`                                  // Ignorado: el monto sale del total del pedido
+	Currency      string `json:"currency"`                                // Ignorado: se cobra en la moneda del pedido
 	OrderID       int    `json:"order_id" binding:"required"`             // ID del pedido
 	Description   string `json:"description"`                             // Descripción del pago
 	CustomerEmail string `json:"customer_email" binding:"required,email"` // Email del cliente
@@ -68,19 +67,7 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Creando PaymentIntent para pedido %d, monto: %d %s", req.OrderID, req.Amount, req.Currency)
-
-	// Obtener el usuario autenticado
-	userID := c.GetInt("user_id")
-	if userID == 0 {
-		log.Printf("Usuario no autenticado")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
-		return
-	}
-
-	log.Printf("Usuario autenticado: %d", userID)
-
-	// Verificar que el pedido existe y pertenece al usuario
+	// Verificar que el pedido existe y pertenece al usuario o al invitado con el enlace del pedido
 	order, err := db.GetOrderByID(h.DB, req.OrderID)
 	if err != nil {
 		log.Printf("Error obteniendo pedido %d: %v", req.OrderID, err)
@@ -88,8 +75,9 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
-	if order.UserID != userID {
-		log.Printf("Pedido %d no pertenece al usuario %d", req.OrderID, userID)
+	userID := order.UserID
+	if !canAccessOrder(c, order) {
+		log.Printf("Pedido %d no pertenece a quien hace la solicitud", req.OrderID)
 		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permisos para este pedido"})
 		return
 	}
@@ -101,6 +89,26 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
+	// Un pedido cancelado (por ejemplo, vencido por falta de pago) ya liberó su stock
+	if order.Status == "cancelled" {
+		log.Printf("Pedido %d está cancelado", req.OrderID)
+		c.JSON(http.StatusConflict, gin.H{"error": "Este pedido fue cancelado; realiza un nuevo pedido"})
+		return
+	}
+
+	// El monto y la moneda salen del pedido calculado por el servidor, nunca del cliente
+	amount := payments.ToCents(order.Total)
+	currency := strings.ToLower(order.Currency)
+	if amount <= 0 || currency == "" {
+		log.Printf("Pedido %d sin total o moneda válidos: %.2f %q", req.OrderID, order.Total, order.Currency)
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido no tiene un total válido para cobrar"})
+		return
+	}
+	if req.Amount != 0 && req.Amount != amount {
+		log.Printf("Pedido %d: se ignora el monto enviado por el cliente (%d), el total es %d", req.OrderID, req.Amount, amount)
+	}
+
+	log.Printf("Creando PaymentIntent para pedido %d, monto: %d %s", req.OrderID, amount, currency)
 	log.Printf("Creando cliente de Stripe para email: %s", req.CustomerEmail)
 
 	// Crear o obtener el cliente de Stripe
@@ -115,8 +123,8 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 
 	// Crear el PaymentIntent
 	params := &stripe.PaymentIntentParams{
-		Amount:      stripe.Int64(req.Amount),
-		Currency:    stripe.String(req.Currency),
+		Amount:      stripe.Int64(amount),
+		Currency:    stripe.String(currency),
 		Customer:    stripe.String(stripeCustomer.ID),
 		Description: stripe.String(req.Description),
 		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
@@ -142,8 +150,8 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 	payment := &models.Payment{
 		OrderID:               req.OrderID,
 		PaymentMethod:         "stripe",
-		Amount:                float64(req.Amount) / 100, // Convertir de centavos
-		Currency:              req.Currency,
+		Amount:                payments.FromCents(amount),
+		Currency:              currency,
 		Status:                "pending",
 		StripePaymentIntentID: pi.ID,
 		StripeCustomerID:      stripeCustomer.ID,
@@ -167,8 +175,8 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"client_secret":     pi.ClientSecret,
 		"payment_intent_id": pi.ID,
-		"amount":            req.Amount,
-		"currency":          req.Currency,
+		"amount":            amount,
+		"currency":          currency,
 		"status":            "pending",
 	})
 }
@@ -198,24 +206,28 @@ func (h *PaymentHandler) ConfirmPayment(c *gin.Context) {
 		return
 	}
 
-	// Verificar que el usuario autenticado es el propietario del pedido
-	userID := c.GetInt("user_id")
+	// Verificar que el usuario autenticado (o el invitado con el enlace) es el propietario del pedido
 	order, err := db.GetOrderByID(h.DB, payment.OrderID)
-	if err != nil || order.UserID != userID {
+	if err != nil || !canAccessOrder(c, order) {
 		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "No tienes permisos para este pago"})
 		return
 	}
 
 	// Actualizar el estado del pago según el PaymentIntent
 	var newStatus string
+	cancelledCharge := false
 	switch pi.Status {
 	case stripe.PaymentIntentStatusSucceeded:
 		newStatus = "succeeded"
-		// Actualizar el pedido como pagado
-		err = db.UpdateOrderPaymentStatus(h.DB, payment.OrderID, "paid")
+		// Actualizar el pedido como pagado, salvo que ya se haya cancelado por falta de pago
+		paid, err := db.MarkOrderPaid(h.DB, payment.OrderID)
 		if err != nil {
 			log.Printf("Error actualizando estado del pedido: %v", err)
 		}
+		if err == nil && !paid {
+			cancelledCharge = true
+			break
+		}
 
 		// Enviar emails de confirmación inmediatamente
 		go h.sendConfirmationEmails(payment.OrderID)
@@ -246,6 +258,12 @@ func (h *PaymentHandler) ConfirmPayment(c *gin.Context) {
 		return
 	}
 
+	if cancelledCharge {
+		go h.flagCancelledOrderCharge(order, pi)
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "El pedido se canceló por falta de pago; revisaremos el cobro para reembolsarlo"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":           true,
 		"status":            newStatus,
@@ -270,10 +288,9 @@ func (h *PaymentHandler) GetPaymentStatus(c *gin.Context) {
 		return
 	}
 
-	// Verificar que el usuario autenticado es el propietario
-	userID := c.GetInt("user_id")
+	// Verificar que el usuario autenticado (o el invitado con el enlace) es el propietario
 	order, err := db.GetOrderByID(h.DB, payment.OrderID)
-	if err != nil || order.UserID != userID {
+	if err != nil || !canAccessOrder(c, order) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permisos para ver este pago"})
 		return
 	}
@@ -287,6 +304,36 @@ func (h *PaymentHandler) GetPaymentStatus(c *gin.Context) {
 	})
 }
 
+// canAccessOrder indica si quien hace la solicitud puede ver y pagar el pedido: el usuario dueño
+// o un invitado con el token de acceso del enlace del pedido (cabecera X-Order-Token o ?token=).
+// El token solo vale mientras el pedido sea de invitado; si después se ligó a una cuenta, un
+// enlace filtrado ya no da acceso.
+func canAccessOrder(c *gin.Context, order *models.Order) bool {
+	if userID := c.GetInt("user_id"); userID != 0 && order.UserID == userID {
+		return true
+	}
+	if order.UserID != 0 {
+		return false
+	}
+	token := c.GetHeader(auth.OrderTokenHeader)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token == "" {
+		return false
+	}
+	orderID, err := auth.ParseOrderAccessToken(token)
+	return err == nil && orderID == order.ID
+}
+
+// orderCustomer devuelve el usuario del pedido o, en los pedidos de invitado, uno con solo su email
+func orderCustomer(pool *pgxpool.Pool, order *models.Order) (*models.User, error) {
+	if order.UserID == 0 {
+		return &models.User{Email: order.GuestEmail}, nil
+	}
+	return db.GetUserByID(pool, order.UserID)
+}
+
 // getOrCreateStripeCustomer obtiene o crea un cliente de Stripe
 func (h *PaymentHandler) getOrCreateStripeCustomer(email string, userID int) (*stripe.Customer, error) {
 	// Buscar cliente existente por email
@@ -377,10 +424,18 @@ func (h *PaymentHandler) handlePaymentSucceeded(pi *stripe.PaymentIntent) {
 		return
 	}
 
-	// Actualizar el pedido como pagado
-	err = db.UpdateOrderPaymentStatus(h.DB, payment.OrderID, "paid")
+	// Actualizar el pedido como pagado, salvo que ya se haya cancelado por falta de pago
+	paid, err := db.MarkOrderPaid(h.DB, payment.OrderID)
 	if err != nil {
 		log.Printf("Error actualizando estado del pedido: %v", err)
+	} else if !paid {
+		order, err := db.GetOrderByID(h.DB, payment.OrderID)
+		if err != nil {
+			log.Printf("Error obteniendo pedido cancelado %d: %v", payment.OrderID, err)
+			return
+		}
+		h.flagCancelledOrderCharge(order, pi)
+		return
 	}
 
 	log.Printf("Pago exitoso para PaymentIntent %s, OrderID %d", pi.ID, payment.OrderID)
@@ -389,6 +444,18 @@ func (h *PaymentHandler) handlePaymentSucceeded(pi *stripe.PaymentIntent) {
 	go h.sendConfirmationEmails(payment.OrderID)
 }
 
+// flagCancelledOrderCharge avisa a los admins de un cobro que llegó tarde a un pedido ya cancelado,
+// igual que la conciliación: el stock se repuso, así que el pedido no se marca como pagado y hay que
+// decidir si reembolsar o reactivarlo
+func (h *PaymentHandler) flagCancelledOrderCharge(order *models.Order, pi *stripe.PaymentIntent) {
+	log.Printf("⚠️  PaymentIntent %s cobrado para el pedido cancelado %d: se envía a revisión", pi.ID, order.ID)
+	details := fmt.Sprintf("El pedido #%s está cancelado y su stock fue liberado, pero el PaymentIntent %s se cobró (%.2f %s). Revisa si reembolsar o reactivar el pedido.",
+		order.OrderNumber, pi.ID, payments.FromCents(pi.Amount), order.Currency)
+	if err := h.NotificationSvc.CreateAdminNotification(context.Background(), "Cobro de pedido cancelado", details, "urgent"); err != nil {
+		log.Printf("Error notificando cobro del pedido cancelado %d: %v", order.ID, err)
+	}
+}
+
 // sendConfirmationEmails envía los emails de confirmación
 func (h *PaymentHandler) sendConfirmationEmails(orderID int) {
 	// Obtener el pedido con items y usuario
@@ -398,8 +465,8 @@ func (h *PaymentHandler) sendConfirmationEmails(orderID int) {
 		return
 	}
 
-	// Obtener el usuario
-	user, err := db.GetUserByID(h.DB, order.UserID)
+	// Obtener el usuario (o el email del invitado)
+	user, err := orderCustomer(h.DB, order)
 	if err != nil {
 		log.Printf("Error obteniendo usuario %d para email: %v", order.UserID, err)
 		return
@@ -419,8 +486,17 @@ func (h *PaymentHandler) sendConfirmationEmails(orderID int) {
 			log.Printf("Error enviando email de confirmación de pedido: %v", err)
 		}
 
-		// Enviar email de confirmación de pago
-		err = h.EmailService.SendPaymentConfirmation(user, &payments[0])
+		// Enviar email de confirmación de pago con la factura y el recibo adjuntos
+		var attachments []email.Attachment
+		for _, kind := range []string{invoice.TypeInvoice, invoice.TypeReceipt} {
+			inv, data, err := h.Invoices.Document(order, kind)
+			if err != nil {
+				log.Printf("Error generando %s del pedido %d: %v", kind, orderID, err)
+				continue
+			}
+			attachments = append(attachments, email.Attachment{Filename: invoice.Filename(inv), Content: data})
+		}
+		err = h.EmailService.SendPaymentConfirmation(user, &payments[0], attachments...)
 		if err != nil {
 			log.Printf("Error enviando email de confirmación de pago: %v", err)
 		}
@@ -469,7 +545,7 @@ func (h *PaymentHandler) handlePaymentFailed(pi *stripe.PaymentIntent) {
 	}
 
 	// Enviar notificación a los admins sobre el pago fallido
-	user, err := db.GetUserByID(h.DB, order.UserID)
+	user, err := orderCustomer(h.DB, order)
 	if err != nil {
 		log.Printf("Error obteniendo usuario para notificación admin: %v", err)
 	} else {
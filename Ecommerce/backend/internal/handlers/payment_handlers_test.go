@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+func TestCanAccessOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token, err := auth.GenerateOrderAccessToken(10, "ana@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateOrderAccessToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		order  models.Order
+		userID int
+		token  string
+		want   bool
+	}{
+		{"dueño del pedido", models.Order{ID: 10, UserID: 5}, 5, "", true},
+		{"otro usuario", models.Order{ID: 10, UserID: 5}, 6, "", false},
+		{"invitado con su token", models.Order{ID: 10, GuestEmail: "ana@example.com"}, 0, token, true},
+		{"token de otro pedido", models.Order{ID: 11, GuestEmail: "ana@example.com"}, 0, token, false},
+		{"token de un pedido ya ligado a una cuenta", models.Order{ID: 10, UserID: 5}, 0, token, false},
+		{"otro usuario con el token de un pedido ligado", models.Order{ID: 10, UserID: 5}, 6, token, false},
+		{"invitado sin token", models.Order{ID: 10}, 0, "", false},
+		{"token inválido", models.Order{ID: 10}, 0, "x.y.z", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				c.Request.Header.Set(auth.OrderTokenHeader, tt.token)
+			}
+			if tt.userID != 0 {
+				c.Set("user_id", tt.userID)
+			}
+			if got := canAccessOrder(c, &tt.order); got != tt.want {
+				t.Errorf("canAccessOrder = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ProductAlertHandler maneja las suscripciones a avisos de vuelta de stock y bajada de precio. Los
+// productos en favoritos se avisan sin suscribirse (PRODUCT_ALERTS_FAVORITES).
+type ProductAlertHandler struct {
+	DB *pgxpool.Pool
+}
+
+func NewProductAlertHandler(db *pgxpool.Pool) *ProductAlertHandler {
+	return &ProductAlertHandler{DB: db}
+}
+
+// GetProductAlerts lista las alertas del usuario con el precio y stock actuales
+func (h *ProductAlertHandler) GetProductAlerts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	alerts, err := db.GetUserProductAlerts(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo alertas: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// CreateProductAlert suscribe al usuario a un aviso del producto. Para price_drop se puede indicar
+// el precio objetivo; sin él se avisa de bajadas a partir de PRODUCT_ALERTS_MIN_DROP_PERCENT.
+func (h *ProductAlertHandler) CreateProductAlert(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	var req struct {
+		ProductID   int      `json:"product_id" binding:"required"`
+		Kind        string   `json:"kind" binding:"required"`
+		TargetPrice *float64 `json:"target_price"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.Kind != models.ProductAlertBackInStock && req.Kind != models.ProductAlertPriceDrop {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de alerta inválido (back_in_stock o price_drop)"})
+		return
+	}
+
+	product, err := db.GetProductByID(h.DB, req.ProductID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+		return
+	}
+	if req.Kind == models.ProductAlertBackInStock {
+		if product.Stock > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El producto ya está disponible"})
+			return
+		}
+		req.TargetPrice = nil
+	}
+	if req.TargetPrice != nil && (*req.TargetPrice <= 0 || *req.TargetPrice >= product.Price) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El precio objetivo debe ser mayor que 0 y menor que el precio actual"})
+		return
+	}
+
+	alert := &models.ProductAlert{
+		UserID:       userID,
+		ProductID:    product.ID,
+		Kind:         req.Kind,
+		TargetPrice:  req.TargetPrice,
+		ProductName:  product.Name,
+		CurrentPrice: product.Price,
+		Stock:        product.Stock,
+	}
+	if err := db.UpsertProductAlert(h.DB, alert); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando alerta: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"alert": alert})
+}
+
+// DeleteProductAlert cancela una alerta del usuario
+func (h *ProductAlertHandler) DeleteProductAlert(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	alertID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de alerta inválido"})
+		return
+	}
+	if err := db.DeleteProductAlert(h.DB, userID, alertID); err != nil {
+		if err.Error() == "alerta no encontrada" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Alerta no encontrada"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando alerta: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alerta eliminada exitosamente"})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// returnReasonCodes son los motivos de devolución aceptados
+var returnReasonCodes = map[string]bool{
+	"damaged":          true, // Llegó dañado
+	"defective":        true, // No funciona
+	"wrong_item":       true, // Producto equivocado
+	"not_as_described": true, // No corresponde a la descripción
+	"size_fit":         true, // Talla o medida incorrecta
+	"no_longer_needed": true, // Ya no lo necesita
+	"other":            true,
+}
+
+// returnWindowFromEnv devuelve la ventana de devolución configurada en RETURN_WINDOW (30 días por defecto)
+func returnWindowFromEnv() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("RETURN_WINDOW"))); err == nil && d > 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// CreateReturnRequestBody representa la solicitud de devolución del cliente
+type CreateReturnRequestBody struct {
+	ReasonCode string `json:"reason_code" binding:"required"`
+	Comment    string `json:"comment"`
+	Items      []struct {
+		OrderItemID int `json:"order_item_id" binding:"required"`
+		Quantity    int `json:"quantity" binding:"required,min=1"`
+	} `json:"items" binding:"required,min=1,dive"`
+}
+
+// CreateReturn crea una solicitud de devolución para un pedido entregado
+func (h *OrderHandler) CreateReturn(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+
+	var req CreateReturnRequestBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if !returnReasonCodes[req.ReasonCode] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Motivo de devolución inválido"})
+		return
+	}
+
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil || order.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	if order.Status != "delivered" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Solo se pueden devolver pedidos entregados"})
+		return
+	}
+
+	// La ventana de devolución cuenta desde la última entrega del pedido
+	deliveredAt := order.UpdatedAt
+	if shipments, err := db.GetOrderShipments(h.DB, orderID); err == nil {
+		for _, s := range shipments {
+			if s.DeliveredAt != nil && s.DeliveredAt.After(deliveredAt) {
+				deliveredAt = *s.DeliveredAt
+			}
+		}
+	}
+	if time.Since(deliveredAt) > h.ReturnWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El plazo para devolver este pedido ya venció"})
+		return
+	}
+
+	ret := &models.ReturnRequest{
+		OrderID:    orderID,
+		UserID:     userID,
+		ReasonCode: req.ReasonCode,
+		Comment:    strings.TrimSpace(req.Comment),
+	}
+	for _, it := range req.Items {
+		ret.Items = append(ret.Items, models.ReturnItem{OrderItemID: it.OrderItemID, Quantity: it.Quantity})
+	}
+	if err := db.CreateReturnRequest(h.DB, ret); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if items, err := db.GetReturnItems(h.DB, ret.ID); err == nil {
+		ret.Items = items
+	}
+
+	if err := h.NotificationSvc.CreateReturnNotification(context.Background(), userID, orderID, order.OrderNumber, ret.RMANumber, "requested", "", ret.RefundAmount, order.Currency); err != nil {
+		log.Printf("Error notificando devolución %s: %v", ret.RMANumber, err)
+	}
+	if err := h.NotificationSvc.CreateAdminNotification(context.Background(), "Nueva devolución",
+		"Devolución "+ret.RMANumber+" solicitada para el pedido #"+order.OrderNumber+" ("+req.ReasonCode+")", "medium"); err != nil {
+		log.Printf("Error notificando devolución al administrador: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"return": ret})
+}
+
+// GetOrderReturns lista las devoluciones de un pedido del usuario
+func (h *OrderHandler) GetOrderReturns(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	orderID, err := strconv.Atoi(c.Param("orderID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+	order, err := db.GetOrderByID(h.DB, orderID)
+	if err != nil || order.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+
+	returns, err := db.GetOrderReturns(h.DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo devoluciones"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"returns": returns})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
+)
+
+// ShippingHandler maneja la cotización de envíos
+type ShippingHandler struct {
+	DB       *pgxpool.Pool
+	Rates    *shipping.RateEngine
+	Carrier  shipping.Carrier
+	Tracking *shipping.TrackingService
+}
+
+// NewShippingHandler crea una nueva instancia del handler de envíos
+func NewShippingHandler(db *pgxpool.Pool) *ShippingHandler {
+	return &ShippingHandler{
+		DB:       db,
+		Rates:    shipping.NewRateEngine(db),
+		Carrier:  shipping.CarrierFromEnv(),
+		Tracking: shipping.NewTrackingService(db),
+	}
+}
+
+// ShippingQuoteRequest representa la solicitud de cotización: una dirección guardada o una dirección completa
+type ShippingQuoteRequest struct {
+	AddressID int             `json:"address_id"`
+	Address   *models.Address `json:"address"`
+}
+
+// QuoteShipping devuelve las opciones de envío para el carrito actual y el destino
+func (h *ShippingHandler) QuoteShipping(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	var req ShippingQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+
+	address := req.Address
+	if req.AddressID != 0 {
+		saved, err := db.GetAddressByID(h.DB, req.AddressID)
+		if err != nil || saved.UserID != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dirección no encontrada"})
+			return
+		}
+		address = saved
+	}
+	if address == nil || address.Country == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Debes indicar una dirección de destino con país"})
+		return
+	}
+
+	cartID, err := db.FindOrCreateCartByUserID(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo carrito"})
+		return
+	}
+	items, err := cartShippingItems(h.DB, cartID)
+	if err != nil {
+		log.Printf("Error preparando items para cotizar envío: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo items del carrito"})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El carrito está vacío"})
+		return
+	}
+
+	options, err := h.Rates.Quote(c.Request.Context(), items, address, "USD")
+	if err != nil {
+		log.Printf("Error cotizando envío: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error cotizando envío"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"options": options})
+}
+
+// cartShippingItems arma los items de envío del carrito con peso y dimensiones de cada producto. Usa
+// el producto como se vende (con el peso y stock de la variante) y las mismas reglas que el checkout:
+// los productos no disponibles no se cotizan y la cantidad no pasa del stock. No modifica el carrito.
+func cartShippingItems(pool *pgxpool.Pool, cartID int) ([]shipping.Item, error) {
+	cartItems, err := db.GetCartContents(pool, cartID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]shipping.Item, 0, len(cartItems))
+	for _, ci := range cartItems {
+		product, _, err := db.GetCartProduct(pool, ci.ProductID, ci.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		quantity := min(ci.Quantity, product.Stock)
+		if !product.IsActive || quantity <= 0 {
+			continue
+		}
+		items = append(items, shipping.ItemFromProduct(product, quantity))
+	}
+	return items, nil
+}
+
+// CarrierWebhookPayload es la notificación de rastreo enviada por la paquetería
+type CarrierWebhookPayload struct {
+	TrackingNumber string                    `json:"tracking_number"`
+	Status         string                    `json:"status"`
+	Events         []shipping.TrackingUpdate `json:"events"`
+}
+
+// CarrierWebhook recibe actualizaciones de rastreo firmadas con HMAC-SHA256 (header X-Carrier-Signature)
+func (h *ShippingHandler) CarrierWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error leyendo body"})
+		return
+	}
+
+	secret := os.Getenv("CARRIER_WEBHOOK_SECRET")
+	if secret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhook de paquetería no configurado"})
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(c.GetHeader("X-Carrier-Signature"))) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Firma inválida"})
+		return
+	}
+
+	var payload CarrierWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.TrackingNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payload inválido"})
+		return
+	}
+
+	shipment, err := db.GetShipmentByTracking(h.DB, c.Param("carrier"), payload.TrackingNumber)
+	if err != nil {
+		// Se responde 200 para que la paquetería no reintente envíos que no son nuestros
+		log.Printf("Webhook de paquetería para envío desconocido %s/%s", c.Param("carrier"), payload.TrackingNumber)
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
+	}
+
+	info := &shipping.TrackingInfo{TrackingNumber: payload.TrackingNumber, Status: payload.Status, Events: payload.Events}
+	if err := h.Tracking.Apply(c.Request.Context(), shipment, info); err != nil {
+		log.Printf("Error aplicando webhook de rastreo %s: %v", payload.TrackingNumber, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error procesando rastreo"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// WishlistRequest representa los datos de una lista de deseos con nombre
+type WishlistRequest struct {
+	Name       string `json:"name" binding:"required"`
+	Visibility string `json:"visibility"` // private (por defecto), shared o public
+}
+
+// WishlistItemRequest representa un producto que se agrega a una lista
+type WishlistItemRequest struct {
+	ProductID int    `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity"`
+	Note      string `json:"note"`
+}
+
+// validWishlistVisibility indica si la visibilidad existe
+func validWishlistVisibility(v string) bool {
+	return v == models.WishlistPrivate || v == models.WishlistShared || v == models.WishlistPublic
+}
+
+// newWishlistShareToken genera el identificador del enlace para compartir una lista
+func newWishlistShareToken() (*string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(bytes)
+	return &token, nil
+}
+
+// wishlistShareURL arma el enlace público de solo lectura de la lista
+func wishlistShareURL(w *models.Wishlist) string {
+	if w.ShareToken == nil || w.Visibility == models.WishlistPrivate {
+		return ""
+	}
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "https://axiora.pro"
+	}
+	return frontendURL + "/wishlists/shared/" + *w.ShareToken
+}
+
+// ownWishlist obtiene la lista indicada en :id si es del usuario. Acepta "favorites" y "saved"
+// para las listas especiales, que se crean la primera vez. Si no la encuentra ya respondió.
+func (h *FavoritesHandler) ownWishlist(c *gin.Context) (*models.Wishlist, bool) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return nil, false
+	}
+
+	var wishlistID int
+	var err error
+	switch param := c.Param("id"); param {
+	case "favorites":
+		wishlistID, err = db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindFavorites)
+	case "saved":
+		wishlistID, err = db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindSaved)
+	default:
+		wishlistID, err = strconv.Atoi(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de lista inválido"})
+			return nil, false
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo lista: " + err.Error()})
+		return nil, false
+	}
+
+	w, err := db.GetWishlistByID(h.DB, wishlistID)
+	if err != nil || w.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lista no encontrada"})
+		return nil, false
+	}
+	return w, true
+}
+
+// GetWishlists lista las listas de deseos del usuario
+func (h *FavoritesHandler) GetWishlists(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	wishlists, err := db.GetUserWishlists(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo listas: " + err.Error()})
+		return
+	}
+	result := make([]gin.H, len(wishlists))
+	for i := range wishlists {
+		result[i] = gin.H{"wishlist": wishlists[i], "share_url": wishlistShareURL(&wishlists[i])}
+	}
+	c.JSON(http.StatusOK, gin.H{"wishlists": result})
+}
+
+// GetWishlist obtiene una lista del usuario con sus productos
+func (h *FavoritesHandler) GetWishlist(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	items, err := db.GetWishlistItems(h.DB, w.ID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo productos de la lista: " + err.Error()})
+		return
+	}
+	w.Items = items
+	c.JSON(http.StatusOK, gin.H{"wishlist": w, "share_url": wishlistShareURL(w)})
+}
+
+// CreateWishlist crea una lista con nombre
+func (h *FavoritesHandler) CreateWishlist(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	var req WishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	w := &models.Wishlist{
+		UserID:     userID,
+		Name:       strings.TrimSpace(req.Name),
+		Kind:       models.WishlistKindCustom,
+		Visibility: req.Visibility,
+	}
+	if w.Visibility == "" {
+		w.Visibility = models.WishlistPrivate
+	}
+	if w.Name == "" || len(w.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El nombre debe tener entre 1 y 100 caracteres"})
+		return
+	}
+	if !validWishlistVisibility(w.Visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Visibilidad inválida (private, shared o public)"})
+		return
+	}
+	if w.Visibility != models.WishlistPrivate {
+		token, err := newWishlistShareToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando enlace de la lista"})
+			return
+		}
+		w.ShareToken = token
+	}
+
+	if err := db.CreateWishlist(h.DB, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando lista: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"wishlist": w, "share_url": wishlistShareURL(w)})
+}
+
+// UpdateWishlist cambia el nombre o la visibilidad de una lista. Al compartirla por primera vez se
+// genera su enlace; las listas especiales conservan su nombre.
+func (h *FavoritesHandler) UpdateWishlist(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       *string `json:"name"`
+		Visibility *string `json:"visibility"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if w.Kind != models.WishlistKindCustom {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Esta lista no se puede renombrar"})
+			return
+		}
+		if name == "" || len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El nombre debe tener entre 1 y 100 caracteres"})
+			return
+		}
+		w.Name = name
+	}
+	if req.Visibility != nil {
+		if !validWishlistVisibility(*req.Visibility) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Visibilidad inválida (private, shared o public)"})
+			return
+		}
+		if w.Kind == models.WishlistKindSaved && *req.Visibility != models.WishlistPrivate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Los guardados para después no se pueden compartir"})
+			return
+		}
+		w.Visibility = *req.Visibility
+	}
+	if w.Visibility != models.WishlistPrivate && w.ShareToken == nil {
+		token, err := newWishlistShareToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando enlace de la lista"})
+			return
+		}
+		w.ShareToken = token
+	}
+
+	if err := db.UpdateWishlist(h.DB, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando lista: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wishlist": w, "share_url": wishlistShareURL(w)})
+}
+
+// RegenerateWishlistShareLink cambia el enlace de la lista; el anterior deja de funcionar
+func (h *FavoritesHandler) RegenerateWishlistShareLink(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	if w.Visibility == models.WishlistPrivate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La lista es privada; cámbiala a shared o public para compartirla"})
+		return
+	}
+	token, err := newWishlistShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generando enlace de la lista"})
+		return
+	}
+	w.ShareToken = token
+	if err := db.UpdateWishlist(h.DB, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error actualizando lista: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wishlist": w, "share_url": wishlistShareURL(w)})
+}
+
+// DeleteWishlist elimina una lista con nombre
+func (h *FavoritesHandler) DeleteWishlist(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	if w.Kind != models.WishlistKindCustom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Esta lista no se puede eliminar"})
+		return
+	}
+	if err := db.DeleteWishlist(h.DB, w.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error eliminando lista: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Lista eliminada exitosamente"})
+}
+
+// AddWishlistItem agrega un producto a la lista (o actualiza su cantidad y nota)
+func (h *FavoritesHandler) AddWishlistItem(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	var req WishlistItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.Quantity < 0 || len(req.Note) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La cantidad debe ser mayor que 0 y la nota de máximo 255 caracteres"})
+		return
+	}
+	if _, err := db.GetProductByID(h.DB, req.ProductID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+		return
+	}
+	if err := db.AddWishlistItem(h.DB, w.ID, req.ProductID, req.Quantity, strings.TrimSpace(req.Note)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error agregando producto: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Producto agregado a la lista"})
+}
+
+// RemoveWishlistItem quita un producto de la lista
+func (h *FavoritesHandler) RemoveWishlistItem(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	if err := db.RemoveWishlistItem(h.DB, w.ID, productID); err != nil {
+		if err.Error() == "item no encontrado" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "El producto no está en la lista"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error quitando producto: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Producto quitado de la lista"})
+}
+
+// MoveWishlistItemToCart pasa un producto de la lista al carrito del usuario, sin superar el stock
+func (h *FavoritesHandler) MoveWishlistItemToCart(c *gin.Context) {
+	w, ok := h.ownWishlist(c)
+	if !ok {
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	cartID, err := db.FindOrCreateCartByUserID(h.DB, w.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo carrito"})
+		return
+	}
+	quantity, err := db.MoveWishlistItemToCart(h.DB, w.ID, productID, cartID)
+	if err != nil {
+		switch err.Error() {
+		case "item no encontrado":
+			c.JSON(http.StatusNotFound, gin.H{"error": "El producto no está en la lista"})
+		case "producto no disponible":
+			c.JSON(http.StatusConflict, gin.H{"error": "El producto no está disponible"})
+		case "el producto requiere elegir una variante":
+			c.JSON(http.StatusConflict, gin.H{"error": "Elige una variante del producto para agregarlo al carrito"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error moviendo al carrito: " + err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Producto movido al carrito", "cart_quantity": quantity})
+}
+
+// GetSharedWishlist muestra una lista compartida o pública por su enlace, en solo lectura y sin
+// productos que ya no se venden
+func (h *FavoritesHandler) GetSharedWishlist(c *gin.Context) {
+	w, err := db.GetWishlistByShareToken(h.DB, c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lista no encontrada"})
+		return
+	}
+	h.respondPublicWishlist(c, w)
+}
+
+// GetPublicWishlist muestra una lista pública por su ID
+func (h *FavoritesHandler) GetPublicWishlist(c *gin.Context) {
+	wishlistID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de lista inválido"})
+		return
+	}
+	w, err := db.GetWishlistByID(h.DB, wishlistID)
+	if err != nil || w.Visibility != models.WishlistPublic {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lista no encontrada"})
+		return
+	}
+	h.respondPublicWishlist(c, w)
+}
+
+// respondPublicWishlist responde la lista sin datos del dueño ni su enlace
+func (h *FavoritesHandler) respondPublicWishlist(c *gin.Context, w *models.Wishlist) {
+	items, err := db.GetWishlistItems(h.DB, w.ID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error obteniendo productos de la lista"})
+		return
+	}
+	for i := range items {
+		items[i].UserID = 0
+	}
+
+	owner := ""
+	if user, err := db.GetUserByID(h.DB, w.UserID); err == nil && user.Nombre != nil {
+		owner = *user.Nombre
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"wishlist": gin.H{
+			"id":         w.ID,
+			"name":       w.Name,
+			"owner_name": owner,
+			"items":      items,
+			"updated_at": w.UpdatedAt,
+		},
+	})
+}
+
+// SaveCartItemForLater pasa un item del carrito a la lista de guardados para después
+func (h *Handler) SaveCartItemForLater(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Inicia sesión para guardar productos para después"})
+		return
+	}
+	itemID, err := strconv.Atoi(c.Param("itemID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return
+	}
+	if !h.cartItemInCart(c, itemID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in cart"})
+		return
+	}
+
+	wishlistID, err := db.GetOrCreateSpecialWishlist(h.DB, userID, models.WishlistKindSaved)
+	if err == nil {
+		err = db.MoveCartItemToWishlist(h.DB, itemID, wishlistID)
+	}
+	if err != nil {
+		log.Printf("Error guardando item %d para después: %v", itemID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error guardando para después"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Producto guardado para después", "wishlist_id": wishlistID})
+}
@@ -0,0 +1,236 @@
+// Package imaging procesa las imágenes de producto al subirlas: valida tipo, tamaño y dimensiones,
+// aplica la orientación EXIF, descarta los metadatos y genera los tamaños que usa la tienda en JPEG
+// y WebP con claves derivadas de su contenido. WebP se decodifica con golang.org/x/image/webp y se
+// codifica con el codificador VP8 del paquete.
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Decodificadores de los formatos aceptados
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrTooLarge    = errors.New("la imagen supera el tamaño máximo")
+	ErrUnsupported = errors.New("formato de imagen no soportado (usa JPEG, PNG, GIF o WebP)")
+	ErrDimensions  = errors.New("las dimensiones de la imagen superan el máximo")
+	ErrInvalid     = errors.New("la imagen está dañada o no se puede leer")
+)
+
+// Formatos de salida
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+// Size es un tamaño de salida: la imagen se reduce hasta caber en Width x Height sin deformarse y
+// nunca se amplía
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// DefaultSizes son los tamaños de las fichas de producto
+var DefaultSizes = []Size{
+	{Name: "thumbnail", Width: 160, Height: 160},
+	{Name: "card", Width: 480, Height: 480},
+	{Name: "zoom", Width: 1600, Height: 1600},
+}
+
+// Encoder codifica una imagen en un formato de salida con la calidad indicada (1-100)
+type Encoder func(w io.Writer, img image.Image, quality int) error
+
+// WebPEncoder genera las versiones WebP; se puede reemplazar por otro codificador (por ejemplo, uno
+// basado en libwebp). Si es nil solo se generan las versiones JPEG.
+var WebPEncoder Encoder = EncodeWebP
+
+// Pipeline es la configuración del procesamiento de imágenes
+type Pipeline struct {
+	MaxBytes    int64
+	MaxWidth    int
+	MaxHeight   int
+	Sizes       []Size
+	Quality     int
+	WebPEncoder Encoder
+}
+
+// FromEnv crea el procesamiento con IMAGE_MAX_MB (15), IMAGE_MAX_DIMENSION (5000) e
+// IMAGE_QUALITY (82)
+func FromEnv() *Pipeline {
+	maxDimension := intFromEnv("IMAGE_MAX_DIMENSION", 5000)
+	return &Pipeline{
+		MaxBytes:    int64(intFromEnv("IMAGE_MAX_MB", 15)) << 20,
+		MaxWidth:    maxDimension,
+		MaxHeight:   maxDimension,
+		Sizes:       DefaultSizes,
+		Quality:     min(intFromEnv("IMAGE_QUALITY", 82), 100),
+		WebPEncoder: WebPEncoder,
+	}
+}
+
+// Variant es una versión procesada de la imagen lista para subir
+type Variant struct {
+	Size        string
+	Format      string
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+	Hash        string // SHA-256 del contenido en hexadecimal
+}
+
+// Key es la clave de almacenamiento: cambia si cambia el contenido, así que se puede cachear sin
+// caducidad
+func (v Variant) Key(prefix string) string {
+	ext := "jpg"
+	if v.Format == FormatWebP {
+		ext = "webp"
+	}
+	return fmt.Sprintf("%s/%s-%s.%s", strings.TrimSuffix(prefix, "/"), v.Hash[:16], v.Size, ext)
+}
+
+// Result es la imagen procesada
+type Result struct {
+	SourceType string // Tipo detectado del archivo original
+	Width      int    // Dimensiones ya orientadas
+	Height     int
+	Variants   []Variant
+}
+
+// Process lee una imagen subida y genera sus versiones. El tipo se detecta por el contenido y no
+// por el nombre o la cabecera del cliente; las dimensiones se comprueban antes de decodificar para
+// no reservar memoria para imágenes enormes.
+func (p *Pipeline) Process(r io.Reader) (*Result, error) {
+	data, err := io.ReadAll(io.LimitReader(r, p.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo imagen: %w", err)
+	}
+	if int64(len(data)) > p.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return nil, ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrInvalid
+	}
+	if config.Width > p.MaxWidth || config.Height > p.MaxHeight {
+		return nil, ErrDimensions
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	img := toNRGBA(src)
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	result := &Result{SourceType: contentType, Width: img.Rect.Dx(), Height: img.Rect.Dy()}
+	for _, size := range p.Sizes {
+		w, h := fit(result.Width, result.Height, size.Width, size.Height)
+		resized := img
+		if w != result.Width || h != result.Height {
+			resized = resize(img, w, h)
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, flatten(resized), &jpeg.Options{Quality: p.Quality}); err != nil {
+			return nil, fmt.Errorf("error generando %s en JPEG: %w", size.Name, err)
+		}
+		result.Variants = append(result.Variants, newVariant(size.Name, FormatJPEG, "image/jpeg", w, h, buf.Bytes()))
+
+		if p.WebPEncoder != nil {
+			var buf bytes.Buffer
+			if err := p.WebPEncoder(&buf, resized, p.Quality); err != nil {
+				return nil, fmt.Errorf("error generando %s en WebP: %w", size.Name, err)
+			}
+			result.Variants = append(result.Variants, newVariant(size.Name, FormatWebP, "image/webp", w, h, buf.Bytes()))
+		}
+	}
+	return result, nil
+}
+
+func newVariant(size, format, contentType string, w, h int, data []byte) Variant {
+	sum := sha256.Sum256(data)
+	return Variant{
+		Size:        size,
+		Format:      format,
+		Width:       w,
+		Height:      h,
+		ContentType: contentType,
+		Data:        data,
+		Hash:        hex.EncodeToString(sum[:]),
+	}
+}
+
+// fit calcula las dimensiones que caben en la caja conservando la proporción, sin ampliar
+func fit(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	if w*maxH > h*maxW {
+		return maxW, max(1, (h*maxW+w/2)/w)
+	}
+	return max(1, (w*maxH+h/2)/h), maxH
+}
+
+// toNRGBA copia la imagen a NRGBA con origen en (0, 0)
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, src, b.Min, draw.Src)
+	return dst
+}
+
+// flatten pinta la imagen sobre blanco: JPEG no tiene transparencia y sin esto se vería negra
+func flatten(img *image.NRGBA) image.Image {
+	if img.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Rect)
+	draw.Draw(dst, dst.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, image.Point{}, draw.Over)
+	return dst
+}
+
+// intFromEnv lee un entero positivo de una variable de entorno
+func intFromEnv(key string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("⚠️  %s inválida (%q), usando %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
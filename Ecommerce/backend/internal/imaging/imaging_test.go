@@ -0,0 +1,387 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testPipeline es un procesamiento pequeño para las pruebas
+func testPipeline() *Pipeline {
+	return &Pipeline{
+		MaxBytes:    1 << 20,
+		MaxWidth:    400,
+		MaxHeight:   400,
+		Sizes:       []Size{{Name: "thumbnail", Width: 32, Height: 32}, {Name: "card", Width: 120, Height: 120}},
+		Quality:     82,
+		WebPEncoder: EncodeWebP,
+	}
+}
+
+// gradient genera una imagen opaca con degradados suaves
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) * 127 / (w + h)), 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation inserta tras el SOI un segmento APP1 EXIF con la orientación indicada
+func withOrientation(data []byte, orientation int, order binary.ByteOrder) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)       // Una entrada
+	order.PutUint16(tiff[10:], 0x0112) // Orientation
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)      // Un valor
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestProcessRejectsByContent(t *testing.T) {
+	p := testPipeline()
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"texto", []byte("hola, esto no es una imagen"), ErrUnsupported},
+		{"html", []byte("<html><body><img src=x onerror=alert(1)></body></html>"), ErrUnsupported},
+		{"pdf", []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n"), ErrUnsupported},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupported},
+		{"png truncado", encodePNG(t, gradient(20, 20))[:40], ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Process(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("error %v, se esperaba %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLimits(t *testing.T) {
+	data := encodePNG(t, gradient(50, 30))
+
+	p := testPipeline()
+	p.MaxBytes = int64(len(data) - 1)
+	if _, err := p.Process(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("con MaxBytes %d: error %v, se esperaba ErrTooLarge", p.MaxBytes, err)
+	}
+	p.MaxBytes = int64(len(data))
+	if _, err := p.Process(bytes.NewReader(data)); err != nil {
+		t.Errorf("con MaxBytes justo: %v", err)
+	}
+
+	p = testPipeline()
+	p.MaxWidth = 49
+	if _, err := p.Process(bytes.NewReader(data)); !errors.Is(err, ErrDimensions) {
+		t.Errorf("ancho 50 con máximo 49: error %v, se esperaba ErrDimensions", err)
+	}
+	p = testPipeline()
+	p.MaxHeight = 29
+	if _, err := p.Process(bytes.NewReader(data)); !errors.Is(err, ErrDimensions) {
+		t.Errorf("alto 30 con máximo 29: error %v, se esperaba ErrDimensions", err)
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	data := encodeJPEG(t, gradient(8, 8))
+	if got := jpegOrientation(data); got != 1 {
+		t.Errorf("sin EXIF: orientación %d, se esperaba 1", got)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := 1; o <= 8; o++ {
+			if got := jpegOrientation(withOrientation(data, o, order)); got != o {
+				t.Errorf("%v: orientación %d, se esperaba %d", order, got, o)
+			}
+		}
+	}
+	if got := jpegOrientation(withOrientation(data, 9, binary.BigEndian)); got != 1 {
+		t.Errorf("orientación inválida: %d, se esperaba 1", got)
+	}
+	if got := jpegOrientation([]byte("no es un jpeg")); got != 1 {
+		t.Errorf("datos que no son JPEG: %d, se esperaba 1", got)
+	}
+	if got := jpegOrientation(withOrientation(data, 6, binary.BigEndian)[:12]); got != 1 {
+		t.Errorf("EXIF truncado: %d, se esperaba 1", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2:  a b c
+	//       d e f
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.SetNRGBA(i%3, i/3, color.NRGBA{uint8('a' + i), 0, 0, 255})
+	}
+	tests := []struct {
+		orientation int
+		want        []string // Filas de la imagen resultante
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		var rows []string
+		for y := 0; y < dst.Rect.Dy(); y++ {
+			var row []byte
+			for x := 0; x < dst.Rect.Dx(); x++ {
+				row = append(row, dst.NRGBAAt(x, y).R)
+			}
+			rows = append(rows, string(row))
+		}
+		if strings.Join(rows, "/") != strings.Join(tt.want, "/") {
+			t.Errorf("orientación %d: %v, se esperaba %v", tt.orientation, rows, tt.want)
+		}
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	data := withOrientation(encodeJPEG(t, gradient(60, 20)), 6, binary.BigEndian)
+	result, err := testPipeline().Process(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Width != 20 || result.Height != 60 {
+		t.Errorf("dimensiones %dx%d, se esperaba 20x60 ya girada", result.Width, result.Height)
+	}
+	for _, v := range result.Variants {
+		if v.Width > v.Height {
+			t.Errorf("%s %s: %dx%d sigue apaisada", v.Size, v.Format, v.Width, v.Height)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, maxW, maxH int
+		wantW, wantH     int
+	}{
+		{100, 50, 160, 160, 100, 50},   // Cabe: no se amplía
+		{160, 160, 160, 160, 160, 160}, // Justo en el límite
+		{1600, 800, 160, 160, 160, 80},
+		{800, 1600, 160, 160, 80, 160},
+		{1000, 333, 480, 480, 480, 160},
+		{333, 1000, 480, 480, 160, 480},
+		{3000, 2, 160, 160, 160, 1}, // Nunca queda en 0
+		{640, 480, 320, 480, 320, 240},
+	}
+	for _, tt := range tests {
+		w, h := fit(tt.w, tt.h, tt.maxW, tt.maxH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("fit(%d, %d, %d, %d) = %dx%d, se esperaba %dx%d", tt.w, tt.h, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+		}
+		if w > tt.w || h > tt.h {
+			t.Errorf("fit(%d, %d, %d, %d) amplió a %dx%d", tt.w, tt.h, tt.maxW, tt.maxH, w, h)
+		}
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	data := encodePNG(t, gradient(200, 100))
+	first, err := testPipeline().Process(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := testPipeline().Process(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Variants) != 4 {
+		t.Fatalf("%d versiones, se esperaban 4 (2 tamaños en JPEG y WebP)", len(first.Variants))
+	}
+
+	format := regexp.MustCompile(`^products/7/media/[0-9a-f]{16}-(thumbnail|card)\.(jpg|webp)$`)
+	seen := map[string]bool{}
+	for i, v := range first.Variants {
+		key := v.Key("products/7/media/")
+		if !format.MatchString(key) {
+			t.Errorf("clave %q con formato inesperado", key)
+		}
+		if !strings.HasPrefix(v.Hash, key[len("products/7/media/"):][:16]) {
+			t.Errorf("clave %q no deriva del hash %s", key, v.Hash)
+		}
+		if again := second.Variants[i].Key("products/7/media"); again != key {
+			t.Errorf("el mismo contenido dio claves distintas: %q y %q", key, again)
+		}
+		if seen[key] {
+			t.Errorf("clave %q repetida", key)
+		}
+		seen[key] = true
+	}
+
+	other, err := testPipeline().Process(bytes.NewReader(encodePNG(t, gradient(100, 200))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Variants[0].Key("p") == first.Variants[0].Key("p") {
+		t.Error("contenido distinto con la misma clave")
+	}
+}
+
+// planeError es la diferencia media por muestra entre los planos decodificados y los que recibió
+// el codificador. Se compara en YCbCr porque image.YCbCr convierte a RGB con el rango completo de
+// JPEG y VP8 usa el rango limitado de BT.601.
+func planeError(decoded *image.YCbCr, e *vp8Encoder) float64 {
+	var sum, n float64
+	add := func(a, b uint8) {
+		sum += float64(max(int(a)-int(b), int(b)-int(a)))
+		n++
+	}
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			add(decoded.Y[y*decoded.YStride+x], e.y[y*e.yStride+x])
+		}
+	}
+	for y := 0; y < (e.height+1)/2; y++ {
+		for x := 0; x < (e.width+1)/2; x++ {
+			add(decoded.Cb[y*decoded.CStride+x], e.u[y*e.cStride+x])
+			add(decoded.Cr[y*decoded.CStride+x], e.v[y*e.cStride+x])
+		}
+	}
+	return sum / n
+}
+
+func TestEncodeWebP(t *testing.T) {
+	// Incluye tamaños que no son múltiplos de 16 para los bordes de los macrobloques
+	for _, size := range [][2]int{{1, 1}, {17, 9}, {64, 48}, {161, 97}} {
+		img := gradient(size[0], size[1])
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, img, 82); err != nil {
+			t.Fatalf("%dx%d: %v", size[0], size[1], err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%dx%d: no se puede decodificar: %v", size[0], size[1], err)
+		}
+		if b := decoded.Bounds(); b.Dx() != size[0] || b.Dy() != size[1] {
+			t.Fatalf("%dx%d: decodificada de %dx%d", size[0], size[1], b.Dx(), b.Dy())
+		}
+		if e := planeError(decoded.(*image.YCbCr), newVP8Encoder(img, 82)); e > 3 {
+			t.Errorf("%dx%d: error medio %.2f por muestra", size[0], size[1], e)
+		}
+	}
+
+	// Blanco y negro en el rango limitado: Y 235 y 16, croma neutra
+	for _, tt := range []struct {
+		c    color.NRGBA
+		want uint8
+	}{{color.NRGBA{255, 255, 255, 255}, 235}, {color.NRGBA{0, 0, 0, 255}, 16}, {color.NRGBA{0, 0, 0, 0}, 235}} {
+		flat := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		draw.Draw(flat, flat.Rect, image.NewUniform(tt.c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, flat, 82); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ycc := decoded.(*image.YCbCr)
+		if ycc.Y[0] != tt.want || ycc.Cb[0] != 128 || ycc.Cr[0] != 128 {
+			t.Errorf("%v: YCbCr %d %d %d, se esperaba %d 128 128", tt.c, ycc.Y[0], ycc.Cb[0], ycc.Cr[0], tt.want)
+		}
+	}
+
+	// Más calidad, más bytes
+	img := gradient(128, 128)
+	var low, high bytes.Buffer
+	if err := EncodeWebP(&low, img, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeWebP(&high, img, 100); err != nil {
+		t.Fatal(err)
+	}
+	if low.Len() >= high.Len() {
+		t.Errorf("calidad 10 ocupa %d bytes y calidad 100 %d", low.Len(), high.Len())
+	}
+
+	if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 16384, 1)), 82); err == nil {
+		t.Error("se aceptó un ancho mayor que el máximo de WebP")
+	}
+}
+
+func TestProcessWebP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, gradient(200, 150), 90); err != nil {
+		t.Fatal(err)
+	}
+	result, err := testPipeline().Process(&buf)
+	if err != nil {
+		t.Fatalf("WebP subido: %v", err)
+	}
+	if result.SourceType != "image/webp" || result.Width != 200 || result.Height != 150 {
+		t.Errorf("origen %s %dx%d, se esperaba image/webp 200x150", result.SourceType, result.Width, result.Height)
+	}
+	for _, v := range result.Variants {
+		if v.Format != FormatWebP {
+			continue
+		}
+		decoded, err := webp.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatalf("%s: versión WebP inválida: %v", v.Size, err)
+		}
+		if b := decoded.Bounds(); b.Dx() != v.Width || b.Dy() != v.Height {
+			t.Errorf("%s: %dx%d, se esperaba %dx%d", v.Size, b.Dx(), b.Dy(), v.Width, v.Height)
+		}
+	}
+}
+
+func TestFromEnvEncodesWebP(t *testing.T) {
+	if FromEnv().WebPEncoder == nil {
+		t.Fatal("FromEnv no configura el codificador WebP")
+	}
+}
@@ -0,0 +1,136 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// resize escala la imagen a w x h con un filtro Catmull-Rom en dos pasadas (horizontal y
+// vertical). Al reducir, el filtro se ensancha en proporción para promediar todos los píxeles de
+// origen y no producir aliasing. Los canales se promedian premultiplicados por alfa para que los
+// bordes transparentes no dejen halos.
+func resize(src *image.NRGBA, w, h int) *image.NRGBA {
+	srcW, srcH := src.Rect.Dx(), src.Rect.Dy()
+	hWeights := filterWeights(srcW, w)
+	vWeights := filterWeights(srcH, h)
+
+	// Filas de origen ya escaladas en horizontal (w píxeles, premultiplicados). Se calculan al
+	// necesitarlas y se liberan al dejar atrás la ventana del filtro, así que la memoria depende
+	// del alto del filtro y no del de la imagen.
+	rows := make([][]float32, srcH)
+	freed := 0
+	horizontal := func(sy int) []float32 {
+		if rows[sy] != nil {
+			return rows[sy]
+		}
+		out := make([]float32, w*4)
+		row := src.Pix[sy*src.Stride:]
+		for x, fw := range hWeights {
+			var r, g, b, a float64
+			for i, weight := range fw.weights {
+				p := row[(fw.start+i)*4:]
+				alpha := float64(p[3]) * weight
+				r += float64(p[0]) * alpha
+				g += float64(p[1]) * alpha
+				b += float64(p[2]) * alpha
+				a += alpha
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = float32(r), float32(g), float32(b), float32(a)
+		}
+		rows[sy] = out
+		return out
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y, fw := range vWeights {
+		for ; freed < fw.start; freed++ {
+			rows[freed] = nil
+		}
+		sources := make([][]float32, len(fw.weights))
+		for i := range fw.weights {
+			sources[i] = horizontal(fw.start + i)
+		}
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for i, weight := range fw.weights {
+				p := sources[i][x*4:]
+				r += float64(p[0]) * weight
+				g += float64(p[1]) * weight
+				b += float64(p[2]) * weight
+				a += float64(p[3]) * weight
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			if a <= 0 {
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				continue
+			}
+			p[0], p[1], p[2] = clamp8(r/a), clamp8(g/a), clamp8(b/a)
+			p[3] = clamp8(a)
+		}
+	}
+	return dst
+}
+
+// filterWeight son los pesos de los píxeles de origen (desde start) para un píxel de destino
+type filterWeight struct {
+	start   int
+	weights []float64
+}
+
+// filterWeights calcula, para cada píxel de destino, los pesos normalizados de sus píxeles de
+// origen en una dimensión
+func filterWeights(srcSize, dstSize int) []filterWeight {
+	scale := float64(srcSize) / float64(dstSize)
+	support := math.Max(scale, 1)
+	radius := 2 * support
+
+	weights := make([]filterWeight, dstSize)
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - radius))
+		end := int(math.Floor(center + radius))
+		if start < 0 {
+			start = 0
+		}
+		if end > srcSize-1 {
+			end = srcSize - 1
+		}
+
+		fw := filterWeight{start: start, weights: make([]float64, end-start+1)}
+		var sum float64
+		for j := start; j <= end; j++ {
+			weight := catmullRom((float64(j) - center) / support)
+			fw.weights[j-start] = weight
+			sum += weight
+		}
+		if sum != 0 {
+			for j := range fw.weights {
+				fw.weights[j] /= sum
+			}
+		}
+		weights[i] = fw
+	}
+	return weights
+}
+
+// catmullRom es el núcleo del filtro bicúbico Catmull-Rom (B=0, C=0.5)
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+func clamp8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
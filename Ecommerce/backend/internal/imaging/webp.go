@@ -0,0 +1,805 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// webpMaxDimension es el ancho y alto máximos de un fotograma VP8 (14 bits)
+const webpMaxDimension = 16383
+
+// EncodeWebP codifica la imagen como WebP con pérdida (VP8) con la calidad indicada (1-100). Es un
+// codificador sencillo: cada macrobloque elige la mejor predicción de 16x16 en luma y de 8x8 en
+// croma, usa las probabilidades por defecto del formato y deja el suavizado de bordes al filtro de
+// bucle del decodificador. Como en las versiones JPEG, la transparencia se pinta sobre blanco.
+func EncodeWebP(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() > webpMaxDimension || b.Dy() > webpMaxDimension {
+		return fmt.Errorf("dimensiones no válidas para WebP: %dx%d", b.Dx(), b.Dy())
+	}
+	src, ok := img.(*image.NRGBA)
+	if !ok || src.Rect.Min != (image.Point{}) {
+		src = toNRGBA(img)
+	}
+
+	frame := newVP8Encoder(src, quality).encode()
+
+	// Contenedor RIFF con un solo fragmento "VP8 "; los fragmentos se rellenan a tamaño par
+	pad := len(frame) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+len(frame)+pad))
+	copy(header[8:], "WEBPVP8 ")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(frame)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	if pad != 0 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webpQuantIndex traduce la calidad (1-100) al índice de cuantización de VP8 (0 es la mejor), con
+// la misma curva que libwebp para que la calidad signifique lo mismo que en otras herramientas
+func webpQuantIndex(quality int) int {
+	q := float64(min(max(quality, 0), 100)) / 100
+	c := q * 2 / 3
+	if q >= 0.75 {
+		c = 2*q - 1
+	}
+	return min(max(int(127*(1-c)), 0), 127)
+}
+
+// Modos de predicción de los bloques de 16x16 y 8x8
+const (
+	vp8ModeDC = iota
+	vp8ModeVE
+	vp8ModeHE
+	vp8ModeTM
+)
+
+// vp8Quant son los pasos de cuantización (DC, AC) de cada tipo de bloque
+type vp8Quant struct {
+	y1, y2, uv [2]int32
+}
+
+// Sesgo de redondeo de la cuantización en 1/256 del paso (DC, AC); por debajo de 0.5 descarta los
+// coeficientes pequeños, que cuestan más bits de lo que aportan
+var (
+	vp8BiasY1 = [2]int32{96, 110}
+	vp8BiasY2 = [2]int32{96, 108}
+	vp8BiasUV = [2]int32{110, 115}
+)
+
+// vp8Nz indica qué bloques del borde de un macrobloque tienen coeficientes: es el contexto de los
+// bloques vecinos
+type vp8Nz struct {
+	y2   uint8
+	y    [4]uint8
+	u, v [2]uint8
+}
+
+// vp8MB es lo que se escribe de cada macrobloque en la primera partición
+type vp8MB struct {
+	yMode, uvMode uint8
+	skip          bool
+}
+
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+	qIndex        int
+	quant         vp8Quant
+
+	// Planos de origen con los bordes replicados hasta completar macrobloques y su reconstrucción,
+	// que es lo que verá el decodificador y de donde salen las predicciones
+	y, u, v          []uint8
+	ry, ru, rv       []uint8
+	yStride, cStride int
+
+	tokens  vp8BoolEncoder
+	mbs     []vp8MB
+	topNz   []vp8Nz
+	leftNz  vp8Nz
+	skipped int
+}
+
+func newVP8Encoder(src *image.NRGBA, quality int) *vp8Encoder {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	e := &vp8Encoder{
+		width:  w,
+		height: h,
+		mbw:    (w + 15) / 16,
+		mbh:    (h + 15) / 16,
+		qIndex: webpQuantIndex(quality),
+	}
+	q := e.qIndex
+	e.quant = vp8Quant{
+		y1: [2]int32{int32(vp8DCTable[q]), int32(vp8ACTable[q])},
+		y2: [2]int32{int32(vp8DCTable[q]) * 2, max(int32(vp8ACTable[q])*155/100, 8)},
+		uv: [2]int32{int32(vp8DCTable[min(q, 117)]), int32(vp8ACTable[q])},
+	}
+	e.yStride, e.cStride = 16*e.mbw, 8*e.mbw
+	e.y = make([]uint8, e.yStride*16*e.mbh)
+	e.u = make([]uint8, e.cStride*8*e.mbh)
+	e.v = make([]uint8, e.cStride*8*e.mbh)
+	e.ry = make([]uint8, len(e.y))
+	e.ru = make([]uint8, len(e.u))
+	e.rv = make([]uint8, len(e.v))
+	e.mbs = make([]vp8MB, e.mbw*e.mbh)
+	e.topNz = make([]vp8Nz, e.mbw)
+	e.tokens.init()
+	e.importPixels(src)
+	return e
+}
+
+// importPixels convierte a YUV 4:2:0 con los coeficientes BT.601 de rango limitado que esperan
+// los decodificadores de WebP. Fuera de la imagen se repiten los píxeles del borde.
+func (e *vp8Encoder) importPixels(src *image.NRGBA) {
+	rgb := func(x, y int) (int32, int32, int32) {
+		x, y = min(x, e.width-1), min(y, e.height-1)
+		p := src.Pix[y*src.Stride+4*x : y*src.Stride+4*x+4]
+		r, g, b, a := int32(p[0]), int32(p[1]), int32(p[2]), int32(p[3])
+		if a != 0xff {
+			r = (r*a + 0xff*(0xff-a) + 127) / 0xff
+			g = (g*a + 0xff*(0xff-a) + 127) / 0xff
+			b = (b*a + 0xff*(0xff-a) + 127) / 0xff
+		}
+		return r, g, b
+	}
+	for y := 0; y < 16*e.mbh; y++ {
+		for x := 0; x < 16*e.mbw; x++ {
+			r, g, b := rgb(x, y)
+			e.y[y*e.yStride+x] = uint8((16839*r + 33059*g + 6420*b + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for y := 0; y < 8*e.mbh; y++ {
+		for x := 0; x < 8*e.mbw; x++ {
+			var r, g, b int32
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := rgb(2*x+d[0], 2*y+d[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			e.u[y*e.cStride+x] = clipUV(-9719*r - 19081*g + 28800*b)
+			e.v[y*e.cStride+x] = clipUV(28800*r - 24116*g - 4684*b)
+		}
+	}
+}
+
+// clipUV escala la croma de la suma de 4 píxeles
+func clipUV(v int32) uint8 {
+	return clip8((v + 1<<17 + 128<<18) >> 18)
+}
+
+func clip8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// encode codifica todos los macrobloques y arma el fotograma clave
+func (e *vp8Encoder) encode() []byte {
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNz = vp8Nz{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	first := e.firstPartition()
+	tokens := e.tokens.finish()
+
+	frame := make([]byte, 10, 10+len(first)+len(tokens))
+	// Etiqueta: fotograma clave, versión 0, visible y tamaño de la primera partición
+	tag := uint32(1<<4) | uint32(len(first))<<5
+	frame[0], frame[1], frame[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	frame[3], frame[4], frame[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(frame[6:], uint16(e.width))
+	binary.LittleEndian.PutUint16(frame[8:], uint16(e.height))
+	frame = append(frame, first...)
+	return append(frame, tokens...)
+}
+
+// firstPartition escribe la cabecera del fotograma y los modos de cada macrobloque
+func (e *vp8Encoder) firstPartition() []byte {
+	var bw vp8BoolEncoder
+	bw.init()
+	bw.putFlag(false) // Espacio de color
+	bw.putFlag(false) // Recorte de píxeles
+	bw.putFlag(false) // Sin segmentos
+	bw.putFlag(false) // Filtro de bucle normal
+	bw.putLiteral(6, uint32(e.filterLevel()))
+	bw.putLiteral(3, 0) // Nitidez
+	bw.putFlag(false)   // Sin ajustes del filtro por modo
+	bw.putLiteral(2, 0) // Una sola partición de coeficientes
+	bw.putLiteral(7, uint32(e.qIndex))
+	for i := 0; i < 5; i++ {
+		bw.putFlag(false) // Sin deltas de cuantización
+	}
+	bw.putFlag(false) // refresh_entropy_probs
+
+	for i := range vp8CoeffUpdateProbs {
+		for j := range vp8CoeffUpdateProbs[i] {
+			for k := range vp8CoeffUpdateProbs[i][j] {
+				for l := range vp8CoeffUpdateProbs[i][j][k] {
+					bw.put(vp8CoeffUpdateProbs[i][j][k][l], false)
+				}
+			}
+		}
+	}
+
+	useSkip := e.skipped > 0
+	var skipProb uint8
+	bw.putFlag(useSkip)
+	if useSkip {
+		total := len(e.mbs)
+		skipProb = uint8(min(max((255*(total-e.skipped)+total/2)/total, 1), 254))
+		bw.putLiteral(8, uint32(skipProb))
+	}
+
+	for _, mb := range e.mbs {
+		if useSkip {
+			bw.put(skipProb, mb.skip)
+		}
+		bw.put(145, true) // Predicción de 16x16
+		switch mb.yMode {
+		case vp8ModeDC:
+			bw.put(156, false)
+			bw.put(163, false)
+		case vp8ModeVE:
+			bw.put(156, false)
+			bw.put(163, true)
+		case vp8ModeHE:
+			bw.put(156, true)
+			bw.put(128, false)
+		case vp8ModeTM:
+			bw.put(156, true)
+			bw.put(128, true)
+		}
+		bw.put(142, mb.uvMode != vp8ModeDC)
+		if mb.uvMode != vp8ModeDC {
+			bw.put(114, mb.uvMode != vp8ModeVE)
+			if mb.uvMode != vp8ModeVE {
+				bw.put(183, mb.uvMode == vp8ModeTM)
+			}
+		}
+	}
+	return bw.finish()
+}
+
+// filterLevel calcula la intensidad del filtro de bucle a partir del paso de cuantización, como
+// libwebp con la intensidad por defecto
+func (e *vp8Encoder) filterLevel() int {
+	level := int(vp8ACTable[e.qIndex]>>2) * 300 / 256
+	if level < 2 {
+		return 0
+	}
+	return min(level, 63)
+}
+
+// edges devuelve los bordes de un bloque de n x n ya reconstruidos: la fila de arriba, la columna
+// izquierda y la esquina. Fuera de la imagen valen 127 arriba y 129 a la izquierda.
+func edges(plane []uint8, stride, n, mbx, mby int) (top, left []uint8, corner uint8) {
+	top, left = make([]uint8, n), make([]uint8, n)
+	x0, y0 := n*mbx, n*mby
+	for i := 0; i < n; i++ {
+		top[i], left[i] = 127, 129
+		if mby > 0 {
+			top[i] = plane[(y0-1)*stride+x0+i]
+		}
+		if mbx > 0 {
+			left[i] = plane[(y0+i)*stride+x0-1]
+		}
+	}
+	switch {
+	case mby == 0:
+		corner = 127
+	case mbx == 0:
+		corner = 129
+	default:
+		corner = plane[(y0-1)*stride+x0-1]
+	}
+	return top, left, corner
+}
+
+// predict rellena un bloque de n x n con el modo indicado. El modo DC solo promedia los bordes que
+// existen y sin ninguno predice 128.
+func predict(pred []uint8, n int, mode uint8, top, left []uint8, corner uint8, hasTop, hasLeft bool) {
+	switch mode {
+	case vp8ModeDC:
+		var sum, count int
+		if hasTop {
+			for _, v := range top {
+				sum += int(v)
+			}
+			count += n
+		}
+		if hasLeft {
+			for _, v := range left {
+				sum += int(v)
+			}
+			count += n
+		}
+		dc := uint8(128)
+		if count > 0 {
+			dc = uint8((sum + count/2) / count)
+		}
+		for i := range pred[:n*n] {
+			pred[i] = dc
+		}
+	case vp8ModeVE:
+		for j := 0; j < n; j++ {
+			copy(pred[j*n:(j+1)*n], top)
+		}
+	case vp8ModeHE:
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				pred[j*n+i] = left[j]
+			}
+		}
+	case vp8ModeTM:
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				pred[j*n+i] = clip8(int32(left[j]) + int32(top[i]) - int32(corner))
+			}
+		}
+	}
+}
+
+// bestMode elige el modo cuya predicción se parece más al origen y devuelve las predicciones de
+// cada plano. src y recon son los planos que comparten el modo: la luma o las dos crominancias.
+func bestMode(n, mbx, mby int, src, recon [][]uint8, stride int) (uint8, [][]uint8) {
+	type border struct {
+		top, left []uint8
+		corner    uint8
+	}
+	borders := make([]border, len(src))
+	for p := range src {
+		borders[p].top, borders[p].left, borders[p].corner = edges(recon[p], stride, n, mbx, mby)
+	}
+
+	var best uint8
+	var bestPreds [][]uint8
+	bestErr := -1
+	for mode := uint8(vp8ModeDC); mode <= vp8ModeTM; mode++ {
+		preds := make([][]uint8, len(src))
+		sse := 0
+		for p := range src {
+			preds[p] = make([]uint8, n*n)
+			predict(preds[p], n, mode, borders[p].top, borders[p].left, borders[p].corner, mby > 0, mbx > 0)
+			for j := 0; j < n; j++ {
+				row := src[p][(n*mby+j)*stride+n*mbx:]
+				for i := 0; i < n; i++ {
+					d := int(row[i]) - int(preds[p][j*n+i])
+					sse += d * d
+				}
+			}
+		}
+		if bestErr < 0 || sse < bestErr {
+			best, bestPreds, bestErr = mode, preds, sse
+		}
+	}
+	return best, bestPreds
+}
+
+// encodeMacroblock predice, transforma y cuantiza un macrobloque, escribe sus coeficientes y
+// guarda su reconstrucción
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+
+	// Luma: los DC de los 16 bloques van juntos en el bloque Y2 con la transformada de Walsh-Hadamard
+	yMode, preds := bestMode(16, mbx, mby, [][]uint8{e.y}, [][]uint8{e.ry}, e.yStride)
+	yPred := preds[0]
+	var yLevels [16][16]int32
+	var dcs [16]int32
+	for n := 0; n < 16; n++ {
+		bx, by := 4*(n%4), 4*(n/4)
+		var residual, coeffs [16]int32
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				src := e.y[(16*mby+by+j)*e.yStride+16*mbx+bx+i]
+				residual[j*4+i] = int32(src) - int32(yPred[(by+j)*16+bx+i])
+			}
+		}
+		fdct(&residual, &coeffs)
+		dcs[n] = coeffs[0]
+		for k := 1; k < 16; k++ {
+			yLevels[n][k] = quantize(coeffs[k], e.quant.y1[1], vp8BiasY1[1])
+		}
+	}
+	var y2, y2Levels [16]int32
+	fwht(&dcs, &y2)
+	for k := range y2 {
+		i := min(k, 1)
+		y2Levels[k] = quantize(y2[k], e.quant.y2[i], vp8BiasY2[i])
+	}
+
+	var deq, yDC [16]int32
+	for k := range deq {
+		deq[k] = dequantize(y2Levels[k], e.quant.y2[min(k, 1)])
+	}
+	iwht(&deq, &yDC)
+	for n := 0; n < 16; n++ {
+		bx, by := 4*(n%4), 4*(n/4)
+		var coeffs [16]int32
+		coeffs[0] = yDC[n]
+		for k := 1; k < 16; k++ {
+			coeffs[k] = dequantize(yLevels[n][k], e.quant.y1[1])
+		}
+		idctAdd(&coeffs, yPred[by*16+bx:], 16, e.ry[(16*mby+by)*e.yStride+16*mbx+bx:], e.yStride)
+	}
+
+	// Croma: U y V comparten el modo
+	uvMode, uvPreds := bestMode(8, mbx, mby, [][]uint8{e.u, e.v}, [][]uint8{e.ru, e.rv}, e.cStride)
+	var uvLevels [2][4][16]int32
+	for p, plane := range [][]uint8{e.u, e.v} {
+		recon := [][]uint8{e.ru, e.rv}[p]
+		for n := 0; n < 4; n++ {
+			bx, by := 4*(n%2), 4*(n/2)
+			var residual, coeffs [16]int32
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					src := plane[(8*mby+by+j)*e.cStride+8*mbx+bx+i]
+					residual[j*4+i] = int32(src) - int32(uvPreds[p][(by+j)*8+bx+i])
+				}
+			}
+			fdct(&residual, &coeffs)
+			for k := range coeffs {
+				i := min(k, 1)
+				uvLevels[p][n][k] = quantize(coeffs[k], e.quant.uv[i], vp8BiasUV[i])
+				coeffs[k] = dequantize(uvLevels[p][n][k], e.quant.uv[i])
+			}
+			idctAdd(&coeffs, uvPreds[p][by*8+bx:], 8, recon[(8*mby+by)*e.cStride+8*mbx+bx:], e.cStride)
+		}
+	}
+
+	mb.yMode, mb.uvMode = yMode, uvMode
+	mb.skip = allZero(y2Levels[:]) && allZero2(yLevels[:]) && allZero2(uvLevels[0][:]) && allZero2(uvLevels[1][:])
+	if mb.skip {
+		e.skipped++
+		e.leftNz = vp8Nz{}
+		e.topNz[mbx] = vp8Nz{}
+		return
+	}
+	e.writeResiduals(mbx, &y2Levels, &yLevels, &uvLevels)
+}
+
+// writeResiduals escribe los coeficientes del macrobloque en el orden y con los contextos del
+// decodificador
+func (e *vp8Encoder) writeResiduals(mbx int, y2 *[16]int32, y *[16][16]int32, uv *[2][4][16]int32) {
+	top, left := &e.topNz[mbx], &e.leftNz
+
+	nz := e.putCoeffs(vp8PlaneY2, top.y2+left.y2, y2, 0)
+	top.y2, left.y2 = nz, nz
+
+	for j := 0; j < 4; j++ {
+		nz := left.y[j]
+		for i := 0; i < 4; i++ {
+			nz = e.putCoeffs(vp8PlaneY1WithY2, nz+top.y[i], &y[j*4+i], 1)
+			top.y[i] = nz
+		}
+		left.y[j] = nz
+	}
+
+	for p := 0; p < 2; p++ {
+		topNz, leftNz := &top.u, &left.u
+		if p == 1 {
+			topNz, leftNz = &top.v, &left.v
+		}
+		for j := 0; j < 2; j++ {
+			nz := leftNz[j]
+			for i := 0; i < 2; i++ {
+				nz = e.putCoeffs(vp8PlaneUV, nz+topNz[i], &uv[p][j*2+i], 0)
+				topNz[i] = nz
+			}
+			leftNz[j] = nz
+		}
+	}
+}
+
+// putCoeffs escribe los coeficientes de un bloque de 4x4 a partir de first (1 si el DC va en Y2) y
+// devuelve 1 si tenía alguno distinto de cero (sección 13)
+func (e *vp8Encoder) putCoeffs(plane int, ctx uint8, levels *[16]int32, first int) uint8 {
+	bw := &e.tokens
+	probs := &vp8CoeffProbs[plane]
+
+	last := -1
+	for n := first; n < 16; n++ {
+		if levels[vp8Zigzag[n]] != 0 {
+			last = n
+		}
+	}
+	p := &probs[vp8Bands[first]][ctx]
+	if last < 0 {
+		bw.put(p[0], false) // Fin del bloque
+		return 0
+	}
+	bw.put(p[0], true)
+
+	for n := first; n <= last; {
+		v := levels[vp8Zigzag[n]]
+		n++
+		if v == 0 {
+			// Tras un cero no puede venir el fin del bloque, así que no se escribe
+			bw.put(p[1], false)
+			p = &probs[vp8Bands[n]][0]
+			continue
+		}
+		bw.put(p[1], true)
+		abs := v
+		if abs < 0 {
+			abs = -abs
+		}
+		if abs == 1 {
+			bw.put(p[2], false)
+			p = &probs[vp8Bands[n]][1]
+		} else {
+			bw.put(p[2], true)
+			putLargeValue(bw, p, abs)
+			p = &probs[vp8Bands[n]][2]
+		}
+		bw.putFlag(v < 0)
+		if n == 16 {
+			break
+		}
+		bw.put(p[0], n <= last)
+	}
+	return 1
+}
+
+// putLargeValue escribe un valor absoluto mayor que 1 con el árbol de tokens de la sección 13.2
+func putLargeValue(bw *vp8BoolEncoder, p *[vp8NumProbs]uint8, abs int32) {
+	switch {
+	case abs <= 4:
+		bw.put(p[3], false)
+		if abs == 2 {
+			bw.put(p[4], false)
+		} else {
+			bw.put(p[4], true)
+			bw.put(p[5], abs == 4)
+		}
+	case abs <= 10:
+		bw.put(p[3], true)
+		bw.put(p[6], false)
+		if abs <= 6 {
+			bw.put(p[7], false) // Categoría 1: 5 y 6
+			bw.put(159, abs == 6)
+		} else {
+			bw.put(p[7], true) // Categoría 2: 7 a 10
+			bw.put(165, (abs-7)&2 != 0)
+			bw.put(145, (abs-7)&1 != 0)
+		}
+	default:
+		bw.put(p[3], true)
+		bw.put(p[6], true)
+		cat := 3 // Categorías 3 a 6: desde 11, 19, 35 y 67
+		for cat > 0 && abs < 3+(8<<cat) {
+			cat--
+		}
+		bw.put(p[8], cat >= 2)
+		bw.put(p[9+cat/2], cat&1 != 0)
+		extra := abs - (3 + (8 << cat))
+		tab := vp8CatProbs[cat]
+		for i, prob := range tab {
+			bw.put(prob, extra>>(len(tab)-1-i)&1 != 0)
+		}
+	}
+}
+
+// quantize divide el coeficiente entre el paso con el sesgo de redondeo en 1/256 del paso
+func quantize(c, step, bias int32) int32 {
+	neg := c < 0
+	if neg {
+		c = -c
+	}
+	level := min((c+step*bias>>8)/step, 2047)
+	if neg {
+		return -level
+	}
+	return level
+}
+
+// dequantize reproduce el valor que reconstruye el decodificador, que lo guarda en 16 bits
+func dequantize(level, step int32) int32 {
+	return int32(int16(level * step))
+}
+
+func allZero(levels []int32) bool {
+	for _, l := range levels {
+		if l != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero2(blocks [][16]int32) bool {
+	for i := range blocks {
+		if !allZero(blocks[i][:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fdct es la transformada del coseno directa de 4x4, la inversa exacta de la del decodificador
+// salvo por el redondeo
+func fdct(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		d0, d1, d2, d3 := in[i*4], in[i*4+1], in[i*4+2], in[i*4+3]
+		a0, a1, a2, a3 := d0+d3, d1+d2, d1-d2, d0-d3
+		tmp[0+i*4] = (a0 + a1) * 8
+		tmp[1+i*4] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[2+i*4] = (a0 - a1) * 8
+		tmp[3+i*4] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[0+i]+tmp[12+i], tmp[4+i]+tmp[8+i]
+		a2, a3 := tmp[4+i]-tmp[8+i], tmp[0+i]-tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// idctAdd aplica la transformada inversa como el decodificador y suma el resultado a la predicción
+func idctAdd(coeffs *[16]int32, pred []uint8, predStride int, dst []uint8, dstStride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := coeffs[i] + coeffs[8+i]
+		b := coeffs[i] - coeffs[8+i]
+		c := (coeffs[4+i]*c2)>>16 - (coeffs[12+i]*c1)>>16
+		d := (coeffs[4+i]*c1)>>16 + (coeffs[12+i]*c2)>>16
+		m[i][0], m[i][1], m[i][2], m[i][3] = a+d, b+c, b-c, a-d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		p, out := pred[j*predStride:], dst[j*dstStride:]
+		out[0] = clip8(int32(p[0]) + (a+d)>>3)
+		out[1] = clip8(int32(p[1]) + (b+c)>>3)
+		out[2] = clip8(int32(p[2]) + (b-c)>>3)
+		out[3] = clip8(int32(p[3]) + (a-d)>>3)
+	}
+}
+
+// fwht es la transformada de Walsh-Hadamard directa de los 16 DC de la luma
+func fwht(in, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		x := in[i*4 : i*4+4]
+		a0, a1, a2, a3 := x[0]+x[2], x[1]+x[3], x[1]-x[3], x[0]-x[2]
+		tmp[0+i*4] = a0 + a1
+		tmp[1+i*4] = a3 + a2
+		tmp[2+i*4] = a3 - a2
+		tmp[3+i*4] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[0+i]+tmp[8+i], tmp[4+i]+tmp[12+i]
+		a2, a3 := tmp[4+i]-tmp[12+i], tmp[0+i]-tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// iwht es la inversa de fwht tal como la calcula el decodificador: devuelve el DC de cada bloque
+func iwht(in, out *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0, a1 := in[0+i]+in[12+i], in[4+i]+in[8+i]
+		a2, a3 := in[4+i]-in[8+i], in[0+i]-in[12+i]
+		m[0+i], m[8+i] = a0+a1, a0-a1
+		m[4+i], m[12+i] = a3+a2, a3-a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0, a1 := dc+m[3+i*4], m[1+i*4]+m[2+i*4]
+		a2, a3 := m[1+i*4]-m[2+i*4], dc-m[3+i*4]
+		out[i*4+0] = int32(int16((a0 + a1) >> 3))
+		out[i*4+1] = int32(int16((a3 + a2) >> 3))
+		out[i*4+2] = int32(int16((a0 - a1) >> 3))
+		out[i*4+3] = int32(int16((a3 - a2) >> 3))
+	}
+}
+
+// vp8BoolEncoder es el codificador aritmético binario de VP8 (sección 7.3)
+type vp8BoolEncoder struct {
+	out      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func (e *vp8BoolEncoder) init() {
+	e.rng, e.bottom, e.bitCount = 255, 0, 24
+}
+
+// put escribe un bit cuya probabilidad de ser 0 es prob/256
+func (e *vp8BoolEncoder) put(prob uint8, bit bool) {
+	split := 1 + ((e.rng-1)*uint32(prob))>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.out = append(e.out, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// carry propaga el acarreo a los bytes ya escritos
+func (e *vp8BoolEncoder) carry() {
+	i := len(e.out) - 1
+	for ; i >= 0 && e.out[i] == 0xff; i-- {
+		e.out[i] = 0
+	}
+	if i >= 0 {
+		e.out[i]++
+	}
+}
+
+func (e *vp8BoolEncoder) putFlag(bit bool) {
+	e.put(128, bit)
+}
+
+// putLiteral escribe un entero sin signo de n bits, el más significativo primero
+func (e *vp8BoolEncoder) putLiteral(n int, v uint32) {
+	for n > 0 {
+		n--
+		e.putFlag(v>>n&1 != 0)
+	}
+}
+
+// finish vacía los bits pendientes y devuelve la partición
+func (e *vp8BoolEncoder) finish() []byte {
+	c := e.bitCount
+	v := e.bottom
+	if v&(1<<(32-c)) != 0 {
+		e.carry()
+	}
+	v <<= c & 7
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for i := 0; i < 4; i++ {
+		e.out = append(e.out, byte(v>>24))
+		v <<= 8
+	}
+	return e.out
+}
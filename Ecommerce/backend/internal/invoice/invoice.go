@@ -0,0 +1,344 @@
+package invoice
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/pdf"
+)
+
+// Tipos de documento
+const (
+	TypeInvoice = "invoice"
+	TypeReceipt = "receipt"
+)
+
+// Store son los datos del emisor que aparecen en el encabezado
+type Store struct {
+	Name    string
+	TaxID   string
+	Address string
+	Email   string
+}
+
+// Service emite y dibuja facturas y recibos de pedidos pagados
+type Service struct {
+	DB            *pgxpool.Pool
+	Store         Store
+	InvoiceSeries string
+	ReceiptSeries string
+}
+
+// NewService crea el servicio con STORE_NAME, STORE_TAX_ID, STORE_ADDRESS, STORE_EMAIL,
+// INVOICE_SERIES (F por defecto) y RECEIPT_SERIES (R por defecto)
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		DB: db,
+		Store: Store{
+			Name:    envOr("STORE_NAME", "Ecommerce"),
+			TaxID:   os.Getenv("STORE_TAX_ID"),
+			Address: os.Getenv("STORE_ADDRESS"),
+			Email:   os.Getenv("STORE_EMAIL"),
+		},
+		InvoiceSeries: strings.ToUpper(envOr("INVOICE_SERIES", "F")),
+		ReceiptSeries: strings.ToUpper(envOr("RECEIPT_SERIES", "R")),
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ValidType indica si el tipo de documento es conocido
+func ValidType(kind string) bool {
+	return kind == TypeInvoice || kind == TypeReceipt
+}
+
+// Invoiceable indica si el pedido ya se cobró; los reembolsos posteriores no anulan el documento
+func Invoiceable(order *models.Order) bool {
+	switch order.PaymentStatus {
+	case "paid", "partially_refunded", "refunded":
+		return true
+	}
+	return false
+}
+
+// Filename devuelve el nombre de archivo sugerido para el documento
+func Filename(inv *models.Invoice) string {
+	if inv.Type == TypeReceipt {
+		return fmt.Sprintf("recibo-%s.pdf", inv.FullNumber)
+	}
+	return fmt.Sprintf("factura-%s.pdf", inv.FullNumber)
+}
+
+// Document emite (si hace falta) y genera el PDF del documento de un pedido pagado
+func (s *Service) Document(order *models.Order, kind string) (*models.Invoice, []byte, error) {
+	if !ValidType(kind) {
+		return nil, nil, fmt.Errorf("tipo de documento inválido")
+	}
+	if !Invoiceable(order) {
+		return nil, nil, fmt.Errorf("el pedido no está pagado")
+	}
+
+	items, err := db.GetOrderItems(s.DB, order.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inv, err := db.GetOrderInvoice(s.DB, order.ID, kind)
+	if err != nil {
+		inv, err = db.IssueInvoice(s.DB, s.draft(order, kind))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return inv, s.render(order, items, inv), nil
+}
+
+// draft arma los datos del cliente y del pago que quedan fijos al emitir el documento
+func (s *Service) draft(order *models.Order, kind string) *models.Invoice {
+	inv := &models.Invoice{OrderID: order.ID, Type: kind, Series: s.InvoiceSeries}
+	if kind == TypeReceipt {
+		inv.Series = s.ReceiptSeries
+	}
+
+	if user, err := db.GetUserByID(s.DB, order.UserID); err == nil {
+		inv.CustomerEmail = user.Email
+		var parts []string
+		if user.Nombre != nil && *user.Nombre != "" {
+			parts = append(parts, *user.Nombre)
+		}
+		if user.Apellido != nil && *user.Apellido != "" {
+			parts = append(parts, *user.Apellido)
+		}
+		inv.CustomerName = strings.Join(parts, " ")
+	} else if order.GuestEmail != "" {
+		inv.CustomerEmail = order.GuestEmail
+	}
+	if addr := order.BillingAddress; addr != nil {
+		if name := strings.TrimSpace(addr.FirstName + " " + addr.LastName); name != "" {
+			inv.CustomerName = name
+		}
+		if addr.Company != "" {
+			inv.CustomerName = addr.Company
+		}
+		inv.CustomerTaxID = addr.TaxID
+	}
+
+	if payments, err := db.GetOrderPayments(s.DB, order.ID); err == nil {
+		for _, p := range payments {
+			if p.Status != "succeeded" && p.Status != "refunded" && p.Status != "partially_refunded" {
+				continue
+			}
+			inv.PaymentReference = p.StripePaymentIntentID
+			if inv.PaymentReference == "" {
+				inv.PaymentReference = p.TransactionID
+			}
+			break
+		}
+	}
+	return inv
+}
+
+// Posiciones de las columnas de la tabla de conceptos
+const (
+	marginLeft  = 50.0
+	marginRight = pdf.PageWidth - 50
+	colQty      = 360.0
+	colPrice    = 440.0
+	colTax      = 500.0
+	bottomLimit = 90.0
+)
+
+func (s *Service) render(order *models.Order, items []models.OrderItem, inv *models.Invoice) []byte {
+	doc := pdf.New()
+	title := "FACTURA"
+	if inv.Type == TypeReceipt {
+		title = "RECIBO DE PAGO"
+	}
+
+	y := s.header(doc, title, order, inv)
+
+	// Datos del cliente
+	doc.Text(marginLeft, y, 10, true, "Cliente")
+	y -= 14
+	for _, line := range customerLines(order, inv) {
+		doc.Text(marginLeft, y, 9, false, line)
+		y -= 12
+	}
+	y -= 14
+
+	y = itemsHeader(doc, y)
+	taxes := map[string]*models.TaxComponent{}
+	for _, item := range items {
+		if y < bottomLimit {
+			doc.AddPage()
+			y = s.header(doc, title, order, inv)
+			y = itemsHeader(doc, y)
+		}
+		name := fmt.Sprintf("Producto #%d", item.ProductID)
+		if product, err := db.GetProductByID(s.DB, item.ProductID); err == nil {
+			name = product.Name
+		}
+		doc.Text(marginLeft, y, 9, false, truncate(name, colQty-marginLeft-10, 9))
+		doc.TextRight(colQty+20, y, 9, false, fmt.Sprintf("%d", item.Quantity))
+		doc.TextRight(colPrice+30, y, 9, false, money(item.Price))
+		doc.TextRight(colTax+35, y, 9, false, money(item.TaxAmount))
+		doc.TextRight(marginRight, y, 9, false, money(item.Subtotal))
+		y -= 14
+
+		for _, t := range item.TaxBreakdown {
+			key := fmt.Sprintf("%s|%.4f", t.Name, t.Rate)
+			agg, ok := taxes[key]
+			if !ok {
+				agg = &models.TaxComponent{Name: t.Name, Rate: t.Rate}
+				taxes[key] = agg
+			}
+			agg.Taxable += t.Taxable
+			agg.Amount += t.Amount
+		}
+	}
+
+	// Desglose de impuestos y totales; se mantienen juntos en la misma página
+	if y < bottomLimit+float64(len(taxes)+6)*14 {
+		doc.AddPage()
+		y = s.header(doc, title, order, inv)
+	}
+	doc.Line(marginLeft, y+4, marginRight, y+4, 0.5)
+	y -= 12
+
+	if len(taxes) > 0 {
+		doc.Text(marginLeft, y, 10, true, "Desglose de impuestos")
+		y -= 14
+		keys := make([]string, 0, len(taxes))
+		for k := range taxes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			t := taxes[k]
+			doc.Text(marginLeft, y, 9, false, fmt.Sprintf("%s (%.2f%%) sobre %s", t.Name, t.Rate*100, money(t.Taxable)))
+			doc.TextRight(colPrice, y, 9, false, money(t.Amount))
+			y -= 12
+		}
+		y -= 6
+	}
+
+	totals := [][2]string{{"Subtotal", money(order.Subtotal)}}
+	if order.Discount > 0 {
+		totals = append(totals, [2]string{"Descuento", "-" + money(order.Discount)})
+	}
+	totals = append(totals, [2]string{"Impuestos", money(order.Tax)}, [2]string{"Envío", money(order.Shipping)})
+	for _, row := range totals {
+		doc.Text(colPrice-40, y, 10, false, row[0])
+		doc.TextRight(marginRight, y, 10, false, row[1])
+		y -= 14
+	}
+	doc.Text(colPrice-40, y, 11, true, "Total")
+	doc.TextRight(marginRight, y, 11, true, fmt.Sprintf("%s %s", money(order.Total), order.Currency))
+	y -= 24
+
+	if inv.PaymentReference != "" {
+		doc.Text(marginLeft, y, 9, false, "Referencia de pago: "+inv.PaymentReference)
+		y -= 12
+	}
+	if order.PaymentStatus == "refunded" || order.PaymentStatus == "partially_refunded" {
+		doc.Text(marginLeft, y, 9, false, "Este pedido tiene reembolsos registrados.")
+	}
+
+	return doc.Bytes()
+}
+
+// header dibuja el encabezado del emisor y los datos del documento; devuelve la siguiente posición
+func (s *Service) header(doc *pdf.Document, title string, order *models.Order, inv *models.Invoice) float64 {
+	y := pdf.PageHeight - 60
+	doc.Text(marginLeft, y, 16, true, s.Store.Name)
+	doc.TextRight(marginRight, y, 14, true, title)
+	y -= 16
+	var lines []string
+	if s.Store.TaxID != "" {
+		lines = append(lines, "ID fiscal: "+s.Store.TaxID)
+	}
+	if s.Store.Address != "" {
+		lines = append(lines, s.Store.Address)
+	}
+	if s.Store.Email != "" {
+		lines = append(lines, s.Store.Email)
+	}
+	right := []string{
+		"Folio: " + inv.FullNumber,
+		"Fecha: " + inv.IssuedAt.Format("2006-01-02"),
+		"Pedido: " + order.OrderNumber,
+	}
+	for i := 0; i < len(lines) || i < len(right); i++ {
+		if i < len(lines) {
+			doc.Text(marginLeft, y, 9, false, lines[i])
+		}
+		if i < len(right) {
+			doc.TextRight(marginRight, y, 9, false, right[i])
+		}
+		y -= 12
+	}
+	y -= 8
+	doc.Line(marginLeft, y, marginRight, y, 1)
+	return y - 20
+}
+
+func itemsHeader(doc *pdf.Document, y float64) float64 {
+	doc.Rect(marginLeft, y-4, marginRight-marginLeft, 16, 0.9)
+	doc.Text(marginLeft+4, y, 9, true, "Concepto")
+	doc.TextRight(colQty+20, y, 9, true, "Cant.")
+	doc.TextRight(colPrice+30, y, 9, true, "Precio")
+	doc.TextRight(colTax+35, y, 9, true, "Impuesto")
+	doc.TextRight(marginRight, y, 9, true, "Importe")
+	return y - 20
+}
+
+func customerLines(order *models.Order, inv *models.Invoice) []string {
+	var lines []string
+	if inv.CustomerName != "" {
+		lines = append(lines, inv.CustomerName)
+	}
+	if inv.CustomerTaxID != "" {
+		lines = append(lines, "ID fiscal: "+inv.CustomerTaxID)
+	}
+	if addr := order.BillingAddress; addr != nil {
+		street := strings.TrimSpace(addr.Address1 + " " + addr.Address2)
+		if street != "" {
+			lines = append(lines, street)
+		}
+		city := strings.Trim(strings.Join([]string{addr.City, addr.State, addr.PostalCode, addr.Country}, ", "), ", ")
+		if city != "" {
+			lines = append(lines, city)
+		}
+	}
+	if inv.CustomerEmail != "" {
+		lines = append(lines, inv.CustomerEmail)
+	}
+	return lines
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// truncate recorta el texto para que quepa en el ancho indicado
+func truncate(text string, width, size float64) string {
+	if pdf.TextWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
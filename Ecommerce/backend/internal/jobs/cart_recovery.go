@@ -0,0 +1,245 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// CartRecovery envía la secuencia de recordatorios a los usuarios que dejaron productos en el
+// carrito y aceptan emails de marketing. La secuencia se corta sola cuando el cliente compra (el
+// checkout vacía el carrito), vuelve a modificar el carrito o desactiva los emails de marketing.
+type CartRecovery struct {
+	DB            *pgxpool.Pool
+	EmailService  *email.EmailService
+	Steps         []time.Duration // Tiempo sin actividad para cada recordatorio, en orden
+	CouponPercent float64         // Descuento del cupón generado; 0 no genera cupón
+	CouponStep    int             // Recordatorio (desde 1) a partir del cual se incluye el cupón
+	CouponTTL     time.Duration   // Vigencia del cupón generado
+	LinkTTL       time.Duration   // Vigencia del enlace para recuperar el carrito
+	BatchSize     int             // Carritos por paso y ejecución
+}
+
+// NewCartRecovery crea el job de recuperación de carritos con la configuración del entorno
+func NewCartRecovery(db *pgxpool.Pool) *CartRecovery {
+	return &CartRecovery{
+		DB:            db,
+		EmailService:  email.DefaultEmailService,
+		Steps:         durationsFromEnv("CART_RECOVERY_STEPS", []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour}),
+		CouponPercent: percentFromEnv("CART_RECOVERY_COUPON_PERCENT", 0),
+		CouponStep:    intFromEnv("CART_RECOVERY_COUPON_STEP", 3),
+		CouponTTL:     DurationFromEnv("CART_RECOVERY_COUPON_TTL", 72*time.Hour),
+		LinkTTL:       DurationFromEnv("CART_RECOVERY_LINK_TTL", 7*24*time.Hour),
+		BatchSize:     100,
+	}
+}
+
+// Start ejecuta el envío periódicamente hasta que se cancele el contexto
+func (j *CartRecovery) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run envía los recordatorios que tocan en cada paso de la secuencia
+func (j *CartRecovery) Run(ctx context.Context) {
+	if j.EmailService == nil || len(j.Steps) == 0 {
+		return
+	}
+	now := time.Now()
+	// Un abandono más antiguo que la secuencia completa ya no recibe el primer recordatorio
+	notBefore := now.Add(-j.Steps[len(j.Steps)-1])
+
+	sent := 0
+	for step, delay := range j.Steps {
+		var prevSentBefore time.Time
+		if step > 0 {
+			prevSentBefore = now.Add(-(delay - j.Steps[step-1]))
+		}
+		carts, err := db.GetAbandonedCarts(j.DB, step, now.Add(-delay), prevSentBefore, notBefore, j.BatchSize)
+		if err != nil {
+			log.Printf("Error obteniendo carritos abandonados (recordatorio %d): %v", step+1, err)
+			continue
+		}
+		for i := range carts {
+			if ctx.Err() != nil {
+				return
+			}
+			if j.remind(&carts[i], step) {
+				sent++
+			}
+		}
+	}
+	if sent > 0 {
+		log.Printf("🛒 Recordatorios de carrito abandonado enviados: %d", sent)
+	}
+}
+
+// remind registra y envía un recordatorio; si el envío falla se libera para reintentarlo
+func (j *CartRecovery) remind(cart *db.AbandonedCart, step int) bool {
+	if cart.CouponID == nil && j.CouponPercent > 0 && step+1 >= j.CouponStep {
+		if err := j.issueCoupon(cart); err != nil {
+			log.Printf("Error generando cupón para el carrito %d: %v", cart.CartID, err)
+		}
+	}
+
+	recoveryID, err := db.RecordCartRecoveryEmail(j.DB, cart, step)
+	if err != nil {
+		log.Printf("Error registrando recordatorio del carrito %d: %v", cart.CartID, err)
+		return false
+	}
+	if recoveryID == 0 {
+		return false
+	}
+
+	reminder, err := j.reminder(cart, step, recoveryID)
+	if err == nil {
+		err = j.EmailService.SendCartReminder(cart.Email, *reminder)
+	}
+	if err != nil {
+		log.Printf("Error enviando recordatorio del carrito %d: %v", cart.CartID, err)
+		if err := db.DeleteCartRecoveryEmail(j.DB, recoveryID); err != nil {
+			log.Printf("Error liberando recordatorio %d: %v", recoveryID, err)
+		}
+		return false
+	}
+	return true
+}
+
+// reminder arma el contenido del email con el enlace firmado para recuperar el carrito
+func (j *CartRecovery) reminder(cart *db.AbandonedCart, step, recoveryID int) (*email.CartReminder, error) {
+	items, err := db.GetCartContents(j.DB, cart.CartID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := auth.GenerateCartRestoreToken(recoveryID, cart.UserID, j.LinkTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "https://axiora.pro"
+	}
+	reminder := &email.CartReminder{
+		Step:         step + 1,
+		Total:        cart.Value,
+		RestoreLink:  fmt.Sprintf("%s/cart?restore=%s", frontendURL, url.QueryEscape(token)),
+		SettingsLink: frontendURL + "/account/notifications",
+	}
+	if cart.Nombre != nil {
+		reminder.Name = *cart.Nombre
+	}
+	for _, item := range items {
+		if item.Product != nil {
+			reminder.Items = append(reminder.Items, fmt.Sprintf("%d × %s", item.Quantity, item.Product.Name))
+		}
+	}
+	if cart.CouponCode != "" {
+		if c, err := db.GetCouponByCode(j.DB, cart.CouponCode); err == nil && c.IsActive {
+			reminder.CouponCode = c.Code
+			reminder.CouponPercent = c.Value
+			if c.EndsAt != nil {
+				reminder.CouponEndsAt = *c.EndsAt
+			}
+		}
+	}
+	return reminder, nil
+}
+
+// issueCoupon genera un cupón de un solo uso para el carrito; los recordatorios siguientes del
+// mismo abandono repiten el mismo código
+func (j *CartRecovery) issueCoupon(cart *db.AbandonedCart) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	usageLimit, perCustomer := 1, 1
+	endsAt := time.Now().Add(j.CouponTTL)
+	c := &models.Coupon{
+		Code:             "VUELVE-" + strings.ToUpper(hex.EncodeToString(suffix)),
+		Description:      fmt.Sprintf("Recuperación del carrito %d", cart.CartID),
+		Type:             coupon.TypePercent,
+		Value:            j.CouponPercent,
+		UsageLimit:       &usageLimit,
+		PerCustomerLimit: &perCustomer,
+		EndsAt:           &endsAt,
+		IsActive:         true,
+	}
+	if err := db.CreateCoupon(j.DB, c); err != nil {
+		return err
+	}
+	cart.CouponID = &c.ID
+	cart.CouponCode = c.Code
+	return nil
+}
+
+// durationsFromEnv lee una lista de duraciones separadas por comas (ej. "1h,24h,72h"), que deben ir
+// en orden creciente
+func durationsFromEnv(key string, fallback []time.Duration) []time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	var durations []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 || (len(durations) > 0 && d <= durations[len(durations)-1]) {
+			log.Printf("⚠️  %s inválida (%q), usando la secuencia por defecto", key, value)
+			return fallback
+		}
+		durations = append(durations, d)
+	}
+	return durations
+}
+
+// percentFromEnv lee un porcentaje (0-100) de una variable de entorno
+func percentFromEnv(key string, fallback float64) float64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 100 {
+		log.Printf("⚠️  %s inválida (%q), usando %v", key, value, fallback)
+		return fallback
+	}
+	return f
+}
+
+// intFromEnv lee un entero positivo de una variable de entorno
+func intFromEnv(key string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("⚠️  %s inválida (%q), usando %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
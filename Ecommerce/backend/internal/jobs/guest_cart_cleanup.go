@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+)
+
+// GuestCartCleaner elimina los carritos de invitado abandonados que ya vencieron
+type GuestCartCleaner struct {
+	DB *pgxpool.Pool
+}
+
+// NewGuestCartCleaner crea el limpiador de carritos de invitado
+func NewGuestCartCleaner(db *pgxpool.Pool) *GuestCartCleaner {
+	return &GuestCartCleaner{DB: db}
+}
+
+// Start ejecuta la limpieza periódicamente hasta que se cancele el contexto
+func (j *GuestCartCleaner) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run elimina los carritos vencidos
+func (j *GuestCartCleaner) Run(ctx context.Context) {
+	deleted, err := db.DeleteExpiredGuestCarts(j.DB)
+	if err != nil {
+		log.Printf("Error limpiando carritos de invitado: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("🧹 Carritos de invitado vencidos eliminados: %d", deleted)
+	}
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+)
+
+// IdempotencyCleaner elimina las claves de idempotencia vencidas
+type IdempotencyCleaner struct {
+	DB *pgxpool.Pool
+}
+
+// NewIdempotencyCleaner crea el limpiador de claves de idempotencia
+func NewIdempotencyCleaner(db *pgxpool.Pool) *IdempotencyCleaner {
+	return &IdempotencyCleaner{DB: db}
+}
+
+// Start ejecuta la limpieza periódicamente hasta que se cancele el contexto
+func (j *IdempotencyCleaner) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run elimina las claves vencidas
+func (j *IdempotencyCleaner) Run(ctx context.Context) {
+	deleted, err := db.DeleteExpiredIdempotencyKeys(j.DB)
+	if err != nil {
+		log.Printf("Error limpiando claves de idempotencia: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("🧹 Claves de idempotencia vencidas eliminadas: %d", deleted)
+	}
+}
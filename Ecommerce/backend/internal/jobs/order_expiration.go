@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
+)
+
+// OrderExpirer cancela los pedidos que no se pagaron dentro de la ventana configurada
+type OrderExpirer struct {
+	DB              *pgxpool.Pool
+	Provider        payments.Provider
+	NotificationSvc *email.NotificationService
+	PaymentWindow   time.Duration // Tiempo máximo para pagar un pedido
+	ReminderBefore  time.Duration // Anticipación del recordatorio respecto al vencimiento
+}
+
+// NewOrderExpirer crea el cancelador de pedidos usando Stripe como procesador
+func NewOrderExpirer(db *pgxpool.Pool) *OrderExpirer {
+	return &OrderExpirer{
+		DB:              db,
+		Provider:        payments.NewStripeProvider(),
+		NotificationSvc: email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink),
+		PaymentWindow:   DurationFromEnv("ORDER_PAYMENT_WINDOW", 24*time.Hour),
+		ReminderBefore:  DurationFromEnv("ORDER_PAYMENT_REMINDER_BEFORE", 2*time.Hour),
+	}
+}
+
+// Start ejecuta la revisión periódicamente hasta que se cancele el contexto
+func (e *OrderExpirer) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run envía los recordatorios pendientes y cancela los pedidos vencidos
+func (e *OrderExpirer) Run(ctx context.Context) {
+	now := time.Now()
+
+	if e.ReminderBefore > 0 && e.ReminderBefore < e.PaymentWindow {
+		orders, err := db.GetUnpaidOrdersCreatedBefore(e.DB, now.Add(-(e.PaymentWindow - e.ReminderBefore)), true)
+		if err != nil {
+			log.Printf("Error obteniendo pedidos para recordatorio: %v", err)
+		}
+		for _, order := range orders {
+			if order.CreatedAt.Before(now.Add(-e.PaymentWindow)) {
+				// Ya venció: se cancela abajo sin recordatorio
+				continue
+			}
+			deadline := order.CreatedAt.Add(e.PaymentWindow)
+			if err := e.NotificationSvc.CreatePaymentReminderNotification(ctx, order.UserID, order.ID, order.OrderNumber, deadline); err != nil {
+				log.Printf("Error enviando recordatorio de pago del pedido %d: %v", order.ID, err)
+				continue
+			}
+			if err := db.MarkPaymentReminderSent(e.DB, order.ID); err != nil {
+				log.Printf("Error marcando recordatorio del pedido %d: %v", order.ID, err)
+			}
+		}
+	}
+
+	expired, err := db.GetUnpaidOrdersCreatedBefore(e.DB, now.Add(-e.PaymentWindow), false)
+	if err != nil {
+		log.Printf("Error obteniendo pedidos vencidos: %v", err)
+		return
+	}
+	for i := range expired {
+		e.expire(ctx, &expired[i])
+	}
+}
+
+// finalPaymentStatuses son los estados de pago cuyo PaymentIntent ya no se puede confirmar. Los demás
+// (pending, failed, requires_payment_method, ...) siguen vivos en Stripe: el cliente podría pagarlos
+// después de cancelar el pedido y reponer su stock.
+var finalPaymentStatuses = map[string]bool{
+	"succeeded":          true,
+	"refunded":           true,
+	"partially_refunded": true,
+	"canceled":           true,
+}
+
+// expire cancela los pagos abiertos del pedido y luego el pedido. Si algo falla el pedido queda
+// marcado y se reintenta más tarde sin bloquear a los siguientes.
+func (e *OrderExpirer) expire(ctx context.Context, order *models.Order) {
+	if !e.cancelPayments(ctx, order) {
+		e.retryLater(order.ID)
+		return
+	}
+
+	cancelled, err := db.CancelUnpaidOrder(e.DB, order.ID, "Cancelado automáticamente por falta de pago", "system")
+	if err != nil {
+		log.Printf("Error cancelando pedido %d: %v", order.ID, err)
+		e.retryLater(order.ID)
+		return
+	}
+	if !cancelled {
+		return
+	}
+
+	log.Printf("Pedido %d cancelado automáticamente por falta de pago", order.ID)
+	if err := e.NotificationSvc.CreateOrderExpiredNotification(ctx, order.UserID, order.ID, order.OrderNumber); err != nil {
+		log.Printf("Error enviando notificación de cancelación del pedido %d: %v", order.ID, err)
+	}
+}
+
+// retryLater aparta el pedido de las próximas revisiones durante un rato
+func (e *OrderExpirer) retryLater(orderID int) {
+	if err := db.MarkOrderExpirationFailed(e.DB, orderID); err != nil {
+		log.Printf("Error marcando pedido %d: %v", orderID, err)
+	}
+}
+
+// cancelPayments cancela en el procesador todos los PaymentIntent del pedido que aún se pueden
+// confirmar. Devuelve false si alguno no se pudo cancelar: el pedido no se cancela para no reponer
+// el stock de algo que todavía se puede cobrar.
+func (e *OrderExpirer) cancelPayments(ctx context.Context, order *models.Order) bool {
+	orderPayments, err := db.GetOrderPayments(e.DB, order.ID)
+	if err != nil {
+		log.Printf("Error obteniendo pagos del pedido %d: %v", order.ID, err)
+		return false
+	}
+
+	for i := range orderPayments {
+		payment := &orderPayments[i]
+		if finalPaymentStatuses[payment.Status] || payment.StripePaymentIntentID == "" {
+			continue
+		}
+
+		if _, err := e.Provider.CancelPaymentIntent(ctx, payment.StripePaymentIntentID); err != nil {
+			// Si el intent ya fue cobrado no se puede cancelar: la conciliación marcará el pedido como pagado
+			current, getErr := e.Provider.GetPaymentIntent(ctx, payment.StripePaymentIntentID)
+			if getErr != nil || current.Status != "canceled" {
+				log.Printf("No se canceló el pedido %d: el PaymentIntent %s no pudo cancelarse: %v", order.ID, payment.StripePaymentIntentID, err)
+				return false
+			}
+		}
+
+		payment.Status = "canceled"
+		payment.ErrorMessage = "Cancelado automáticamente por falta de pago"
+		payment.UpdatedAt = time.Now()
+		if err := db.UpdatePayment(e.DB, payment); err != nil {
+			log.Printf("Error actualizando pago %d: %v", payment.ID, err)
+		}
+	}
+	return true
+}
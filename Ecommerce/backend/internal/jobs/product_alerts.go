@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// ProductAlerts envía los avisos de vuelta de stock y bajada de precio encolados por los cambios de
+// producto. Cada ejecución procesa un lote acotado: un cambio con más suscriptores que el lote se
+// sigue avisando en las ejecuciones siguientes.
+type ProductAlerts struct {
+	DB              *pgxpool.Pool
+	NotificationSvc *email.NotificationService
+	Favorites       bool          // Avisar también a quien tiene el producto en favoritos
+	MinDropPercent  float64       // Bajada mínima de precio para avisar sin precio objetivo
+	Cooldown        time.Duration // Tiempo mínimo entre avisos del mismo producto y tipo a un usuario
+	DailyLimit      int           // Avisos máximos por usuario en 24 horas
+	BatchSize       int           // Usuarios por cambio y ejecución
+	MaxPerRun       int           // Avisos máximos por ejecución
+}
+
+// NewProductAlerts crea el job de alertas de producto con la configuración del entorno
+func NewProductAlerts(db *pgxpool.Pool) *ProductAlerts {
+	return &ProductAlerts{
+		DB:              db,
+		NotificationSvc: email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink),
+		Favorites:       boolFromEnv("PRODUCT_ALERTS_FAVORITES", true),
+		MinDropPercent:  percentFromEnv("PRODUCT_ALERTS_MIN_DROP_PERCENT", 5),
+		Cooldown:        DurationFromEnv("PRODUCT_ALERTS_COOLDOWN", 24*time.Hour),
+		DailyLimit:      intFromEnv("PRODUCT_ALERTS_DAILY_LIMIT", 5),
+		BatchSize:       100,
+		MaxPerRun:       intFromEnv("PRODUCT_ALERTS_MAX_PER_RUN", 500),
+	}
+}
+
+// Start ejecuta el envío periódicamente hasta que se cancele el contexto
+func (j *ProductAlerts) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run avisa de los cambios pendientes, del más antiguo al más reciente
+func (j *ProductAlerts) Run(ctx context.Context) {
+	events, err := db.GetPendingProductAlertEvents(j.DB, 50)
+	if err != nil {
+		log.Printf("Error obteniendo cambios de producto pendientes: %v", err)
+		return
+	}
+
+	sent := 0
+	for i := range events {
+		if ctx.Err() != nil || sent >= j.MaxPerRun {
+			break
+		}
+		e := &events[i]
+		n, done := j.notify(ctx, e, j.MaxPerRun-sent)
+		sent += n
+		if done {
+			if err := db.MarkProductAlertEventProcessed(j.DB, e.ID); err != nil {
+				log.Printf("Error marcando cambio de producto %d: %v", e.ID, err)
+			}
+		}
+	}
+	if sent > 0 {
+		log.Printf("🔔 Alertas de producto enviadas: %d", sent)
+	}
+}
+
+// notify avisa a un lote de suscriptores del cambio; done indica que ya no queda nadie por avisar
+func (j *ProductAlerts) notify(ctx context.Context, e *db.ProductAlertEvent, budget int) (sent int, done bool) {
+	// Si el cambio ya no es cierto (se volvió a agotar o subió el precio) no se avisa
+	if !e.IsActive ||
+		(e.Kind == models.ProductAlertBackInStock && e.Stock <= 0) ||
+		(e.Kind == models.ProductAlertPriceDrop && e.CurrentPrice > e.NewPrice) {
+		return 0, true
+	}
+
+	notificationType := "stock"
+	if e.Kind == models.ProductAlertPriceDrop {
+		notificationType = "price"
+	}
+	limit := j.BatchSize
+	if budget < limit {
+		limit = budget
+	}
+	userIDs, err := db.GetProductAlertRecipients(j.DB, e, notificationType, db.ProductAlertRecipientsFilter{
+		Favorites:      j.Favorites,
+		MinDropPercent: j.MinDropPercent,
+		Cooldown:       j.Cooldown,
+		DailyLimit:     j.DailyLimit,
+		Limit:          limit,
+	})
+	if err != nil {
+		log.Printf("Error obteniendo suscriptores del producto %d: %v", e.ProductID, err)
+		return 0, false
+	}
+
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return sent, false
+		}
+		claimed, err := db.RecordProductAlertDelivery(j.DB, e, userID)
+		if err != nil {
+			log.Printf("Error registrando alerta del producto %d para usuario %d: %v", e.ProductID, userID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if e.Kind == models.ProductAlertPriceDrop {
+			err = j.NotificationSvc.CreatePriceDropNotification(ctx, userID, e.ProductID, e.ProductName, e.OldPrice, e.NewPrice)
+		} else {
+			err = j.NotificationSvc.CreateStockNotification(ctx, userID, e.ProductID, e.ProductName)
+		}
+		if err != nil {
+			log.Printf("Error enviando alerta del producto %d a usuario %d: %v", e.ProductID, userID, err)
+			if err := db.DeleteProductAlertDelivery(j.DB, e.ID, userID); err != nil {
+				log.Printf("Error liberando alerta del producto %d: %v", e.ProductID, err)
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, len(userIDs) < limit
+}
+
+// boolFromEnv lee un booleano de una variable de entorno
+func boolFromEnv(key string, fallback bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️  %s inválida (%q), usando %v", key, value, fallback)
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,412 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/payments"
+)
+
+// Reconciler compara los pagos del procesador con la base de datos y corrige las diferencias seguras
+type Reconciler struct {
+	DB              *pgxpool.Pool
+	Provider        payments.Provider
+	NotificationSvc *email.NotificationService
+	Lookback        time.Duration
+}
+
+// NewReconciler crea un conciliador usando Stripe como procesador
+func NewReconciler(db *pgxpool.Pool) *Reconciler {
+	return &Reconciler{
+		DB:              db,
+		Provider:        payments.NewStripeProvider(),
+		NotificationSvc: email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink),
+		Lookback:        DurationFromEnv("RECONCILIATION_LOOKBACK", 72*time.Hour),
+	}
+}
+
+// Start ejecuta la conciliación periódicamente hasta que se cancele el contexto
+func (r *Reconciler) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := r.Run(ctx, "scheduler"); err != nil {
+					log.Printf("Error en conciliación de pagos: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Run ejecuta una conciliación completa y guarda el reporte
+func (r *Reconciler) Run(ctx context.Context, trigger string) (*models.ReconciliationRun, error) {
+	now := time.Now()
+	run := &models.ReconciliationRun{
+		Trigger:     trigger,
+		Status:      "running",
+		WindowStart: now.Add(-r.Lookback),
+		StartedAt:   now,
+	}
+	if err := db.CreateReconciliationRun(r.DB, run); err != nil {
+		return nil, err
+	}
+
+	runErr := r.reconcile(ctx, run)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = "completed"
+	if runErr != nil {
+		run.Status = "failed"
+		run.ErrorMessage = runErr.Error()
+	}
+	if err := db.FinishReconciliationRun(r.DB, run); err != nil {
+		log.Printf("Error guardando resultado de conciliación %d: %v", run.ID, err)
+	}
+
+	log.Printf("Conciliación %d (%s): %d intents, %d cargos, %d corregidos, %d discrepancias",
+		run.ID, run.Status, run.IntentsChecked, run.ChargesChecked, run.FixedCount, run.DiscrepancyCount)
+
+	if run.DiscrepancyCount > run.FixedCount || runErr != nil {
+		details := fmt.Sprintf("Conciliación #%d: %d discrepancias sin resolver, %d corregidas automáticamente.",
+			run.ID, run.DiscrepancyCount-run.FixedCount, run.FixedCount)
+		if runErr != nil {
+			details = fmt.Sprintf("Conciliación #%d falló: %v", run.ID, runErr)
+		}
+		if err := r.NotificationSvc.CreateAdminNotification(ctx, "Conciliación de pagos", details, "high"); err != nil {
+			log.Printf("Error notificando conciliación a admins: %v", err)
+		}
+	}
+
+	return run, runErr
+}
+
+// reconcile recorre los intents, cargos y pagos locales de la ventana
+func (r *Reconciler) reconcile(ctx context.Context, run *models.ReconciliationRun) error {
+	intents, err := r.Provider.ListPaymentIntents(ctx, run.WindowStart)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(intents))
+	for _, intent := range intents {
+		seen[intent.ID] = true
+		run.IntentsChecked++
+		r.checkIntent(ctx, run, intent)
+	}
+
+	// Pagos pendientes locales que el listado no incluyó (creados antes de la ventana del procesador)
+	pending, err := db.GetPendingStripePayments(r.DB, run.WindowStart, run.StartedAt)
+	if err != nil {
+		return err
+	}
+	for _, payment := range pending {
+		if seen[payment.StripePaymentIntentID] {
+			continue
+		}
+		intent, err := r.Provider.GetPaymentIntent(ctx, payment.StripePaymentIntentID)
+		if err != nil {
+			log.Printf("Error consultando PaymentIntent %s: %v", payment.StripePaymentIntentID, err)
+			continue
+		}
+		run.IntentsChecked++
+		r.checkIntent(ctx, run, *intent)
+	}
+
+	charges, err := r.Provider.ListCharges(ctx, run.WindowStart)
+	if err != nil {
+		return err
+	}
+	for _, ch := range charges {
+		run.ChargesChecked++
+		r.checkCharge(ctx, run, ch)
+	}
+
+	return r.checkPaidOrders(run)
+}
+
+// checkIntent compara un PaymentIntent con el pago y pedido locales
+func (r *Reconciler) checkIntent(ctx context.Context, run *models.ReconciliationRun, intent payments.Intent) {
+	d := &models.ReconciliationDiscrepancy{
+		PaymentIntentID: intent.ID,
+		ProviderStatus:  intent.Status,
+		ProviderAmount:  payments.FromCents(intent.Amount),
+	}
+
+	payment, err := db.GetPaymentByStripeIntentID(r.DB, intent.ID)
+	if err != nil {
+		if intent.Status != "succeeded" {
+			return
+		}
+		r.checkMissingPayment(ctx, run, intent, d)
+		return
+	}
+
+	d.PaymentID = &payment.ID
+	d.OrderID = &payment.OrderID
+	d.LocalStatus = payment.Status
+	d.LocalAmount = payment.Amount
+
+	if payments.ToCents(payment.Amount) != intent.Amount {
+		d.Kind = "amount_mismatch"
+		r.record(run, d)
+		return
+	}
+
+	order, err := db.GetOrderByID(r.DB, payment.OrderID)
+	if err != nil {
+		d.Kind = "orphan_payment"
+		r.record(run, d)
+		return
+	}
+
+	missedSuccess := intent.Status == "succeeded" && payment.Status != "succeeded" && payment.Status != "refunded"
+	orderNotPaid := intent.Status == "succeeded" && payment.Status == "succeeded" &&
+		order.PaymentStatus != "paid" && order.PaymentStatus != "refunded" && order.PaymentStatus != "partially_refunded"
+
+	switch {
+	case (missedSuccess || orderNotPaid) && order.Status == "cancelled":
+		r.flagCancelledOrderCharge(ctx, run, d, order)
+	case missedSuccess:
+		d.Kind = "missed_success"
+		d.Resolved = r.markPaid(ctx, payment, order)
+		d.Action = "pago marcado como exitoso y pedido como pagado"
+		r.record(run, d)
+	case orderNotPaid:
+		d.Kind = "order_not_paid"
+		d.LocalStatus = order.PaymentStatus
+		d.Resolved = db.UpdateOrderPaymentStatus(r.DB, order.ID, "paid") == nil
+		d.Action = "pedido marcado como pagado"
+		r.record(run, d)
+	case intent.Status == "canceled" && payment.Status == "pending":
+		d.Kind = "missed_cancellation"
+		payment.Status = "canceled"
+		payment.UpdatedAt = time.Now()
+		d.Resolved = db.UpdatePayment(r.DB, payment) == nil
+		d.Action = "pago marcado como cancelado"
+		r.record(run, d)
+	case intent.Status != "succeeded" && payment.Status == "succeeded":
+		d.Kind = "unexpected_success"
+		r.record(run, d)
+	}
+}
+
+// checkMissingPayment maneja un intent exitoso sin pago local
+func (r *Reconciler) checkMissingPayment(ctx context.Context, run *models.ReconciliationRun, intent payments.Intent, d *models.ReconciliationDiscrepancy) {
+	if intent.OrderID == 0 {
+		d.Kind = "orphan_intent"
+		r.record(run, d)
+		return
+	}
+	d.OrderID = &intent.OrderID
+
+	order, err := db.GetOrderByID(r.DB, intent.OrderID)
+	if err != nil {
+		d.Kind = "orphan_intent"
+		r.record(run, d)
+		return
+	}
+	d.LocalStatus = order.PaymentStatus
+	d.LocalAmount = order.Total
+
+	if order.PaymentStatus == "paid" {
+		// El pedido ya fue pagado con otro intent: posible doble cobro
+		d.Kind = "duplicate_charge"
+		r.record(run, d)
+		return
+	}
+	if order.Status == "cancelled" {
+		r.flagCancelledOrderCharge(ctx, run, d, order)
+		return
+	}
+	if payments.ToCents(order.Total) != intent.Amount {
+		d.Kind = "amount_mismatch"
+		r.record(run, d)
+		return
+	}
+
+	payment := &models.Payment{
+		OrderID:               order.ID,
+		PaymentMethod:         "stripe",
+		Amount:                payments.FromCents(intent.Amount),
+		Currency:              intent.Currency,
+		Status:                "pending",
+		StripePaymentIntentID: intent.ID,
+		CreatedAt:             time.Now(),
+		UpdatedAt:             time.Now(),
+	}
+	d.Kind = "missing_payment"
+	d.Action = "pago creado y pedido marcado como pagado"
+	if err := db.SavePayment(r.DB, payment); err == nil {
+		d.PaymentID = &payment.ID
+		d.Resolved = r.markPaid(ctx, payment, order)
+	} else {
+		log.Printf("Error creando pago para PaymentIntent %s: %v", intent.ID, err)
+	}
+	r.record(run, d)
+}
+
+// checkCharge detecta reembolsos que no se reflejaron localmente
+func (r *Reconciler) checkCharge(ctx context.Context, run *models.ReconciliationRun, ch payments.Charge) {
+	if ch.AmountRefunded == 0 || ch.PaymentIntentID == "" {
+		return
+	}
+
+	payment, err := db.GetPaymentByStripeIntentID(r.DB, ch.PaymentIntentID)
+	if err != nil || payment.Status == "refunded" {
+		return
+	}
+
+	d := &models.ReconciliationDiscrepancy{
+		PaymentIntentID: ch.PaymentIntentID,
+		ChargeID:        ch.ID,
+		PaymentID:       &payment.ID,
+		OrderID:         &payment.OrderID,
+		ProviderStatus:  "refunded",
+		LocalStatus:     payment.Status,
+		ProviderAmount:  payments.FromCents(ch.AmountRefunded),
+		LocalAmount:     payment.Amount,
+	}
+
+	if !ch.Refunded {
+		// Los reembolsos parciales registrados localmente (devoluciones) ya están conciliados
+		if local, err := db.GetRefundedAmount(r.DB, payment.ID); err == nil && payments.ToCents(local) == ch.AmountRefunded {
+			return
+		}
+		// Los reembolsos parciales requieren revisión manual
+		d.Kind = "partial_refund"
+		d.ProviderStatus = "partially_refunded"
+		r.record(run, d)
+		return
+	}
+
+	d.Kind = "missed_refund"
+	d.Action = "pago y pedido marcados como reembolsados"
+	payment.Status = "refunded"
+	payment.UpdatedAt = time.Now()
+	if err := db.UpdatePayment(r.DB, payment); err == nil {
+		d.Resolved = db.UpdateOrderPaymentStatus(r.DB, payment.OrderID, "refunded") == nil
+		if order, err := db.GetOrderByID(r.DB, payment.OrderID); err == nil {
+			amount := fmt.Sprintf("%.2f", payment.Amount)
+			if err := r.NotificationSvc.CreatePaymentNotification(ctx, order.UserID, order.ID, amount, order.Currency, "reembolsado"); err != nil {
+				log.Printf("Error creando notificación de reembolso: %v", err)
+			}
+		}
+	}
+	r.record(run, d)
+}
+
+// checkPaidOrders reporta pedidos pagados sin un pago exitoso registrado
+func (r *Reconciler) checkPaidOrders(run *models.ReconciliationRun) error {
+	orderIDs, err := db.GetPaidOrderIDsSince(r.DB, run.WindowStart)
+	if err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		orderPayments, err := db.GetOrderPayments(r.DB, orderID)
+		if err != nil {
+			log.Printf("Error obteniendo pagos del pedido %d: %v", orderID, err)
+			continue
+		}
+		succeeded := false
+		for _, p := range orderPayments {
+			if p.Status == "succeeded" {
+				succeeded = true
+				break
+			}
+		}
+		if !succeeded {
+			id := orderID
+			r.record(run, &models.ReconciliationDiscrepancy{
+				Kind:        "paid_without_payment",
+				OrderID:     &id,
+				LocalStatus: "paid",
+			})
+		}
+	}
+	return nil
+}
+
+// flagCancelledOrderCharge reporta un cobro exitoso de un pedido que ya se canceló. El stock ya se
+// liberó, así que no se marca como pagado: un admin decide si reembolsar o reactivar el pedido.
+func (r *Reconciler) flagCancelledOrderCharge(ctx context.Context, run *models.ReconciliationRun, d *models.ReconciliationDiscrepancy, order *models.Order) {
+	d.Kind = "paid_cancelled_order"
+	d.LocalStatus = order.Status
+	r.record(run, d)
+
+	details := fmt.Sprintf("El pedido #%s está cancelado y su stock fue liberado, pero el PaymentIntent %s se cobró (%.2f %s). Revisa si reembolsar o reactivar el pedido.",
+		order.OrderNumber, d.PaymentIntentID, d.ProviderAmount, order.Currency)
+	if err := r.NotificationSvc.CreateAdminNotification(ctx, "Cobro de pedido cancelado", details, "urgent"); err != nil {
+		log.Printf("Error notificando cobro del pedido cancelado %d: %v", order.ID, err)
+	}
+}
+
+// markPaid marca el pago como exitoso, el pedido como pagado y avisa al cliente
+func (r *Reconciler) markPaid(ctx context.Context, payment *models.Payment, order *models.Order) bool {
+	payment.Status = "succeeded"
+	payment.TransactionID = payment.StripePaymentIntentID
+	payment.UpdatedAt = time.Now()
+	if err := db.UpdatePayment(r.DB, payment); err != nil {
+		log.Printf("Error actualizando pago %d: %v", payment.ID, err)
+		return false
+	}
+	if err := db.UpdateOrderPaymentStatus(r.DB, order.ID, "paid"); err != nil {
+		log.Printf("Error actualizando pedido %d: %v", order.ID, err)
+		return false
+	}
+
+	amount := fmt.Sprintf("%.2f", payment.Amount)
+	if err := r.NotificationSvc.CreatePaymentNotification(ctx, order.UserID, order.ID, amount, order.Currency, "confirmado"); err != nil {
+		log.Printf("Error creando notificación de pago: %v", err)
+	}
+	return true
+}
+
+// record guarda la discrepancia y actualiza los contadores de la ejecución
+func (r *Reconciler) record(run *models.ReconciliationRun, d *models.ReconciliationDiscrepancy) {
+	d.RunID = run.ID
+	if d.Action == "" || !d.Resolved {
+		if d.Action != "" {
+			d.Action = "fallo al aplicar: " + d.Action
+		} else {
+			d.Action = "revisión manual"
+		}
+	}
+	if err := db.SaveReconciliationDiscrepancy(r.DB, d); err != nil {
+		log.Printf("Error guardando discrepancia %s: %v", d.Kind, err)
+	}
+
+	run.DiscrepancyCount++
+	if d.Resolved {
+		run.FixedCount++
+	}
+	run.Discrepancies = append(run.Discrepancies, *d)
+}
+
+// DurationFromEnv lee una duración de una variable de entorno con valor por defecto
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️  %s inválida (%q), usando %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
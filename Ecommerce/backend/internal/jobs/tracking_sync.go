@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
+)
+
+// TrackingSyncer consulta periódicamente a la paquetería los envíos en curso
+type TrackingSyncer struct {
+	DB        *pgxpool.Pool
+	Carrier   shipping.Carrier
+	Tracking  *shipping.TrackingService
+	BatchSize int // Envíos revisados por ejecución
+}
+
+// NewTrackingSyncer crea el sincronizador con la paquetería configurada
+func NewTrackingSyncer(db *pgxpool.Pool, carrier shipping.Carrier) *TrackingSyncer {
+	return &TrackingSyncer{
+		DB:        db,
+		Carrier:   carrier,
+		Tracking:  shipping.NewTrackingService(db),
+		BatchSize: 100,
+	}
+}
+
+// Start ejecuta la sincronización periódicamente hasta que se cancele el contexto
+func (t *TrackingSyncer) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run actualiza el rastreo de los envíos activos
+func (t *TrackingSyncer) Run(ctx context.Context) {
+	shipments, err := db.GetActiveShipments(t.DB, t.BatchSize)
+	if err != nil {
+		log.Printf("Error obteniendo envíos activos: %v", err)
+		return
+	}
+
+	updated := 0
+	for i := range shipments {
+		shipment := &shipments[i]
+		if shipment.Carrier != t.Carrier.Name() {
+			continue
+		}
+		if err := t.Tracking.Refresh(ctx, t.Carrier, shipment); err != nil {
+			log.Printf("Error sincronizando envío %d: %v", shipment.ID, err)
+			continue
+		}
+		updated++
+	}
+	if updated > 0 {
+		log.Printf("📦 Rastreo sincronizado para %d envíos", updated)
+	}
+}
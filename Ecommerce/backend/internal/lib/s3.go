@@ -1,10 +1,13 @@
 package lib
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,6 +15,15 @@ import (
 )
 
 func UploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key string) (string, error) {
+	return uploadToS3(file, key, fileHeader.Header.Get("Content-Type"))
+}
+
+// UploadBytesToS3 sube contenido generado en memoria (etiquetas, PDFs, etc.)
+func UploadBytesToS3(data []byte, key, contentType string) (string, error) {
+	return uploadToS3(bytes.NewReader(data), key, contentType)
+}
+
+func uploadToS3(body io.Reader, key, contentType string) (string, error) {
 	bucket := os.Getenv("AWS_S3_BUCKET")
 	region := os.Getenv("AWS_REGION")
 
@@ -31,8 +43,8 @@ func UploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key s
 	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
-		Body:        file,
-		ContentType: aws.String(fileHeader.Header.Get("Content-Type")),
+		Body:        body,
+		ContentType: aws.String(contentType),
 	})
 	if err != nil {
 		return "", fmt.Errorf("error uploading to S3: %w", err)
@@ -46,3 +58,36 @@ func UploadFileToS3(file multipart.File, fileHeader *multipart.FileHeader, key s
 
 	return url, nil
 }
+
+// DeleteObjectFromS3 borra un objeto del bucket. Borrar una clave que no existe no es un error.
+func DeleteObjectFromS3(key string) error {
+	bucket := os.Getenv("AWS_S3_BUCKET")
+	region := os.Getenv("AWS_REGION")
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+	)
+	if err != nil {
+		return fmt.Errorf("error loading AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg)
+	_, err = client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting from S3: %w", err)
+	}
+	return nil
+}
+
+// S3KeyFromURL obtiene la clave de un objeto a partir de la URL pública que devuelve uploadToS3;
+// ok es false si la URL no es del bucket configurado
+func S3KeyFromURL(url string) (key string, ok bool) {
+	prefix := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", os.Getenv("AWS_S3_BUCKET"), os.Getenv("AWS_REGION"))
+	if !strings.HasPrefix(url, prefix) || len(url) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, prefix), true
+}
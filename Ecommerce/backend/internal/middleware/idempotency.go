@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// IdempotencyHeader es el header con la clave de idempotencia enviada por el cliente
+const IdempotencyHeader = "Idempotency-Key"
+
+// Idempotency evita procesar dos veces la misma petición (doble clic o reintentos de red).
+// La respuesta se guarda en Postgres y se repite mientras la clave no expire.
+type Idempotency struct {
+	DB       *pgxpool.Pool
+	TTL      time.Duration // Tiempo que se conserva la respuesta
+	Lease    time.Duration // Reserva de la clave mientras se procesa; se renueva hasta terminar
+	Wait     time.Duration // Espera máxima cuando otra petición con la misma clave está en curso
+	Interval time.Duration // Frecuencia de consulta mientras se espera
+}
+
+// NewIdempotency crea el middleware con una retención de 24h. Si el proceso se cae a mitad de una
+// petición, su clave se puede reintentar en cuanto vence el lease de 1 minuto.
+func NewIdempotency(db *pgxpool.Pool) *Idempotency {
+	return &Idempotency{
+		DB:       db,
+		TTL:      24 * time.Hour,
+		Lease:    time.Minute,
+		Wait:     10 * time.Second,
+		Interval: 100 * time.Millisecond,
+	}
+}
+
+// Handler devuelve el middleware de gin. Las peticiones sin header se procesan normalmente.
+func (m *Idempotency) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key demasiado larga (máximo 255 caracteres)"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Error leyendo body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// La clave se aplica por usuario y ruta; la huella detecta reutilizaciones con otro body
+		scope := fmt.Sprintf("%s:%s:%s", idempotencyOwner(c), c.Request.Method, c.FullPath())
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		rec := &models.IdempotencyRecord{Key: key, Scope: scope, Fingerprint: hex.EncodeToString(sum[:])}
+
+		deadline := time.Now().Add(m.Wait)
+		for {
+			claimed, existing, err := db.ClaimIdempotencyKey(m.DB, rec, m.TTL, m.Lease)
+			if err != nil {
+				log.Printf("Error reservando Idempotency-Key: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error procesando Idempotency-Key"})
+				return
+			}
+			if claimed {
+				break
+			}
+			if existing.Fingerprint != rec.Fingerprint {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "La Idempotency-Key ya se usó con una petición diferente"})
+				return
+			}
+			if existing.Status == "completed" {
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.ResponseStatus, existing.ContentType, existing.ResponseBody)
+				c.Abort()
+				return
+			}
+			// Otra petición con la misma clave está en curso: esperar su resultado o a que venza su lease
+			if time.Now().After(deadline) {
+				retry := max(time.Until(existing.LockedUntil), time.Second)
+				c.Header("Retry-After", strconv.Itoa(int(retry.Seconds()+0.5)))
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Hay una petición con la misma Idempotency-Key en proceso"})
+				return
+			}
+			select {
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			case <-time.After(m.Interval):
+			}
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// Renovar la reserva mientras el handler trabaja, para que nadie la retome por lenta
+		done := make(chan struct{})
+		go m.keepLease(rec, done)
+		defer close(done)
+
+		// Si el handler entra en pánico la clave se libera para que el cliente pueda reintentar
+		defer func() {
+			if r := recover(); r != nil {
+				if err := db.ReleaseIdempotencyKey(m.DB, rec.Scope, rec.Key); err != nil {
+					log.Printf("Error liberando Idempotency-Key: %v", err)
+				}
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		// Los errores del servidor no se guardan para que el cliente pueda reintentar
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := db.ReleaseIdempotencyKey(m.DB, rec.Scope, rec.Key); err != nil {
+				log.Printf("Error liberando Idempotency-Key: %v", err)
+			}
+			return
+		}
+
+		rec.ResponseStatus = writer.Status()
+		rec.ResponseBody = writer.body.Bytes()
+		rec.ContentType = writer.Header().Get("Content-Type")
+		if err := db.CompleteIdempotencyKey(m.DB, rec); err != nil {
+			log.Printf("Error guardando respuesta idempotente: %v", err)
+		}
+	}
+}
+
+// keepLease renueva el lease de la clave cada medio lease hasta que se cierre done
+func (m *Idempotency) keepLease(rec *models.IdempotencyRecord, done <-chan struct{}) {
+	ticker := time.NewTicker(m.Lease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := db.ExtendIdempotencyLease(m.DB, rec.Scope, rec.Key, m.Lease); err != nil {
+				log.Printf("Error renovando Idempotency-Key: %v", err)
+			}
+		}
+	}
+}
+
+// captureWriter copia el body de la respuesta mientras se escribe al cliente
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyOwner identifica a quien hace la petición. Los invitados no tienen user_id, así que
+// se usa una huella del token de su carrito o pedido para que sus claves no choquen entre sí.
+func idempotencyOwner(c *gin.Context) string {
+	if userID := c.GetInt("user_id"); userID != 0 {
+		return strconv.Itoa(userID)
+	}
+	guest := c.GetHeader(auth.CartTokenHeader) + "|" + c.GetHeader(auth.OrderTokenHeader) + "|" + c.Query("token")
+	sum := sha256.Sum256([]byte(guest))
+	return "guest-" + hex.EncodeToString(sum[:8])
+}
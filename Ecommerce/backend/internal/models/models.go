@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -116,6 +117,163 @@ type Product struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Featured     bool      `json:"featured"`
+	// Solo en resultados de búsqueda: nombre y fragmento de la descripción escapados como HTML y
+	// con los términos en <mark>
+	HighlightedName *string `json:"highlighted_name,omitempty"`
+	Snippet         *string `json:"snippet,omitempty"`
+	// Solo en el detalle del producto
+	Attributes  []ProductAttribute   `json:"attributes,omitempty"`
+	Options     []ProductOption      `json:"options,omitempty"`
+	Variants    []ProductVariant     `json:"variants,omitempty"`
+	Breadcrumbs []CategoryBreadcrumb `json:"breadcrumbs,omitempty"`
+	Media       []ProductMedia       `json:"media,omitempty"`
+	ImageSet    *ImageSet            `json:"image_set,omitempty"` // Tamaños de la imagen principal
+}
+
+// Tipos de media de producto
+const (
+	ProductMediaImage = "image"
+	ProductMediaModel = "model" // Modelo 3D (.glb / .gltf)
+	ProductMediaVideo = "video"
+)
+
+// ProductMedia es una imagen, modelo 3D o video de la galería del producto. La imagen principal
+// se copia en Product.ImageURL y el primer modelo en Product.ModelURL.
+type ProductMedia struct {
+	ID          int       `json:"id"`
+	ProductID   int       `json:"product_id"`
+	Kind        string    `json:"kind"`
+	URL         string    `json:"url"`
+	StorageKey  *string   `json:"-"` // Clave en S3; nil para URLs externas
+	AltText     string    `json:"alt_text"`
+	Position    int       `json:"position"`
+	IsPrimary   bool      `json:"is_primary"`
+	ContentType *string   `json:"content_type,omitempty"`
+	SizeBytes   *int64    `json:"size_bytes,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Solo imágenes procesadas: cada tamaño y formato generado y todas sus claves en S3
+	Variants    []ImageVariant `json:"-"`
+	StorageKeys []string       `json:"-"`
+	ImageSet    *ImageSet      `json:"image_set,omitempty"`
+}
+
+// ImageVariant es un tamaño y formato generado de una imagen (ej. card en WebP)
+type ImageVariant struct {
+	Size   string `json:"size"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// ImageSet agrupa las versiones de una imagen para usarlas directamente en <picture>/srcset
+type ImageSet struct {
+	Sizes  map[string]map[string]string `json:"sizes"`  // tamaño -> formato -> URL
+	Widths map[string]int               `json:"widths"` // tamaño -> ancho en píxeles
+	Srcset map[string]string            `json:"srcset"` // formato -> "url 160w, url 480w, ..."
+}
+
+// NewImageSet arma el ImageSet de las versiones de una imagen; nil si no tiene
+func NewImageSet(variants []ImageVariant) *ImageSet {
+	if len(variants) == 0 {
+		return nil
+	}
+	set := &ImageSet{
+		Sizes:  map[string]map[string]string{},
+		Widths: map[string]int{},
+		Srcset: map[string]string{},
+	}
+	for _, v := range variants {
+		if set.Sizes[v.Size] == nil {
+			set.Sizes[v.Size] = map[string]string{}
+		}
+		set.Sizes[v.Size][v.Format] = v.URL
+		set.Widths[v.Size] = v.Width
+		entry := v.URL + " " + strconv.Itoa(v.Width) + "w"
+		if set.Srcset[v.Format] != "" {
+			entry = set.Srcset[v.Format] + ", " + entry
+		}
+		set.Srcset[v.Format] = entry
+	}
+	return set
+}
+
+// ProductOption es una opción del producto (ej. Talla) con sus valores en orden
+type ProductOption struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Position int      `json:"position"`
+	Values   []string `json:"values"`
+}
+
+// ProductVariant es una combinación de valores de las opciones con su propio SKU, precio, stock,
+// peso e imágenes. Sin precio o peso propios se usan los del producto.
+type ProductVariant struct {
+	ID            int               `json:"id"`
+	ProductID     int               `json:"product_id"`
+	SKU           *string           `json:"sku,omitempty"`
+	Label         string            `json:"label"` // Valores en el orden de las opciones (ej. "M / Rojo")
+	Options       map[string]string `json:"options"`
+	PriceOverride *float64          `json:"price_override,omitempty"`
+	Price         float64           `json:"price"` // Precio vigente: el propio o el del producto
+	Stock         int               `json:"stock"`
+	Weight        *float64          `json:"weight,omitempty"`
+	ImageURLs     []string          `json:"image_urls"`
+	IsActive      bool              `json:"is_active"`
+	Available     bool              `json:"available"` // Activa y con stock
+	Position      int               `json:"position"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// ProductAttribute es una característica filtrable de un producto (ej. Material: Roble)
+type ProductAttribute struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CatalogFacets son los conteos de cada filtro del catálogo. Cada grupo se cuenta con el resto de
+// filtros aplicados pero no el suyo, para poder ampliar la selección.
+type CatalogFacets struct {
+	Categories   []CategoryFacet  `json:"categories"`
+	PriceBuckets []PriceBucket    `json:"price_buckets"`
+	MinPrice     float64          `json:"min_price"`
+	MaxPrice     float64          `json:"max_price"`
+	Attributes   []AttributeFacet `json:"attributes"`
+	Ratings      []RatingFacet    `json:"ratings"`
+	InStock      int              `json:"in_stock"`
+}
+
+// CategoryFacet cuenta los productos de una categoría
+type CategoryFacet struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// PriceBucket cuenta los productos en un rango de precio [Min, Max); Max nulo es sin límite
+type PriceBucket struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// AttributeFacet cuenta los productos de cada valor de un atributo
+type AttributeFacet struct {
+	Name   string       `json:"name"`
+	Values []FacetValue `json:"values"`
+}
+
+// FacetValue es un valor de atributo con su número de productos
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// RatingFacet cuenta los productos con calificación media de al menos MinRating estrellas
+type RatingFacet struct {
+	MinRating int `json:"min_rating"`
+	Count     int `json:"count"`
 }
 
 // Category representa una categoría de productos
@@ -130,12 +288,28 @@ type Category struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// CategoryNode es una categoría del árbol con sus subcategorías. ProductCount incluye los productos
+// activos de todas sus subcategorías.
+type CategoryNode struct {
+	Category
+	ProductCount int             `json:"product_count"`
+	Children     []*CategoryNode `json:"children"`
+}
+
+// CategoryBreadcrumb es un paso de la ruta de una categoría, desde la raíz
+type CategoryBreadcrumb struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
 // CartItem representa un item en el carrito
 type CartItem struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
 	ProductID int       `json:"product_id"`
+	VariantID *int      `json:"variant_id,omitempty"`
 	Quantity  int       `json:"quantity"`
+	SeenPrice *float64  `json:"seen_price,omitempty"` // Último precio que vio el cliente
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Product   *Product  `json:"product,omitempty"` // Para incluir datos del producto
@@ -156,6 +330,7 @@ type Address struct {
 	PostalCode string    `json:"postal_code"`
 	Country    string    `json:"country"`
 	Phone      string    `json:"phone"`
+	TaxID      string    `json:"tax_id,omitempty"` // Identificación fiscal del cliente para la factura
 	IsDefault  bool      `json:"is_default"`
 	Lat        float64   `json:"lat,omitempty"`
 	Lng        float64   `json:"lng,omitempty"`
@@ -166,18 +341,21 @@ type Address struct {
 // Order representa un pedido
 type Order struct {
 	ID              int         `json:"id"`
-	UserID          int         `json:"user_id"`
+	UserID          int         `json:"user_id"`               // 0 en pedidos de invitado
+	GuestEmail      string      `json:"guest_email,omitempty"` // Email del invitado que hizo el pedido
 	OrderNumber     string      `json:"order_number"`
-	Status          string      `json:"status"` // pending, paid, shipped, delivered, cancelled
+	Status          string      `json:"status"` // pending, paid, partially_shipped, shipped, delivered, cancelled
 	Subtotal        float64     `json:"subtotal"`
+	Discount        float64     `json:"discount"` // Descuento de cupones sobre los productos (el envío gratis ya se refleja en Shipping)
 	Tax             float64     `json:"tax"`
 	Shipping        float64     `json:"shipping"`
 	Total           float64     `json:"total"`
 	Currency        string      `json:"currency"`
-	PaymentStatus   string      `json:"payment_status"` // pending, paid, failed, refunded
+	PaymentStatus   string      `json:"payment_status"` // pending, paid, failed, partially_refunded, refunded
 	ShippingAddress *Address    `json:"shipping_address"`
 	BillingAddress  *Address    `json:"billing_address"`
 	Notes           string      `json:"notes"`
+	ShippingMethod  string      `json:"shipping_method,omitempty"`
 	Tracking        string      `json:"tracking"`
 	CreatedAt       time.Time   `json:"created_at"`
 	UpdatedAt       time.Time   `json:"updated_at"`
@@ -190,10 +368,17 @@ type OrderItem struct {
 	ID        int      `json:"id"`
 	OrderID   int      `json:"order_id"`
 	ProductID int      `json:"product_id"`
+	VariantID *int     `json:"variant_id,omitempty"`
 	Quantity  int      `json:"quantity"`
 	Price     float64  `json:"price"`
 	Subtotal  float64  `json:"subtotal"`
+	Discount  float64  `json:"discount"` // Parte del descuento del pedido asignada a la línea
 	Product   *Product `json:"product,omitempty"`
+
+	TaxAmount    float64        `json:"tax_amount"`
+	TaxBreakdown []TaxComponent `json:"tax_breakdown,omitempty"`
+	VariantLabel *string        `json:"variant_label,omitempty"` // Variante al momento de la compra
+	VariantSKU   *string        `json:"variant_sku,omitempty"`
 }
 
 // Payment representa un pago
@@ -212,13 +397,65 @@ type Payment struct {
 	UpdatedAt             time.Time `json:"updated_at"`
 }
 
+// Tipos de lista de deseos. Cada usuario tiene como máximo una lista de favoritos y una de
+// guardados para después; las listas con nombre son custom.
+const (
+	WishlistKindFavorites = "favorites"
+	WishlistKindSaved     = "saved_for_later"
+	WishlistKindCustom    = "custom"
+)
+
+// Visibilidad de una lista de deseos
+const (
+	WishlistPrivate = "private" // Solo el dueño
+	WishlistShared  = "shared"  // Cualquiera con el enlace
+	WishlistPublic  = "public"  // Con el enlace o por su ID
+)
+
+// Wishlist es una lista de deseos del usuario
+type Wishlist struct {
+	ID         int            `json:"id"`
+	UserID     int            `json:"user_id"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Visibility string         `json:"visibility"`
+	ShareToken *string        `json:"share_token,omitempty"` // Solo se muestra al dueño
+	ItemCount  int            `json:"item_count"`
+	Items      []WishlistItem `json:"items,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
 // WishlistItem representa un item en la lista de deseos
 type WishlistItem struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	ProductID int       `json:"product_id"`
-	CreatedAt time.Time `json:"created_at"`
-	Product   *Product  `json:"product,omitempty"`
+	ID         int       `json:"id"`
+	WishlistID int       `json:"wishlist_id"`
+	UserID     int       `json:"user_id"`
+	ProductID  int       `json:"product_id"`
+	Quantity   int       `json:"quantity"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Product    *Product  `json:"product,omitempty"` // Con el precio y stock actuales
+}
+
+// Tipos de alerta de producto
+const (
+	ProductAlertBackInStock = "back_in_stock"
+	ProductAlertPriceDrop   = "price_drop"
+)
+
+// ProductAlert representa la suscripción de un usuario a los avisos de un producto. Los productos
+// en favoritos reciben estos avisos sin suscribirse.
+type ProductAlert struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	ProductID    int       `json:"product_id"`
+	Kind         string    `json:"kind"`                   // back_in_stock, price_drop
+	TargetPrice  *float64  `json:"target_price,omitempty"` // Solo price_drop: avisar al llegar a este precio
+	CreatedAt    time.Time `json:"created_at"`
+	ProductName  string    `json:"product_name"`
+	CurrentPrice float64   `json:"current_price"`
+	Stock        int       `json:"stock"`
 }
 
 // Review representa una reseña de producto
@@ -263,3 +500,348 @@ type NotificationPreference struct {
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
+
+// ReconciliationRun representa una ejecución de la conciliación de pagos
+type ReconciliationRun struct {
+	ID               int                         `json:"id"`
+	Trigger          string                      `json:"trigger"` // scheduler, cli
+	Status           string                      `json:"status"`  // running, completed, failed
+	WindowStart      time.Time                   `json:"window_start"`
+	IntentsChecked   int                         `json:"intents_checked"`
+	ChargesChecked   int                         `json:"charges_checked"`
+	FixedCount       int                         `json:"fixed_count"`
+	DiscrepancyCount int                         `json:"discrepancy_count"`
+	ErrorMessage     string                      `json:"error_message,omitempty"`
+	StartedAt        time.Time                   `json:"started_at"`
+	FinishedAt       *time.Time                  `json:"finished_at,omitempty"`
+	Discrepancies    []ReconciliationDiscrepancy `json:"discrepancies,omitempty"`
+}
+
+// ReconciliationDiscrepancy representa una diferencia entre el procesador de pagos y la base de datos
+type ReconciliationDiscrepancy struct {
+	ID              int       `json:"id"`
+	RunID           int       `json:"run_id"`
+	Kind            string    `json:"kind"` // missed_success, missed_cancellation, missed_refund, missing_payment, amount_mismatch, ...
+	PaymentIntentID string    `json:"payment_intent_id,omitempty"`
+	ChargeID        string    `json:"charge_id,omitempty"`
+	OrderID         *int      `json:"order_id,omitempty"`
+	PaymentID       *int      `json:"payment_id,omitempty"`
+	ProviderStatus  string    `json:"provider_status"`
+	LocalStatus     string    `json:"local_status"`
+	ProviderAmount  float64   `json:"provider_amount"`
+	LocalAmount     float64   `json:"local_amount"`
+	Action          string    `json:"action"`   // Corrección aplicada o "none"
+	Resolved        bool      `json:"resolved"` // true si se corrigió automáticamente
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// OrderStatusHistory representa un cambio de estado registrado en el historial de un pedido
+type OrderStatusHistory struct {
+	ID         int       `json:"id"`
+	OrderID    int       `json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Note       string    `json:"note"`
+	Actor      string    `json:"actor"` // system, user, admin
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TaxRate representa una tasa de impuesto para una jurisdicción y clase fiscal
+type TaxRate struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`          // Ej. "IVA", "State Sales Tax"
+	Country      string    `json:"country"`       // Código ISO; vacío aplica a cualquier país
+	State        string    `json:"state"`         // Vacío aplica a todo el país
+	PostalPrefix string    `json:"postal_prefix"` // Vacío aplica a todos los códigos postales
+	TaxClass     string    `json:"tax_class"`     // standard, reduced, zero, ...
+	Rate         float64   `json:"rate"`          // Fracción, ej. 0.16
+	Inclusive    bool      `json:"inclusive"`     // true si el precio del producto ya incluye el impuesto
+	Priority     int       `json:"priority"`      // Las tasas de distinta prioridad se suman
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TaxComponent representa un impuesto aplicado a una línea de pedido
+type TaxComponent struct {
+	RateID    int     `json:"rate_id"`
+	Name      string  `json:"name"`
+	Rate      float64 `json:"rate"`
+	Inclusive bool    `json:"inclusive"`
+	Taxable   float64 `json:"taxable"` // Base gravable (sin impuesto)
+	Amount    float64 `json:"amount"`
+}
+
+// ShippingZone agrupa destinos por país, estado y prefijo de código postal
+type ShippingZone struct {
+	ID           int              `json:"id"`
+	Name         string           `json:"name"`
+	Country      string           `json:"country"`       // Código ISO; vacío aplica a cualquier país
+	State        string           `json:"state"`         // Vacío aplica a todo el país
+	PostalPrefix string           `json:"postal_prefix"` // Vacío aplica a todos los códigos postales
+	IsActive     bool             `json:"is_active"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	Methods      []ShippingMethod `json:"methods,omitempty"`
+}
+
+// ShippingMethod representa un método de envío de una zona con su regla de tarifa
+type ShippingMethod struct {
+	ID        int            `json:"id"`
+	ZoneID    int            `json:"zone_id"`
+	Code      string         `json:"code"` // standard, express, pickup
+	Name      string         `json:"name"`
+	RuleType  string         `json:"rule_type"`  // flat, weight, price
+	BaseCost  float64        `json:"base_cost"`  // Costo fijo (flat) o base (weight)
+	PerKg     float64        `json:"per_kg"`     // Costo por kg adicional (weight)
+	Tiers     []ShippingTier `json:"tiers"`      // Rangos por peso (kg) o por subtotal
+	FreeOver  *float64       `json:"free_over"`  // Envío gratis a partir de este subtotal
+	MaxWeight *float64       `json:"max_weight"` // Peso máximo aceptado en kg
+	MinDays   int            `json:"min_days"`
+	MaxDays   int            `json:"max_days"`
+	SortOrder int            `json:"sort_order"`
+	IsActive  bool           `json:"is_active"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ShippingTier es un rango de la tarifa: aplica cuando Min <= valor < Max (Max 0 = sin límite)
+type ShippingTier struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Cost float64 `json:"cost"`
+}
+
+// ShippingOption es una opción de envío cotizada para un carrito y destino
+type ShippingOption struct {
+	MethodID       int     `json:"method_id"`
+	ZoneID         int     `json:"zone_id"`
+	Code           string  `json:"code"`
+	Name           string  `json:"name"`
+	Cost           float64 `json:"cost"`
+	Currency       string  `json:"currency"`
+	MinDays        int     `json:"min_days"`
+	MaxDays        int     `json:"max_days"`
+	BillableWeight float64 `json:"billable_weight"`
+	FreeShipping   bool    `json:"free_shipping"`
+}
+
+// Shipment representa un envío generado con una paquetería para un pedido
+type Shipment struct {
+	ID             int             `json:"id"`
+	OrderID        int             `json:"order_id"`
+	Carrier        string          `json:"carrier"`
+	Service        string          `json:"service"`
+	TrackingNumber string          `json:"tracking_number"`
+	LabelURL       string          `json:"label_url"`
+	Status         string          `json:"status"` // label_created, in_transit, out_for_delivery, delivered, exception, cancelled
+	Cost           float64         `json:"cost"`
+	LastEvent      string          `json:"last_event"`
+	LastCheckedAt  *time.Time      `json:"last_checked_at,omitempty"`
+	ShippedAt      *time.Time      `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Items          []ShipmentItem  `json:"items,omitempty"`
+	Events         []TrackingEvent `json:"events,omitempty"`
+}
+
+// ShipmentItem representa la cantidad de un item del pedido incluida en un paquete
+type ShipmentItem struct {
+	ID          int    `json:"id"`
+	ShipmentID  int    `json:"shipment_id"`
+	OrderItemID int    `json:"order_item_id"`
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+}
+
+// TrackingEvent representa un evento de rastreo reportado por la paquetería
+type TrackingEvent struct {
+	ID          int       `json:"id"`
+	ShipmentID  int       `json:"shipment_id"`
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ReturnRequest representa una solicitud de devolución (RMA) de un pedido
+type ReturnRequest struct {
+	ID           int          `json:"id"`
+	RMANumber    string       `json:"rma_number"`
+	OrderID      int          `json:"order_id"`
+	UserID       int          `json:"user_id"`
+	Status       string       `json:"status"` // requested, approved, rejected, received, restocked
+	ReasonCode   string       `json:"reason_code"`
+	Comment      string       `json:"comment"`
+	AdminNote    string       `json:"admin_note"`
+	RefundAmount float64      `json:"refund_amount"`
+	RefundStatus string       `json:"refund_status"` // none, pending, succeeded, failed
+	RefundID     string       `json:"refund_id,omitempty"`
+	ApprovedAt   *time.Time   `json:"approved_at,omitempty"`
+	ReceivedAt   *time.Time   `json:"received_at,omitempty"`
+	RestockedAt  *time.Time   `json:"restocked_at,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Items        []ReturnItem `json:"items,omitempty"`
+}
+
+// ReturnItem representa la cantidad de un item del pedido incluida en una devolución
+type ReturnItem struct {
+	ID                int     `json:"id"`
+	ReturnID          int     `json:"return_id"`
+	OrderItemID       int     `json:"order_item_id"`
+	ProductID         int     `json:"product_id"`
+	ProductName       string  `json:"product_name"`
+	Quantity          int     `json:"quantity"`
+	UnitRefund        float64 `json:"unit_refund"`         // Precio más impuesto por unidad
+	Condition         string  `json:"condition,omitempty"` // sellable, damaged (al recibir)
+	RestockedQuantity int     `json:"restocked_quantity"`
+}
+
+// Refund representa un reembolso registrado contra un pago
+type Refund struct {
+	ID               int       `json:"id"`
+	PaymentID        int       `json:"payment_id"`
+	OrderID          int       `json:"order_id"`
+	ReturnID         *int      `json:"return_id,omitempty"`
+	ProviderRefundID string    `json:"provider_refund_id"`
+	Amount           float64   `json:"amount"`
+	Status           string    `json:"status"` // pending, succeeded, failed
+	Reason           string    `json:"reason"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord guarda la respuesta de una petición identificada por Idempotency-Key
+type IdempotencyRecord struct {
+	Key            string    `json:"key"`
+	Scope          string    `json:"scope"` // Usuario, método y ruta
+	Fingerprint    string    `json:"fingerprint"`
+	Status         string    `json:"status"` // in_progress, completed
+	ResponseStatus int       `json:"response_status"`
+	ResponseBody   []byte    `json:"-"`
+	ContentType    string    `json:"content_type"`
+	LockedUntil    time.Time `json:"locked_until"` // Mientras está in_progress; pasado este momento se puede retomar
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// Invoice representa una factura o recibo emitido para un pedido
+type Invoice struct {
+	ID               int       `json:"id"`
+	OrderID          int       `json:"order_id"`
+	Type             string    `json:"type"` // invoice, receipt
+	Series           string    `json:"series"`
+	Number           int       `json:"number"`
+	FullNumber       string    `json:"full_number"` // Serie y folio, ej. F-000123
+	CustomerName     string    `json:"customer_name"`
+	CustomerEmail    string    `json:"customer_email"`
+	CustomerTaxID    string    `json:"customer_tax_id"`
+	PaymentReference string    `json:"payment_reference"`
+	IssuedAt         time.Time `json:"issued_at"`
+}
+
+// BillingProfile son los datos fiscales de un cliente para emitir CFDI
+type BillingProfile struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	RFC           string    `json:"rfc"`
+	RazonSocial   string    `json:"razon_social"`
+	RegimenFiscal string    `json:"regimen_fiscal"` // Clave del catálogo c_RegimenFiscal, ej. 601
+	UsoCFDI       string    `json:"uso_cfdi"`       // Clave del catálogo c_UsoCFDI, ej. G03
+	CodigoPostal  string    `json:"codigo_postal"`  // Domicilio fiscal del receptor
+	Email         string    `json:"email,omitempty"`
+	IsDefault     bool      `json:"is_default"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// CFDIDocument representa un CFDI 4.0 emitido (o en proceso) para un pedido
+type CFDIDocument struct {
+	ID               int        `json:"id"`
+	OrderID          int        `json:"order_id"`
+	BillingProfileID int        `json:"billing_profile_id"`
+	Series           string     `json:"serie"`
+	Folio            int        `json:"folio"`
+	UUID             string     `json:"uuid,omitempty"`
+	Status           string     `json:"status"` // pending, stamped, error, cancel_pending, cancelled
+	ReceptorRFC      string     `json:"receptor_rfc"`
+	Total            float64    `json:"total"`
+	XMLURL           string     `json:"xml_url,omitempty"`
+	PDFURL           string     `json:"pdf_url,omitempty"`
+	ErrorMessage     string     `json:"error_message,omitempty"`
+	CancelReason     string     `json:"cancel_reason,omitempty"`    // Motivo SAT: 01, 02, 03, 04
+	ReplacementUUID  string     `json:"replacement_uuid,omitempty"` // Folio que sustituye (motivo 01)
+	StampedAt        *time.Time `json:"stamped_at,omitempty"`
+	CancelledAt      *time.Time `json:"cancelled_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// Coupon representa un código promocional
+type Coupon struct {
+	ID               int        `json:"id"`
+	Code             string     `json:"code"` // Se guarda en mayúsculas
+	Description      string     `json:"description"`
+	Type             string     `json:"type"`         // percent, fixed, free_shipping
+	Value            float64    `json:"value"`        // Porcentaje (0-100) o importe fijo
+	MinSubtotal      float64    `json:"min_subtotal"` // Subtotal mínimo del carrito
+	MaxDiscount      *float64   `json:"max_discount"` // Tope del descuento porcentual
+	ProductIDs       []int      `json:"product_ids"`  // Vacío aplica a todos los productos
+	CategoryIDs      []int      `json:"category_ids"` // Vacío aplica a todas las categorías
+	UsageLimit       *int       `json:"usage_limit"`  // Usos totales permitidos
+	PerCustomerLimit *int       `json:"per_customer_limit"`
+	StartsAt         *time.Time `json:"starts_at"`
+	EndsAt           *time.Time `json:"ends_at"`
+	Stackable        bool       `json:"stackable"` // Se puede combinar con otros cupones acumulables
+	IsActive         bool       `json:"is_active"`
+	TimesUsed        int        `json:"times_used"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// CouponRedemption registra el uso de un cupón en un pedido
+type CouponRedemption struct {
+	ID               int       `json:"id"`
+	CouponID         int       `json:"coupon_id"`
+	OrderID          int       `json:"order_id"`
+	OrderNumber      string    `json:"order_number,omitempty"`
+	UserID           int       `json:"user_id"`
+	GuestEmail       string    `json:"guest_email,omitempty"` // Email del invitado; cuenta para el límite por cliente
+	Code             string    `json:"code"`
+	Discount         float64   `json:"discount"`          // Descuento sobre los productos
+	ShippingDiscount float64   `json:"shipping_discount"` // Envío bonificado
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Promotion es una promoción automática que se aplica sin código
+type Promotion struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`         // buy_x_get_y, tiered, category_percent, bundle
+	Priority    int             `json:"priority"`     // Las de mayor prioridad se aplican primero
+	Exclusive   bool            `json:"exclusive"`    // Si aplica, ya no se evalúan las siguientes
+	ProductIDs  []int           `json:"product_ids"`  // Productos elegibles; en bundle, los que forman el paquete
+	CategoryIDs []int           `json:"category_ids"` // Categorías elegibles
+	BuyQuantity int             `json:"buy_quantity"` // buy_x_get_y: unidades que se pagan
+	GetQuantity int             `json:"get_quantity"` // buy_x_get_y: unidades con descuento
+	Percent     float64         `json:"percent"`      // buy_x_get_y (100 = gratis) y category_percent
+	Tiers       []PromotionTier `json:"tiers"`        // tiered
+	BundlePrice float64         `json:"bundle_price"` // bundle: precio del paquete completo
+	StartsAt    *time.Time      `json:"starts_at"`
+	EndsAt      *time.Time      `json:"ends_at"`
+	IsActive    bool            `json:"is_active"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// PromotionTier es un escalón de una promoción por monto: a partir de MinSubtotal se descuenta
+// Percent o Amount
+type PromotionTier struct {
+	MinSubtotal float64 `json:"min_subtotal"`
+	Percent     float64 `json:"percent"`
+	Amount      float64 `json:"amount"`
+}
@@ -0,0 +1,74 @@
+package ordernumber
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Generator arma números de pedido legibles: PREFIJO-AÑO-CONTADOR-DÍGITO (ej. AX-2026-000123-7).
+// El contador viene de una secuencia de Postgres, por lo que nunca se repite.
+type Generator struct {
+	Prefix string // Prefijo opcional (ej. AX)
+	Digits int    // Ancho mínimo del contador con ceros a la izquierda
+}
+
+// FromEnv crea el generador con ORDER_NUMBER_PREFIX y ORDER_NUMBER_DIGITS (6 por defecto)
+func FromEnv() *Generator {
+	digits, err := strconv.Atoi(os.Getenv("ORDER_NUMBER_DIGITS"))
+	if err != nil || digits <= 0 || digits > 12 {
+		digits = 6
+	}
+	return &Generator{
+		Prefix: strings.ToUpper(strings.TrimSpace(os.Getenv("ORDER_NUMBER_PREFIX"))),
+		Digits: digits,
+	}
+}
+
+// Format arma el número de pedido para el año y el valor de la secuencia
+func (g *Generator) Format(year int, seq int64) string {
+	counter := fmt.Sprintf("%0*d", g.Digits, seq)
+	check := CheckDigit(fmt.Sprintf("%04d%s", year, counter))
+	number := fmt.Sprintf("%04d-%s-%d", year, counter, check)
+	if g.Prefix != "" {
+		return g.Prefix + "-" + number
+	}
+	return number
+}
+
+var numberPattern = regexp.MustCompile(`^(?:([A-Z0-9]+)-)?(\d{4})-(\d+)-(\d)$`)
+
+// Valid indica si el número tiene el formato esperado y su dígito verificador es correcto.
+// Sirve para rechazar errores de captura antes de consultar la base de datos.
+func Valid(number string) bool {
+	m := numberPattern.FindStringSubmatch(Normalize(number))
+	if m == nil {
+		return false
+	}
+	return strconv.Itoa(CheckDigit(m[2]+m[3])) == m[4]
+}
+
+// Normalize limpia espacios y mayúsculas de un número capturado por el usuario
+func Normalize(number string) string {
+	return strings.ToUpper(strings.TrimSpace(number))
+}
+
+// CheckDigit calcula el dígito verificador Luhn de una cadena de dígitos
+func CheckDigit(digits string) int {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
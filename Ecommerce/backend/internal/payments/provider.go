@@ -0,0 +1,64 @@
+package payments
+
+import (
+	"context"
+	"time"
+)
+
+// Intent es la vista mínima de un PaymentIntent que necesitan los procesos internos
+type Intent struct {
+	ID       string
+	Status   string // succeeded, canceled, processing, requires_payment_method, ...
+	Amount   int64  // Monto en centavos
+	Currency string
+	OrderID  int // Tomado de la metadata order_id (0 si no existe)
+	Created  time.Time
+}
+
+// Charge es la vista mínima de un cargo del procesador de pagos
+type Charge struct {
+	ID              string
+	PaymentIntentID string
+	Status          string // succeeded, pending, failed
+	Amount          int64  // Monto en centavos
+	AmountRefunded  int64  // Monto reembolsado en centavos
+	Refunded        bool   // true si el cargo fue reembolsado por completo
+	Created         time.Time
+}
+
+// Refund es la vista mínima de un reembolso del procesador de pagos
+type Refund struct {
+	ID              string
+	PaymentIntentID string
+	Status          string // succeeded, pending, failed, canceled
+	Amount          int64  // Monto en centavos
+	Created         time.Time
+}
+
+// Provider abstrae las consultas al procesador de pagos
+type Provider interface {
+	// ListPaymentIntents devuelve los PaymentIntents creados desde la fecha indicada
+	ListPaymentIntents(ctx context.Context, since time.Time) ([]Intent, error)
+	// GetPaymentIntent obtiene un PaymentIntent por su ID
+	GetPaymentIntent(ctx context.Context, id string) (*Intent, error)
+	// ListCharges devuelve los cargos creados desde la fecha indicada
+	ListCharges(ctx context.Context, since time.Time) ([]Charge, error)
+	// CancelPaymentIntent cancela un PaymentIntent que aún no fue cobrado
+	CancelPaymentIntent(ctx context.Context, id string) (*Intent, error)
+	// RefundPayment reembolsa total o parcialmente un PaymentIntent cobrado. La clave de
+	// idempotencia evita reembolsos duplicados si la operación se reintenta.
+	RefundPayment(ctx context.Context, paymentIntentID string, amount int64, reason, idempotencyKey string) (*Refund, error)
+}
+
+// ToCents convierte un monto decimal a centavos
+func ToCents(amount float64) int64 {
+	if amount < 0 {
+		return int64(amount*100 - 0.5)
+	}
+	return int64(amount*100 + 0.5)
+}
+
+// FromCents convierte un monto en centavos a decimal
+func FromCents(amount int64) float64 {
+	return float64(amount) / 100
+}
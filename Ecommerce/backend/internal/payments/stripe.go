@@ -0,0 +1,155 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/charge"
+	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/stripe/stripe-go/v74/refund"
+)
+
+// ConfigureStripe configura la clave secreta global de Stripe
+func ConfigureStripe() {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+	if stripe.Key == "" {
+		log.Println("⚠️  STRIPE_SECRET_KEY no configurada. Usando clave de prueba.")
+		stripe.Key = "sk_test_..." // Clave de prueba - cambiar en producción
+	} else {
+		log.Println("✅ STRIPE_SECRET_KEY configurada correctamente")
+	}
+}
+
+// StripeProvider implementa Provider usando la API de Stripe
+type StripeProvider struct{}
+
+// NewStripeProvider crea un nuevo proveedor de Stripe
+func NewStripeProvider() *StripeProvider {
+	return &StripeProvider{}
+}
+
+// ListPaymentIntents lista los PaymentIntents creados desde la fecha indicada
+func (p *StripeProvider) ListPaymentIntents(ctx context.Context, since time.Time) ([]Intent, error) {
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since.Unix()},
+	}
+	params.Context = ctx
+	params.Limit = stripe.Int64(100)
+
+	var intents []Intent
+	i := paymentintent.List(params)
+	for i.Next() {
+		intents = append(intents, intentFromStripe(i.PaymentIntent()))
+	}
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("error listando PaymentIntents: %w", err)
+	}
+	return intents, nil
+}
+
+// GetPaymentIntent obtiene un PaymentIntent por su ID
+func (p *StripeProvider) GetPaymentIntent(ctx context.Context, id string) (*Intent, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+
+	pi, err := paymentintent.Get(id, params)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo PaymentIntent %s: %w", id, err)
+	}
+	intent := intentFromStripe(pi)
+	return &intent, nil
+}
+
+// CancelPaymentIntent cancela un PaymentIntent abierto
+func (p *StripeProvider) CancelPaymentIntent(ctx context.Context, id string) (*Intent, error) {
+	params := &stripe.PaymentIntentCancelParams{
+		CancellationReason: stripe.String(string(stripe.PaymentIntentCancellationReasonAbandoned)),
+	}
+	params.Context = ctx
+
+	pi, err := paymentintent.Cancel(id, params)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelando PaymentIntent %s: %w", id, err)
+	}
+	intent := intentFromStripe(pi)
+	return &intent, nil
+}
+
+// RefundPayment crea un reembolso en Stripe para el PaymentIntent indicado
+func (p *StripeProvider) RefundPayment(ctx context.Context, paymentIntentID string, amount int64, reason, idempotencyKey string) (*Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Amount:        stripe.Int64(amount),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.Context = ctx
+	if reason != "" {
+		params.AddMetadata("reason", reason)
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	r, err := refund.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("error reembolsando PaymentIntent %s: %w", paymentIntentID, err)
+	}
+	return &Refund{
+		ID:              r.ID,
+		PaymentIntentID: paymentIntentID,
+		Status:          string(r.Status),
+		Amount:          r.Amount,
+		Created:         time.Unix(r.Created, 0),
+	}, nil
+}
+
+// ListCharges lista los cargos creados desde la fecha indicada
+func (p *StripeProvider) ListCharges(ctx context.Context, since time.Time) ([]Charge, error) {
+	params := &stripe.ChargeListParams{
+		CreatedRange: &stripe.RangeQueryParams{GreaterThanOrEqual: since.Unix()},
+	}
+	params.Context = ctx
+	params.Limit = stripe.Int64(100)
+
+	var charges []Charge
+	i := charge.List(params)
+	for i.Next() {
+		ch := i.Charge()
+		c := Charge{
+			ID:             ch.ID,
+			Status:         string(ch.Status),
+			Amount:         ch.Amount,
+			AmountRefunded: ch.AmountRefunded,
+			Refunded:       ch.Refunded,
+			Created:        time.Unix(ch.Created, 0),
+		}
+		if ch.PaymentIntent != nil {
+			c.PaymentIntentID = ch.PaymentIntent.ID
+		}
+		charges = append(charges, c)
+	}
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("error listando cargos: %w", err)
+	}
+	return charges, nil
+}
+
+// intentFromStripe convierte un PaymentIntent de Stripe a la vista interna
+func intentFromStripe(pi *stripe.PaymentIntent) Intent {
+	intent := Intent{
+		ID:       pi.ID,
+		Status:   string(pi.Status),
+		Amount:   pi.Amount,
+		Currency: string(pi.Currency),
+		Created:  time.Unix(pi.Created, 0),
+	}
+	if orderID, err := strconv.Atoi(pi.Metadata["order_id"]); err == nil {
+		intent.OrderID = orderID
+	}
+	return intent
+}
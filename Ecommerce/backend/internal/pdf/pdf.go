@@ -0,0 +1,157 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Tamaño carta en puntos
+const (
+	PageWidth  = 612.0
+	PageHeight = 792.0
+)
+
+// Document es un generador mínimo de PDF con texto en Helvetica y líneas, sin dependencias externas
+type Document struct {
+	pages []*bytes.Buffer
+}
+
+// New crea un documento con una página en blanco
+func New() *Document {
+	d := &Document{}
+	d.AddPage()
+	return d
+}
+
+// AddPage agrega una página nueva; el contenido siguiente se dibuja en ella
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+// PageCount devuelve el número de páginas
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+func (d *Document) current() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text escribe texto con la esquina inferior izquierda en (x, y); y se mide desde abajo
+func (d *Document) Text(x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.current(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(text))
+}
+
+// TextRight escribe texto alineado a la derecha terminando en x
+func (d *Document) TextRight(x, y, size float64, bold bool, text string) {
+	d.Text(x-TextWidth(text, size), y, size, bold, text)
+}
+
+// Line dibuja una línea de (x1, y1) a (x2, y2)
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.current(), "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// Rect rellena un rectángulo con un tono de gris (0 negro, 1 blanco)
+func (d *Document) Rect(x, y, w, h, gray float64) {
+	fmt.Fprintf(d.current(), "q %.2f g %.2f %.2f %.2f %.2f re f Q\n", gray, x, y, w, h)
+}
+
+// Bytes serializa el documento
+func (d *Document) Bytes() []byte {
+	// Objetos: 1 catálogo, 2 páginas, 3 Helvetica, 4 Helvetica-Bold, luego página y contenido por cada página
+	var objects []string
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, content := range d.pages {
+		objects = append(objects, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, 6+i*2))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// TextLines genera un PDF de una página con una línea de texto por elemento
+func TextLines(lines []string) []byte {
+	d := New()
+	y := PageHeight - 50
+	for i, line := range lines {
+		d.Text(50, y, 12, i == 0, line)
+		y -= 16
+	}
+	return d.Bytes()
+}
+
+// TextWidth estima el ancho del texto en Helvetica con las métricas estándar (milésimas de em).
+// Para negritas la diferencia es pequeña y se usa la misma tabla.
+func TextWidth(text string, size float64) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// escape escapa el texto y lo convierte a WinAnsi (latin-1) para las fuentes estándar
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 128:
+			b.WriteRune(r)
+		case r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths son los anchos de Helvetica para los caracteres 32..126
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // espacio a /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0-9
+	278, 278, 584, 584, 584, 556, 1015, // : a @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A-M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N-Z
+	278, 278, 278, 469, 556, 333, // [ a `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a-m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n-z
+	334, 260, 334, 584, // { a ~
+}
@@ -0,0 +1,322 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/discount"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/promotion"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
+	"github.com/tuusuario/ecommerce-backend/internal/tax"
+)
+
+// Currency es la moneda en la que se cotizan el carrito y los pedidos
+const Currency = "USD"
+
+// Tipos de aviso del carrito
+const (
+	WarningPriceChanged    = "price_changed"    // El precio cambió desde que el cliente lo vio
+	WarningStockReduced    = "stock_reduced"    // La cantidad se ajustó al stock disponible
+	WarningProductInactive = "product_inactive" // El producto ya no está a la venta y se quitó
+)
+
+// Warning es un cambio en el carrito que el cliente debe revisar antes de pagar
+type Warning struct {
+	Type        string   `json:"type"`
+	ProductID   int      `json:"product_id"`
+	ProductName string   `json:"product_name"`
+	Message     string   `json:"message"`
+	OldPrice    *float64 `json:"old_price,omitempty"`
+	NewPrice    *float64 `json:"new_price,omitempty"`
+	OldQuantity *int     `json:"old_quantity,omitempty"`
+	NewQuantity *int     `json:"new_quantity,omitempty"`
+}
+
+// CartLine es un item del carrito con su precio, descuentos e impuesto
+type CartLine struct {
+	ID                int                   `json:"id"` // ID del item del carrito
+	ProductID         int                   `json:"product_id"`
+	VariantID         *int                  `json:"variant_id,omitempty"`
+	VariantLabel      *string               `json:"variant_label,omitempty"`
+	VariantSKU        *string               `json:"variant_sku,omitempty"`
+	ProductName       string                `json:"product_name"`
+	ImageURL          *string               `json:"image_url"`
+	Price             float64               `json:"price"` // Precio unitario vigente
+	Quantity          int                   `json:"quantity"`
+	Stock             int                   `json:"stock"`
+	Subtotal          float64               `json:"subtotal"`
+	PromotionDiscount float64               `json:"promotion_discount"`
+	CouponDiscount    float64               `json:"coupon_discount"`
+	Discount          float64               `json:"discount"`
+	LineTotal         float64               `json:"line_total"` // Subtotal menos descuentos, antes de impuestos
+	Tax               *float64              `json:"tax"`        // Impuesto estimado; nil sin dirección
+	TaxBreakdown      []models.TaxComponent `json:"tax_breakdown,omitempty"`
+	Promotions        []string              `json:"promotions"`
+	CreatedAt         time.Time             `json:"created_at"`
+	UpdatedAt         time.Time             `json:"updated_at"`
+}
+
+// Cart es el carrito con todos sus importes calculados en el servidor
+type Cart struct {
+	ID                int                 `json:"id"`
+	Items             []CartLine          `json:"items"`
+	ItemCount         int                 `json:"item_count"`
+	Subtotal          float64             `json:"subtotal"`
+	PromotionDiscount float64             `json:"promotion_discount"`
+	CouponDiscount    float64             `json:"coupon_discount"`
+	Discount          float64             `json:"discount"`
+	Tax               *float64            `json:"tax"`          // Impuesto estimado; nil sin dirección
+	TaxIncluded       float64             `json:"tax_included"` // Parte del impuesto que ya va en los precios
+	Shipping          *float64            `json:"shipping"`     // Costo de envío estimado; nil sin dirección o sin métodos
+	ShippingMethodID  int                 `json:"shipping_method_id,omitempty"`
+	ShippingMethod    string              `json:"shipping_method,omitempty"`
+	ShippingDiscount  float64             `json:"shipping_discount"`
+	ShippingError     string              `json:"shipping_error,omitempty"`
+	FreeShipping      bool                `json:"free_shipping"`
+	Total             float64             `json:"total"`
+	Currency          string              `json:"currency"`
+	Promotions        []promotion.Applied `json:"promotions"`
+	Coupons           []coupon.Applied    `json:"coupons"`
+	RejectedCoupons   []coupon.Rejected   `json:"rejected_coupons,omitempty"`
+	Warnings          []Warning           `json:"warnings"`
+
+	// Datos que necesita el checkout para guardar el pedido
+	Quote          *Quote                 `json:"-"`
+	CartCoupons    []models.Coupon        `json:"-"`
+	ShippingOption *models.ShippingOption `json:"-"`
+}
+
+// CartRequest indica qué carrito calcular y hacia dónde se enviaría
+type CartRequest struct {
+	CartID           int
+	UserID           int
+	ShippingAddress  *models.Address // Sin dirección no se estiman impuestos ni envío
+	BillingAddress   *models.Address // Si se omite se usa la de envío
+	ShippingMethodID int             // 0 para el método más económico
+}
+
+// Cart revisa los items del carrito contra el catálogo y calcula todos sus importes. Los productos
+// inactivos se quitan, las cantidades se ajustan al stock y se avisa de los precios que cambiaron
+// desde la última vez que el cliente vio el carrito; cada aviso se devuelve una sola vez.
+func (e *Engine) Cart(ctx context.Context, req CartRequest) (*Cart, error) {
+	cartItems, err := db.GetCartContents(e.DB, req.CartID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := &Cart{
+		ID:         req.CartID,
+		Items:      []CartLine{},
+		Currency:   Currency,
+		Promotions: []promotion.Applied{},
+		Coupons:    []coupon.Applied{},
+		Warnings:   []Warning{},
+	}
+
+	var products []*models.Product
+	var items []Item
+	for _, ci := range cartItems {
+		product, variant, err := db.GetCartProduct(e.DB, ci.ProductID, ci.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		quantity, err := e.reconcile(cart, ci, product)
+		if err != nil {
+			return nil, err
+		}
+		if quantity == 0 {
+			continue
+		}
+		products = append(products, product)
+		items = append(items, ItemFromProduct(product, quantity))
+		line := CartLine{
+			ID:          ci.ID,
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			ImageURL:    product.ImageURL,
+			Price:       product.Price,
+			Quantity:    quantity,
+			Stock:       product.Stock,
+			CreatedAt:   ci.CreatedAt,
+			UpdatedAt:   ci.UpdatedAt,
+		}
+		if variant != nil {
+			line.VariantID = &variant.ID
+			line.VariantLabel = &variant.Label
+			line.VariantSKU = variant.SKU
+		}
+		cart.Items = append(cart.Items, line)
+		cart.ItemCount += quantity
+	}
+	if len(items) == 0 {
+		return cart, nil
+	}
+
+	cart.CartCoupons, err = db.GetCartCoupons(e.DB, req.CartID)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := e.Price(items, cart.CartCoupons, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	cart.Quote = quote
+	cart.Subtotal = quote.Subtotal
+	cart.PromotionDiscount = quote.PromotionDiscount
+	cart.CouponDiscount = quote.CouponDiscount
+	cart.Discount = quote.Discount
+	cart.FreeShipping = quote.FreeShipping
+	cart.Promotions = quote.Promotions
+	cart.Coupons = quote.Coupons
+	cart.RejectedCoupons = quote.RejectedCoupons
+	for i := range cart.Items {
+		line := &cart.Items[i]
+		line.Subtotal = quote.Lines[i].Subtotal
+		line.PromotionDiscount = quote.Lines[i].PromotionDiscount
+		line.CouponDiscount = quote.Lines[i].CouponDiscount
+		line.Discount = quote.Lines[i].Discount
+		line.LineTotal = quote.Lines[i].Total
+		line.Promotions = quote.Lines[i].Promotions
+	}
+	total := cart.Subtotal - cart.Discount
+
+	if req.ShippingAddress != nil {
+		taxResult, err := e.estimateTax(ctx, req, products, quote)
+		if err != nil {
+			return nil, err
+		}
+		for i := range cart.Items {
+			lineTax := taxResult.Lines[i].Tax
+			cart.Items[i].Tax = &lineTax
+			cart.Items[i].TaxBreakdown = taxResult.Lines[i].Components
+		}
+		totalTax := taxResult.TotalTax
+		cart.Tax = &totalTax
+		cart.TaxIncluded = taxResult.InclusiveTax
+		total += taxResult.ExclusiveTax
+
+		shippingItems := make([]shipping.Item, len(products))
+		for i, product := range products {
+			shippingItems[i] = shipping.ItemFromProduct(product, cart.Items[i].Quantity)
+		}
+		option, err := e.Shipping.Reprice(ctx, shippingItems, req.ShippingAddress, Currency, req.ShippingMethodID)
+		if err != nil {
+			cart.ShippingError = err.Error()
+		} else {
+			cost := option.Cost
+			if quote.FreeShipping {
+				cart.ShippingDiscount = cost
+				cost = 0
+			}
+			cart.ShippingOption = option
+			cart.ShippingMethodID = option.MethodID
+			cart.ShippingMethod = option.Name
+			cart.Shipping = &cost
+			total += cost
+		}
+	}
+	cart.Total = round(total)
+	return cart, nil
+}
+
+// reconcile compara el item con el producto actual, corrige el carrito y registra los avisos.
+// Devuelve la cantidad que queda en el carrito.
+func (e *Engine) reconcile(cart *Cart, ci models.CartItem, product *models.Product) (int, error) {
+	warning := Warning{ProductID: product.ID, ProductName: product.Name}
+
+	if !product.IsActive {
+		warning.Type = WarningProductInactive
+		warning.Message = fmt.Sprintf("%s ya no está disponible y se quitó del carrito", product.Name)
+		warning.OldQuantity, warning.NewQuantity = intPtr(ci.Quantity), intPtr(0)
+		cart.Warnings = append(cart.Warnings, warning)
+		return 0, db.RemoveItemFromCart(e.DB, ci.ID)
+	}
+
+	quantity := ci.Quantity
+	if quantity > product.Stock {
+		quantity = max(product.Stock, 0)
+		warning.Type = WarningStockReduced
+		if quantity == 0 {
+			warning.Message = fmt.Sprintf("%s se agotó y se quitó del carrito", product.Name)
+		} else {
+			warning.Message = fmt.Sprintf("Solo quedan %d unidades de %s; ajustamos la cantidad", quantity, product.Name)
+		}
+		warning.OldQuantity, warning.NewQuantity = intPtr(ci.Quantity), intPtr(quantity)
+		cart.Warnings = append(cart.Warnings, warning)
+		// Con cantidad 0 el item se elimina
+		if err := db.UpdateCartItemQuantity(e.DB, ci.ID, quantity); err != nil {
+			return 0, err
+		}
+		if quantity == 0 {
+			return 0, nil
+		}
+	}
+
+	// Los items agregados antes de guardar el precio visto no generan aviso
+	if ci.SeenPrice == nil || discount.Cents(*ci.SeenPrice) != discount.Cents(product.Price) {
+		if ci.SeenPrice != nil {
+			oldPrice, newPrice := *ci.SeenPrice, product.Price
+			cart.Warnings = append(cart.Warnings, Warning{
+				Type:        WarningPriceChanged,
+				ProductID:   product.ID,
+				ProductName: product.Name,
+				Message:     fmt.Sprintf("El precio de %s cambió de %.2f a %.2f", product.Name, oldPrice, newPrice),
+				OldPrice:    &oldPrice,
+				NewPrice:    &newPrice,
+			})
+		}
+		if err := db.SetCartItemSeenPrice(e.DB, ci.ID, product.Price); err != nil {
+			return 0, err
+		}
+	}
+	return quantity, nil
+}
+
+// estimateTax calcula los impuestos de las líneas ya con descuentos según la dirección y la
+// clase fiscal de cada producto
+func (e *Engine) estimateTax(ctx context.Context, req CartRequest, products []*models.Product, quote *Quote) (*tax.Result, error) {
+	taxClasses, err := db.GetCategoryTaxClasses(e.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]tax.Line, len(products))
+	for i, product := range products {
+		lines[i] = tax.Line{
+			ProductID: product.ID,
+			Quantity:  quote.Lines[i].Quantity,
+			UnitPrice: product.Price,
+			Amount:    quote.Lines[i].Total,
+		}
+		if product.CategoryID != nil {
+			lines[i].CategoryID = *product.CategoryID
+			lines[i].TaxClass = taxClasses[*product.CategoryID]
+		}
+	}
+
+	var taxExempt bool
+	if req.UserID != 0 {
+		taxExempt, err = db.IsUserTaxExempt(e.DB, req.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	billing := req.BillingAddress
+	if billing == nil {
+		billing = req.ShippingAddress
+	}
+	return e.Tax.Calculate(ctx, tax.Request{
+		Lines:           lines,
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  billing,
+		TaxExempt:       taxExempt,
+	})
+}
+
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,173 @@
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+	"github.com/tuusuario/ecommerce-backend/internal/promotion"
+	"github.com/tuusuario/ecommerce-backend/internal/shipping"
+	"github.com/tuusuario/ecommerce-backend/internal/tax"
+)
+
+// Item es una línea del carrito con el precio vigente del producto
+type Item struct {
+	ProductID  int
+	CategoryID int
+	Quantity   int
+	UnitPrice  float64
+}
+
+// Line es una línea con sus descuentos
+type Line struct {
+	ProductID         int      `json:"product_id"`
+	Quantity          int      `json:"quantity"`
+	UnitPrice         float64  `json:"unit_price"`
+	Subtotal          float64  `json:"subtotal"` // Precio unitario por cantidad
+	PromotionDiscount float64  `json:"promotion_discount"`
+	CouponDiscount    float64  `json:"coupon_discount"`
+	Discount          float64  `json:"discount"`
+	Total             float64  `json:"total"` // Subtotal menos descuentos, antes de impuestos
+	Promotions        []string `json:"promotions"`
+}
+
+// Quote es el carrito con las promociones y cupones aplicados
+type Quote struct {
+	Lines             []Line              `json:"lines"`
+	Subtotal          float64             `json:"subtotal"`
+	PromotionDiscount float64             `json:"promotion_discount"`
+	CouponDiscount    float64             `json:"coupon_discount"`
+	Discount          float64             `json:"discount"`
+	FreeShipping      bool                `json:"free_shipping"`
+	Promotions        []promotion.Applied `json:"promotions"`
+	Coupons           []coupon.Applied    `json:"coupons"`
+	RejectedCoupons   []coupon.Rejected   `json:"rejected_coupons,omitempty"`
+}
+
+// Engine calcula los descuentos, impuestos y envío de un carrito. Lo usan tanto el carrito como el
+// checkout para que el cliente vea exactamente lo que se le va a cobrar.
+type Engine struct {
+	DB       *pgxpool.Pool
+	Coupons  *coupon.Service
+	Tax      tax.Calculator
+	Shipping *shipping.RateEngine
+}
+
+// NewEngine crea el motor de precios
+func NewEngine(db *pgxpool.Pool) *Engine {
+	return &Engine{
+		DB:       db,
+		Coupons:  coupon.NewService(db),
+		Tax:      tax.NewTableCalculator(db),
+		Shipping: shipping.NewRateEngine(db),
+	}
+}
+
+// CartItems toma el precio y la categoría actuales de los productos del carrito, con el precio de la
+// variante. Como en el checkout, los no disponibles se omiten y la cantidad no pasa del stock.
+func (e *Engine) CartItems(cartItems []models.CartItem) ([]Item, error) {
+	items := make([]Item, 0, len(cartItems))
+	for _, ci := range cartItems {
+		product, _, err := db.GetCartProduct(e.DB, ci.ProductID, ci.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		quantity := min(ci.Quantity, product.Stock)
+		if !product.IsActive || quantity <= 0 {
+			continue
+		}
+		items = append(items, ItemFromProduct(product, quantity))
+	}
+	return items, nil
+}
+
+// ItemFromProduct arma la línea a partir del producto y la cantidad
+func ItemFromProduct(product *models.Product, quantity int) Item {
+	item := Item{ProductID: product.ID, Quantity: quantity, UnitPrice: product.Price}
+	if product.CategoryID != nil {
+		item.CategoryID = *product.CategoryID
+	}
+	return item
+}
+
+// Price aplica las promociones vigentes y después los cupones, que descuentan sobre lo que dejaron
+// las promociones. Los cupones que ya no son válidos se devuelven en RejectedCoupons.
+func (e *Engine) Price(items []Item, coupons []models.Coupon, userID int) (*Quote, error) {
+	promotions, err := db.GetCurrentPromotions(e.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	promoLines := make([]promotion.Line, len(items))
+	for i, item := range items {
+		promoLines[i] = promotion.Line{
+			ProductID:  item.ProductID,
+			CategoryID: item.CategoryID,
+			Quantity:   item.Quantity,
+			UnitPrice:  item.UnitPrice,
+		}
+	}
+	promoResult := promotion.Apply(promotions, promoLines, time.Now())
+
+	quote := &Quote{
+		Lines:      make([]Line, len(items)),
+		Promotions: promoResult.Applied,
+	}
+	couponLines := make([]coupon.Line, len(items))
+	for i, item := range items {
+		subtotal := round(item.UnitPrice * float64(item.Quantity))
+		quote.Lines[i] = Line{
+			ProductID:         item.ProductID,
+			Quantity:          item.Quantity,
+			UnitPrice:         item.UnitPrice,
+			Subtotal:          subtotal,
+			PromotionDiscount: promoResult.Lines[i],
+			Promotions:        promoResult.LinePromotions[i],
+		}
+		if quote.Lines[i].Promotions == nil {
+			quote.Lines[i].Promotions = []string{}
+		}
+		couponLines[i] = coupon.Line{
+			ProductID:  item.ProductID,
+			CategoryID: item.CategoryID,
+			Amount:     round(subtotal - promoResult.Lines[i]),
+		}
+		quote.Subtotal += subtotal
+	}
+
+	couponResult := &coupon.Result{Lines: make([]float64, len(items))}
+	if len(coupons) > 0 {
+		couponResult, quote.RejectedCoupons, err = e.Coupons.Evaluate(coupons, userID, couponLines)
+		if err != nil {
+			return nil, err
+		}
+	}
+	quote.Coupons = couponResult.Applied
+	quote.FreeShipping = couponResult.FreeShipping
+	if quote.Promotions == nil {
+		quote.Promotions = []promotion.Applied{}
+	}
+	if quote.Coupons == nil {
+		quote.Coupons = []coupon.Applied{}
+	}
+
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		line.CouponDiscount = couponResult.Lines[i]
+		line.Discount = round(line.PromotionDiscount + line.CouponDiscount)
+		line.Total = round(line.Subtotal - line.Discount)
+	}
+	quote.Subtotal = round(quote.Subtotal)
+	quote.PromotionDiscount = promoResult.Discount
+	quote.CouponDiscount = couponResult.Discount
+	quote.Discount = round(quote.PromotionDiscount + quote.CouponDiscount)
+	return quote, nil
+}
+
+// round redondea a centavos
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
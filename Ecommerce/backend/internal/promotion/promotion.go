@@ -0,0 +1,311 @@
+package promotion
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/discount"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Tipos de promoción
+const (
+	TypeBuyXGetY        = "buy_x_get_y"      // Compra X y llévate Y con descuento (las unidades más baratas)
+	TypeTiered          = "tiered"           // Descuento por escalones de monto
+	TypeCategoryPercent = "category_percent" // Porcentaje sobre productos de ciertas categorías
+	TypeBundle          = "bundle"           // Precio especial por un paquete de productos
+)
+
+// ValidType indica si el tipo de promoción existe
+func ValidType(t string) bool {
+	switch t {
+	case TypeBuyXGetY, TypeTiered, TypeCategoryPercent, TypeBundle:
+		return true
+	}
+	return false
+}
+
+// Line es una línea del carrito
+type Line struct {
+	ProductID  int
+	CategoryID int
+	Quantity   int
+	UnitPrice  float64
+}
+
+// Applied es el descuento que aportó una promoción
+type Applied struct {
+	PromotionID int     `json:"promotion_id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Discount    float64 `json:"discount"`
+}
+
+// Result es el descuento de las promociones sobre un carrito
+type Result struct {
+	Lines          []float64  // Descuento de cada línea, en el mismo orden que las recibidas
+	LinePromotions [][]string // Nombres de las promociones que tocaron cada línea
+	Discount       float64
+	Applied        []Applied
+}
+
+// Active indica si la promoción está activa y vigente
+func Active(p *models.Promotion, now time.Time) bool {
+	if !p.IsActive {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	return p.EndsAt == nil || now.Before(*p.EndsAt)
+}
+
+// Sort ordena las promociones en el orden en que se evalúan: mayor prioridad primero y, a igual
+// prioridad, la más antigua (menor ID). Así el resultado no depende del orden de la consulta.
+func Sort(promotions []models.Promotion) {
+	sort.SliceStable(promotions, func(i, j int) bool {
+		if promotions[i].Priority != promotions[j].Priority {
+			return promotions[i].Priority > promotions[j].Priority
+		}
+		return promotions[i].ID < promotions[j].ID
+	})
+}
+
+// Apply evalúa las promociones vigentes en orden de prioridad. Cada una descuenta sobre lo que las
+// anteriores dejaron en cada línea, así una línea nunca queda en negativo; si una promoción
+// exclusiva aplica, las siguientes ya no se evalúan.
+func Apply(promotions []models.Promotion, lines []Line, now time.Time) *Result {
+	result := &Result{
+		Lines:          make([]float64, len(lines)),
+		LinePromotions: make([][]string, len(lines)),
+	}
+	remaining := make([]int64, len(lines))
+	for i, line := range lines {
+		remaining[i] = discount.Cents(line.UnitPrice * float64(line.Quantity))
+	}
+
+	ordered := make([]models.Promotion, len(promotions))
+	copy(ordered, promotions)
+	Sort(ordered)
+
+	for i := range ordered {
+		p := &ordered[i]
+		if !Active(p, now) {
+			continue
+		}
+		shares := evaluate(p, lines, remaining)
+
+		var total int64
+		for j, share := range shares {
+			if share > remaining[j] {
+				share = remaining[j]
+				shares[j] = share
+			}
+			total += share
+		}
+		if total <= 0 {
+			continue
+		}
+		for j, share := range shares {
+			if share <= 0 {
+				continue
+			}
+			remaining[j] -= share
+			result.Lines[j] += discount.Amount(share)
+			result.LinePromotions[j] = append(result.LinePromotions[j], p.Name)
+		}
+		result.Applied = append(result.Applied, Applied{
+			PromotionID: p.ID,
+			Name:        p.Name,
+			Type:        p.Type,
+			Discount:    discount.Amount(total),
+		})
+		if p.Exclusive {
+			break
+		}
+	}
+
+	for i := range result.Lines {
+		result.Lines[i] = math.Round(result.Lines[i]*100) / 100
+		result.Discount += result.Lines[i]
+	}
+	result.Discount = math.Round(result.Discount*100) / 100
+	return result
+}
+
+// eligible indica si la línea entra en los productos o categorías de la promoción; sin
+// restricciones aplica a todo el carrito
+func eligible(p *models.Promotion, line Line) bool {
+	if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, id := range p.CategoryIDs {
+		if line.CategoryID != 0 && id == line.CategoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate calcula los centavos que la promoción descuenta de cada línea
+func evaluate(p *models.Promotion, lines []Line, remaining []int64) []int64 {
+	switch p.Type {
+	case TypeCategoryPercent:
+		return categoryPercent(p, lines, remaining)
+	case TypeTiered:
+		return tiered(p, lines, remaining)
+	case TypeBuyXGetY:
+		return buyXGetY(p, lines, remaining)
+	case TypeBundle:
+		return bundle(p, lines, remaining)
+	}
+	return make([]int64, len(lines))
+}
+
+func categoryPercent(p *models.Promotion, lines []Line, remaining []int64) []int64 {
+	shares := make([]int64, len(lines))
+	for i, line := range lines {
+		if eligible(p, line) {
+			shares[i] = int64(math.Round(float64(remaining[i]) * p.Percent / 100))
+		}
+	}
+	return shares
+}
+
+// tiered aplica el escalón más alto alcanzado por el subtotal elegible
+func tiered(p *models.Promotion, lines []Line, remaining []int64) []int64 {
+	weights := make([]int64, len(lines))
+	var base int64
+	for i, line := range lines {
+		if eligible(p, line) {
+			weights[i] = remaining[i]
+			base += remaining[i]
+		}
+	}
+
+	var best *models.PromotionTier
+	for i := range p.Tiers {
+		tier := &p.Tiers[i]
+		if base >= discount.Cents(tier.MinSubtotal) && (best == nil || tier.MinSubtotal > best.MinSubtotal) {
+			best = tier
+		}
+	}
+	if best == nil {
+		return make([]int64, len(lines))
+	}
+
+	amount := discount.Cents(best.Amount)
+	if best.Percent > 0 {
+		amount = int64(math.Round(float64(base) * best.Percent / 100))
+	}
+	if amount > base {
+		amount = base
+	}
+	return discount.Allocate(amount, weights, weights)
+}
+
+// buyXGetY descuenta las unidades más baratas: por cada BuyQuantity+GetQuantity unidades
+// elegibles, GetQuantity llevan el porcentaje de descuento
+func buyXGetY(p *models.Promotion, lines []Line, remaining []int64) []int64 {
+	shares := make([]int64, len(lines))
+	group := p.BuyQuantity + p.GetQuantity
+	if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+		return shares
+	}
+
+	var indexes []int
+	units := 0
+	for i, line := range lines {
+		if eligible(p, line) && line.Quantity > 0 && remaining[i] > 0 {
+			indexes = append(indexes, i)
+			units += line.Quantity
+		}
+	}
+	free := units / group * p.GetQuantity
+	if free == 0 {
+		return shares
+	}
+
+	// Primero las unidades más baratas; a igual precio, la primera línea del carrito
+	unit := func(i int) float64 { return float64(remaining[i]) / float64(lines[i].Quantity) }
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return unit(indexes[a]) < unit(indexes[b])
+	})
+	for _, i := range indexes {
+		if free == 0 {
+			break
+		}
+		n := lines[i].Quantity
+		if n > free {
+			n = free
+		}
+		shares[i] = int64(math.Round(unit(i) * float64(n) * p.Percent / 100))
+		free -= n
+	}
+	return shares
+}
+
+// bundle cobra BundlePrice por cada juego completo de los productos del paquete. El ahorro se
+// reparte entre las líneas según el valor de las unidades que forman los paquetes.
+func bundle(p *models.Promotion, lines []Line, remaining []int64) []int64 {
+	shares := make([]int64, len(lines))
+	products := map[int]bool{}
+	for _, id := range p.ProductIDs {
+		products[id] = true
+	}
+	if len(products) < 2 {
+		return shares
+	}
+
+	quantities := map[int]int{}
+	unitPrices := map[int]float64{}
+	for i, line := range lines {
+		if products[line.ProductID] && line.Quantity > 0 {
+			quantities[line.ProductID] += line.Quantity
+			if _, ok := unitPrices[line.ProductID]; !ok {
+				unitPrices[line.ProductID] = float64(remaining[i]) / float64(line.Quantity)
+			}
+		}
+	}
+	bundles := -1
+	var regular float64
+	for id := range products {
+		if quantities[id] == 0 {
+			return shares
+		}
+		if bundles < 0 || quantities[id] < bundles {
+			bundles = quantities[id]
+		}
+		regular += unitPrices[id]
+	}
+
+	saving := int64(math.Round(regular)) - discount.Cents(p.BundlePrice)
+	if saving <= 0 {
+		return shares
+	}
+
+	// Las unidades de cada producto que entran en paquetes se toman de sus líneas en orden
+	weights := make([]int64, len(lines))
+	left := map[int]int{}
+	for id := range products {
+		left[id] = bundles
+	}
+	for i, line := range lines {
+		if !products[line.ProductID] || left[line.ProductID] == 0 || line.Quantity <= 0 {
+			continue
+		}
+		n := line.Quantity
+		if n > left[line.ProductID] {
+			n = left[line.ProductID]
+		}
+		left[line.ProductID] -= n
+		weights[i] = int64(math.Round(float64(remaining[i]) / float64(line.Quantity) * float64(n)))
+	}
+	return discount.Allocate(saving*int64(bundles), weights, remaining)
+}
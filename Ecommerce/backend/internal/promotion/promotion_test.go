@@ -0,0 +1,269 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/coupon"
+	"github.com/tuusuario/ecommerce-backend/internal/discount"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+var testNow = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// percentOff arma una promoción category_percent sin restricciones
+func percentOff(id, priority int, percent float64) models.Promotion {
+	return models.Promotion{ID: id, Name: "P" + string(rune('0'+id)), Type: TypeCategoryPercent, Priority: priority, Percent: percent, IsActive: true}
+}
+
+func exclusive(p models.Promotion) models.Promotion {
+	p.Exclusive = true
+	return p
+}
+
+func appliedIDs(r *Result) []int {
+	ids := []int{}
+	for _, a := range r.Applied {
+		ids = append(ids, a.PromotionID)
+	}
+	return ids
+}
+
+func TestApply(t *testing.T) {
+	yesterday := testNow.Add(-24 * time.Hour)
+	tomorrow := testNow.Add(24 * time.Hour)
+	onlyProduct99 := percentOff(4, 30, 50)
+	onlyProduct99.ProductIDs = []int{99}
+	inactive := percentOff(5, 40, 50)
+	inactive.IsActive = false
+	expired := percentOff(6, 40, 50)
+	expired.EndsAt = &yesterday
+	upcoming := percentOff(7, 40, 50)
+	upcoming.StartsAt = &tomorrow
+
+	single := []Line{{ProductID: 1, Quantity: 1, UnitPrice: 100}}
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		lines      []Line
+		applied    []int
+		discounts  []float64 // Descuento de cada promoción aplicada
+		lineTotals []float64
+	}{
+		{
+			name:       "mayor prioridad primero y a igual prioridad el menor ID",
+			promotions: []models.Promotion{percentOff(2, 10, 10), percentOff(3, 20, 20), percentOff(1, 10, 50)},
+			lines:      single,
+			applied:    []int{3, 1, 2},
+			discounts:  []float64{20, 40, 4},
+			lineTotals: []float64{64},
+		},
+		{
+			name:       "la exclusiva que aplica detiene la evaluación",
+			promotions: []models.Promotion{percentOff(1, 10, 50), exclusive(percentOff(3, 20, 20)), percentOff(2, 30, 10)},
+			lines:      single,
+			applied:    []int{2, 3},
+			discounts:  []float64{10, 18},
+			lineTotals: []float64{28},
+		},
+		{
+			name:       "la exclusiva que no aplica no detiene la evaluación",
+			promotions: []models.Promotion{exclusive(onlyProduct99), percentOff(1, 10, 10)},
+			lines:      single,
+			applied:    []int{1},
+			discounts:  []float64{10},
+			lineTotals: []float64{10},
+		},
+		{
+			name:       "se ignoran las inactivas, vencidas y futuras",
+			promotions: []models.Promotion{inactive, expired, upcoming, percentOff(1, 10, 10)},
+			lines:      single,
+			applied:    []int{1},
+			discounts:  []float64{10},
+			lineTotals: []float64{10},
+		},
+		{
+			name:       "un porcentaje mayor a 100 se topa en lo que queda de la línea",
+			promotions: []models.Promotion{percentOff(1, 20, 60), percentOff(2, 10, 150)},
+			lines:      []Line{{ProductID: 1, Quantity: 2, UnitPrice: 50}},
+			applied:    []int{1, 2},
+			discounts:  []float64{60, 40},
+			lineTotals: []float64{100},
+		},
+		{
+			name: "un escalón fijo mayor que la base se topa en la base",
+			promotions: []models.Promotion{
+				percentOff(1, 20, 50),
+				{ID: 2, Name: "T", Type: TypeTiered, Priority: 10, IsActive: true, Tiers: []models.PromotionTier{{MinSubtotal: 10, Amount: 500}}},
+			},
+			lines:      []Line{{ProductID: 1, Quantity: 1, UnitPrice: 40}, {ProductID: 2, Quantity: 1, UnitPrice: 20}},
+			applied:    []int{1, 2},
+			discounts:  []float64{30, 30},
+			lineTotals: []float64{40, 20},
+		},
+		{
+			name: "escalones: aplica el más alto alcanzado",
+			promotions: []models.Promotion{{ID: 1, Name: "T", Type: TypeTiered, IsActive: true, Tiers: []models.PromotionTier{
+				{MinSubtotal: 100, Percent: 10}, {MinSubtotal: 200, Percent: 15}, {MinSubtotal: 500, Percent: 30},
+			}}},
+			lines:      []Line{{ProductID: 1, Quantity: 1, UnitPrice: 150}, {ProductID: 2, Quantity: 2, UnitPrice: 50}},
+			applied:    []int{1},
+			discounts:  []float64{37.5},
+			lineTotals: []float64{22.5, 15},
+		},
+		{
+			name:       "compra 2 y llévate 1: se descuenta la unidad más barata",
+			promotions: []models.Promotion{{ID: 1, Name: "B", Type: TypeBuyXGetY, IsActive: true, BuyQuantity: 2, GetQuantity: 1, Percent: 100}},
+			lines:      []Line{{ProductID: 1, Quantity: 1, UnitPrice: 30}, {ProductID: 2, Quantity: 1, UnitPrice: 10}, {ProductID: 3, Quantity: 1, UnitPrice: 20}},
+			applied:    []int{1},
+			discounts:  []float64{10},
+			lineTotals: []float64{0, 10, 0},
+		},
+		{
+			name:       "paquete: el ahorro se reparte según el valor de cada producto",
+			promotions: []models.Promotion{{ID: 1, Name: "K", Type: TypeBundle, IsActive: true, ProductIDs: []int{1, 2}, BundlePrice: 80}},
+			lines:      []Line{{ProductID: 1, Quantity: 2, UnitPrice: 60}, {ProductID: 2, Quantity: 1, UnitPrice: 40}},
+			applied:    []int{1},
+			discounts:  []float64{20},
+			lineTotals: []float64{12, 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Apply(tt.promotions, tt.lines, testNow)
+			if ids := appliedIDs(r); !reflect.DeepEqual(ids, tt.applied) {
+				t.Fatalf("promociones aplicadas %v, se esperaba %v", ids, tt.applied)
+			}
+			var sum float64
+			for i, a := range r.Applied {
+				if a.Discount != tt.discounts[i] {
+					t.Errorf("la promoción %d descontó %.2f, se esperaba %.2f", a.PromotionID, a.Discount, tt.discounts[i])
+				}
+				sum += a.Discount
+			}
+			if !reflect.DeepEqual(r.Lines, tt.lineTotals) {
+				t.Errorf("descuento por línea %v, se esperaba %v", r.Lines, tt.lineTotals)
+			}
+			for i, line := range tt.lines {
+				if r.Lines[i] > line.UnitPrice*float64(line.Quantity) {
+					t.Errorf("la línea %d quedó en negativo", i)
+				}
+			}
+			if discount.Cents(r.Discount) != discount.Cents(sum) {
+				t.Errorf("el descuento total %.2f no coincide con la suma de las promociones %.2f", r.Discount, sum)
+			}
+		})
+	}
+}
+
+func TestApplyDoesNotReorderInput(t *testing.T) {
+	promotions := []models.Promotion{percentOff(2, 10, 10), percentOff(1, 20, 10)}
+	Apply(promotions, []Line{{ProductID: 1, Quantity: 1, UnitPrice: 10}}, testNow)
+	if promotions[0].ID != 2 || promotions[1].ID != 1 {
+		t.Error("Apply reordenó el slice recibido")
+	}
+}
+
+func TestApplyLinePromotions(t *testing.T) {
+	onlyFirst := percentOff(2, 10, 10)
+	onlyFirst.ProductIDs = []int{1}
+	r := Apply([]models.Promotion{percentOff(1, 20, 10), onlyFirst}, []Line{
+		{ProductID: 1, Quantity: 1, UnitPrice: 100},
+		{ProductID: 2, Quantity: 1, UnitPrice: 100},
+	}, testNow)
+
+	want := [][]string{{"P1", "P2"}, {"P1"}}
+	if !reflect.DeepEqual(r.LinePromotions, want) {
+		t.Errorf("promociones por línea %v, se esperaba %v", r.LinePromotions, want)
+	}
+}
+
+// TestCouponsStackOnPromotions reproduce lo que hace pricing: los cupones se aplican sobre lo que
+// dejaron las promociones en cada línea
+func TestCouponsStackOnPromotions(t *testing.T) {
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		coupons    []models.Coupon
+		lines      []Line
+		promo      []float64
+		coupon     []float64
+	}{
+		{
+			name:       "porcentaje y fijo sobre el precio ya rebajado",
+			promotions: []models.Promotion{percentOff(1, 10, 10)},
+			coupons: []models.Coupon{
+				{ID: 2, Code: "MENOS5", Type: coupon.TypeFixed, Value: 5, Stackable: true, IsActive: true},
+				{ID: 1, Code: "DIEZ", Type: coupon.TypePercent, Value: 10, Stackable: true, IsActive: true},
+			},
+			lines:  []Line{{ProductID: 1, Quantity: 1, UnitPrice: 100}, {ProductID: 2, Quantity: 1, UnitPrice: 50}},
+			promo:  []float64{10, 5},
+			coupon: []float64{12.34, 6.16},
+		},
+		{
+			name:       "el cupón no descuenta líneas que la promoción dejó en cero",
+			promotions: []models.Promotion{{ID: 1, Name: "B", Type: TypeBuyXGetY, IsActive: true, BuyQuantity: 1, GetQuantity: 1, Percent: 100}},
+			coupons:    []models.Coupon{{ID: 1, Code: "MENOS100", Type: coupon.TypeFixed, Value: 100, IsActive: true}},
+			lines:      []Line{{ProductID: 1, Quantity: 1, UnitPrice: 30}, {ProductID: 2, Quantity: 1, UnitPrice: 10}},
+			promo:      []float64{0, 10},
+			coupon:     []float64{30, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promo := Apply(tt.promotions, tt.lines, testNow)
+			if !reflect.DeepEqual(promo.Lines, tt.promo) {
+				t.Fatalf("descuento de promociones %v, se esperaba %v", promo.Lines, tt.promo)
+			}
+
+			couponLines := make([]coupon.Line, len(tt.lines))
+			for i, line := range tt.lines {
+				subtotal := discount.Cents(line.UnitPrice * float64(line.Quantity))
+				couponLines[i] = coupon.Line{ProductID: line.ProductID, CategoryID: line.CategoryID, Amount: discount.Amount(subtotal - discount.Cents(promo.Lines[i]))}
+			}
+			result := coupon.Apply(tt.coupons, couponLines)
+			if !reflect.DeepEqual(result.Lines, tt.coupon) {
+				t.Errorf("descuento de cupones %v, se esperaba %v", result.Lines, tt.coupon)
+			}
+
+			for i, line := range tt.lines {
+				left := discount.Cents(line.UnitPrice*float64(line.Quantity)) - discount.Cents(promo.Lines[i]) - discount.Cents(result.Lines[i])
+				if left < 0 {
+					t.Errorf("la línea %d quedó en %d centavos", i, left)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []int64
+		caps    []int64
+		want    []int64
+	}{
+		{"partes iguales con sobrante", 100, []int64{1, 1, 1}, []int64{100, 100, 100}, []int64{34, 33, 33}},
+		{"proporcional exacto", 300, []int64{200, 100}, []int64{200, 100}, []int64{200, 100}},
+		{"el sobrante de varios centavos va en orden", 5, []int64{3, 3, 3}, []int64{10, 10, 10}, []int64{2, 2, 1}},
+		{"respeta el tope de cada línea", 10, []int64{5, 5}, []int64{3, 100}, []int64{3, 7}},
+		{"no pasa de la suma de los topes", 100, []int64{1, 1}, []int64{30, 30}, []int64{30, 30}},
+		{"las líneas sin peso no reciben sobrante", 7, []int64{0, 2, 1}, []int64{10, 10, 10}, []int64{0, 5, 2}},
+		{"sin pesos no reparte", 100, []int64{0, 0}, []int64{100, 100}, []int64{0, 0}},
+		{"sin importe no reparte", 0, []int64{1, 1}, []int64{100, 100}, []int64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := discount.Allocate(tt.amount, tt.weights, tt.caps)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate(%d, %v, %v) = %v, se esperaba %v", tt.amount, tt.weights, tt.caps, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Estados de rastreo normalizados entre paqueterías
+const (
+	StatusLabelCreated   = "label_created"
+	StatusInTransit      = "in_transit"
+	StatusOutForDelivery = "out_for_delivery"
+	StatusDelivered      = "delivered"
+	StatusException      = "exception"
+	StatusCancelled      = "cancelled"
+)
+
+// ShipmentRequest contiene los datos para cotizar o generar un envío con la paquetería
+type ShipmentRequest struct {
+	OrderID     int
+	OrderNumber string
+	Service     string // standard, express, ...
+	From        *models.Address
+	To          *models.Address
+	Items       []Item
+}
+
+// CarrierRate es una tarifa cotizada por la paquetería
+type CarrierRate struct {
+	Service string  `json:"service"`
+	Name    string  `json:"name"`
+	Cost    float64 `json:"cost"`
+	MinDays int     `json:"min_days"`
+	MaxDays int     `json:"max_days"`
+}
+
+// Label es la guía generada por la paquetería
+type Label struct {
+	TrackingNumber string
+	Service        string
+	Cost           float64
+	Data           []byte // Contenido del archivo de la guía
+	ContentType    string // application/pdf, image/png, ...
+	Extension      string // pdf, png, zpl
+}
+
+// TrackingInfo es el estado de rastreo de un envío
+type TrackingInfo struct {
+	TrackingNumber string
+	Status         string
+	Events         []TrackingUpdate
+}
+
+// TrackingUpdate es un evento de rastreo reportado por la paquetería
+type TrackingUpdate struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Carrier abstrae la integración con una paquetería
+type Carrier interface {
+	// Name identifica a la paquetería (se guarda en shipments.carrier)
+	Name() string
+	// Rate cotiza los servicios disponibles para el envío
+	Rate(ctx context.Context, req ShipmentRequest) ([]CarrierRate, error)
+	// CreateShipment genera el envío y su guía
+	CreateShipment(ctx context.Context, req ShipmentRequest) (*Label, error)
+	// Track consulta el estado de rastreo
+	Track(ctx context.Context, trackingNumber string) (*TrackingInfo, error)
+	// Cancel cancela una guía que aún no ha sido recolectada
+	Cancel(ctx context.Context, trackingNumber string) error
+}
+
+// NewCarrier devuelve la paquetería configurada por nombre
+func NewCarrier(name string) (Carrier, error) {
+	switch strings.ToLower(name) {
+	case "", "simulated":
+		return NewSimulatedCarrier(), nil
+	default:
+		return nil, fmt.Errorf("paquetería no soportada: %s", name)
+	}
+}
+
+var (
+	defaultCarrier     Carrier
+	defaultCarrierOnce sync.Once
+)
+
+// CarrierFromEnv devuelve la paquetería configurada en SHIPPING_CARRIER (simulada por defecto).
+// La instancia se comparte entre handlers y jobs.
+func CarrierFromEnv() Carrier {
+	defaultCarrierOnce.Do(func() {
+		carrier, err := NewCarrier(os.Getenv("SHIPPING_CARRIER"))
+		if err != nil {
+			log.Printf("⚠️ %v, usando paquetería simulada", err)
+			carrier = NewSimulatedCarrier()
+		}
+		defaultCarrier = carrier
+	})
+	return defaultCarrier
+}
+
+// OriginAddressFromEnv devuelve la dirección de origen de los envíos
+func OriginAddressFromEnv() *models.Address {
+	return &models.Address{
+		Company:    os.Getenv("SHIPPING_ORIGIN_NAME"),
+		Address1:   os.Getenv("SHIPPING_ORIGIN_ADDRESS"),
+		City:       os.Getenv("SHIPPING_ORIGIN_CITY"),
+		State:      os.Getenv("SHIPPING_ORIGIN_STATE"),
+		PostalCode: os.Getenv("SHIPPING_ORIGIN_POSTAL_CODE"),
+		Country:    os.Getenv("SHIPPING_ORIGIN_COUNTRY"),
+	}
+}
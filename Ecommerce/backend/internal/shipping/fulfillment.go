@@ -0,0 +1,79 @@
+package shipping
+
+import (
+	"fmt"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// Estados del pedido derivados de sus envíos
+const (
+	OrderPartiallyShipped = "partially_shipped"
+	OrderShipped          = "shipped"
+	OrderDelivered        = "delivered"
+)
+
+// DeriveOrderStatus calcula el estado del pedido a partir de las cantidades enviadas y entregadas.
+// Devuelve "" si todavía no ha salido ningún paquete. Los envíos sin items (anteriores a los
+// envíos parciales) cubren el pedido completo.
+func DeriveOrderStatus(items []models.OrderItem, shipments []models.Shipment) string {
+	shipped := make(map[int]int)
+	delivered := make(map[int]int)
+
+	for _, s := range shipments {
+		if !isShippedStatus(s.Status) {
+			continue
+		}
+		if len(s.Items) == 0 {
+			for _, oi := range items {
+				shipped[oi.ID] += oi.Quantity
+				if s.Status == StatusDelivered {
+					delivered[oi.ID] += oi.Quantity
+				}
+			}
+			continue
+		}
+		for _, si := range s.Items {
+			shipped[si.OrderItemID] += si.Quantity
+			if s.Status == StatusDelivered {
+				delivered[si.OrderItemID] += si.Quantity
+			}
+		}
+	}
+
+	if len(items) == 0 || len(shipped) == 0 {
+		return ""
+	}
+
+	allShipped, allDelivered := true, true
+	for _, oi := range items {
+		if shipped[oi.ID] < oi.Quantity {
+			allShipped = false
+		}
+		if delivered[oi.ID] < oi.Quantity {
+			allDelivered = false
+		}
+	}
+
+	switch {
+	case allDelivered:
+		return OrderDelivered
+	case allShipped:
+		return OrderShipped
+	default:
+		return OrderPartiallyShipped
+	}
+}
+
+// PackageContents describe los items de un paquete para las notificaciones ("2 x Producto")
+func PackageContents(items []models.ShipmentItem) []string {
+	contents := make([]string, 0, len(items))
+	for _, item := range items {
+		name := item.ProductName
+		if name == "" {
+			name = fmt.Sprintf("Producto #%d", item.ProductID)
+		}
+		contents = append(contents, fmt.Sprintf("%d x %s", item.Quantity, name))
+	}
+	return contents
+}
@@ -0,0 +1,255 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// VolumetricDivisor convierte cm³ a kg de peso volumétrico (estándar de paquetería)
+const VolumetricDivisor = 5000.0
+
+// Item es un producto a enviar
+type Item struct {
+	ProductID  int
+	Quantity   int
+	UnitPrice  float64
+	Weight     float64 // Peso unitario en kg
+	Dimensions string  // "largo x ancho x alto" en cm, ej. "30x20x10"
+}
+
+// ItemFromProduct crea un Item a partir de un producto y una cantidad
+func ItemFromProduct(product *models.Product, quantity int) Item {
+	item := Item{ProductID: product.ID, Quantity: quantity, UnitPrice: product.Price}
+	if product.Weight != nil {
+		item.Weight = *product.Weight
+	}
+	if product.Dimensions != nil {
+		item.Dimensions = *product.Dimensions
+	}
+	return item
+}
+
+// Parcel resume los totales del envío
+type Parcel struct {
+	Subtotal         float64
+	ActualWeight     float64
+	VolumetricWeight float64
+	BillableWeight   float64
+}
+
+// NewParcel calcula subtotal y pesos de un conjunto de items
+func NewParcel(items []Item) Parcel {
+	var p Parcel
+	for _, item := range items {
+		qty := float64(item.Quantity)
+		p.Subtotal += item.UnitPrice * qty
+		p.ActualWeight += item.Weight * qty
+		if l, w, h, ok := ParseDimensions(item.Dimensions); ok {
+			p.VolumetricWeight += l * w * h / VolumetricDivisor * qty
+		}
+	}
+	p.Subtotal = round(p.Subtotal)
+	p.ActualWeight = round(p.ActualWeight)
+	p.VolumetricWeight = round(p.VolumetricWeight)
+	p.BillableWeight = math.Max(p.ActualWeight, p.VolumetricWeight)
+	return p
+}
+
+// ParseDimensions interpreta dimensiones del tipo "30x20x10" (cm)
+func ParseDimensions(dimensions string) (float64, float64, float64, bool) {
+	normalized := strings.ToLower(strings.ReplaceAll(dimensions, " ", ""))
+	normalized = strings.TrimSuffix(normalized, "cm")
+	parts := strings.FieldsFunc(normalized, func(r rune) bool { return r == 'x' || r == '*' || r == '×' })
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	var values [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v <= 0 {
+			return 0, 0, 0, false
+		}
+		values[i] = v
+	}
+	return values[0], values[1], values[2], true
+}
+
+// RateEngine cotiza envíos a partir de las zonas y métodos configurados
+type RateEngine struct {
+	DB *pgxpool.Pool
+}
+
+// NewRateEngine crea el motor de tarifas de envío
+func NewRateEngine(db *pgxpool.Pool) *RateEngine {
+	return &RateEngine{DB: db}
+}
+
+// Quote devuelve las opciones de envío disponibles para los items y el destino
+func (e *RateEngine) Quote(ctx context.Context, items []Item, address *models.Address, currency string) ([]models.ShippingOption, error) {
+	zones, err := db.GetShippingZones(e.DB, true)
+	if err != nil {
+		return nil, err
+	}
+	return QuoteWithZones(zones, items, address, currency), nil
+}
+
+// Reprice busca el método elegido entre las opciones disponibles y devuelve su costo actual
+func (e *RateEngine) Reprice(ctx context.Context, items []Item, address *models.Address, currency string, methodID int) (*models.ShippingOption, error) {
+	options, err := e.Quote(ctx, items, address, currency)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("no hay métodos de envío disponibles para la dirección")
+	}
+	if methodID == 0 {
+		// Sin método elegido se usa la opción más económica
+		return &options[0], nil
+	}
+	for i := range options {
+		if options[i].MethodID == methodID {
+			return &options[i], nil
+		}
+	}
+	return nil, fmt.Errorf("el método de envío seleccionado no está disponible para la dirección")
+}
+
+// QuoteWithZones cotiza usando la zona más específica que coincide con la dirección
+func QuoteWithZones(zones []models.ShippingZone, items []Item, address *models.Address, currency string) []models.ShippingOption {
+	zone := matchZone(zones, address)
+	if zone == nil {
+		return nil
+	}
+
+	parcel := NewParcel(items)
+	var options []models.ShippingOption
+	for _, method := range zone.Methods {
+		cost, ok := methodCost(method, parcel)
+		if !ok {
+			continue
+		}
+		options = append(options, models.ShippingOption{
+			MethodID:       method.ID,
+			ZoneID:         zone.ID,
+			Code:           method.Code,
+			Name:           method.Name,
+			Cost:           cost,
+			Currency:       currency,
+			MinDays:        method.MinDays,
+			MaxDays:        method.MaxDays,
+			BillableWeight: parcel.BillableWeight,
+			FreeShipping:   cost == 0,
+		})
+	}
+
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Cost < options[j].Cost })
+	return options
+}
+
+// methodCost aplica la regla de tarifa del método. Devuelve false si el método no aplica.
+func methodCost(method models.ShippingMethod, parcel Parcel) (float64, bool) {
+	if !method.IsActive {
+		return 0, false
+	}
+	if method.MaxWeight != nil && *method.MaxWeight > 0 && parcel.BillableWeight > *method.MaxWeight {
+		return 0, false
+	}
+
+	var cost float64
+	switch method.RuleType {
+	case "weight":
+		if len(method.Tiers) > 0 {
+			tierCost, ok := tierFor(method.Tiers, parcel.BillableWeight)
+			if !ok {
+				return 0, false
+			}
+			cost = tierCost
+		} else {
+			cost = method.BaseCost + method.PerKg*math.Ceil(parcel.BillableWeight)
+		}
+	case "price":
+		if len(method.Tiers) > 0 {
+			tierCost, ok := tierFor(method.Tiers, parcel.Subtotal)
+			if !ok {
+				return 0, false
+			}
+			cost = tierCost
+		} else {
+			cost = method.BaseCost
+		}
+	default: // flat
+		cost = method.BaseCost
+	}
+
+	if method.FreeOver != nil && parcel.Subtotal >= *method.FreeOver {
+		cost = 0
+	}
+	return round(cost), true
+}
+
+// tierFor busca el rango que contiene el valor
+func tierFor(tiers []models.ShippingTier, value float64) (float64, bool) {
+	for _, t := range tiers {
+		if value >= t.Min && (t.Max == 0 || value < t.Max) {
+			return t.Cost, true
+		}
+	}
+	return 0, false
+}
+
+// matchZone devuelve la zona más específica para la dirección
+func matchZone(zones []models.ShippingZone, address *models.Address) *models.ShippingZone {
+	var country, state, postal string
+	if address != nil {
+		country = strings.ToUpper(strings.TrimSpace(address.Country))
+		state = strings.ToUpper(strings.TrimSpace(address.State))
+		postal = strings.ToUpper(strings.ReplaceAll(address.PostalCode, " ", ""))
+	}
+
+	var best *models.ShippingZone
+	bestScore := -1
+	for i := range zones {
+		z := &zones[i]
+		if !z.IsActive {
+			continue
+		}
+		score := 0
+		if z.Country != "" {
+			if !strings.EqualFold(z.Country, country) {
+				continue
+			}
+			score += 1
+		}
+		if z.State != "" {
+			if strings.ToUpper(z.State) != state {
+				continue
+			}
+			score += 10
+		}
+		if z.PostalPrefix != "" {
+			prefix := strings.ToUpper(strings.ReplaceAll(z.PostalPrefix, " ", ""))
+			if !strings.HasPrefix(postal, prefix) {
+				continue
+			}
+			score += 100 + len(prefix)
+		}
+		if score > bestScore {
+			best = z
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// round redondea a centavos
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
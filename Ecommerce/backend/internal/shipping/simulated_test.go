@@ -0,0 +1,133 @@
+package shipping
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// simulatedTracking arma un número de rastreo como los de CreateShipment con la fecha indicada
+func simulatedTracking(createdAt time.Time) string {
+	return "SIM" + strings.ToUpper(strconv.FormatInt(createdAt.Unix(), 36)) + "ABC123"
+}
+
+func TestSimulatedCarrierRate(t *testing.T) {
+	carrier := &SimulatedCarrier{Step: time.Hour, cancelled: map[string]bool{}}
+	rates, err := carrier.Rate(context.Background(), ShipmentRequest{
+		Items: []Item{{ProductID: 1, Quantity: 1, UnitPrice: 100, Weight: 2.3}},
+	})
+	if err != nil {
+		t.Fatalf("Rate: %v", err)
+	}
+
+	// 2.3 kg se cobra como 3 kg
+	want := map[string]float64{"standard": 125, "express": 240}
+	if len(rates) != len(want) {
+		t.Fatalf("se esperaban %d tarifas, hay %d", len(want), len(rates))
+	}
+	for _, r := range rates {
+		if r.Cost != want[r.Service] {
+			t.Errorf("tarifa %s = %.2f, se esperaba %.2f", r.Service, r.Cost, want[r.Service])
+		}
+	}
+}
+
+func TestSimulatedCarrierCreateShipment(t *testing.T) {
+	carrier := NewSimulatedCarrier()
+	label, err := carrier.CreateShipment(context.Background(), ShipmentRequest{
+		OrderNumber: "ORD-1001",
+		Service:     "express",
+		To:          &models.Address{FirstName: "Ana", LastName: "López", Address1: "Calle 1", City: "CDMX", Country: "MX"},
+		Items:       []Item{{ProductID: 1, Quantity: 2, UnitPrice: 50, Weight: 0.5}},
+	})
+	if err != nil {
+		t.Fatalf("CreateShipment: %v", err)
+	}
+
+	if label.Service != "express" || label.Cost != 180 {
+		t.Errorf("servicio %s con costo %.2f, se esperaba express con 180", label.Service, label.Cost)
+	}
+	if label.ContentType != "application/pdf" || !bytes.HasPrefix(label.Data, []byte("%PDF")) {
+		t.Errorf("la guía no es un PDF (%s)", label.ContentType)
+	}
+
+	createdAt, err := simulatedCreatedAt(label.TrackingNumber)
+	if err != nil {
+		t.Fatalf("número de rastreo %s: %v", label.TrackingNumber, err)
+	}
+	if time.Since(createdAt) > time.Minute {
+		t.Errorf("la fecha codificada en %s es %v", label.TrackingNumber, createdAt)
+	}
+
+	info, err := carrier.Track(context.Background(), label.TrackingNumber)
+	if err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+	if info.Status != StatusLabelCreated || len(info.Events) != 1 {
+		t.Errorf("una guía nueva está en %s con %d eventos", info.Status, len(info.Events))
+	}
+}
+
+func TestSimulatedCarrierTrackProgress(t *testing.T) {
+	carrier := &SimulatedCarrier{Step: time.Hour, cancelled: map[string]bool{}}
+	tests := []struct {
+		elapsed time.Duration
+		status  string
+		events  int
+	}{
+		{30 * time.Minute, StatusLabelCreated, 1},
+		{90 * time.Minute, StatusInTransit, 2},
+		{150 * time.Minute, StatusOutForDelivery, 3},
+		{10 * time.Hour, StatusDelivered, 4},
+	}
+	for _, tt := range tests {
+		info, err := carrier.Track(context.Background(), simulatedTracking(time.Now().Add(-tt.elapsed)))
+		if err != nil {
+			t.Fatalf("Track tras %v: %v", tt.elapsed, err)
+		}
+		if info.Status != tt.status || len(info.Events) != tt.events {
+			t.Errorf("tras %v: %s con %d eventos, se esperaba %s con %d", tt.elapsed, info.Status, len(info.Events), tt.status, tt.events)
+		}
+		for i := 1; i < len(info.Events); i++ {
+			if !info.Events[i].OccurredAt.After(info.Events[i-1].OccurredAt) {
+				t.Errorf("tras %v: los eventos no están en orden", tt.elapsed)
+			}
+		}
+	}
+}
+
+func TestSimulatedCarrierCancel(t *testing.T) {
+	carrier := &SimulatedCarrier{Step: time.Hour, cancelled: map[string]bool{}}
+	ctx := context.Background()
+
+	fresh := simulatedTracking(time.Now())
+	if err := carrier.Cancel(ctx, fresh); err != nil {
+		t.Fatalf("no se pudo cancelar una guía sin recolectar: %v", err)
+	}
+	info, err := carrier.Track(ctx, fresh)
+	if err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+	if info.Status != StatusCancelled {
+		t.Errorf("la guía cancelada está en %s", info.Status)
+	}
+
+	inTransit := simulatedTracking(time.Now().Add(-90 * time.Minute))
+	if err := carrier.Cancel(ctx, inTransit); err == nil {
+		t.Error("se canceló una guía ya recolectada")
+	}
+}
+
+func TestSimulatedCarrierInvalidTracking(t *testing.T) {
+	carrier := NewSimulatedCarrier()
+	for _, tracking := range []string{"", "1Z999AA10123456784", "SIM123", "SIM!!!!ABC123"} {
+		if _, err := carrier.Track(context.Background(), tracking); err == nil {
+			t.Errorf("se aceptó el número de rastreo %q", tracking)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/auth"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/email"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// TrackingService aplica las actualizaciones de rastreo a los envíos y pedidos
+type TrackingService struct {
+	DB              *pgxpool.Pool
+	NotificationSvc *email.NotificationService
+}
+
+// NewTrackingService crea el servicio de rastreo
+func NewTrackingService(db *pgxpool.Pool) *TrackingService {
+	return &TrackingService{
+		DB:              db,
+		NotificationSvc: email.NewNotificationService(db, email.DefaultEmailService, auth.GuestOrderLink),
+	}
+}
+
+// Refresh consulta a la paquetería y aplica el resultado
+func (s *TrackingService) Refresh(ctx context.Context, carrier Carrier, shipment *models.Shipment) error {
+	info, err := carrier.Track(ctx, shipment.TrackingNumber)
+	if err != nil {
+		return fmt.Errorf("error consultando rastreo de %s: %w", shipment.TrackingNumber, err)
+	}
+	return s.Apply(ctx, shipment, info)
+}
+
+// Apply guarda los eventos nuevos, actualiza el envío y avanza el pedido a enviado o entregado
+func (s *TrackingService) Apply(ctx context.Context, shipment *models.Shipment, info *TrackingInfo) error {
+	for _, update := range info.Events {
+		event := &models.TrackingEvent{
+			ShipmentID:  shipment.ID,
+			Status:      update.Status,
+			Description: update.Description,
+			Location:    update.Location,
+			OccurredAt:  update.OccurredAt,
+		}
+		inserted, err := db.AddTrackingEvent(s.DB, event)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			shipment.LastEvent = update.Description
+		}
+	}
+
+	now := time.Now()
+	previous := shipment.Status
+	if info.Status != "" {
+		shipment.Status = info.Status
+	}
+	shipment.LastCheckedAt = &now
+	if shipment.ShippedAt == nil && isShippedStatus(shipment.Status) {
+		shipment.ShippedAt = &now
+	}
+	if shipment.DeliveredAt == nil && shipment.Status == StatusDelivered {
+		shipment.DeliveredAt = &now
+	}
+	if err := db.UpdateShipmentStatus(s.DB, shipment); err != nil {
+		return err
+	}
+
+	if previous == shipment.Status {
+		return nil
+	}
+
+	orderStatus, err := s.syncOrder(shipment)
+	if err != nil {
+		return err
+	}
+
+	packageShipped := !isShippedStatus(previous) && isShippedStatus(shipment.Status)
+	packageDelivered := previous != StatusDelivered && shipment.Status == StatusDelivered
+	if (packageShipped || packageDelivered) && s.NotificationSvc != nil {
+		s.notifyPackage(ctx, shipment, orderStatus)
+	}
+	return nil
+}
+
+// syncOrder recalcula el estado del pedido a partir de todos sus envíos y lo guarda si cambió.
+// Devuelve el estado derivado.
+func (s *TrackingService) syncOrder(shipment *models.Shipment) (string, error) {
+	order, err := db.GetOrderByID(s.DB, shipment.OrderID)
+	if err != nil {
+		return "", err
+	}
+	items, err := db.GetOrderItems(s.DB, order.ID)
+	if err != nil {
+		return "", err
+	}
+	shipments, err := db.GetOrderShipments(s.DB, order.ID)
+	if err != nil {
+		return "", err
+	}
+
+	target := DeriveOrderStatus(items, shipments)
+	if target == "" || order.Status == target || order.Status == "cancelled" {
+		return target, nil
+	}
+
+	if err := db.UpdateOrderStatus(s.DB, order.ID, target); err != nil {
+		return "", err
+	}
+	if isShippedStatus(shipment.Status) {
+		if err := db.UpdateOrderTracking(s.DB, order.ID, shipment.TrackingNumber); err != nil {
+			log.Printf("Error guardando tracking del pedido %d: %v", order.ID, err)
+		}
+	}
+	if err := db.AddOrderHistory(s.DB, &models.OrderStatusHistory{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   target,
+		Note:       fmt.Sprintf("Actualización de %s: %s (%s)", shipment.Carrier, shipment.Status, shipment.TrackingNumber),
+		Actor:      "system",
+	}); err != nil {
+		log.Printf("Error guardando historial del pedido %d: %v", order.ID, err)
+	}
+	return target, nil
+}
+
+// notifyPackage avisa al cliente que un paquete salió o fue entregado, con lo que contiene
+func (s *TrackingService) notifyPackage(ctx context.Context, shipment *models.Shipment, orderStatus string) {
+	order, err := db.GetOrderByID(s.DB, shipment.OrderID)
+	if err != nil {
+		log.Printf("Error obteniendo pedido %d para notificar envío: %v", shipment.OrderID, err)
+		return
+	}
+	items, err := db.GetShipmentItems(s.DB, shipment.ID)
+	if err != nil {
+		log.Printf("Error obteniendo items del envío %d: %v", shipment.ID, err)
+	}
+
+	status := OrderShipped
+	if shipment.Status == StatusDelivered {
+		status = OrderDelivered
+	}
+	partial := orderStatus == OrderPartiallyShipped
+	if err := s.NotificationSvc.CreateShipmentNotification(ctx, order.UserID, order.ID, order.OrderNumber, status,
+		shipment.TrackingNumber, PackageContents(items), partial); err != nil {
+		log.Printf("Error notificando envío %d del pedido %d: %v", shipment.ID, order.ID, err)
+	}
+}
+
+// isShippedStatus indica si el paquete ya salió del almacén
+func isShippedStatus(status string) bool {
+	return status == StatusInTransit || status == StatusOutForDelivery || status == StatusDelivered
+}
@@ -0,0 +1,55 @@
+package tax
+
+import (
+	"context"
+	"math"
+
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// DefaultTaxClass es la clase fiscal usada cuando la categoría no define otra
+const DefaultTaxClass = "standard"
+
+// Line es una línea de pedido a gravar
+type Line struct {
+	ProductID  int
+	CategoryID int
+	TaxClass   string
+	Quantity   int
+	UnitPrice  float64
+	Amount     float64 // Importe de la línea (precio unitario por cantidad, ya con descuentos)
+}
+
+// Request contiene lo necesario para calcular los impuestos de un pedido
+type Request struct {
+	Lines           []Line
+	ShippingAddress *models.Address
+	BillingAddress  *models.Address
+	TaxExempt       bool
+}
+
+// LineResult es el impuesto calculado para una línea
+type LineResult struct {
+	Net        float64 // Importe sin impuestos
+	Tax        float64 // Total de impuestos de la línea
+	Gross      float64 // Importe con impuestos
+	Components []models.TaxComponent
+}
+
+// Result es el resultado del cálculo de impuestos de un pedido
+type Result struct {
+	Lines        []LineResult // Mismo orden que Request.Lines
+	TotalTax     float64      // Impuestos totales (incluidos y no incluidos en precio)
+	ExclusiveTax float64      // Impuestos que se suman al subtotal
+	InclusiveTax float64      // Impuestos ya contenidos en el precio
+}
+
+// Calculator calcula los impuestos de un pedido
+type Calculator interface {
+	Calculate(ctx context.Context, req Request) (*Result, error)
+}
+
+// round redondea a centavos
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,166 @@
+package tax
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tuusuario/ecommerce-backend/internal/db"
+	"github.com/tuusuario/ecommerce-backend/internal/models"
+)
+
+// TableCalculator calcula impuestos a partir de la tabla tax_rates
+type TableCalculator struct {
+	DB *pgxpool.Pool
+}
+
+// NewTableCalculator crea un calculador basado en la tabla de tasas
+func NewTableCalculator(db *pgxpool.Pool) *TableCalculator {
+	return &TableCalculator{DB: db}
+}
+
+// Calculate aplica a cada línea las tasas que coinciden con la dirección y la clase fiscal
+func (t *TableCalculator) Calculate(ctx context.Context, req Request) (*Result, error) {
+	rates, err := db.GetTaxRates(t.DB, true)
+	if err != nil {
+		return nil, err
+	}
+	return CalculateWithRates(rates, req), nil
+}
+
+// CalculateWithRates calcula los impuestos usando la lista de tasas recibida
+func CalculateWithRates(rates []models.TaxRate, req Request) *Result {
+	result := &Result{Lines: make([]LineResult, len(req.Lines))}
+
+	// Los bienes físicos tributan en el destino; si no hay dirección de envío se usa la de facturación
+	address := req.ShippingAddress
+	if address == nil || address.Country == "" {
+		address = req.BillingAddress
+	}
+
+	for i, line := range req.Lines {
+		taxClass := line.TaxClass
+		if taxClass == "" {
+			taxClass = DefaultTaxClass
+		}
+
+		lr := LineResult{Net: line.Amount, Gross: line.Amount}
+		if req.TaxExempt {
+			result.Lines[i] = lr
+			continue
+		}
+
+		applicable := matchRates(rates, address, taxClass)
+
+		// Primero se separan los impuestos incluidos en el precio para obtener la base gravable
+		var inclusiveRate float64
+		for _, r := range applicable {
+			if r.Inclusive {
+				inclusiveRate += r.Rate
+			}
+		}
+		net := line.Amount
+		if inclusiveRate > 0 {
+			net = round(line.Amount / (1 + inclusiveRate))
+		}
+		lr.Net = net
+
+		var inclusiveTax, exclusiveTax float64
+		for _, r := range applicable {
+			amount := round(net * r.Rate)
+			lr.Components = append(lr.Components, models.TaxComponent{
+				RateID:    r.ID,
+				Name:      r.Name,
+				Rate:      r.Rate,
+				Inclusive: r.Inclusive,
+				Taxable:   net,
+				Amount:    amount,
+			})
+			if r.Inclusive {
+				inclusiveTax += amount
+			} else {
+				exclusiveTax += amount
+			}
+		}
+
+		// Ajustar el redondeo para que neto + impuestos incluidos coincida con el precio
+		if inclusiveRate > 0 {
+			diff := round(line.Amount - net - inclusiveTax)
+			if diff != 0 {
+				for j := range lr.Components {
+					if lr.Components[j].Inclusive {
+						lr.Components[j].Amount = round(lr.Components[j].Amount + diff)
+						break
+					}
+				}
+				inclusiveTax = round(inclusiveTax + diff)
+			}
+		}
+
+		lr.Tax = round(inclusiveTax + exclusiveTax)
+		lr.Gross = round(line.Amount + exclusiveTax)
+		result.Lines[i] = lr
+		result.InclusiveTax += inclusiveTax
+		result.ExclusiveTax += exclusiveTax
+	}
+
+	result.InclusiveTax = round(result.InclusiveTax)
+	result.ExclusiveTax = round(result.ExclusiveTax)
+	result.TotalTax = round(result.InclusiveTax + result.ExclusiveTax)
+	return result
+}
+
+// matchRates devuelve, por cada prioridad, la tasa más específica que aplica a la dirección
+func matchRates(rates []models.TaxRate, address *models.Address, taxClass string) []models.TaxRate {
+	var country, state, postal string
+	if address != nil {
+		country = strings.ToUpper(strings.TrimSpace(address.Country))
+		state = strings.ToUpper(strings.TrimSpace(address.State))
+		postal = strings.ToUpper(strings.ReplaceAll(address.PostalCode, " ", ""))
+	}
+
+	best := make(map[int]models.TaxRate)
+	bestScore := make(map[int]int)
+	for _, r := range rates {
+		if !r.IsActive || r.TaxClass != taxClass {
+			continue
+		}
+		score := 0
+		if r.Country != "" {
+			if !strings.EqualFold(r.Country, country) {
+				continue
+			}
+			score += 1
+		}
+		if r.State != "" {
+			if strings.ToUpper(r.State) != state {
+				continue
+			}
+			score += 10
+		}
+		if r.PostalPrefix != "" {
+			prefix := strings.ToUpper(strings.ReplaceAll(r.PostalPrefix, " ", ""))
+			if !strings.HasPrefix(postal, prefix) {
+				continue
+			}
+			score += 100 + len(prefix)
+		}
+		if current, ok := bestScore[r.Priority]; !ok || score > current || (score == current && r.ID < best[r.Priority].ID) {
+			best[r.Priority] = r
+			bestScore[r.Priority] = score
+		}
+	}
+
+	priorities := make([]int, 0, len(best))
+	for p := range best {
+		priorities = append(priorities, p)
+	}
+	sort.Ints(priorities)
+
+	matched := make([]models.TaxRate, 0, len(priorities))
+	for _, p := range priorities {
+		matched = append(matched, best[p])
+	}
+	return matched
+}
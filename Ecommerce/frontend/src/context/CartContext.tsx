@@ -62,8 +62,8 @@ export const CartProvider = ({ children }: { children: React.ReactNode }) => {
         const errorText = await response.text();
         throw new Error('Failed to fetch cart');
       }
-      const data: CartItem[] = await response.json();
-      setCart(data);
+      const data: { items: CartItem[] } = await response.json();
+      setCart(data.items ?? []);
     } catch (err: any) {
       setError(err.message);
     } finally {
@@ -174,7 +174,7 @@ export const CartProvider = ({ children }: { children: React.ReactNode }) => {
       const response = await fetch(`${API_BASE_URL}/api/cart`, {
         headers: { 'Authorization': `Bearer ${token}` },
       });
-      const backendCart: CartItem[] = response.ok ? await response.json() : [];
+      const backendCart: CartItem[] = response.ok ? ((await response.json()).items ?? []) : [];
       
       // 2. Crear un mapa de productos del backend para acceso rápido
       const backendCartMap = new Map(backendCart.map(item => [item.product_id, item]));
@@ -58,7 +58,7 @@ export const FavoritesProvider = ({ children }: { children: ReactNode }) => {
     });
     if (res.ok) {
       const data = await res.json();
-      setFavorites(data.favorites || []);
+      setFavorites((data.favorites || []).map((f: { product_id: number }) => f.product_id));
     }
   };
 